@@ -19,11 +19,19 @@ along with this program; if not, see <http://www.gnu.org/licenses/>.
 package main
 
 import (
-	"crypto/tls"
+	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/time/rate"
@@ -35,14 +43,52 @@ import (
 )
 
 func main() {
+	// The environment provides defaults, flags override them.
+	env, err := client.LinstorConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defaultLogLevel := "info"
+	if env.Debug {
+		defaultLogLevel = "debug"
+	}
+
 	var (
-		lsEndpoint            = flag.String("linstor-endpoint", "http://localhost:3070", "Controller API endpoint for LINSTOR")
+		lsEndpoint            = flag.String("linstor-endpoint", env.Endpoint.String(), "Controller API endpoint for LINSTOR")
 		lsSkipTLSVerification = flag.Bool("linstor-skip-tls-verification", false, "If true, do not verify tls")
+		lsTraceHTTP           = flag.Bool("linstor-trace-http", env.TraceHTTP, "If true, log all requests to the LINSTOR controller and their responses, with secrets redacted")
 		csiEndpoint           = flag.String("csi-endpoint", "unix:///var/lib/kubelet/plugins/linstor.csi.linbit.com/csi.sock", "CSI endpoint")
 		node                  = flag.String("node", "", "Node ID to pass to node service")
-		logLevel              = flag.String("log-level", "info", "Enable debug log output. Choose from: panic, fatal, error, warn, info, debug")
+		logLevel              = flag.String("log-level", defaultLogLevel, "Enable debug log output. Choose from: panic, fatal, error, warn, info, debug")
 		rps                   = flag.Float64("linstor-api-requests-per-second", 0, "Maximum allowed number of LINSTOR API requests per second. Default: Unlimited")
 		burst                 = flag.Int("linstor-api-burst", 1, "Maximum number of API requests allowed before being limited by requests-per-second. Default: 1 (no bursting)")
+		createTimeout         = flag.Duration("create-timeout", env.CreateTimeout, "Deadline for the LINSTOR calls made while creating a volume")
+		deleteTimeout         = flag.Duration("delete-timeout", env.DeleteTimeout, "Deadline for the LINSTOR calls made while deleting a volume")
+		attachTimeout         = flag.Duration("attach-timeout", env.AttachTimeout, "Deadline for the LINSTOR calls made while attaching or detaching a volume")
+		mountTimeout          = flag.Duration("mount-timeout", env.MountTimeout, "Deadline for the LINSTOR calls made while mounting a volume")
+		lookupTimeout         = flag.Duration("lookup-timeout", env.LookupTimeout, "Deadline for looking up volumes by name or ID")
+		maxReplicas           = flag.Int("max-replicas", 0, "Maximum number of replicas a volume may have. Default: Unlimited")
+		clampReplicas         = flag.Bool("clamp-replicas", false, "If true, volumes requesting more than max-replicas replicas are created with max-replicas, rather than refused")
+		clampMinSize          = flag.Bool("clamp-minimum-size", false, "If true, volumes limited to less than LINSTOR's minimum volume size are created with the minimum size, rather than refused")
+		maxResyncs            = flag.Int("max-concurrent-resyncs", 0, "Maximum number of volumes resyncing on a node at once when placing new replicas on it. Default: Unlimited")
+		defaultPool           = flag.String("default-storage-pool", "", "Storage pool of volumes whose StorageClass names none. Default: Chosen by LINSTOR")
+		lsTokenFile           = flag.String("linstor-token-file", "", "File containing a bearer token sent to the LINSTOR controller. Re-read periodically to allow rotation")
+		lsTokenRefresh        = flag.Duration("linstor-token-refresh", client.DefaultTokenRefresh, "How often the linstor-token-file is re-read")
+		nameTemplate          = flag.String("resource-name-template", "", "text/template for the names of new LINSTOR resources, e.g. '{{.StorageClass}}-{{.Name}}'. Default: Derived from the volume name")
+		allowForcePrimary     = flag.Bool("allow-force-primary", false, "If true, volumes may be forced primary on a node as a last resort to recover them. This risks data divergence")
+		watchRoles            = flag.Bool("watch-requested-roles", false, "If true, volumes on node are promoted and demoted when a failover on another node requests it. Enable on the node plugin")
+		maxIdleConns          = flag.Int("linstor-max-idle-conns", client.DefaultMaxIdleConns, "Maximum number of idle connections to the LINSTOR controller kept open for reuse")
+		ioWeightCgroup        = flag.String("io-weight-cgroup", client.DefaultIOWeightCgroup, "Cgroup containing the pod cgroups in which the ioWeight parameter of volumes is applied to their devices, e.g. /sys/fs/cgroup/kubepods.slice with the systemd cgroup driver")
+		snapshotReserve       = flag.Float64("snapshot-reserve", 0, "Percentage of a thin storage pool that must be free to create snapshots of volumes in it. Default: No reserve")
+		poolSnapshotReserve   = flag.String("pool-snapshot-reserve", "", "Comma separated list of pool=percentage pairs overriding snapshot-reserve per storage pool")
+		managedBy             = flag.String("managed-by", client.DefaultManagedBy, "Marker recorded on the LINSTOR resources of volumes. Resources with another marker are never touched")
+		adminAddress          = flag.String("admin-address", "", "Address to serve the admin endpoint on, e.g. 'localhost:9810'. PUT /maintenance?on=true pauses provisioning. Default: Disabled")
+		adminTokenFile        = flag.String("admin-token-file", "", "File containing a bearer token required by the admin endpoint. Required unless admin-address is a loopback address")
+		poolVolumeQuota       = flag.String("pool-volume-quota", "", "Comma separated list of pool=count pairs limiting the number of volumes per storage pool. Default: Unlimited")
+		maxAnnotationSize     = flag.Int("max-annotation-size", 0, "Maximum size in bytes of the volume information stored in LINSTOR properties. Default: Unlimited")
+		deletionGrace         = flag.Duration("deletion-grace-period", 0, "How long deleted volumes are kept before they are removed. Creating a volume of the same name and size in the meantime restores it. Default: Removed right away")
+		otlpEndpoint          = flag.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "Base URL of an OpenTelemetry collector that receives traces of LINSTOR operations over OTLP/HTTP, e.g. 'http://otel-collector:4318'. Default: Not traced")
+		otlpServiceName       = flag.String("otlp-service-name", os.Getenv("OTEL_SERVICE_NAME"), "Service name of the exported traces. Default: linstor-csi")
 	)
 	flag.Parse()
 
@@ -63,10 +109,32 @@ func main() {
 	if r <= 0 {
 		r = rate.Inf
 	}
+	headers, err := client.ParseHeaders(os.Getenv("LS_CONTROLLER_HEADERS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	tlsConfig, err := env.TLSConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tlsConfig.InsecureSkipVerify = *lsSkipTLSVerification
+	transport := &client.HeaderTransport{
+		Base:         client.NewPooledTransport(tlsConfig, *maxIdleConns),
+		Headers:      headers,
+		TokenFile:    *lsTokenFile,
+		TokenRefresh: *lsTokenRefresh,
+	}
+	if *lsTraceHTTP {
+		traceLog := log.New()
+		traceLog.SetOutput(logOut)
+		traceLog.SetFormatter(logFmt)
+		traceLog.SetLevel(log.TraceLevel)
+		transport.Trace = traceLog
+	}
 	c, err := lc.NewHighLevelClient(
 		lapi.BaseURL(u),
 		lapi.BasicAuth(&lapi.BasicAuthCfg{Username: os.Getenv("LS_USERNAME"), Password: os.Getenv("LS_PASSWORD")}),
-		lapi.HTTPClient(&http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: *lsSkipTLSVerification}}}),
+		lapi.HTTPClient(&http.Client{Transport: transport}),
 		lapi.Limit(r, *burst),
 		lapi.Log(&lapi.LogCfg{Level: *logLevel, Out: logOut, Formatter: logFmt}),
 	)
@@ -74,19 +142,104 @@ func main() {
 		log.Fatal(err)
 	}
 
+	poolReserves, err := parsePoolSnapshotReserve(*poolSnapshotReserve)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	poolQuotas, err := parsePoolVolumeQuota(*poolVolumeQuota)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var tracer client.Tracer
+	var otlpTracer *client.OTLPTracer
+	if *otlpEndpoint != "" {
+		otlpHeaders, err := client.ParseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		otlpTracer = client.NewOTLPTracer(client.OTLPTracesEndpoint(*otlpEndpoint), *otlpServiceName, otlpHeaders, log.NewEntry(log.StandardLogger()))
+		tracer = otlpTracer
+	}
+
 	linstorClient, err := client.NewLinstor(
 		client.APIClient(c),
 		client.LogFmt(logFmt),
 		client.LogLevel(*logLevel),
 		client.LogOut(logOut),
+		client.MasterPassphrase(os.Getenv("LS_MASTER_PASSPHRASE")),
+		client.NodeName(*node),
+		client.AllowForcePrimary(*allowForcePrimary),
+		client.ResourceNameTemplate(*nameTemplate),
+		client.CreateTimeout(*createTimeout),
+		client.DeleteTimeout(*deleteTimeout),
+		client.AttachTimeout(*attachTimeout),
+		client.MountTimeout(*mountTimeout),
+		client.LookupTimeout(*lookupTimeout),
+		client.MaxReplicas(int32(*maxReplicas)),
+		client.ClampReplicas(*clampReplicas),
+		client.ClampMinimumSize(*clampMinSize),
+		client.MaxConcurrentResyncs(*maxResyncs),
+		client.DefaultStoragePool(*defaultPool),
+		client.ManagedBy(*managedBy),
+		client.IOWeightCgroup(*ioWeightCgroup),
+		client.SnapshotReserve(*snapshotReserve),
+		client.PoolSnapshotReserve(poolReserves),
+		client.PoolVolumeQuota(poolQuotas),
+		client.MaxAnnotationSize(*maxAnnotationSize),
+		client.DeletionGracePeriod(*deletionGrace),
+		client.Transport(transport),
+		client.ControllerAPI(u, os.Getenv("LS_USERNAME"), os.Getenv("LS_PASSWORD")),
+		client.Tracing(tracer),
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Failovers of the controller invalidate what the client cached.
+	transport.OnControllerChange = linstorClient.ControllerChanged
+
+	if *deletionGrace > 0 {
+		linstorClient.StartReaper()
+	}
+
+	if *watchRoles {
+		linstorClient.StartRoleWatcher()
+	}
+
+	// Controllers that are not reachable yet may still turn out compatible.
+	if err := linstorClient.CheckCompatibility(context.Background()); err != nil {
+		if _, ok := err.(*client.IncompatibleControllerError); ok {
+			log.Fatal(err)
+		}
+		log.WithError(err).Warn("unable to check compatibility of LINSTOR controller")
+	}
+
+	if *adminAddress != "" {
+		var adminToken string
+		if *adminTokenFile != "" {
+			token, err := ioutil.ReadFile(*adminTokenFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			adminToken = strings.TrimSpace(string(token))
+		}
+		if adminToken == "" && !loopbackAddress(*adminAddress) {
+			log.Fatalf("admin endpoint on %s would be reachable by anyone on the network, set -admin-token-file or listen on a loopback address", *adminAddress)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/maintenance", client.RequireToken(linstorClient.MaintenanceHandler(), adminToken))
+		go func() {
+			log.Fatal(http.ListenAndServe(*adminAddress, mux))
+		}()
+	}
+
 	drv, err := driver.NewDriver(
 		driver.Assignments(linstorClient),
 		driver.Endpoint(*csiEndpoint),
+		driver.Expander(linstorClient),
 		driver.LogLevel(*logLevel),
 		driver.LogOut(logOut),
 		driver.Mounter(linstorClient),
@@ -101,7 +254,88 @@ func main() {
 	//nolint:errcheck
 	defer drv.Stop()
 
+	// Kubernetes sends SIGTERM before killing the plugin.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-sigs
+		log.WithField("signal", sig).Info("shutting down")
+		//nolint:errcheck
+		drv.Stop()
+	}()
+
 	if err := drv.Run(); err != nil {
 		log.Fatal(err)
 	}
+
+	if err := linstorClient.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if otlpTracer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := otlpTracer.Shutdown(ctx); err != nil {
+			log.WithError(err).Warn("unable to export remaining spans")
+		}
+	}
+}
+
+// loopbackAddress reports whether the host of the listen address addr is
+// localhost or a loopback IP. Addresses without host listen on all interfaces.
+func loopbackAddress(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// parsePoolSnapshotReserve parses a comma separated list of pool=percentage
+// pairs.
+func parsePoolSnapshotReserve(s string) (map[string]float64, error) {
+	var reserves = make(map[string]float64)
+	if s == "" {
+		return reserves, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid pool snapshot reserve %q, expected pool=percentage", pair)
+		}
+		percent, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool snapshot reserve %q: %v", pair, err)
+		}
+		reserves[strings.TrimSpace(kv[0])] = percent
+	}
+
+	return reserves, nil
+}
+
+// parsePoolVolumeQuota parses a comma separated list of pool=count pairs.
+func parsePoolVolumeQuota(s string) (map[string]int, error) {
+	var quotas = make(map[string]int)
+	if s == "" {
+		return quotas, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid pool volume quota %q, expected pool=count", pair)
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool volume quota %q: %v", pair, err)
+		}
+		quotas[strings.TrimSpace(kv[0])] = count
+	}
+
+	return quotas, nil
 }
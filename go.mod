@@ -4,7 +4,7 @@ go 1.12
 
 require (
 	github.com/LINBIT/golinstor v0.16.2
-	github.com/container-storage-interface/spec v1.1.0
+	github.com/container-storage-interface/spec v1.2.0
 	github.com/evanphx/json-patch v4.5.0+incompatible // indirect
 	github.com/golang/protobuf v1.3.1
 	github.com/haySwim/data v0.2.0
@@ -29,7 +29,7 @@ require (
 	k8s.io/kube-openapi v0.0.0-20190603182131-db7b694dc208 // indirect
 	k8s.io/kubernetes v1.14.2
 	k8s.io/utils v0.0.0-20190607212802-c55fbcfc754a // indirect
-	sigs.k8s.io/yaml v1.1.0 // indirect
+	sigs.k8s.io/yaml v1.1.0
 )
 
 // fix for https://github.com/kubernetes/client-go/issues/584
@@ -0,0 +1,136 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	lapi "github.com/LINBIT/golinstor/client"
+)
+
+// controllerFeature is part of the LINSTOR API this plugin relies on.
+type controllerFeature struct {
+	name string
+	// since is the first LINSTOR version providing the feature.
+	since string
+}
+
+var controllerFeatures = []controllerFeature{
+	{name: "REST API", since: "0.9.11"},
+	{name: "resource views", since: "0.9.11"},
+	{name: "storage pool views", since: "0.9.11"},
+}
+
+// controllerVersion is the response to GET /v1/controller/version.
+type controllerVersion struct {
+	Version        string `json:"version"`
+	GitHash        string `json:"git_hash"`
+	BuildTime      string `json:"build_time"`
+	RestAPIVersion string `json:"rest_api_version"`
+}
+
+// IncompatibleControllerError is returned by CheckCompatibility if the
+// LINSTOR controller lacks features this plugin relies on.
+type IncompatibleControllerError struct {
+	// Version is the version of the controller, empty if it did not report
+	// one.
+	Version string
+	// Missing lists the unavailable features and the LINSTOR version
+	// introducing them.
+	Missing []string
+}
+
+func (e *IncompatibleControllerError) Error() string {
+	version := e.Version
+	if version == "" {
+		version = "of unknown version"
+	}
+	return fmt.Sprintf("LINSTOR controller %s is incompatible, unavailable features: %s", version, strings.Join(e.Missing, ", "))
+}
+
+// CheckCompatibility verifies that the version of the LINSTOR controller
+// provides the API this plugin relies on. Controllers that lack parts of it
+// result in an *IncompatibleControllerError, other errors mean that the
+// controller could not be checked.
+func (s *Linstor) CheckCompatibility(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.lookupTimeout)
+	defer cancel()
+
+	var v controllerVersion
+	err := s.controllerGet(ctx, "/v1/controller/version", &v)
+	// Controllers too old to report their version predate all features.
+	if err != nil && err != lapi.NotFoundError {
+		return timeoutErr(ctx, "compatibility check", "LINSTOR controller",
+			fmt.Errorf("unable to determine controller version: %v", err))
+	}
+
+	if missing := missingFeatures(v.Version); len(missing) != 0 {
+		return &IncompatibleControllerError{Version: v.Version, Missing: missing}
+	}
+	return nil
+}
+
+// missingFeatures returns the controllerFeatures that LINSTOR version lacks,
+// all of them if version is empty.
+func missingFeatures(version string) []string {
+	var missing []string
+	for _, f := range controllerFeatures {
+		if version == "" || compareVersions(version, f.since) < 0 {
+			missing = append(missing, fmt.Sprintf("%s (LINSTOR %s or newer)", f.name, f.since))
+		}
+	}
+	return missing
+}
+
+// compareVersions compares dotted versions like 1.4.2 numerically, returning
+// -1, 0, or 1 if a is older, the same, or newer than b. Suffixes like -rc.1
+// are ignored, as are missing or malformed parts that count as 0.
+func compareVersions(a, b string) int {
+	as, bs := versionParts(a), versionParts(b)
+	for len(as) < len(bs) {
+		as = append(as, 0)
+	}
+	for len(bs) < len(as) {
+		bs = append(bs, 0)
+	}
+	for i := range as {
+		switch {
+		case as[i] < bs[i]:
+			return -1
+		case as[i] > bs[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(version string) []int {
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	var parts []int
+	for _, p := range strings.Split(version, ".") {
+		n, _ := strconv.Atoi(p)
+		parts = append(parts, n)
+	}
+	return parts
+}
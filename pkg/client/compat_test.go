@@ -0,0 +1,116 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	lapi "github.com/LINBIT/golinstor/client"
+	lc "github.com/LINBIT/linstor-csi/pkg/linstor/highlevelclient"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	var allMissing = []string{
+		"REST API (LINSTOR 0.9.11 or newer)",
+		"resource views (LINSTOR 0.9.11 or newer)",
+		"storage pool views (LINSTOR 0.9.11 or newer)",
+	}
+
+	var tableTests = []struct {
+		name     string
+		version  string
+		expected error
+	}{
+		{
+			name:     "current controller",
+			version:  "1.4.2",
+			expected: nil,
+		},
+		{
+			name:     "release candidate",
+			version:  "0.9.11-rc.1",
+			expected: nil,
+		},
+		{
+			name:     "old controller",
+			version:  "0.9.10",
+			expected: &IncompatibleControllerError{Version: "0.9.10", Missing: allMissing},
+		},
+		{
+			name:     "controller without version",
+			expected: &IncompatibleControllerError{Missing: allMissing},
+		},
+	}
+
+	for _, tt := range tableTests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/controller/version" || tt.version == "" {
+					http.NotFound(w, r)
+					return
+				}
+				json.NewEncoder(w).Encode(controllerVersion{Version: tt.version, RestAPIVersion: "1.0.13"}) //nolint:errcheck
+			}))
+			defer srv.Close()
+
+			u, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l, err := NewLinstor(APIClient(c), ControllerAPI(u, "", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual := l.CheckCompatibility(context.Background())
+			if !reflect.DeepEqual(tt.expected, actual) {
+				t.Errorf("Expected compatibility check to result in %v, but got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	var tableTests = []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.4.2", "1.4.2", 0},
+		{"1.4", "1.4.0", 0},
+		{"0.9.10", "0.9.11", -1},
+		{"1.10.0", "1.9.3", 1},
+		{"1.0.0-rc.2", "1.0.0", 0},
+	}
+
+	for _, tt := range tableTests {
+		if actual := compareVersions(tt.a, tt.b); actual != tt.expected {
+			t.Errorf("Expected compareVersions(%q, %q) to be %d, got %d", tt.a, tt.b, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	lapi "github.com/LINBIT/golinstor/client"
+)
+
+// ControllerAPI sets the endpoint and credentials of the LINSTOR controller
+// for the requests golinstor provides no method for, like reading the
+// controller version. They are sent through the Transport. Without it, these
+// requests fail.
+func ControllerAPI(endpoint *url.URL, username, password string) func(*Linstor) error {
+	return func(l *Linstor) error {
+		l.endpoint = endpoint
+		l.username = username
+		l.password = password
+		return nil
+	}
+}
+
+// controllerGet decodes the response to a GET request of path into v.
+// Missing paths result in lapi.NotFoundError, like with golinstor.
+func (s *Linstor) controllerGet(ctx context.Context, path string, v interface{}) error {
+	if s.endpoint == nil {
+		return fmt.Errorf("unable to request %s, controller endpoint unknown", path)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.endpoint.ResolveReference(&url.URL{Path: path}).String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	transport := s.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		return lapi.NotFoundError
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return fmt.Errorf("request of %s failed with status %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,209 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultControllerEndpoint is the LINSTOR controller used if LS_CONTROLLERS
+// is not set.
+const DefaultControllerEndpoint = "http://localhost:3070"
+
+// LinstorConfig is the configuration of the connection to the LINSTOR
+// controller and of the deadlines of operations, as read from the environment
+// by LinstorConfigFromEnv.
+type LinstorConfig struct {
+	// Endpoint is the first controller of LS_CONTROLLERS.
+	Endpoint *url.URL
+	// Debug is LS_DEBUG, it enables debug logging.
+	Debug bool
+	// TraceHTTP is LS_TRACE_HTTP, it enables tracing of all requests to the
+	// controller and their responses, see HeaderTransport.Trace.
+	TraceHTTP bool
+	// TLSCertFile and TLSKeyFile are LS_TLS_CERT_FILE and LS_TLS_KEY_FILE, the
+	// client certificate presented to the controller. Either both or none are
+	// set.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSCAFile is LS_TLS_CA_FILE, the certificates the controller is
+	// verified against. The system pool is used if empty.
+	TLSCAFile string
+
+	// Deadlines of operations, from LS_CREATE_TIMEOUT, LS_DELETE_TIMEOUT,
+	// LS_ATTACH_TIMEOUT, LS_MOUNT_TIMEOUT, and LS_LOOKUP_TIMEOUT.
+	CreateTimeout time.Duration
+	DeleteTimeout time.Duration
+	AttachTimeout time.Duration
+	MountTimeout  time.Duration
+	LookupTimeout time.Duration
+}
+
+// LinstorConfigFromEnv reads a LinstorConfig from the environment. Variables
+// that are not set keep their defaults, malformed ones are an error.
+func LinstorConfigFromEnv() (LinstorConfig, error) {
+	return linstorConfigFromEnv(os.LookupEnv)
+}
+
+func linstorConfigFromEnv(lookup func(string) (string, bool)) (LinstorConfig, error) {
+	endpoint, err := url.Parse(DefaultControllerEndpoint)
+	if err != nil {
+		return LinstorConfig{}, err
+	}
+	var c = LinstorConfig{
+		Endpoint:      endpoint,
+		CreateTimeout: DefaultCreateTimeout,
+		DeleteTimeout: DefaultDeleteTimeout,
+		AttachTimeout: DefaultAttachTimeout,
+		MountTimeout:  DefaultMountTimeout,
+		LookupTimeout: DefaultLookupTimeout,
+	}
+
+	if v, ok := lookup("LS_CONTROLLERS"); ok && strings.TrimSpace(v) != "" {
+		u, err := parseControllers(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid LS_CONTROLLERS %q: %v", v, err)
+		}
+		c.Endpoint = u
+	}
+
+	for _, b := range []struct {
+		name  string
+		value *bool
+	}{
+		{"LS_DEBUG", &c.Debug},
+		{"LS_TRACE_HTTP", &c.TraceHTTP},
+	} {
+		v, ok := lookup(b.name)
+		if !ok || v == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid %s %q, must be a boolean", b.name, v)
+		}
+		*b.value = enabled
+	}
+
+	for _, f := range []struct {
+		name string
+		path *string
+	}{
+		{"LS_TLS_CERT_FILE", &c.TLSCertFile},
+		{"LS_TLS_KEY_FILE", &c.TLSKeyFile},
+		{"LS_TLS_CA_FILE", &c.TLSCAFile},
+	} {
+		v, ok := lookup(f.name)
+		if !ok || v == "" {
+			continue
+		}
+		if _, err := os.Stat(v); err != nil {
+			return c, fmt.Errorf("invalid %s: %v", f.name, err)
+		}
+		*f.path = v
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return c, fmt.Errorf("LS_TLS_CERT_FILE and LS_TLS_KEY_FILE must be set together")
+	}
+
+	for _, t := range []struct {
+		name    string
+		timeout *time.Duration
+	}{
+		{"LS_CREATE_TIMEOUT", &c.CreateTimeout},
+		{"LS_DELETE_TIMEOUT", &c.DeleteTimeout},
+		{"LS_ATTACH_TIMEOUT", &c.AttachTimeout},
+		{"LS_MOUNT_TIMEOUT", &c.MountTimeout},
+		{"LS_LOOKUP_TIMEOUT", &c.LookupTimeout},
+	} {
+		v, ok := lookup(t.name)
+		if !ok || v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return c, fmt.Errorf("invalid %s %q, must be a positive duration like 2m", t.name, v)
+		}
+		*t.timeout = d
+	}
+
+	return c, nil
+}
+
+// parseControllers returns the URL of the first controller of a comma
+// separated list, like LS_CONTROLLERS of the LINSTOR client. Controllers
+// may be given as host, host:port, linstor://host, or as URL.
+func parseControllers(s string) (*url.URL, error) {
+	hostPort := strings.TrimSpace(strings.Split(s, ",")[0])
+	hostPort = strings.TrimPrefix(hostPort, "linstor://")
+	if !strings.Contains(hostPort, "://") {
+		if !strings.Contains(hostPort, ":") {
+			hostPort += ":3370"
+		}
+		hostPort = "http://" + hostPort
+	}
+
+	u, err := url.Parse(hostPort)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	return u, nil
+}
+
+// TLSConfig returns the tls.Config for connecting to the controller with the
+// configured certificates.
+func (c LinstorConfig) TLSConfig() (*tls.Config, error) {
+	var conf = &tls.Config{}
+
+	if c.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA certificates: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates found in %s", c.TLSCAFile)
+		}
+		conf.RootCAs = pool
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,101 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLinstorConfigFromEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "linstor-csi-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	if err := ioutil.WriteFile(certFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var tableTests = []struct {
+		name     string
+		env      map[string]string
+		endpoint string
+		debug    bool
+		trace    bool
+		create   time.Duration
+		errExp   bool
+	}{
+		{name: "defaults", env: map[string]string{}, endpoint: DefaultControllerEndpoint, create: DefaultCreateTimeout},
+		{
+			name:     "first controller is used",
+			env:      map[string]string{"LS_CONTROLLERS": "linstor://ctrl-a,ctrl-b", "LS_DEBUG": "true", "LS_CREATE_TIMEOUT": "10m"},
+			endpoint: "http://ctrl-a:3370",
+			debug:    true,
+			create:   10 * time.Minute,
+		},
+		{name: "url controller", env: map[string]string{"LS_CONTROLLERS": "https://ctrl-a:3371"}, endpoint: "https://ctrl-a:3371", create: DefaultCreateTimeout},
+		{name: "unsupported scheme", env: map[string]string{"LS_CONTROLLERS": "ftp://ctrl-a"}, errExp: true},
+		{name: "trace http", env: map[string]string{"LS_TRACE_HTTP": "1"}, endpoint: DefaultControllerEndpoint, trace: true, create: DefaultCreateTimeout},
+		{name: "malformed debug", env: map[string]string{"LS_DEBUG": "yes please"}, errExp: true},
+		{name: "malformed trace http", env: map[string]string{"LS_TRACE_HTTP": "on"}, errExp: true},
+		{name: "malformed timeout", env: map[string]string{"LS_ATTACH_TIMEOUT": "120"}, errExp: true},
+		{name: "negative timeout", env: map[string]string{"LS_MOUNT_TIMEOUT": "-1m"}, errExp: true},
+		{name: "missing tls file", env: map[string]string{"LS_TLS_CA_FILE": filepath.Join(dir, "missing")}, errExp: true},
+		{name: "certificate without key", env: map[string]string{"LS_TLS_CERT_FILE": certFile}, errExp: true},
+	}
+
+	for _, tt := range tableTests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := linstorConfigFromEnv(func(k string) (string, bool) {
+				v, ok := tt.env[k]
+				return v, ok
+			})
+			if tt.errExp {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", c)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if c.Endpoint.String() != tt.endpoint {
+				t.Errorf("Expected endpoint %s, got %s", tt.endpoint, c.Endpoint)
+			}
+			if c.Debug != tt.debug {
+				t.Errorf("Expected debug %t, got %t", tt.debug, c.Debug)
+			}
+			if c.TraceHTTP != tt.trace {
+				t.Errorf("Expected HTTP tracing %t, got %t", tt.trace, c.TraceHTTP)
+			}
+			if c.CreateTimeout != tt.create {
+				t.Errorf("Expected create timeout %v, got %v", tt.create, c.CreateTimeout)
+			}
+			if c.DeleteTimeout != DefaultDeleteTimeout {
+				t.Errorf("Expected default delete timeout, got %v", c.DeleteTimeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/LINBIT/linstor-csi/pkg/volume"
+	"github.com/sirupsen/logrus"
+)
+
+// CreateFromImage creates vol and populates its filesystem with the contents
+// of the tarball at imageRef, an http(s) URL or a local path. The volume is
+// attached to this node for the time being, formatted, mounted, and filled,
+// then unmounted and left ready for use. The temporary assignment is removed
+// again, even on failure. A volume that failed to be populated is left for
+// the caller to delete, like volumes that failed to be created.
+func (s *Linstor) CreateFromImage(ctx context.Context, vol *volume.Info, imageRef string) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if params.FS == "" {
+		return fmt.Errorf("unable to populate %s from an image, it has no filesystem", vol.Name)
+	}
+	if s.nodeName == "" {
+		return fmt.Errorf("unable to populate %s from an image, node name unknown", vol.Name)
+	}
+
+	src, err := imageSource(imageRef)
+	if err != nil {
+		return err
+	}
+	if err := checkImageSource(ctx, src); err != nil {
+		return err
+	}
+
+	if err := s.Create(ctx, vol, nil); err != nil {
+		return err
+	}
+
+	return s.populate(ctx, vol, src, params.FS)
+}
+
+// populate extracts the tarball at src onto the filesystem of vol through a
+// temporary mount on this node.
+func (s *Linstor) populate(ctx context.Context, vol *volume.Info, src *url.URL, fsType string) error {
+	log := s.log.WithFields(logrus.Fields{
+		"volume": vol.ID,
+		"image":  imageName(src),
+		"node":   s.nodeName,
+	})
+
+	existing, err := s.GetAssignmentOnNode(ctx, vol, s.nodeName)
+	if err != nil || existing.Path == "" {
+		if err := s.Attach(ctx, vol, s.nodeName); err != nil {
+			return fmt.Errorf("unable to populate %s: %v", vol.ID, err)
+		}
+		defer func() {
+			if err := s.Detach(context.Background(), vol, s.nodeName); err != nil {
+				log.WithError(err).Warn("unable to remove temporary assignment")
+			}
+		}()
+	}
+
+	assignment, err := s.GetAssignmentOnNode(ctx, vol, s.nodeName)
+	if err != nil {
+		return fmt.Errorf("unable to find device of %s: %v", vol.ID, err)
+	}
+
+	target, err := ioutil.TempDir("", "linstor-csi-populate-")
+	if err != nil {
+		return err
+	}
+
+	var mounted bool
+	defer func() {
+		if mounted {
+			if err := s.Unmount(target); err != nil {
+				// Removing target would delete the populated files.
+				log.WithError(err).WithField("target", target).Warn("unable to unmount populated volume, keeping its mount point")
+				return
+			}
+		}
+		// Only removes target if it is empty, i.e. nothing is mounted.
+		if err := os.Remove(target); err != nil {
+			log.WithError(err).WithField("target", target).Warn("unable to remove temporary mount point")
+		}
+	}()
+
+	if _, err := s.Mount(vol, assignment.Path, target, fsType, nil); err != nil {
+		return fmt.Errorf("unable to populate %s: %v", vol.ID, err)
+	}
+	mounted = true
+
+	// The tarball can't be larger than the volume it has to fit on.
+	tarball, cleanup, err := fetchImage(ctx, src, vol.SizeBytes)
+	if err != nil {
+		return fmt.Errorf("unable to populate %s: %v", vol.ID, err)
+	}
+	defer cleanup()
+
+	log.Info("extracting image onto volume")
+	// tar detects compressed tarballs by itself.
+	out, err := s.mounter.RunContext(ctx, "tar", "-x", "-C", target, "-f", tarball)
+	if err != nil {
+		return fmt.Errorf("unable to extract image onto %s: %v: %q", vol.ID, err, out)
+	}
+	log.Info("populated volume from image")
+	return nil
+}
+
+// imageSource parses imageRef into the URL of a tarball. Plain paths are
+// file URLs.
+func imageSource(imageRef string) (*url.URL, error) {
+	u, err := url.Parse(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %q: %v", imageRef, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid image %q, missing host", imageRef)
+		}
+	case "":
+		u = &url.URL{Scheme: "file", Path: imageRef}
+		fallthrough
+	case "file":
+		if u.Path == "" || u.Path[0] != '/' {
+			return nil, fmt.Errorf("invalid image %q, local images need an absolute path", imageRef)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported image %q, must be a tarball URL or path", imageRef)
+	}
+	return u, nil
+}
+
+// checkImageSource returns an error if the tarball at src is not reachable.
+func checkImageSource(ctx context.Context, src *url.URL) error {
+	if src.Scheme == "file" {
+		if _, err := os.Stat(src.Path); err != nil {
+			return fmt.Errorf("image unavailable: %v", err)
+		}
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodHead, src.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("image %s unavailable: %v", imageName(src), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("image %s unavailable: %s", imageName(src), resp.Status)
+	}
+	return nil
+}
+
+// fetchImage returns the path of a local copy of the tarball at src and a
+// function removing it again. Tarballs larger than maxBytes are refused.
+func fetchImage(ctx context.Context, src *url.URL, maxBytes int64) (string, func(), error) {
+	if src.Scheme == "file" {
+		info, err := os.Stat(src.Path)
+		if err != nil {
+			return "", nil, fmt.Errorf("image unavailable: %v", err)
+		}
+		if info.Size() > maxBytes {
+			return "", nil, fmt.Errorf("image %s is larger than the volume, %d of %d bytes", imageName(src), info.Size(), maxBytes)
+		}
+		return src.Path, func() {}, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, src.String(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to download image %s: %v", imageName(src), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", nil, fmt.Errorf("unable to download image %s: %s", imageName(src), resp.Status)
+	}
+	if resp.ContentLength > maxBytes {
+		return "", nil, fmt.Errorf("image %s is larger than the volume, %d of %d bytes", imageName(src), resp.ContentLength, maxBytes)
+	}
+
+	f, err := ioutil.TempFile("", "linstor-csi-image-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	n, err := io.Copy(f, io.LimitReader(resp.Body, maxBytes+1))
+	if err == nil && n > maxBytes {
+		err = fmt.Errorf("image is larger than the volume, more than %d bytes", maxBytes)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("unable to download image %s: %v", imageName(src), err)
+	}
+	return f.Name(), cleanup, nil
+}
+
+// imageName returns src without credentials, for logs and errors.
+func imageName(src *url.URL) string {
+	u := *src
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,185 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	lapi "github.com/LINBIT/golinstor/client"
+	lc "github.com/LINBIT/linstor-csi/pkg/linstor/highlevelclient"
+	"github.com/LINBIT/linstor-csi/pkg/volume"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+func TestImageSource(t *testing.T) {
+	var tableTests = []struct {
+		ref      string
+		expected string
+		errExp   bool
+	}{
+		{ref: "https://images.example.com/golden.tar.gz", expected: "https://images.example.com/golden.tar.gz"},
+		{ref: "/srv/images/golden.tar", expected: "file:///srv/images/golden.tar"},
+		{ref: "file:///srv/images/golden.tar", expected: "file:///srv/images/golden.tar"},
+		{ref: "images/golden.tar", errExp: true},
+		{ref: "http:///golden.tar", errExp: true},
+		{ref: "docker://registry.example.com/golden:latest", errExp: true},
+	}
+
+	for _, tt := range tableTests {
+		u, err := imageSource(tt.ref)
+		if tt.errExp {
+			if err == nil {
+				t.Errorf("Expected image %q to be refused, got %s", tt.ref, u)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for image %q: %v", tt.ref, err)
+			continue
+		}
+		if tt.expected != u.String() {
+			t.Errorf("Expected image %q to be %s, got %s", tt.ref, tt.expected, u)
+		}
+	}
+}
+
+func TestCheckImageSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/golden.tar" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	f, err := ioutil.TempFile("", "linstor-csi-image-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	var tableTests = []struct {
+		ref    string
+		errExp bool
+	}{
+		{ref: srv.URL + "/golden.tar"},
+		{ref: srv.URL + "/missing.tar", errExp: true},
+		{ref: f.Name()},
+		{ref: f.Name() + "-missing", errExp: true},
+	}
+
+	for _, tt := range tableTests {
+		src, err := imageSource(tt.ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkImageSource(context.Background(), src)
+		if tt.errExp != (err != nil) {
+			t.Errorf("Expected error for image %q: %t, got %v", tt.ref, tt.errExp, err)
+		}
+	}
+}
+
+func TestPopulate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/resource-definitions/pvc-1/resources/node-a/volumes/0" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(lapi.Volume{DevicePath: "/dev/drbd1000"}) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	image, err := ioutil.TempFile("", "linstor-csi-image-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image.Write(make([]byte, 1024)) //nolint:errcheck
+	image.Close()
+	defer os.Remove(image.Name())
+
+	var tableTests = []struct {
+		name       string
+		sizeBytes  int64
+		unmountErr error
+		extracted  bool
+		// kept is true if the mount point is expected to be left behind.
+		kept   bool
+		errExp bool
+	}{
+		{name: "image is extracted", sizeBytes: 1 << 20, extracted: true},
+		{name: "failed unmounts keep the mount point", sizeBytes: 1 << 20, unmountErr: errors.New("target is busy"), extracted: true, kept: true},
+		{name: "images larger than the volume are refused", sizeBytes: 512, errExp: true},
+	}
+
+	for _, tt := range tableTests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l, err := NewLinstor(APIClient(c), NodeName("node-a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			mounter := &fakeMounter{FakeMounter: &mount.FakeMounter{}, diskFormat: "ext4", mountPoint: true, unmountErr: tt.unmountErr}
+			l.mounter = mounter
+
+			src, err := imageSource(image.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			vol := &volume.Info{ID: "pvc-1", SizeBytes: tt.sizeBytes}
+			err = l.populate(context.Background(), vol, src, "ext4")
+			if tt.errExp != (err != nil) {
+				t.Fatalf("Expected error: %t, got %v", tt.errExp, err)
+			}
+
+			var extracted bool
+			for _, cmd := range mounter.commands {
+				extracted = extracted || cmd == "tar"
+			}
+			if tt.extracted != extracted {
+				t.Errorf("Expected image to be extracted: %t, got commands %v", tt.extracted, mounter.commands)
+			}
+
+			if len(mounter.unmounts) != 1 {
+				t.Fatalf("Expected one unmount, got %v", mounter.unmounts)
+			}
+			target := mounter.unmounts[0]
+			_, err = os.Stat(target)
+			if kept := err == nil; tt.kept != kept {
+				t.Errorf("Expected mount point %s to be kept: %t, got %t", target, tt.kept, kept)
+			}
+			os.Remove(target)
+		})
+	}
+}
@@ -24,9 +24,23 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
 
+	apiconst "github.com/LINBIT/golinstor"
 	lapi "github.com/LINBIT/golinstor/client"
 	"github.com/LINBIT/linstor-csi/pkg/linstor"
 	lc "github.com/LINBIT/linstor-csi/pkg/linstor/highlevelclient"
@@ -37,6 +51,7 @@ import (
 	"github.com/LINBIT/linstor-csi/pkg/topology/scheduler/balancer"
 	"github.com/LINBIT/linstor-csi/pkg/topology/scheduler/followtopology"
 	"github.com/LINBIT/linstor-csi/pkg/topology/scheduler/manual"
+	"github.com/LINBIT/linstor-csi/pkg/topology/scheduler/mostfree"
 	"github.com/LINBIT/linstor-csi/pkg/volume"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	ptypes "github.com/golang/protobuf/ptypes"
@@ -44,17 +59,181 @@ import (
 	"github.com/pborman/uuid"
 	logrus "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
-	"k8s.io/kubernetes/pkg/util/mount"
 )
 
 // Linstor is a high-level client for use with CSI.
 type Linstor struct {
 	log            *logrus.Entry
 	fallbackPrefix string
-	client         *lc.HighLevelClient
-	mounter        *mount.SafeFormatAndMount
+	// nameTemplate generates the names of new resource definitions. If nil,
+	// LINSTOR derives them from the volume name.
+	nameTemplate *template.Template
+	client       *lc.HighLevelClient
+	mounter      diskMounter
+	// masterPassphrase unlocks LINSTOR's encryption master passphrase before
+	// encrypted volumes are created. Empty if the controller is unlocked externally.
+	masterPassphrase string
+	// nodeName is the LINSTOR node this plugin is running on.
+	nodeName string
+	// tracer traces client operations.
+	tracer Tracer
+	// transport is the http.RoundTripper of client, its idle connections are
+	// closed by Close.
+	transport http.RoundTripper
+	// endpoint, username, and password are used for requests golinstor has
+	// no method for, see ControllerAPI.
+	endpoint *url.URL
+	username string
+	password string
+	// allowForcePrimary enables ForcePrimary, it is refused otherwise.
+	allowForcePrimary bool
+	// remountWatchers maps mount targets to the channels that stop their
+	// read-write remount watchers.
+	remountWatchers map[string]chan struct{}
+	remountMu       sync.Mutex
+	// fstrimmers maps mount targets to the channels that stop their periodic
+	// fstrim runs.
+	fstrimmers map[string]chan struct{}
+	fstrimMu   sync.Mutex
+	// corruptAnnotations contains the names of resource definitions whose
+	// volume annotations could not be read.
+	corruptAnnotations map[string]bool
+	corruptMu          sync.Mutex
+	// nodes caches the result of ListNodes until nodesExpiry.
+	nodes       []NodeInfo
+	nodesExpiry time.Time
+	// nodesTTL is how long the next result of ListNodes is cached, zero
+	// means nodeCacheTTL.
+	nodesTTL time.Duration
+	// cacheGen counts the flushes of the caches. Results of API calls, which
+	// are made without holding the cache mutexes, are only cached if no
+	// flush happened in the meantime.
+	cacheGen uint64
+	nodesMu  sync.Mutex
+	// poolTypes caches the provisioning type of storage pools.
+	poolTypes   map[string]string
+	poolTypesMu sync.Mutex
+	// Deadlines for the LINSTOR calls made by each operation.
+	createTimeout time.Duration
+	deleteTimeout time.Duration
+	attachTimeout time.Duration
+	mountTimeout  time.Duration
+	lookupTimeout time.Duration
+	// maxReplicas caps the number of replicas of a volume, zero means no cap.
+	maxReplicas int32
+	// clampReplicas lowers placement counts above maxReplicas to it instead
+	// of refusing to create the volume.
+	clampReplicas bool
+	// clampMinimumSize raises size limits below LINSTOR's minimum volume size
+	// to it instead of refusing to create the volume.
+	clampMinimumSize bool
+	// ioWeightCgroup is the cgroup containing the pod cgroups whose io.weight
+	// receives the IO weights of the volumes mounted for them.
+	ioWeightCgroup string
+	// ioWeightDevices maps mount targets to the devices whose IO weight was set.
+	ioWeightDevices map[string]ioWeightDevice
+	ioWeightMu      sync.Mutex
+	// snapshotReserve is the percentage of thin storage pools that must stay
+	// free for a snapshot to be created, poolSnapshotReserve overrides it per pool.
+	snapshotReserve     float64
+	poolSnapshotReserve map[string]float64
+	// poolVolumeQuota limits the number of volumes per storage pool, keyed by
+	// storage pool name. Pools without an entry are unlimited.
+	poolVolumeQuota map[string]int
+	// maxAnnotationSize is the maximum size in bytes of the serialized volume
+	// stored on resource definitions, zero means no maximum.
+	maxAnnotationSize int
+	// nameSanitizer turns names into valid LINSTOR resource names, nil
+	// means linstorifyResourceName.
+	nameSanitizer func(string) (string, error)
+	// managedBy is the marker of resource definitions created by this
+	// client, those with another marker are ignored.
+	managedBy string
+	// maintenance is set while mutating operations are refused.
+	maintenance   bool
+	maintenanceMu sync.Mutex
+	// eventSink receives events worth surfacing to users, nil if unset.
+	eventSink func(level, reason, message string)
+	// maxConcurrentResyncs limits the volumes resyncing per node when
+	// replicas are added, zero means no limit. Operations adding replicas
+	// wait in resyncQueue.
+	maxConcurrentResyncs int
+	resyncQueue          resyncQueue
+	// defaultStoragePool is used for volumes whose parameters name no storage
+	// pool, empty leaves the choice to LINSTOR.
+	defaultStoragePool string
+	// deletionGracePeriod defers the removal of deleted volumes, zero
+	// removes them right away. reaperStop stops the background reaper.
+	deletionGracePeriod time.Duration
+	reaperStop          chan struct{}
+	reaperMu            sync.Mutex
+	// roleWatcherStop stops the background application of requested roles.
+	roleWatcherStop chan struct{}
+	roleWatcherMu   sync.Mutex
+	// preallocations cancels the running preallocations, by volume ID.
+	preallocations   map[string]context.CancelFunc
+	preallocationsMu sync.Mutex
 }
 
+// Levels of the events passed to an event sink, matching the types of
+// Kubernetes events.
+const (
+	EventNormal  = "Normal"
+	EventWarning = "Warning"
+)
+
+// DefaultIOWeightCgroup is the cgroup that contains all Kubernetes pods on
+// nodes using cgroup v2 with the cgroupfs driver. It is kubepods.slice with the
+// systemd driver.
+const DefaultIOWeightCgroup = "/sys/fs/cgroup/kubepods"
+
+// Default deadlines for the LINSTOR calls made by each operation.
+const (
+	DefaultCreateTimeout = 5 * time.Minute
+	DefaultDeleteTimeout = 2 * time.Minute
+	DefaultAttachTimeout = 2 * time.Minute
+	DefaultMountTimeout  = 1 * time.Minute
+	DefaultLookupTimeout = 30 * time.Second
+)
+
+// syncPollInterval is how often the disk state of a resource is checked while
+// waiting for it to sync.
+const syncPollInterval = 2 * time.Second
+
+// growTimeout bounds how long grown volumes may take until all their diskful
+// replicas report the new size.
+const growTimeout = 2 * time.Minute
+
+// nodeCacheTTL is how long results of ListNodes are reused.
+const nodeCacheTTL = 5 * time.Second
+
+// minNodeCacheTTL is how long results of ListNodes are reused right after the
+// controller changed. A new controller may still be catching up, so the TTL
+// starts from here and doubles with every refresh until it is nodeCacheTTL.
+const minNodeCacheTTL = 250 * time.Millisecond
+
+// eventPollInterval is how often WatchEvents polls LINSTOR for changes.
+const eventPollInterval = 5 * time.Second
+
+// remountWatchInterval is how often a watched filesystem is checked for having
+// been remounted read-only.
+const remountWatchInterval = 10 * time.Second
+
+// fstrimInterval is how often filesystems of volumes with discard set to
+// fstrim are trimmed.
+const fstrimInterval = 24 * time.Hour
+
+// preallocateTimeout bounds how long the preallocation of a volume may run.
+const preallocateTimeout = 12 * time.Hour
+
+// reapInterval is how often the background reaper removes volumes whose
+// deletion grace period elapsed.
+const reapInterval = time.Minute
+
+// roleWatchInterval is how often the role watcher looks for roles requested
+// for the resources on its node.
+const roleWatchInterval = 2 * time.Second
+
 // NewLinstor returns a high-level linstor client for CSI applications to interact with
 // By default, it will try to connect with localhost:3370.
 func NewLinstor(options ...func(*Linstor) error) (*Linstor, error) {
@@ -67,6 +246,15 @@ func NewLinstor(options ...func(*Linstor) error) (*Linstor, error) {
 		fallbackPrefix: "csi-",
 		log:            logrus.NewEntry(logrus.New()),
 		client:         c,
+		mounter:        newSafeMounter(),
+		createTimeout:  DefaultCreateTimeout,
+		deleteTimeout:  DefaultDeleteTimeout,
+		attachTimeout:  DefaultAttachTimeout,
+		mountTimeout:   DefaultMountTimeout,
+		lookupTimeout:  DefaultLookupTimeout,
+		tracer:         noopTracer{},
+		ioWeightCgroup: DefaultIOWeightCgroup,
+		managedBy:      DefaultManagedBy,
 	}
 
 	// run all option functions.
@@ -82,19 +270,97 @@ func NewLinstor(options ...func(*Linstor) error) (*Linstor, error) {
 		"linstorCSIComponent": "client",
 	})
 
-	l.mounter = &mount.SafeFormatAndMount{
-		Interface: mount.New("/bin/mount"),
-		Exec:      mount.NewOsExec(),
-	}
-
+	// Only log settings that are no secrets, the client also holds the master
+	// passphrase and the controller password.
 	l.log.WithFields(logrus.Fields{
-		"APIClient":       fmt.Sprintf("%+v", l.client),
-		"highLevelClient": fmt.Sprintf("%+v", l),
+		"APIClient":      fmt.Sprintf("%+v", l.client),
+		"fallbackPrefix": l.fallbackPrefix,
+		"nodeName":       l.nodeName,
+		"username":       l.username,
 	}).Debug("generated new linstor client")
 
 	return l, nil
 }
 
+// Close stops the background work of the client, like remount watchers, drops
+// its caches, and closes idle connections to the LINSTOR controller. It is safe
+// to call Close multiple times.
+func (s *Linstor) Close() error {
+	s.remountMu.Lock()
+	for target, stop := range s.remountWatchers {
+		close(stop)
+		delete(s.remountWatchers, target)
+	}
+	s.remountMu.Unlock()
+
+	s.fstrimMu.Lock()
+	for target, stop := range s.fstrimmers {
+		close(stop)
+		delete(s.fstrimmers, target)
+	}
+	s.fstrimMu.Unlock()
+
+	s.preallocationsMu.Lock()
+	for id, cancel := range s.preallocations {
+		cancel()
+		delete(s.preallocations, id)
+	}
+	s.preallocationsMu.Unlock()
+
+	s.reaperMu.Lock()
+	if s.reaperStop != nil {
+		close(s.reaperStop)
+		s.reaperStop = nil
+	}
+	s.reaperMu.Unlock()
+
+	s.roleWatcherMu.Lock()
+	if s.roleWatcherStop != nil {
+		close(s.roleWatcherStop)
+		s.roleWatcherStop = nil
+	}
+	s.roleWatcherMu.Unlock()
+
+	s.flushCaches()
+
+	if c, ok := s.transport.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+
+	s.log.Debug("closed client")
+	return nil
+}
+
+// ControllerChanged drops all caches of the client, since what they hold may
+// not match what the new controller knows. Node lists are cached only briefly
+// for a while afterwards. It is meant as HeaderTransport.OnControllerChange.
+func (s *Linstor) ControllerChanged(from, to string) {
+	s.flushCaches()
+
+	s.nodesMu.Lock()
+	s.nodesTTL = minNodeCacheTTL
+	s.nodesMu.Unlock()
+
+	s.log.WithFields(logrus.Fields{
+		"from": from,
+		"to":   to,
+	}).Info("LINSTOR controller changed, flushed caches")
+}
+
+// flushCaches drops the cached nodes and storage pool types.
+func (s *Linstor) flushCaches() {
+	atomic.AddUint64(&s.cacheGen, 1)
+
+	s.nodesMu.Lock()
+	s.nodes = nil
+	s.nodesExpiry = time.Time{}
+	s.nodesMu.Unlock()
+
+	s.poolTypesMu.Lock()
+	s.poolTypes = nil
+	s.poolTypesMu.Unlock()
+}
+
 // APIClient the configured LINSTOR API client that will be used to communicate
 // with the LINSTOR cluster.
 func APIClient(c *lc.HighLevelClient) func(*Linstor) error {
@@ -105,6 +371,298 @@ func APIClient(c *lc.HighLevelClient) func(*Linstor) error {
 	}
 }
 
+// MasterPassphrase sets the passphrase used to unlock the LINSTOR controller's
+// encryption master passphrase before encrypted volumes are created. If none
+// is set, the master passphrase must already be entered on the controller.
+func MasterPassphrase(passphrase string) func(*Linstor) error {
+	return func(l *Linstor) error {
+		l.masterPassphrase = passphrase
+		return nil
+	}
+}
+
+// NameSanitizer sets the function that turns volume and snapshot names into
+// LINSTOR resource names, in place of the default that replaces invalid
+// characters. The names it returns must still be valid LINSTOR names. Once
+// set, it also names resources of volumes without a ResourceNameTemplate,
+// which LINSTOR would name otherwise.
+func NameSanitizer(sanitize func(string) (string, error)) func(*Linstor) error {
+	return func(l *Linstor) error {
+		l.nameSanitizer = sanitize
+		return nil
+	}
+}
+
+// ResourceNameTemplate sets a text/template used to name new resource
+// definitions. It may refer to the CSI volume name as {{.Name}} and to the
+// name of the volume's storage class, if known, as {{.StorageClass}}.
+func ResourceNameTemplate(tmpl string) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if tmpl == "" {
+			l.nameTemplate = nil
+			return nil
+		}
+		t, err := template.New("resource-name").Option("missingkey=error").Parse(tmpl)
+		if err != nil {
+			return fmt.Errorf("invalid resource name template: %v", err)
+		}
+		l.nameTemplate = t
+		return nil
+	}
+}
+
+// NodeName sets the name of the LINSTOR node this plugin is running on.
+func NodeName(name string) func(*Linstor) error {
+	return func(l *Linstor) error {
+		l.nodeName = name
+		return nil
+	}
+}
+
+// Transport tells the client which http.RoundTripper its APIClient uses, so
+// that Close can close idle connections to the LINSTOR controller.
+func Transport(rt http.RoundTripper) func(*Linstor) error {
+	return func(l *Linstor) error {
+		l.transport = rt
+		return nil
+	}
+}
+
+// Tracing sets the Tracer that traces client operations. By default, nothing
+// is traced.
+func Tracing(t Tracer) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if t == nil {
+			t = noopTracer{}
+		}
+		l.tracer = t
+		return nil
+	}
+}
+
+// AllowForcePrimary enables ForcePrimary. Forcing a node to become primary
+// can make replicas diverge, so it is disabled by default.
+func AllowForcePrimary(allow bool) func(*Linstor) error {
+	return func(l *Linstor) error {
+		l.allowForcePrimary = allow
+		return nil
+	}
+}
+
+// CreateTimeout sets the deadline for creating a volume, including volumes
+// created from snapshots or other volumes.
+func CreateTimeout(d time.Duration) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if d <= 0 {
+			return fmt.Errorf("create timeout must be positive, got %v", d)
+		}
+		l.createTimeout = d
+		return nil
+	}
+}
+
+// DeleteTimeout sets the deadline for deleting a volume and its snapshots.
+func DeleteTimeout(d time.Duration) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if d <= 0 {
+			return fmt.Errorf("delete timeout must be positive, got %v", d)
+		}
+		l.deleteTimeout = d
+		return nil
+	}
+}
+
+// AttachTimeout sets the deadline for attaching a volume to, or detaching it
+// from, a node.
+func AttachTimeout(d time.Duration) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if d <= 0 {
+			return fmt.Errorf("attach timeout must be positive, got %v", d)
+		}
+		l.attachTimeout = d
+		return nil
+	}
+}
+
+// MountTimeout sets the deadline for the LINSTOR calls made while mounting a
+// volume. Local mount and filesystem operations are not bounded by it.
+func MountTimeout(d time.Duration) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if d <= 0 {
+			return fmt.Errorf("mount timeout must be positive, got %v", d)
+		}
+		l.mountTimeout = d
+		return nil
+	}
+}
+
+// LookupTimeout sets the deadline for looking up volumes by name or ID, so
+// that lookups fail rather than hang on an unresponsive controller.
+func LookupTimeout(d time.Duration) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if d <= 0 {
+			return fmt.Errorf("lookup timeout must be positive, got %v", d)
+		}
+		l.lookupTimeout = d
+		return nil
+	}
+}
+
+// MaxReplicas caps the number of replicas a volume may be created with. Zero
+// means no cap.
+func MaxReplicas(max int32) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if max < 0 {
+			return fmt.Errorf("max replicas must not be negative, got %d", max)
+		}
+		l.maxReplicas = max
+		return nil
+	}
+}
+
+// ClampReplicas sets whether volumes requesting more replicas than the
+// maximum are created with the maximum, rather than refused.
+func ClampReplicas(clamp bool) func(*Linstor) error {
+	return func(l *Linstor) error {
+		l.clampReplicas = clamp
+		return nil
+	}
+}
+
+// DeletionGracePeriod defers the removal of deleted volumes by d. Until then
+// they are only marked as deleted and may be restored by creating a volume of
+// the same name and size. Zero, the default, removes volumes right away.
+func DeletionGracePeriod(d time.Duration) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if d < 0 {
+			return fmt.Errorf("deletion grace period must not be negative, got %v", d)
+		}
+		l.deletionGracePeriod = d
+		return nil
+	}
+}
+
+// ClampMinimumSize sets whether volumes limited to less than LINSTOR's
+// minimum volume size are created with the minimum size, rather than refused.
+func ClampMinimumSize(clamp bool) func(*Linstor) error {
+	return func(l *Linstor) error {
+		l.clampMinimumSize = clamp
+		return nil
+	}
+}
+
+// IOWeightCgroup sets the cgroup containing the pod cgroups in which the
+// ioWeight parameter of volumes is applied to their devices.
+func IOWeightCgroup(path string) func(*Linstor) error {
+	return func(l *Linstor) error {
+		l.ioWeightCgroup = path
+		return nil
+	}
+}
+
+// SnapshotReserve sets the percentage of a thin storage pool's capacity that
+// must be free for snapshots of volumes in that pool to be created.
+func SnapshotReserve(percent float64) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("snapshot reserve must be a percentage between 0 and 100, got %v", percent)
+		}
+		l.snapshotReserve = percent
+		return nil
+	}
+}
+
+// EventSink sets a callback that receives events about volumes, like
+// placement decisions, failed attachments, and degraded replicas, e.g. for
+// turning them into Kubernetes events. Level is EventNormal or EventWarning,
+// reason is a short CamelCase identifier. The callback must not block.
+func EventSink(sink func(level, reason, message string)) func(*Linstor) error {
+	return func(l *Linstor) error {
+		l.eventSink = sink
+		return nil
+	}
+}
+
+// MaxConcurrentResyncs limits how many volumes may resync on a node at the
+// same time. Creating volumes and adding replicas waits for other resyncs to
+// finish, in the order of the resyncPriority of volumes. Zero means no limit.
+func MaxConcurrentResyncs(n int) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if n < 0 {
+			return fmt.Errorf("maximum number of concurrent resyncs must not be negative, got %d", n)
+		}
+		l.maxConcurrentResyncs = n
+		return nil
+	}
+}
+
+// DefaultStoragePool sets the storage pool of volumes whose parameters name
+// none, rather than leaving the choice to LINSTOR.
+func DefaultStoragePool(pool string) func(*Linstor) error {
+	return func(l *Linstor) error {
+		l.defaultStoragePool = pool
+		return nil
+	}
+}
+
+// PoolSnapshotReserve overrides the snapshot reserve for storage pools, keyed
+// by storage pool name.
+func PoolSnapshotReserve(reserves map[string]float64) func(*Linstor) error {
+	return func(l *Linstor) error {
+		for pool, percent := range reserves {
+			if percent < 0 || percent > 100 {
+				return fmt.Errorf("snapshot reserve of storage pool %s must be a percentage between 0 and 100, got %v", pool, percent)
+			}
+		}
+		l.poolSnapshotReserve = reserves
+		return nil
+	}
+}
+
+// DefaultManagedBy is the managed-by marker of resource definitions unless
+// ManagedBy sets another.
+const DefaultManagedBy = "linstor.csi.linbit.com"
+
+// ManagedBy sets the marker recorded on the resource definitions this client
+// creates. Resource definitions with a different marker are not considered
+// volumes of this client, and are never deleted by it.
+func ManagedBy(id string) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if id == "" {
+			return fmt.Errorf("managed-by marker must not be empty")
+		}
+		l.managedBy = id
+		return nil
+	}
+}
+
+// PoolVolumeQuota limits the number of volumes that may be created in storage
+// pools, keyed by storage pool name.
+func PoolVolumeQuota(quotas map[string]int) func(*Linstor) error {
+	return func(l *Linstor) error {
+		for pool, count := range quotas {
+			if count < 0 {
+				return fmt.Errorf("volume quota of storage pool %s must not be negative, got %d", pool, count)
+			}
+		}
+		l.poolVolumeQuota = quotas
+		return nil
+	}
+}
+
+// MaxAnnotationSize limits the size in bytes of the serialized volumes stored
+// in the properties of resource definitions. Volumes whose serialization is
+// larger are not stored, an error is returned instead. Zero means no limit.
+func MaxAnnotationSize(bytes int) func(*Linstor) error {
+	return func(l *Linstor) error {
+		if bytes < 0 {
+			return fmt.Errorf("maximum annotation size must not be negative, got %d", bytes)
+		}
+		l.maxAnnotationSize = bytes
+		return nil
+	}
+}
+
 // LogOut sets the Linstor client to write logs to the provided io.Writer
 // instead of discarding logs.
 func LogOut(out io.Writer) func(*Linstor) error {
@@ -144,19 +702,32 @@ func LogLevel(s string) func(*Linstor) error {
 // ListAll returns a sorted list of pointers to volume.Info. Only the LINSTOR
 // volumes that can be serialized into a volume.Info are included.
 func (s *Linstor) ListAll(ctx context.Context) ([]*volume.Info, error) {
+	vols, err := s.listAll(ctx)
+	if err != nil {
+		return make([]*volume.Info, 0), nil
+	}
+	return vols, nil
+}
+
+// listAll is ListAll, but returns the error if the resource definitions can't
+// be listed, for callers that must not mistake that for no volumes.
+func (s *Linstor) listAll(ctx context.Context) ([]*volume.Info, error) {
 	var vols = make([]*volume.Info, 0)
 
 	resDefs, err := s.client.ResourceDefinitions.GetAll(ctx)
 	if err != nil {
-		return vols, nil
+		return nil, fmt.Errorf("unable to list resource definitions: %v", err)
 	}
 
 	for _, rd := range resDefs {
 		vol, err := s.resourceDefinitionToVolume(rd)
-		if err != nil {
+		if err != nil || vol == nil {
 			// Not a volume created by us, apparently.
 			continue
 		}
+		if vol.Deleted {
+			continue
+		}
 
 		vols = append(vols, vol)
 	}
@@ -165,773 +736,5989 @@ func (s *Linstor) ListAll(ctx context.Context) ([]*volume.Info, error) {
 	return vols, nil
 }
 
-// AllocationSizeKiB returns LINSTOR's smallest possible number of KiB that can
-// satisfy the requiredBytes.
-func (s *Linstor) AllocationSizeKiB(requiredBytes, limitBytes int64) (int64, error) {
+// VolumeAge returns how long ago vol was created. It returns false for
+// volumes without a recorded creation time, e.g. those created by old
+// versions of this plugin.
+func VolumeAge(vol *volume.Info) (time.Duration, bool) {
+	if vol.CreationTime.IsZero() {
+		return 0, false
+	}
+	return time.Since(vol.CreationTime), true
+}
 
-	requestedSize := data.ByteSize(requiredBytes)
-	minVolumeSize := data.ByteSize(4096)
-	maxVolumeSize := data.ByteSize(limitBytes)
-	unlimited := maxVolumeSize == 0
-	if minVolumeSize > maxVolumeSize && !unlimited {
-		return 0, fmt.Errorf("LINSTOR's minimum volume size exceeds the maximum size limit of the requested volume")
+// ListByNode returns the assignments of all volumes on node, diskful and
+// diskless ones, in the order of the volumes.
+func (s *Linstor) ListByNode(ctx context.Context, node string) ([]*volume.Assignment, error) {
+	vols, err := s.ListAll(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if requestedSize < minVolumeSize {
-		requestedSize = minVolumeSize
+
+	resources, err := s.client.Resources.GetResourceView(ctx, &lapi.ListOpts{Node: []string{node}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list assignments on node %s: %v", node, err)
 	}
 
-	// make sure there are enough KiBs to fit the required number of bytes,
-	// e.g. 1025 bytes require 2 KiB worth of space to be allocated.
-	volumeSize := data.NewKibiByte(data.NewKibiByte(requestedSize).InclusiveBytes())
+	return assignmentsOnNode(vols, resources, node), nil
+}
 
-	limit := data.NewByte(maxVolumeSize)
+// assignmentsOnNode returns the assignments of vols on node among resources.
+func assignmentsOnNode(vols []*volume.Info, resources []lapi.Resource, node string) []*volume.Assignment {
+	var onNode = make(map[string]lapi.Resource)
+	for _, r := range resources {
+		if r.NodeName == node {
+			onNode[r.Name] = r
+		}
+	}
 
-	if volumeSize.InclusiveBytes() > limit.InclusiveBytes() && !unlimited {
-		return int64(volumeSize.Value()),
-			fmt.Errorf("got request for %d bytes of storage, but needed to allocate %d more bytes than the %d byte limit",
-				requiredBytes, int64(volumeSize.To(data.B)-limit.To(data.B)), int64(limit.To(data.B)))
+	var assignments = make([]*volume.Assignment, 0, len(onNode))
+	for _, vol := range vols {
+		r, ok := onNode[vol.ID]
+		if !ok {
+			continue
+		}
+		va := &volume.Assignment{
+			Vol:      vol,
+			Node:     node,
+			Diskless: containsOpt(r.Flags, apiconst.FlagDiskless),
+		}
+		if len(r.Volumes) != 0 {
+			va.Path = r.Volumes[0].DevicePath
+		}
+		assignments = append(assignments, va)
 	}
-	return int64(volumeSize.Value()), nil
+	return assignments
 }
 
-// resourceDefinitionToVolume reads the serialized volume info on the lapi.ResourceDefinition
-// and contructs a pointer to a volume.Info from it.
-func (s *Linstor) resourceDefinitionToVolume(resDef lapi.ResourceDefinition) (*volume.Info, error) {
-	csiVolumeAnnotation, ok := resDef.Props[linstor.AnnotationsKey]
-	if !ok {
-		return nil, fmt.Errorf("unable to find CSI volume annotation on resource %+v", resDef)
+// ListByPool returns a sorted list of pointers to volume.Info of the volumes
+// that are configured to use the named storage pool, or have replicas in it.
+// Unlike ListAll, it fails if the volumes can't be listed.
+func (s *Linstor) ListByPool(ctx context.Context, pool string) ([]*volume.Info, error) {
+	vols, err := s.listAll(ctx)
+	if err != nil {
+		return nil, err
 	}
-	vol := &volume.Info{
-		Parameters: make(map[string]string),
-		Snapshots:  make([]*volume.SnapInfo, 0),
+
+	resources, err := s.client.Resources.GetResourceView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine storage pools of volumes: %v", err)
 	}
-	if err := json.Unmarshal([]byte(csiVolumeAnnotation), vol); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal annotations for ResDef %+v", resDef)
+
+	var placed = make(map[string]bool)
+	for _, r := range resources {
+		for _, v := range r.Volumes {
+			if v.StoragePool == pool {
+				placed[r.Name] = true
+			}
+		}
 	}
 
-	if vol.Name == "" {
-		return nil, fmt.Errorf("failed to extract resource name from %+v", vol)
+	var inPool = make([]*volume.Info, 0)
+	for _, vol := range vols {
+		params, err := volume.NewParameters(vol.Parameters)
+		if err != nil {
+			continue
+		}
+		if params.StoragePool == pool || placed[vol.ID] {
+			inPool = append(inPool, vol)
+		}
 	}
 
-	s.log.WithFields(logrus.Fields{
-		"resourceDefinition": fmt.Sprintf("%+v", resDef),
-		"volume":             fmt.Sprintf("%+v", vol),
+	return inPool, nil
+}
+
+// PoolVolumeUsage returns the number of volumes in the named storage pool, as
+// counted by ListByPool, and its volume quota, which is zero if there is none.
+func (s *Linstor) PoolVolumeUsage(ctx context.Context, pool string) (int, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.lookupTimeout)
+	defer cancel()
+
+	vols, err := s.ListByPool(ctx, pool)
+	if err != nil {
+		return 0, 0, timeoutErr(ctx, "counting volumes", pool, err)
+	}
+	return len(vols), s.poolVolumeQuota[pool], nil
+}
+
+// PoolQuotaError is returned by Create if the storage pool of a volume holds
+// as many volumes as its quota allows.
+type PoolQuotaError struct {
+	Pool  string
+	Quota int
+}
+
+func (e *PoolQuotaError) Error() string {
+	return fmt.Sprintf("storage pool %s reached its quota of %d volumes", e.Pool, e.Quota)
+}
+
+// checkPoolQuota refuses volumes whose storage pool has reached its volume
+// quota. Volumes without a storage pool are left to LINSTOR to place, so no
+// quota applies to them.
+func (s *Linstor) checkPoolQuota(ctx context.Context, vol *volume.Info) error {
+	if len(s.poolVolumeQuota) == 0 {
+		return nil
+	}
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	quota, ok := s.poolVolumeQuota[params.StoragePool]
+	if !ok || params.StoragePool == "" {
+		return nil
+	}
+
+	vols, err := s.ListByPool(ctx, params.StoragePool)
+	if err != nil {
+		return fmt.Errorf("unable to check volume quota of storage pool %s: %v", params.StoragePool, err)
+	}
+	if countOthers(vols, vol.ID) >= quota {
+		return &PoolQuotaError{Pool: params.StoragePool, Quota: quota}
+	}
+	return nil
+}
+
+// countOthers returns the number of vols that are not the volume with id, so
+// that retried creations do not count themselves.
+func countOthers(vols []*volume.Info, id string) int {
+	var n int
+	for _, v := range vols {
+		if v.ID != id {
+			n++
+		}
+	}
+	return n
+}
+
+// VolumeSizeDrift is a volume whose stored size differs from the size of its
+// devices.
+type VolumeSizeDrift struct {
+	Volume *volume.Info
+	// StoredBytes is the size recorded for the volume.
+	StoredBytes int64
+	// ActualBytes is the usable size of the smallest diskful replica.
+	ActualBytes int64
+}
+
+// SizeDrift returns the volumes whose stored size differs from the usable
+// size of their diskful replicas by more than thresholdBytes.
+func (s *Linstor) SizeDrift(ctx context.Context, thresholdBytes int64) ([]VolumeSizeDrift, error) {
+	vols, err := s.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := s.client.Resources.GetResourceView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine volume sizes: %v", err)
+	}
+
+	var actual = make(map[string]int64)
+	for _, r := range resources {
+		if !util.DeployedDiskfully(r) {
+			continue
+		}
+		for _, v := range r.Volumes {
+			size := int64(data.NewKibiByte(data.KiB * data.ByteSize(v.UsableSizeKib)).To(data.B))
+			if cur, ok := actual[r.Name]; !ok || size < cur {
+				actual[r.Name] = size
+			}
+		}
+	}
+
+	var drifts = make([]VolumeSizeDrift, 0)
+	for _, vol := range vols {
+		size, ok := actual[vol.ID]
+		if !ok {
+			continue
+		}
+
+		diff := size - vol.SizeBytes
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > thresholdBytes {
+			drifts = append(drifts, VolumeSizeDrift{Volume: vol, StoredBytes: vol.SizeBytes, ActualBytes: size})
+			s.emit(EventWarning, "SizeDrift", "volume %s is recorded with %d bytes, but its replicas provide %d bytes",
+				vol.ID, vol.SizeBytes, size)
+		}
+	}
+
+	return drifts, nil
+}
+
+// ReplicaUsage returns the bytes allocated by each diskful replica of the
+// volume, by node. Thinly provisioned replicas that allocated far more than
+// their peers point to a problem with the replica or its pool.
+func (s *Linstor) ReplicaUsage(ctx context.Context, vol *volume.Info) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.lookupTimeout)
+	defer cancel()
+
+	resources, err := s.client.Resources.GetResourceView(ctx, &lapi.ListOpts{Resource: []string{vol.ID}})
+	if err != nil {
+		return nil, timeoutErr(ctx, "determining replica usage", vol.ID, err)
+	}
+	return replicaUsage(resources, vol.ID), nil
+}
+
+// replicaUsage sums the allocated size of the volumes of each diskful
+// resource named id. Diskless resources allocate no storage and are left out.
+func replicaUsage(resources []lapi.Resource, id string) map[string]int64 {
+	var usage = make(map[string]int64)
+	for _, r := range resources {
+		if r.Name != id || !util.DeployedDiskfully(r) {
+			continue
+		}
+		var allocated int64
+		for _, v := range r.Volumes {
+			allocated += int64(data.NewKibiByte(data.KiB * data.ByteSize(v.AllocatedSizeKib)).To(data.B))
+		}
+		usage[r.NodeName] = allocated
+	}
+	return usage
+}
+
+// emit passes an event to the event sink, if there is one.
+func (s *Linstor) emit(level, reason, format string, args ...interface{}) {
+	if s.eventSink != nil {
+		s.eventSink(level, reason, fmt.Sprintf(format, args...))
+	}
+}
+
+// AllocationSizeKiB returns LINSTOR's smallest possible number of KiB that can
+// satisfy the requiredBytes.
+func (s *Linstor) AllocationSizeKiB(requiredBytes, limitBytes int64) (int64, error) {
+
+	requestedSize := data.ByteSize(requiredBytes)
+	minVolumeSize := data.ByteSize(4096)
+	maxVolumeSize := data.ByteSize(limitBytes)
+	unlimited := maxVolumeSize == 0
+	if minVolumeSize > maxVolumeSize && !unlimited {
+		if !s.clampMinimumSize {
+			return 0, fmt.Errorf("LINSTOR's minimum volume size exceeds the maximum size limit of the requested volume")
+		}
+		s.log.WithFields(logrus.Fields{
+			"limitBytes":   limitBytes,
+			"minimumBytes": int64(minVolumeSize),
+		}).Warn("size limit is below LINSTOR's minimum volume size, raising it to the minimum")
+		maxVolumeSize = minVolumeSize
+	}
+	if requestedSize < minVolumeSize {
+		requestedSize = minVolumeSize
+	}
+
+	// make sure there are enough KiBs to fit the required number of bytes,
+	// e.g. 1025 bytes require 2 KiB worth of space to be allocated.
+	volumeSize := data.NewKibiByte(data.NewKibiByte(requestedSize).InclusiveBytes())
+
+	limit := data.NewByte(maxVolumeSize)
+
+	if volumeSize.InclusiveBytes() > limit.InclusiveBytes() && !unlimited {
+		return int64(volumeSize.Value()),
+			fmt.Errorf("got request for %d bytes of storage, but needed to allocate %d more bytes than the %d byte limit",
+				requiredBytes, int64(volumeSize.To(data.B)-limit.To(data.B)), int64(limit.To(data.B)))
+	}
+	return int64(volumeSize.Value()), nil
+}
+
+// resourceDefinitionToVolume reads the serialized volume info on the lapi.ResourceDefinition
+// and contructs a pointer to a volume.Info from it. Resource definitions with
+// corrupt annotations are logged and skipped by returning a nil volume.Info
+// without an error, so that a single bad record doesn't break enumeration.
+func (s *Linstor) resourceDefinitionToVolume(resDef lapi.ResourceDefinition) (*volume.Info, error) {
+	if s.managedByOther(resDef) {
+		s.log.WithFields(logrus.Fields{
+			"resourceDefinition": resDef.Name,
+			"managedBy":          resDef.Props[linstor.ManagedByKey],
+		}).Debug("skipping resource managed by others")
+		return nil, nil
+	}
+
+	csiVolumeAnnotation, ok := resDef.Props[linstor.AnnotationsKey]
+	if !ok {
+		return nil, fmt.Errorf("unable to find CSI volume annotation on resource %+v", resDef)
+	}
+	vol := &volume.Info{
+		Parameters: make(map[string]string),
+		Snapshots:  make([]*volume.SnapInfo, 0),
+	}
+	if err := json.Unmarshal([]byte(csiVolumeAnnotation), vol); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"resourceDefinition": resDef.Name,
+		}).WithError(err).Error("failed to unmarshal volume annotations, skipping resource")
+		s.recordCorruptAnnotation(resDef.Name, true)
+		return nil, nil
+	}
+	s.recordCorruptAnnotation(resDef.Name, false)
+
+	if vol.Name == "" {
+		return nil, fmt.Errorf("failed to extract resource name from %+v", vol)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"resourceDefinition": fmt.Sprintf("%+v", resDef),
+		"volume":             fmt.Sprintf("%+v", vol),
 	}).Debug("converted resource definition to volume")
 
-	return vol, nil
-}
+	return vol, nil
+}
+
+// managedByOther returns true if resDef is marked as managed by someone else.
+// Resource definitions without a marker were created before markers were
+// introduced, so they are considered managed by this client.
+func (s *Linstor) managedByOther(resDef lapi.ResourceDefinition) bool {
+	marker, ok := resDef.Props[linstor.ManagedByKey]
+	return ok && marker != s.managedBy
+}
+
+func (s *Linstor) recordCorruptAnnotation(resName string, corrupt bool) {
+	s.corruptMu.Lock()
+	defer s.corruptMu.Unlock()
+
+	if s.corruptAnnotations == nil {
+		s.corruptAnnotations = make(map[string]bool)
+	}
+	if corrupt {
+		s.corruptAnnotations[resName] = true
+	} else {
+		delete(s.corruptAnnotations, resName)
+	}
+}
+
+// CorruptAnnotations returns a sorted list of the resource definitions that
+// were skipped because their volume annotations could not be read.
+func (s *Linstor) CorruptAnnotations() []string {
+	s.corruptMu.Lock()
+	defer s.corruptMu.Unlock()
+
+	var names = make([]string, 0, len(s.corruptAnnotations))
+	for name := range s.corruptAnnotations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// RebuildAnnotation reconstructs the volume annotation of the resource
+// definition resourceName from its live state in LINSTOR, for volumes whose
+// annotation was lost or corrupted. Size, storage pools, replica count, layers,
+// and snapshots are recovered, other parameters are lost. createdBy is the
+// name of the CSI driver that owns the volume. Resources with a readable
+// annotation are left alone.
+func (s *Linstor) RebuildAnnotation(ctx context.Context, resourceName, createdBy string) (*volume.Info, error) {
+	rd, err := s.client.ResourceDefinitions.Get(ctx, resourceName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find resource definition %s: %v", resourceName, err)
+	}
+
+	if annotation, ok := rd.Props[linstor.AnnotationsKey]; ok {
+		var existing volume.Info
+		if err := json.Unmarshal([]byte(annotation), &existing); err == nil && existing.Name != "" {
+			return nil, fmt.Errorf("resource definition %s already has a valid volume annotation", resourceName)
+		}
+	}
+
+	volDef, err := s.client.ResourceDefinitions.GetVolumeDefinition(ctx, resourceName, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine size of %s: %v", resourceName, err)
+	}
+
+	resources, err := s.client.Resources.GetResourceView(ctx, &lapi.ListOpts{Resource: []string{resourceName}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine placement of %s: %v", resourceName, err)
+	}
+
+	snaps, err := s.client.Resources.GetSnapshots(ctx, resourceName)
+	if nil404(err) != nil {
+		return nil, fmt.Errorf("unable to determine snapshots of %s: %v", resourceName, err)
+	}
+
+	vol := rebuildVolume(rd, volDef, resources, snaps, createdBy)
+
+	s.log.WithFields(logrus.Fields{
+		"resourceDefinition": resourceName,
+		"volume":             fmt.Sprintf("%+v", vol),
+	}).Warn("rebuilding volume annotation from LINSTOR state")
+
+	if err := s.saveVolume(ctx, vol); err != nil {
+		return nil, fmt.Errorf("unable to save rebuilt volume annotation of %s: %v", resourceName, err)
+	}
+	s.recordCorruptAnnotation(resourceName, false)
+
+	return vol, nil
+}
+
+// rebuildVolume reconstructs a best-effort volume.Info from the LINSTOR
+// objects of its resource definition.
+func rebuildVolume(rd lapi.ResourceDefinition, volDef lapi.VolumeDefinition, resources []lapi.Resource, snaps []lapi.Snapshot, createdBy string) *volume.Info {
+	name := rd.ExternalName
+	if name == "" {
+		name = rd.Name
+	}
+
+	vol := &volume.Info{
+		Name:         name,
+		ID:           rd.Name,
+		CreatedBy:    createdBy,
+		CreationTime: time.Now(),
+		SizeBytes:    int64(data.NewKibiByte(data.KiB * data.ByteSize(volDef.SizeKib)).InclusiveBytes()),
+		Parameters:   make(map[string]string),
+		Snapshots:    make([]*volume.SnapInfo, 0),
+	}
+
+	var layers []string
+	for _, l := range rd.LayerData {
+		layers = append(layers, strings.ToLower(string(l.Type)))
+		if l.Type == lapi.LUKS {
+			vol.Parameters["encryption"] = "true"
+		}
+	}
+	if len(layers) != 0 {
+		vol.Parameters["layerList"] = strings.Join(layers, " ")
+	}
+
+	var diskful int
+	for _, r := range resources {
+		pool := ""
+		if len(r.Volumes) != 0 {
+			pool = r.Volumes[0].StoragePool
+		}
+		switch {
+		case util.DeployedDiskfully(r):
+			diskful++
+			if pool != "" {
+				vol.Parameters["storagePool"] = pool
+			}
+		case util.DeployedDisklessly(r):
+			if pool != "" {
+				vol.Parameters["disklessStoragePool"] = pool
+			}
+		}
+	}
+	if diskful != 0 {
+		vol.Parameters["placementCount"] = strconv.Itoa(diskful)
+	}
+
+	// LINSTOR doesn't record when snapshots were taken, so they all get the
+	// time of the rebuild.
+	for _, snap := range snaps {
+		var size int64
+		if len(snap.VolumeDefinitions) != 0 {
+			size = int64(data.NewKibiByte(data.KiB * data.ByteSize(snap.VolumeDefinitions[0].SizeKib)).InclusiveBytes())
+		}
+		vol.Snapshots = append(vol.Snapshots, &volume.SnapInfo{
+			Name: snap.Name,
+			CsiSnap: &csi.Snapshot{
+				SnapshotId:     snap.Name,
+				SourceVolumeId: vol.ID,
+				SizeBytes:      size,
+				CreationTime:   ptypes.TimestampNow(),
+				ReadyToUse:     true,
+			},
+		})
+	}
+
+	return vol
+}
+
+// GetByName retrives a volume.Info that has a name that matches the CSI volume
+// Name, not nessesarily the LINSTOR resource name or UUID.
+func (s *Linstor) GetByName(ctx context.Context, name string) (*volume.Info, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.lookupTimeout)
+	defer cancel()
+
+	vol, err := s.getByName(ctx, name)
+	return vol, timeoutErr(ctx, "lookup", name, err)
+}
+
+func (s *Linstor) getByName(ctx context.Context, name string) (*volume.Info, error) {
+	s.log.WithFields(logrus.Fields{
+		"csiVolumeName": name,
+	}).Debug("looking up resource by CSI volume name")
+
+	list, err := s.client.ResourceDefinitions.GetAll(ctx)
+	if err != nil {
+		return nil, nil404(err)
+	}
+
+	for _, rd := range list {
+		vol, err := s.resourceDefinitionToVolume(rd)
+		// Probably found a resource we didn't create.
+		if err != nil || vol == nil {
+			continue
+		}
+
+		if vol.Name == name && !vol.Deleted {
+			return vol, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetByID retrives a volume.Info that has an id that matches the CSI volume
+// id. Matches the LINSTOR resource name. Unlike the other lookups, this also
+// returns volumes that were deleted but kept for their snapshots, so that
+// those snapshots can still be used.
+func (s *Linstor) GetByID(ctx context.Context, id string) (*volume.Info, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.lookupTimeout)
+	defer cancel()
+
+	vol, err := s.getByID(ctx, id)
+	return vol, timeoutErr(ctx, "lookup", id, err)
+}
+
+func (s *Linstor) getByID(ctx context.Context, id string) (*volume.Info, error) {
+	s.log.WithFields(logrus.Fields{
+		"csiVolumeID": id,
+	}).Debug("looking up resource by CSI volume id")
+
+	res, err := s.client.ResourceDefinitions.Get(ctx, id)
+	if err != nil {
+		return nil, nil404(err)
+	}
+
+	return s.resourceDefinitionToVolume(res)
+}
+
+// GetManyByID retrieves the volume.Info of every volume in ids with a single
+// listing of LINSTOR resource definitions. IDs that don't match a volume are
+// not part of the result.
+func (s *Linstor) GetManyByID(ctx context.Context, ids []string) (map[string]*volume.Info, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.lookupTimeout)
+	defer cancel()
+
+	vols, err := s.getManyByID(ctx, ids)
+	return vols, timeoutErr(ctx, "lookup", strings.Join(ids, ", "), err)
+}
+
+func (s *Linstor) getManyByID(ctx context.Context, ids []string) (map[string]*volume.Info, error) {
+	s.log.WithFields(logrus.Fields{
+		"csiVolumeIDs": ids,
+	}).Debug("looking up resources by CSI volume ids")
+
+	var vols = make(map[string]*volume.Info, len(ids))
+	if len(ids) == 0 {
+		return vols, nil
+	}
+
+	var wanted = make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	list, err := s.client.ResourceDefinitions.GetAll(ctx)
+	if err != nil {
+		return nil, nil404(err)
+	}
+
+	for _, rd := range list {
+		if !wanted[rd.Name] {
+			continue
+		}
+
+		vol, err := s.resourceDefinitionToVolume(rd)
+		// Probably found a resource we didn't create.
+		if err != nil || vol == nil || vol.Deleted {
+			continue
+		}
+		vols[rd.Name] = vol
+	}
+
+	return vols, nil
+}
+
+// Create creates the resource definition, volume definition, and assigns the
+// resulting resource to LINSTOR nodes.
+func (s *Linstor) Create(ctx context.Context, vol *volume.Info, req *csi.CreateVolumeRequest) error {
+	ctx, span := s.startSpan(ctx, "create", vol.Name)
+	ctx, cancel := context.WithTimeout(ctx, s.createTimeout)
+	defer cancel()
+
+	err := timeoutErr(ctx, "create", vol.Name, s.create(ctx, vol, req))
+	if err != nil {
+		s.emit(EventWarning, "ProvisioningFailed", "failed to create volume %s: %v", vol.Name, err)
+	}
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) create(ctx context.Context, vol *volume.Info, req *csi.CreateVolumeRequest) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume": fmt.Sprintf("%+v", vol),
+	}).Info("creating volume")
+
+	if restored, err := s.undelete(ctx, vol); err != nil || restored {
+		return err
+	}
+
+	if err := s.capReplicas(vol); err != nil {
+		return err
+	}
+
+	if err := s.applyTier(ctx, vol); err != nil {
+		return err
+	}
+
+	if err := s.applyDefaultStoragePool(vol); err != nil {
+		return err
+	}
+
+	if err := s.chooseStoragePool(ctx, vol); err != nil {
+		return err
+	}
+
+	if err := s.checkPoolQuota(ctx, vol); err != nil {
+		return err
+	}
+
+	if err := s.checkPlacement(ctx, vol); err != nil {
+		return err
+	}
+
+	if err := s.checkEligibleNodes(ctx, vol); err != nil {
+		return err
+	}
+
+	if err := s.checkPreallocation(ctx, vol); err != nil {
+		return err
+	}
+
+	if err := s.createResourceDefinition(ctx, vol); err != nil {
+		return err
+	}
+
+	// Create the volume definition, now that vol has been updated with its ID.
+	if err := s.createVolumeDefinition(ctx, vol); err != nil {
+		return err
+	}
+
+	if err := s.placePreferredLocal(ctx, vol, req); err != nil {
+		return err
+	}
+
+	volumeScheduler, err := s.schedulerByPlacementPolicy(vol)
+	if err != nil {
+		return err
+	}
+	release, err := s.waitForResyncSlot(ctx, vol)
+	if err != nil {
+		return err
+	}
+	err = volumeScheduler.Create(ctx, vol, req)
+	release()
+	if err != nil {
+		return err
+	}
+
+	if err := s.addTiebreaker(ctx, vol); err != nil {
+		return err
+	}
+
+	if s.eventSink != nil {
+		if resources, err := s.client.Resources.GetAll(ctx, vol.ID); err == nil {
+			s.emit(EventNormal, "Placed", "placed replicas of %s on nodes %v, diskless on nodes %v",
+				vol.ID, util.DeployedDiskfullyNodes(resources), util.DeployedDisklesslyNodes(resources))
+		}
+	}
+
+	s.startPreallocation(vol)
+	return nil
+}
+
+// addTiebreaker adds a diskless assignment to volumes with two diskful
+// replicas and no diskless ones, if they have a tiebreaker diskless pool, so
+// that the replicas keep quorum when one of them is lost.
+func (s *Linstor) addTiebreaker(ctx context.Context, vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if params.TiebreakerDisklessPool == "" {
+		return nil
+	}
+
+	resources, err := s.client.Resources.GetAll(ctx, vol.ID)
+	if err != nil {
+		return fmt.Errorf("unable to find assignments of %s: %v", vol.ID, err)
+	}
+	if len(util.DeployedDiskfullyNodes(resources)) != 2 || len(resources) != 2 {
+		return nil
+	}
+
+	pools, err := s.client.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to find diskless storage pool %s for a tiebreaker: %v", params.TiebreakerDisklessPool, err)
+	}
+	node := tiebreakerNode(pools, resources, params.TiebreakerDisklessPool)
+	if node == "" {
+		return fmt.Errorf("unable to add tiebreaker to %s, no node without a replica has diskless storage pool %s",
+			vol.ID, params.TiebreakerDisklessPool)
+	}
+
+	rc, err := vol.ToTiebreakerResourceCreate(node)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Resources.Create(ctx, rc); err != nil {
+		return fmt.Errorf("unable to add tiebreaker to %s on node %s: %v", vol.ID, node, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":     vol.ID,
+		"targetNode": node,
+		"pool":       params.TiebreakerDisklessPool,
+	}).Info("added tiebreaker")
+	return nil
+}
+
+// tiebreakerNode returns the first node by name that has the diskless storage
+// pool and none of resources, or an empty string if there is none.
+func tiebreakerNode(pools []lapi.StoragePool, resources []lapi.Resource, pool string) string {
+	var taken = make(map[string]bool, len(resources))
+	for _, r := range resources {
+		taken[r.NodeName] = true
+	}
+
+	var candidates []string
+	for _, sp := range pools {
+		if sp.StoragePoolName == pool && sp.ProviderKind == lapi.DISKLESS && !taken[sp.NodeName] {
+			candidates = append(candidates, sp.NodeName)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Strings(candidates)
+	return candidates[0]
+}
+
+// placePreferredLocal places a diskful replica of autoplaced volumes that
+// prefer local replicas on the most preferred node of req, before the other
+// replicas are placed. Autoplacing counts it towards the placement count.
+func (s *Linstor) placePreferredLocal(ctx context.Context, vol *volume.Info, req *csi.CreateVolumeRequest) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if !params.PreferLocal || params.PlacementPolicy != topology.AutoPlace {
+		return nil
+	}
+
+	if node := preferredNode(req); node != "" && !params.Excludes(node) {
+		s.placeLocal(ctx, vol, node)
+	}
+	return nil
+}
+
+// preferredNode returns the most preferred node of the topology requirements
+// of req, or an empty string if there is none.
+func preferredNode(req *csi.CreateVolumeRequest) string {
+	for _, pref := range req.GetAccessibilityRequirements().GetPreferred() {
+		if node, ok := pref.GetSegments()[topology.LinstorNodeKey]; ok {
+			return node
+		}
+	}
+	return ""
+}
+
+// placeLocal tries to place a diskful replica of vol on node, returning false
+// if that failed, e.g. for lack of capacity on the node.
+func (s *Linstor) placeLocal(ctx context.Context, vol *volume.Info, node string) bool {
+	release, err := s.waitForResyncSlot(ctx, vol, node)
+	if err == nil {
+		var rc lapi.ResourceCreate
+		rc, err = vol.ToDiskfullResourceCreate(node)
+		if err == nil {
+			err = s.client.Resources.Create(ctx, rc)
+		}
+		release()
+	}
+	if err == nil {
+		return true
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":     vol.ID,
+		"targetNode": node,
+	}).WithError(err).Warn("unable to place a local diskful replica, falling back to remote replicas")
+	s.rollbackAssignment(vol, node)
+	return false
+}
+
+// checkPlacement returns an error if the nodes or storage pools of an explicit
+// placement of vol do not exist.
+func (s *Linstor) checkPlacement(ctx context.Context, vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if params.Placement == nil {
+		return nil
+	}
+
+	pools, err := s.client.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to check placement of %s: %v", vol.Name, err)
+	}
+	return placementError(params, pools)
+}
+
+// placementError returns why the explicit placement of params is impossible
+// with pools, or nil if it is possible.
+func placementError(params volume.Parameters, pools []lapi.StoragePool) error {
+	// Diskful pools are also recorded by node alone, for replicas without a
+	// storage pool for LINSTOR to choose one.
+	var diskful, diskless = make(map[string]bool), make(map[string]bool)
+	for _, sp := range pools {
+		if sp.ProviderKind == lapi.DISKLESS {
+			diskless[sp.NodeName+"/"+sp.StoragePoolName] = true
+		} else {
+			diskful[sp.NodeName+"/"+sp.StoragePoolName] = true
+			diskful[sp.NodeName+"/"] = true
+		}
+	}
+
+	for _, node := range params.NodeList {
+		pool := params.Placement.StoragePool(node, params.StoragePool)
+		if !diskful[node+"/"+pool] {
+			return fmt.Errorf("placement of replica on node %s: node has no diskful storage pool %q", node, pool)
+		}
+	}
+	for _, node := range params.ClientList {
+		if !diskless[node+"/"+params.DisklessStoragePool] {
+			return fmt.Errorf("placement of diskless assignment on node %s: node has no diskless storage pool %s", node, params.DisklessStoragePool)
+		}
+	}
+	return nil
+}
+
+// checkEligibleNodes refuses automatically placed volumes requesting more
+// replicas than there are nodes satisfying their placement constraints, which
+// LINSTOR would only report with an opaque error. Free space is left for
+// LINSTOR to judge.
+func (s *Linstor) checkEligibleNodes(ctx context.Context, vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if params.PlacementPolicy == topology.Manual || params.PlacementCount == 0 {
+		return nil
+	}
+
+	var (
+		nodes     []lapi.Node
+		pools     []lapi.StoragePool
+		resources []lapi.Resource
+	)
+	g, egctx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		nodes, err = s.client.Nodes.GetAll(egctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		pools, err = s.client.Nodes.GetStoragePoolView(egctx)
+		return err
+	})
+	if params.DoNotPlaceWithRegex != "" {
+		g.Go(func() (err error) {
+			resources, err = s.client.Resources.GetResourceView(egctx)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		// LINSTOR gets to decide if the cluster state is unknown.
+		s.log.WithField("volume", vol.Name).WithError(err).Info("unable to count eligible nodes")
+		return nil
+	}
+
+	eligible, err := eligibleNodeCount(params, nodes, pools, resources)
+	if err != nil {
+		return err
+	}
+	if eligible < int(params.PlacementCount) {
+		return fmt.Errorf("requested %d replicas of %s, but only %d nodes are online, have storage pool %q, and satisfy the placement constraints",
+			params.PlacementCount, vol.Name, eligible, params.StoragePool)
+	}
+	return nil
+}
+
+// eligibleNodeCount returns how many online nodes could hold replicas of a
+// volume with params: nodes with a diskful storage pool of the volume, not
+// excluded or hosting resources matching doNotPlaceWithRegex, that satisfy
+// replicasOnSame and replicasOnDifferent.
+func eligibleNodeCount(params volume.Parameters, nodes []lapi.Node, pools []lapi.StoragePool, resources []lapi.Resource) (int, error) {
+	excluded, err := util.ExcludedNodes(params, resources)
+	if err != nil {
+		return 0, err
+	}
+
+	hasPool := make(map[string]bool)
+	for _, sp := range pools {
+		if sp.ProviderKind != lapi.DISKLESS && (params.StoragePool == "" || sp.StoragePoolName == params.StoragePool) {
+			hasPool[sp.NodeName] = true
+		}
+	}
+
+	var eligible []lapi.Node
+	for _, n := range nodes {
+		if n.ConnectionStatus == "ONLINE" && hasPool[n.Name] && !excluded[n.Name] {
+			eligible = append(eligible, n)
+		}
+	}
+	return placementCapacity(eligible, params), nil
+}
+
+// checkPreallocation refuses volumes that are to be preallocated unless their
+// storage pool has enough free space for all of their replicas.
+func (s *Linstor) checkPreallocation(ctx context.Context, vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if !params.Preallocate {
+		return nil
+	}
+	if s.nodeName == "" {
+		return fmt.Errorf("unable to preallocate %s, node name unknown", vol.Name)
+	}
+
+	ok, reason, err := s.CanPlace(ctx, vol.Parameters, vol.SizeBytes)
+	if err != nil {
+		return fmt.Errorf("unable to determine free space for preallocating %s: %v", vol.Name, err)
+	}
+	if !ok {
+		return fmt.Errorf("not enough space to preallocate %s: %s", vol.Name, reason)
+	}
+	return nil
+}
+
+// startPreallocation preallocates vol in the background, if requested and
+// not done or running already. Devices of large volumes take long to write,
+// so the preallocation is not bound to any request, but canceled when the
+// volume is deleted or the client is closed. Until it is done, the volume
+// cannot be attached, see checkPreallocated.
+func (s *Linstor) startPreallocation(vol *volume.Info) {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil || !params.Preallocate || vol.Preallocated {
+		return
+	}
+
+	s.preallocationsMu.Lock()
+	defer s.preallocationsMu.Unlock()
+	if _, ok := s.preallocations[vol.ID]; ok {
+		return
+	}
+	if s.preallocations == nil {
+		s.preallocations = make(map[string]context.CancelFunc)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), preallocateTimeout)
+	s.preallocations[vol.ID] = cancel
+
+	id := vol.ID
+	go func() {
+		defer s.stopPreallocation(id)
+		if err := s.preallocate(ctx, id); err != nil {
+			s.log.WithError(err).WithField("volume", id).Warn("unable to preallocate volume")
+			s.emit(EventWarning, "PreallocationFailed", "failed to preallocate %s, retrying on the next attach: %v", id, err)
+		}
+	}()
+}
+
+// stopPreallocation cancels the preallocation of the volume with id, if one
+// is running.
+func (s *Linstor) stopPreallocation(id string) {
+	s.preallocationsMu.Lock()
+	defer s.preallocationsMu.Unlock()
+
+	if cancel, ok := s.preallocations[id]; ok {
+		cancel()
+		delete(s.preallocations, id)
+	}
+}
+
+// PreallocatingError is returned by Attach for volumes whose preallocation is
+// not done yet. Writing the device would destroy the data of its users.
+type PreallocatingError struct {
+	Volume string
+}
+
+func (e *PreallocatingError) Error() string {
+	return fmt.Sprintf("volume %s is still being preallocated", e.Volume)
+}
+
+// checkPreallocated refuses volumes that are to be preallocated but are not
+// yet. Preallocations that are not running anymore, e.g. because they failed
+// or the plugin restarted, are started again.
+func (s *Linstor) checkPreallocated(vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if !params.Preallocate || vol.Preallocated {
+		return nil
+	}
+
+	s.startPreallocation(vol)
+	return &PreallocatingError{Volume: vol.ID}
+}
+
+// preallocate writes random data to all blocks of the volume with id, so
+// that thin storage allocates them. Unlike zeros, random data is also
+// allocated by storage with compression, deduplication, or zero detection.
+// DRBD replicates the writes, so this is done once, through the volume's
+// device on this node. Nodes without a replica are attached disklessly for
+// the time being.
+func (s *Linstor) preallocate(ctx context.Context, id string) error {
+	vol, err := s.getByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if vol == nil || vol.Deleted {
+		return nil
+	}
+
+	log := s.log.WithFields(logrus.Fields{
+		"volume": vol.ID,
+		"node":   s.nodeName,
+	})
+
+	err = s.withLocalDevice(ctx, vol, "preallocation", func(linVol lapi.Volume) error {
+		log = log.WithField("device", linVol.DevicePath)
+		log.Info("preallocating volume")
+
+		out, err := s.mounter.RunContext(ctx, "dd", preallocateArgs(linVol.DevicePath, linVol.UsableSizeKib)...)
+		if err != nil {
+			return fmt.Errorf("unable to preallocate %s: %v: %s", vol.ID, err, out)
+		}
+
+		log.Info("preallocated volume")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The volume may have changed in the meantime.
+	vol, err = s.getByID(ctx, id)
+	if err != nil || vol == nil {
+		return err
+	}
+	vol.Preallocated = true
+	return s.saveVolume(ctx, vol)
+}
+
+// withLocalDevice calls f with the volume of vol on the node of this plugin.
+// Volumes without a resource on this node are attached disklessly for the
+// duration of f, so that f can use their device.
+func (s *Linstor) withLocalDevice(ctx context.Context, vol *volume.Info, purpose string, f func(lapi.Volume) error) error {
+	_, err := s.client.Resources.Get(ctx, vol.ID, s.nodeName)
+	if nil404(err) != nil {
+		return err
+	}
+	if err == lapi.NotFoundError {
+		rc, err := vol.ToDisklessResourceCreate(s.nodeName)
+		if err != nil {
+			return err
+		}
+		if err := s.client.Resources.Create(ctx, rc); err != nil {
+			return fmt.Errorf("unable to attach %s for %s: %v", vol.ID, purpose, err)
+		}
+		defer s.rollbackAssignment(vol, s.nodeName)
+	}
+
+	if err := s.waitForUsable(ctx, vol.ID, s.nodeName); err != nil {
+		return err
+	}
+	linVol, err := s.client.Resources.GetVolume(ctx, vol.ID, s.nodeName, 0)
+	if err != nil {
+		return fmt.Errorf("unable to find device of %s: %v", vol.ID, err)
+	}
+	return f(linVol)
+}
+
+// preallocateArgs returns the dd arguments to write sizeKiB of random data
+// to device.
+func preallocateArgs(device string, sizeKiB int64) []string {
+	return []string{
+		"if=/dev/urandom",
+		"of=" + device,
+		"bs=1M",
+		"count=" + strconv.FormatInt(sizeKiB*1024, 10),
+		"iflag=count_bytes",
+		"oflag=direct",
+		"conv=fsync",
+	}
+}
+
+// capReplicas enforces the maximum number of replicas on vol, either by
+// refusing it or by lowering its placement count.
+func (s *Linstor) capReplicas(vol *volume.Info) error {
+	if s.maxReplicas == 0 {
+		return nil
+	}
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+
+	if params.PlacementCount <= s.maxReplicas {
+		return nil
+	}
+
+	if !s.clampReplicas {
+		return fmt.Errorf("requested %d replicas, but at most %d are allowed", params.PlacementCount, s.maxReplicas)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":            vol.Name,
+		"requestedReplicas": params.PlacementCount,
+		"maxReplicas":       s.maxReplicas,
+	}).Warn("requested replicas exceed the maximum, lowering to the maximum")
+	vol.SetPlacementCount(s.maxReplicas)
+
+	return nil
+}
+
+// applyDefaultStoragePool sets the default storage pool on vol if its
+// parameters name no storage pool.
+func (s *Linstor) applyDefaultStoragePool(vol *volume.Info) error {
+	if s.defaultStoragePool == "" {
+		return nil
+	}
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	// Volumes of a tier use the storage pools of the tier.
+	if params.StoragePool == "" && params.Tier == "" {
+		vol.SetStoragePool(s.defaultStoragePool)
+	}
+	return nil
+}
+
+// applyTier checks that the storage pools vol is placed in are in its tier.
+// Volumes that name a storage pool must name one of the tier. Others get each
+// replica in a storage pool of the tier on the replica's node, so nodes in the
+// nodeList must have one.
+func (s *Linstor) applyTier(ctx context.Context, vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if params.Tier == "" {
+		return nil
+	}
+
+	pools, err := s.client.TierPools(ctx, params.Tier)
+	if err != nil {
+		return err
+	}
+	if len(pools) == 0 {
+		return fmt.Errorf("no storage pools of tier %q", params.Tier)
+	}
+
+	if params.StoragePool != "" {
+		if _, ok := pools[params.StoragePool]; !ok {
+			return fmt.Errorf("storage pool %s is not in tier %q", params.StoragePool, params.Tier)
+		}
+	}
+
+	for _, n := range params.NodeList {
+		if !inTierPool(pools, params.StoragePool, n) {
+			if params.StoragePool != "" {
+				return fmt.Errorf("node %s of nodeList has no storage pool %s in tier %q", n, params.StoragePool, params.Tier)
+			}
+			return fmt.Errorf("node %s of nodeList has no storage pool in tier %q", n, params.Tier)
+		}
+	}
+	return nil
+}
+
+// inTierPool reports whether node has pool of a tier, or any of its storage
+// pools if pool is empty.
+func inTierPool(pools map[string][]string, pool, node string) bool {
+	for name, nodes := range pools {
+		if pool != "" && name != pool {
+			continue
+		}
+		if i := sort.SearchStrings(nodes, node); i < len(nodes) && nodes[i] == node {
+			return true
+		}
+	}
+	return false
+}
+
+// chooseStoragePool switches vol to its fallbackStoragePool if its storage
+// pool doesn't have enough free space for it on enough nodes, but the fallback
+// does. Failures to determine free space keep the storage pool as it is.
+func (s *Linstor) chooseStoragePool(ctx context.Context, vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+
+	if params.FallbackStoragePool == "" || params.StoragePool == "" {
+		return nil
+	}
+
+	log := s.log.WithFields(logrus.Fields{
+		"volume":              vol.Name,
+		"storagePool":         params.StoragePool,
+		"fallbackStoragePool": params.FallbackStoragePool,
+	})
+
+	capacity, err := s.client.NodePoolCapacity(ctx, params.StoragePool)
+	if err != nil {
+		log.WithError(err).Info("unable to determine storage pool capacity, not considering fallback")
+		return nil
+	}
+	if poolFits(capacity, vol.SizeBytes, int(params.PlacementCount), params.NodeList) {
+		return nil
+	}
+
+	fallback, err := s.client.NodePoolCapacity(ctx, params.FallbackStoragePool)
+	if err != nil {
+		log.WithError(err).Info("unable to determine fallback storage pool capacity, not falling back")
+		return nil
+	}
+	if !poolFits(fallback, vol.SizeBytes, int(params.PlacementCount), params.NodeList) {
+		log.Info("neither storage pool has enough free space, not falling back")
+		return nil
+	}
+
+	log.Info("storage pool has not enough free space, using fallback storage pool")
+	vol.SetStoragePool(params.FallbackStoragePool)
+
+	return nil
+}
+
+// poolFits returns true if capacity, the free bytes of a storage pool per node,
+// allows placing count replicas of sizeBytes. If nodes are given, each of them
+// needs enough space.
+func poolFits(capacity map[string]int64, sizeBytes int64, count int, nodes []string) bool {
+	if len(nodes) != 0 {
+		for _, n := range nodes {
+			if capacity[n] < sizeBytes {
+				return false
+			}
+		}
+		return true
+	}
+
+	var fitting int
+	for _, free := range capacity {
+		if free >= sizeBytes {
+			fitting++
+		}
+	}
+	return fitting >= count
+}
+
+// createVolumeDefinition creates the volume definition for vol. If a particular
+// minor number was requested, but LINSTOR can't use it, the minor number is
+// left up to LINSTOR.
+func (s *Linstor) createVolumeDefinition(ctx context.Context, vol *volume.Info) error {
+	volDefCreate, err := vol.ToVolumeDefinitionCreate()
+	if err != nil {
+		return err
+	}
+
+	err = s.client.ResourceDefinitions.CreateVolumeDefinition(ctx, vol.ID, volDefCreate)
+	if err == nil || volDefCreate.DrbdMinorNumber == 0 || !minorNumberConflict(err) {
+		return err
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":         vol.ID,
+		"requestedMinor": volDefCreate.DrbdMinorNumber,
+	}).WithError(err).Warn("unable to honor requested minor number, letting LINSTOR allocate one")
+
+	volDefCreate.DrbdMinorNumber = 0
+	return s.client.ResourceDefinitions.CreateVolumeDefinition(ctx, vol.ID, volDefCreate)
+}
+
+// minorNumberConflict reports whether err is LINSTOR refusing a requested minor
+// number, because it is taken or out of range. golinstor only passes on the
+// messages of LINSTOR's errors, not their return codes.
+func minorNumberConflict(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "minor number") || strings.Contains(msg, "minor nr")
+}
+
+// Delete removes a resource, all of its volumes, and snapshots from LINSTOR.
+func (s *Linstor) Delete(ctx context.Context, vol *volume.Info) error {
+	ctx, span := s.startSpan(ctx, "delete", vol.ID)
+	ctx, cancel := context.WithTimeout(ctx, s.deleteTimeout)
+	defer cancel()
+
+	err := timeoutErr(ctx, "delete", vol.ID, s.delete(ctx, vol))
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) delete(ctx context.Context, vol *volume.Info) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+	s.stopPreallocation(vol.ID)
+
+	s.log.WithFields(logrus.Fields{
+		"volume": fmt.Sprintf("%+v", vol),
+	}).Info("deleting volume")
+
+	resDef, err := s.client.ResourceDefinitions.Get(ctx, vol.ID)
+	if nil404(err) != nil {
+		return err
+	}
+	if s.managedByOther(resDef) {
+		return fmt.Errorf("refusing to delete %s, it is managed by %s", vol.ID, resDef.Props[linstor.ManagedByKey])
+	}
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+
+	if s.deletionGracePeriod > 0 {
+		return s.deferDeletion(ctx, vol)
+	}
+	return s.remove(ctx, vol, params)
+}
+
+// deferDeletion marks vol as deleted, leaving its removal to the reaper once
+// the deletion grace period elapsed. Volumes that are already marked, also
+// those kept for their snapshots, are left as they are.
+func (s *Linstor) deferDeletion(ctx context.Context, vol *volume.Info) error {
+	if vol.Deleted {
+		return nil
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":      vol.ID,
+		"gracePeriod": s.deletionGracePeriod,
+	}).Info("deferring removal of deleted volume")
+
+	now := time.Now()
+	vol.Deleted = true
+	vol.DeletionTime = &now
+	return s.saveVolume(ctx, vol)
+}
+
+// remove deletes the snapshots and the resource definition of vol, unless
+// its snapshots are to be kept.
+func (s *Linstor) remove(ctx context.Context, vol *volume.Info, params volume.Parameters) error {
+	// Resources with snapshots cannot be deleted so we have to remove those first.
+	snaps, err := s.client.Resources.GetSnapshots(ctx, vol.ID)
+	if nil404(err) != nil {
+		return err
+	}
+
+	// LINSTOR can't keep snapshots without their resource, so keep the whole
+	// resource around until its last snapshot is deleted.
+	if params.KeepSnapshotsOnDelete && len(snaps) != 0 {
+		s.log.WithFields(logrus.Fields{
+			"volume":    vol.ID,
+			"snapshots": len(snaps),
+		}).Info("keeping deleted volume until its snapshots are deleted")
+		vol.Deleted = true
+		vol.DeletionTime = nil
+		return s.saveVolume(ctx, vol)
+	}
+
+	g, egctx := errgroup.WithContext(ctx)
+	for _, snap := range snaps {
+		ss := snap.Name
+		g.Go(func() error {
+			if err := s.client.Resources.DeleteSnapshot(egctx, vol.ID, ss); nil404(err) != nil {
+				return err
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	// No snapshots, remove the resource.
+	return s.deleteResourceDefinition(ctx, vol)
+}
+
+// ReapDeleted removes the volumes whose deletion grace period elapsed and
+// returns their IDs. Volumes that fail to be removed are tried again by the
+// next call, the first failure is returned.
+func (s *Linstor) ReapDeleted(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.deleteTimeout)
+	defer cancel()
+
+	ids, err := s.reapDeleted(ctx)
+	return ids, timeoutErr(ctx, "reap", "deleted volumes", err)
+}
+
+func (s *Linstor) reapDeleted(ctx context.Context) ([]string, error) {
+	if err := s.checkMaintenance(); err != nil {
+		return nil, err
+	}
+
+	vols, err := s.pendingDeletion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reaped = make([]string, 0)
+	var firstErr error
+	for _, vol := range reapable(vols, s.deletionGracePeriod, time.Now()) {
+		params, err := volume.NewParameters(vol.Parameters)
+		if err == nil {
+			err = s.remove(ctx, vol, params)
+		}
+		if err != nil {
+			s.log.WithError(err).WithField("volume", vol.ID).Warn("unable to remove deleted volume")
+			if firstErr == nil {
+				firstErr = fmt.Errorf("unable to remove deleted volume %s: %v", vol.ID, err)
+			}
+			continue
+		}
+		reaped = append(reaped, vol.ID)
+	}
+
+	return reaped, firstErr
+}
+
+// pendingDeletion returns the volumes whose removal was deferred by a
+// deletion grace period.
+func (s *Linstor) pendingDeletion(ctx context.Context) ([]*volume.Info, error) {
+	resDefs, err := s.client.ResourceDefinitions.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list deleted volumes: %v", err)
+	}
+
+	var vols = make([]*volume.Info, 0)
+	for _, rd := range resDefs {
+		vol, err := s.resourceDefinitionToVolume(rd)
+		if err != nil || vol == nil {
+			continue
+		}
+		if vol.Deleted && vol.DeletionTime != nil {
+			vols = append(vols, vol)
+		}
+	}
+	return vols, nil
+}
+
+// reapable returns the vols that were deleted at least grace before now.
+func reapable(vols []*volume.Info, grace time.Duration, now time.Time) []*volume.Info {
+	var expired = make([]*volume.Info, 0)
+	for _, vol := range vols {
+		if vol.Deleted && vol.DeletionTime != nil && now.Sub(*vol.DeletionTime) >= grace {
+			expired = append(expired, vol)
+		}
+	}
+	return expired
+}
+
+// StartReaper calls ReapDeleted every reapInterval, until Close is called.
+// Calling it again while the reaper is running does nothing.
+func (s *Linstor) StartReaper() {
+	s.reaperMu.Lock()
+	defer s.reaperMu.Unlock()
+
+	if s.reaperStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	s.reaperStop = stop
+
+	s.log.WithFields(logrus.Fields{
+		"gracePeriod": s.deletionGracePeriod,
+	}).Debug("starting reaper of deleted volumes")
+
+	go func() {
+		ticker := time.NewTicker(reapInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ids, err := s.ReapDeleted(context.Background())
+				if len(ids) != 0 {
+					s.log.WithField("volumes", ids).Info("removed volumes whose deletion grace period elapsed")
+				}
+				if err != nil {
+					s.log.WithError(err).Warn("unable to reap deleted volumes")
+				}
+			}
+		}
+	}()
+}
+
+// undelete restores a volume of the same name as vol that is pending
+// deletion, instead of creating a new one. It reports whether a volume was
+// restored, in which case vol is updated to match it.
+func (s *Linstor) undelete(ctx context.Context, vol *volume.Info) (bool, error) {
+	if s.deletionGracePeriod == 0 {
+		return false, nil
+	}
+
+	vols, err := s.pendingDeletion(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := restorable(vols, vol)
+	if err != nil || deleted == nil {
+		return false, err
+	}
+
+	deleted.Deleted = false
+	deleted.DeletionTime = nil
+	if err := s.saveVolume(ctx, deleted); err != nil {
+		return false, fmt.Errorf("unable to restore deleted volume %s: %v", deleted.ID, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume": deleted.ID,
+	}).Info("restored volume pending deletion")
+	*vol = *deleted
+	return true, nil
+}
+
+// restorable returns the volume of vols that has the same name as vol, or nil
+// if there is none. It is an error if that volume differs from vol in size or
+// parameters, as restoring it would not give the requested volume.
+func restorable(vols []*volume.Info, vol *volume.Info) (*volume.Info, error) {
+	for _, deleted := range vols {
+		if deleted.Name != vol.Name {
+			continue
+		}
+		if deleted.SizeBytes != vol.SizeBytes {
+			return nil, fmt.Errorf("volume %s is pending deletion with a size of %d bytes, not %d",
+				deleted.ID, deleted.SizeBytes, vol.SizeBytes)
+		}
+
+		// Desired replicas are reconciled separately, only compare parameters.
+		diffs, err := volume.DiffParameters(&volume.Info{Name: deleted.Name, Parameters: deleted.Parameters}, vol.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		if len(diffs) > 0 {
+			return nil, fmt.Errorf("volume %s is pending deletion with %s %s, not %s",
+				deleted.ID, diffs[0].Key, diffs[0].Current, diffs[0].Desired)
+		}
+		return deleted, nil
+	}
+	return nil, nil
+}
+
+// ForceDeleteWithDetach removes vol from LINSTOR no matter which nodes it is
+// still assigned to: all assignments are removed first, followed by the
+// volume's snapshots and its resource definition. Parts that are already gone
+// count as removed. Assignments that are in use can still not be removed.
+func (s *Linstor) ForceDeleteWithDetach(ctx context.Context, vol *volume.Info) error {
+	ctx, span := s.startSpan(ctx, "forceDelete", vol.ID)
+	ctx, cancel := context.WithTimeout(ctx, s.deleteTimeout)
+	defer cancel()
+
+	err := timeoutErr(ctx, "force delete", vol.ID, s.forceDeleteWithDetach(ctx, vol))
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) forceDeleteWithDetach(ctx context.Context, vol *volume.Info) error {
+	log := s.log.WithField("volume", vol.ID)
+	log.Info("force deleting volume")
+	s.stopPreallocation(vol.ID)
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	// Wiping needs the device, which goes away with the assignments.
+	if params.WipeOnDelete {
+		if err := s.wipeVolume(ctx, vol); err != nil {
+			return err
+		}
+	}
+
+	resources, err := s.client.Resources.GetAll(ctx, vol.ID)
+	if nil404(err) != nil {
+		return fmt.Errorf("unable to find assignments of %s: %v", vol.ID, err)
+	}
+	for _, r := range removalOrder(resources) {
+		log.WithField("targetNode", r.NodeName).Info("removing assignment")
+		if err := s.client.Resources.Delete(ctx, vol.ID, r.NodeName); nil404(err) != nil {
+			return fmt.Errorf("failed to remove assignment of %s on node %s: %v", vol.ID, r.NodeName, err)
+		}
+	}
+
+	snaps, err := s.client.Resources.GetSnapshots(ctx, vol.ID)
+	if nil404(err) != nil {
+		return fmt.Errorf("unable to find snapshots of %s: %v", vol.ID, err)
+	}
+	for _, snap := range snaps {
+		log.WithField("snapshot", snap.Name).Info("removing snapshot")
+		if err := s.client.Resources.DeleteSnapshot(ctx, vol.ID, snap.Name); nil404(err) != nil {
+			return fmt.Errorf("failed to remove snapshot %s of %s: %v", snap.Name, vol.ID, err)
+		}
+	}
+
+	log.Info("removing resource definition")
+	if err := s.client.ResourceDefinitions.Delete(ctx, vol.ID); nil404(err) != nil {
+		return fmt.Errorf("failed to remove resource definition of %s: %v", vol.ID, err)
+	}
+
+	return nil
+}
+
+// removalOrder sorts resources so that diskless ones come first, as LINSTOR
+// refuses to remove the last diskful resource of clients.
+func removalOrder(resources []lapi.Resource) []lapi.Resource {
+	var ordered = make([]lapi.Resource, len(resources))
+	copy(ordered, resources)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return util.DeployedDisklessly(ordered[i]) && !util.DeployedDisklessly(ordered[j])
+	})
+	return ordered
+}
+
+// OrphanAssignment is an assignment of a resource to a node whose resource
+// definition is gone or being deleted.
+type OrphanAssignment struct {
+	Node     string
+	Resource string
+}
+
+// ListOrphanedAssignments returns the assignments whose resource definition is
+// gone or stuck being deleted, e.g. after interrupted deletes.
+func (s *Linstor) ListOrphanedAssignments(ctx context.Context) ([]OrphanAssignment, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.lookupTimeout)
+	defer cancel()
+
+	resources, err := s.client.Resources.GetResourceView(ctx)
+	if err != nil {
+		return nil, timeoutErr(ctx, "listing", "orphaned assignments", fmt.Errorf("unable to list assignments: %v", err))
+	}
+	rds, err := s.client.ResourceDefinitions.GetAll(ctx)
+	if err != nil {
+		return nil, timeoutErr(ctx, "listing", "orphaned assignments", fmt.Errorf("unable to list resource definitions: %v", err))
+	}
+	return orphanedAssignments(resources, rds), nil
+}
+
+// orphanedAssignments returns the resources without a resource definition, or
+// whose resource definition is flagged for deletion, sorted by resource and node.
+func orphanedAssignments(resources []lapi.Resource, rds []lapi.ResourceDefinition) []OrphanAssignment {
+	var live = make(map[string]bool, len(rds))
+	for _, rd := range rds {
+		live[rd.Name] = !containsOpt(rd.Flags, apiconst.FlagDelete)
+	}
+
+	var orphans = make([]OrphanAssignment, 0)
+	for _, r := range resources {
+		if !live[r.Name] {
+			orphans = append(orphans, OrphanAssignment{Node: r.NodeName, Resource: r.Name})
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].Resource != orphans[j].Resource {
+			return orphans[i].Resource < orphans[j].Resource
+		}
+		return orphans[i].Node < orphans[j].Node
+	})
+	return orphans
+}
+
+// CleanupOrphanedAssignment removes an assignment returned by
+// ListOrphanedAssignments. Assignments that are gone already count as removed,
+// those that are not orphaned (anymore) are refused.
+func (s *Linstor) CleanupOrphanedAssignment(ctx context.Context, orphan OrphanAssignment) error {
+	ctx, cancel := context.WithTimeout(ctx, s.deleteTimeout)
+	defer cancel()
+
+	rds, err := s.client.ResourceDefinitions.GetAll(ctx)
+	if err != nil {
+		return timeoutErr(ctx, "removing orphaned assignment", orphan.Resource, fmt.Errorf("unable to list resource definitions: %v", err))
+	}
+	res := []lapi.Resource{{Name: orphan.Resource, NodeName: orphan.Node}}
+	if len(orphanedAssignments(res, rds)) == 0 {
+		return fmt.Errorf("refusing to remove assignment of %s on node %s, it is not orphaned", orphan.Resource, orphan.Node)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"resource":   orphan.Resource,
+		"targetNode": orphan.Node,
+	}).Info("removing orphaned assignment")
+
+	err = s.client.Resources.Delete(ctx, orphan.Resource, orphan.Node)
+	return timeoutErr(ctx, "removing orphaned assignment", orphan.Resource, nil404(err))
+}
+
+// deleteResourceDefinition removes the volume's resource definition along with
+// all of its resources, wiping the volume first if requested.
+func (s *Linstor) deleteResourceDefinition(ctx context.Context, vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+
+	if params.WipeOnDelete {
+		if err := s.wipeVolume(ctx, vol); err != nil {
+			return err
+		}
+	}
+
+	return nil404(s.client.ResourceDefinitions.Delete(ctx, vol.ID))
+}
+
+// wipeVolume discards the blocks of a volume that is about to be deleted. DRBD
+// passes discards on to all of its replicas, so this is done once, through the
+// volume's device on this node. Volumes without a resource on this node are
+// attached disklessly for it. Volumes that could not be wiped are not deleted,
+// the error is returned and surfaced as event instead.
+func (s *Linstor) wipeVolume(ctx context.Context, vol *volume.Info) error {
+	if s.nodeName == "" {
+		return fmt.Errorf("unable to wipe %s, node name unknown", vol.ID)
+	}
+
+	log := s.log.WithFields(logrus.Fields{
+		"volume": vol.ID,
+		"node":   s.nodeName,
+	})
+
+	err := s.withLocalDevice(ctx, vol, "wiping", func(linVol lapi.Volume) error {
+		log = log.WithField("device", linVol.DevicePath)
+		log.Info("discarding volume blocks before deletion")
+
+		out, err := s.mounter.Run("blkdiscard", linVol.DevicePath)
+		if err != nil {
+			return fmt.Errorf("unable to discard blocks of %s, storage may not support discard: %v: %s", vol.ID, err, out)
+		}
+
+		log.Info("discarded volume blocks")
+		return nil
+	})
+	if err != nil {
+		s.emit(EventWarning, "WipeFailed", "volume %s was not wiped and is kept: %v", vol.ID, err)
+		return fmt.Errorf("refusing to delete %s without wiping it: %v", vol.ID, err)
+	}
+	return nil
+}
+
+// AccessibleTopologies returns a list of pointers to csi.Topology from where the
+// volume is reachable, based on the localStoragePolicy reported by the volume.
+func (s *Linstor) AccessibleTopologies(ctx context.Context, vol *volume.Info) ([]*csi.Topology, error) {
+	volumeScheduler, err := s.schedulerByPlacementPolicy(vol)
+	if err != nil {
+		return nil, err
+	}
+	return volumeScheduler.AccessibleTopologies(ctx, vol)
+}
+
+// timeoutErr replaces err with one naming the operation and resource if the
+// operation's deadline was exceeded.
+func timeoutErr(ctx context.Context, op, resource string, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s of %s timed out: %v", op, resource, err)
+	}
+	return err
+}
+
+func (s *Linstor) schedulerByPlacementPolicy(vol *volume.Info) (scheduler.Interface, error) {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	switch params.PlacementPolicy {
+	case topology.AutoPlace:
+		return autoplace.NewScheduler(s.client), nil
+	case topology.Manual:
+		return manual.NewScheduler(s.client), nil
+	case topology.FollowTopology:
+		return followtopology.NewScheduler(s.client, s.log), nil
+	case topology.Balanced:
+		return balancer.NewScheduler(s.client, s.log)
+	case topology.MostFree:
+		return mostfree.NewScheduler(s.client, s.log), nil
+	default:
+		return nil, fmt.Errorf("unsupported volume scheduler: %s", params.PlacementPolicy)
+	}
+}
+
+// Attach idempotently creates a resource on the given node disklessly.
+func (s *Linstor) Attach(ctx context.Context, vol *volume.Info, node string) error {
+	ctx, span := s.startSpan(ctx, "attach", vol.ID)
+	span.SetAttribute(SpanAttrNode, node)
+	ctx, cancel := context.WithTimeout(ctx, s.attachTimeout)
+	defer cancel()
+
+	err := s.attach(ctx, vol, node)
+	if _, ok := err.(*DeviceNotReadyError); !ok {
+		err = timeoutErr(ctx, "attach", vol.ID, err)
+	}
+	if err != nil {
+		s.emit(EventWarning, "AttachFailed", "failed to attach %s to node %s: %v", vol.ID, node, err)
+	} else {
+		s.emit(EventNormal, "Attached", "attached %s to node %s", vol.ID, node)
+	}
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) attach(ctx context.Context, vol *volume.Info, node string) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	if err := s.checkPreallocated(vol); err != nil {
+		return err
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":     fmt.Sprintf("%+v", vol),
+		"targetNode": node,
+	}).Info("attaching volume")
+
+	// If the resource is already on the node, don't worry about attaching.
+	res, err := s.client.Resources.Get(ctx, vol.ID, node)
+	if nil404(err) != nil {
+		return err
+	}
+
+	if res.NodeName == node {
+		s.log.WithFields(logrus.Fields{
+			"volume":     fmt.Sprintf("%+v", vol),
+			"resource":   fmt.Sprintf("%+v", res),
+			"targetNode": node,
+		}).Info("volume already attached")
+		return nil
+	}
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if params.Excludes(node) {
+		return fmt.Errorf("refusing to attach %s to node %s, it is in excludeNodes", vol.ID, node)
+	}
+
+	onNode, err := s.client.Resources.GetResourceView(ctx, &lapi.ListOpts{Node: []string{node}})
+	if err != nil {
+		return fmt.Errorf("unable to check resources on node %s: %v", node, err)
+	}
+	conflicts, err := attachConflicts(vol, onNode)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) != 0 {
+		return fmt.Errorf("refusing to attach %s to node %s, it hosts resources matching doNotPlaceWithRegex: %v", vol.ID, node, conflicts)
+	}
+
+	if !params.PreferLocal || !s.placeLocal(ctx, vol, node) {
+		rc, err := vol.ToDisklessResourceCreate(node)
+		if err != nil {
+			return err
+		}
+		if err := s.client.Resources.Create(ctx, rc); err != nil {
+			return fmt.Errorf("unable to assign %s to node %s: %v", vol.ID, node, err)
+		}
+	}
+
+	// Retries must not find a broken assignment that looks attached.
+	if err := s.waitForDevice(ctx, vol.ID, node); err != nil {
+		s.rollbackAssignment(vol, node)
+		return &DeviceNotReadyError{Volume: vol.ID, Node: node, Err: err}
+	}
+	return nil
+}
+
+// DeviceNotReadyError is returned by Attach if the volume was assigned to the
+// node, but its device did not become ready before the attach timeout. The
+// assignment is removed again.
+type DeviceNotReadyError struct {
+	Volume string
+	Node   string
+	Err    error
+}
+
+func (e *DeviceNotReadyError) Error() string {
+	return fmt.Sprintf("assigned %s to node %s, but its device did not become ready: %v", e.Volume, e.Node, e.Err)
+}
+
+// waitForDevice waits until all volumes of resName on node have a device that
+// is attached to its disk or peers.
+func (s *Linstor) waitForDevice(ctx context.Context, resName, node string) error {
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+
+	for {
+		vols, err := s.client.Resources.GetVolumes(ctx, resName, node)
+		if err == nil && len(vols) != 0 && devicesReady(vols) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// devicesReady returns true if all vols have a device in a known disk state.
+// New diskful replicas are ready before they finished syncing.
+func devicesReady(vols []lapi.Volume) bool {
+	for _, v := range vols {
+		switch v.State.DiskState {
+		case "", "DUnknown", "Attaching", "Negotiating":
+			return false
+		}
+		if v.DevicePath == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// attachConflicts returns the names of resources, other than vol's own, that
+// match the doNotPlaceWithRegex of vol. Diskless assignments are created on
+// explicit nodes, so LINSTOR doesn't apply the regex to them by itself.
+func attachConflicts(vol *volume.Info, resources []lapi.Resource) ([]string, error) {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	if params.DoNotPlaceWithRegex == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(params.DoNotPlaceWithRegex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid doNotPlaceWithRegex %q: %v", params.DoNotPlaceWithRegex, err)
+	}
+
+	var conflicts []string
+	for _, r := range resources {
+		if r.Name != vol.ID && re.MatchString(r.Name) {
+			conflicts = append(conflicts, r.Name)
+		}
+	}
+	return conflicts, nil
+}
+
+// Detach removes a volume from the node.
+func (s *Linstor) Detach(ctx context.Context, vol *volume.Info, node string) error {
+	ctx, span := s.startSpan(ctx, "detach", vol.ID)
+	span.SetAttribute(SpanAttrNode, node)
+	ctx, cancel := context.WithTimeout(ctx, s.attachTimeout)
+	defer cancel()
+
+	err := timeoutErr(ctx, "detach", vol.ID, s.detach(ctx, vol, node))
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) detach(ctx context.Context, vol *volume.Info, node string) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	res, err := s.client.Resources.Get(ctx, vol.ID, node)
+	if err != nil {
+		return err
+	}
+	s.log.WithFields(logrus.Fields{
+		"resource":   fmt.Sprintf("%+v", res),
+		"targetNode": node,
+	}).Info("detaching volume")
+
+	if util.DeployedDiskfully(res) {
+		s.log.WithFields(logrus.Fields{
+			"resource":   fmt.Sprintf("%+v", res),
+			"targetNode": node,
+		}).Info("volume is diskfull on node, refusing to detach")
+		return nil
+	}
+
+	return s.client.Resources.Delete(ctx, vol.ID, node)
+}
+
+// Reassign moves the client assignment of vol from fromNode to toNode. The new
+// assignment is created and confirmed usable before the old one is removed, so
+// the volume stays reachable throughout. If the new assignment fails, it is
+// removed again and the one on fromNode is kept.
+func (s *Linstor) Reassign(ctx context.Context, vol *volume.Info, fromNode, toNode string) error {
+	ctx, span := s.startSpan(ctx, "reassign", vol.ID)
+	span.SetAttribute(SpanAttrNode, toNode)
+	ctx, cancel := context.WithTimeout(ctx, s.attachTimeout)
+	defer cancel()
+
+	err := timeoutErr(ctx, "reassign", vol.ID, s.reassign(ctx, vol, fromNode, toNode))
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) reassign(ctx context.Context, vol *volume.Info, fromNode, toNode string) error {
+	if fromNode == toNode {
+		return nil
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":   vol.ID,
+		"fromNode": fromNode,
+		"toNode":   toNode,
+	}).Info("reassigning volume")
+
+	existing, err := s.client.Resources.Get(ctx, vol.ID, toNode)
+	if nil404(err) != nil {
+		return err
+	}
+	created := existing.NodeName != toNode
+
+	err = s.attach(ctx, vol, toNode)
+	if err == nil {
+		err = s.waitForUsable(ctx, vol.ID, toNode)
+	}
+	if err != nil {
+		if created {
+			s.rollbackAssignment(vol, toNode)
+		}
+		return fmt.Errorf("unable to reassign %s to node %s, keeping it on node %s: %v", vol.ID, toNode, fromNode, err)
+	}
+
+	if err := s.detach(ctx, vol, fromNode); nil404(err) != nil {
+		return fmt.Errorf("reassigned %s to node %s, but failed to detach it from node %s: %v", vol.ID, toNode, fromNode, err)
+	}
+
+	return nil
+}
+
+// rollbackAssignment removes a failed assignment of vol on node. It uses its
+// own deadline, as the failure may have been the caller's deadline expiring.
+func (s *Linstor) rollbackAssignment(vol *volume.Info, node string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.attachTimeout)
+	defer cancel()
+
+	if err := s.client.Resources.Delete(ctx, vol.ID, node); nil404(err) != nil {
+		s.log.WithFields(logrus.Fields{
+			"volume":     vol.ID,
+			"targetNode": node,
+		}).WithError(err).Error("failed to remove failed assignment")
+	}
+}
+
+// waitForUsable waits until all volumes of resName on node are either
+// diskless or UpToDate.
+func (s *Linstor) waitForUsable(ctx context.Context, resName, node string) error {
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+
+	for {
+		vols, err := s.client.Resources.GetVolumes(ctx, resName, node)
+		if err == nil && len(vols) != 0 && allUsable(vols) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("resource %s on node %s did not become usable: %v", resName, node, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func allUsable(vols []lapi.Volume) bool {
+	for _, v := range vols {
+		if v.State.DiskState != "UpToDate" && v.State.DiskState != "Diskless" {
+			return false
+		}
+	}
+	return true
+}
+
+// ForcePrimary forcefully promotes the DRBD resource of vol to primary on
+// node, even if DRBD refuses to, e.g. because quorum is lost. This is a last
+// resort for recovering stuck volumes: writes on node may diverge from the
+// other replicas, so that they have to be resolved manually, and data may be
+// lost. It only works on the node this plugin is running on and must be
+// enabled with AllowForcePrimary.
+func (s *Linstor) ForcePrimary(vol *volume.Info, node string) error {
+	if !s.allowForcePrimary {
+		return fmt.Errorf("refusing to force %s primary on node %s, forcing primary is not enabled", vol.ID, node)
+	}
+	if node != s.nodeName {
+		return fmt.Errorf("unable to force %s primary on node %s, only possible on node %q", vol.ID, node, s.nodeName)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":     vol.ID,
+		"targetNode": node,
+	}).Error("FORCING volume primary, replicas may diverge and data may be lost")
+
+	out, err := s.mounter.Run("drbdadm", "primary", "--force", vol.ID)
+	if err != nil {
+		return fmt.Errorf("failed to force %s primary on node %s: %v: %q", vol.ID, node, err, out)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":     vol.ID,
+		"targetNode": node,
+	}).Warn("forced volume primary")
+
+	return nil
+}
+
+// PrimaryNode returns the node on which the DRBD resource of vol is primary,
+// or an empty string if it is primary nowhere.
+func (s *Linstor) PrimaryNode(ctx context.Context, vol *volume.Info) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.lookupTimeout)
+	defer cancel()
+
+	resources, err := s.client.Resources.GetAll(ctx, vol.ID)
+	if err != nil {
+		return "", timeoutErr(ctx, "looking up primary", vol.ID, fmt.Errorf("unable to find assignments of %s: %v", vol.ID, err))
+	}
+	return primaryNode(resources), nil
+}
+
+// primaryNode returns the node of the resource that is in use, or an empty
+// string if none is.
+func primaryNode(resources []lapi.Resource) string {
+	for _, r := range resources {
+		if r.State.InUse {
+			return r.NodeName
+		}
+	}
+	return ""
+}
+
+// Roles that can be requested for a resource with RequestedRoleKey.
+const (
+	rolePrimary      = "Primary"
+	roleSecondary    = "Secondary"
+	roleForcePrimary = "ForcePrimary"
+)
+
+// SetPrimary makes the DRBD resource of vol primary on node, demoting it on the
+// node it is currently primary on, or only demotes it if node is empty. Nodes
+// promote and demote their resources themselves, so other nodes are asked to
+// through RequestedRoleKey, which their role watchers act on, see
+// StartRoleWatcher. Promotion is refused if less than a majority of the diskful
+// replicas are up to date, as the node would lack quorum, unless force is set,
+// which uses ForcePrimary on node.
+func (s *Linstor) SetPrimary(ctx context.Context, vol *volume.Info, node string, force bool) error {
+	ctx, span := s.startSpan(ctx, "setPrimary", vol.ID)
+	span.SetAttribute(SpanAttrNode, node)
+	ctx, cancel := context.WithTimeout(ctx, s.attachTimeout)
+	defer cancel()
+
+	err := timeoutErr(ctx, "setting primary", vol.ID, s.setPrimary(ctx, vol, node, force))
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) setPrimary(ctx context.Context, vol *volume.Info, node string, force bool) error {
+	// golinstor escapes the query of ListOpts into the path, so the view is
+	// filtered here.
+	view, err := s.client.Resources.GetResourceView(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to find assignments of %s: %v", vol.ID, err)
+	}
+	var resources []lapi.Resource
+	for _, r := range view {
+		if r.Name == vol.ID {
+			resources = append(resources, r)
+		}
+	}
+
+	current := primaryNode(resources)
+	if current == node {
+		return nil
+	}
+
+	log := s.log.WithFields(logrus.Fields{
+		"volume":         vol.ID,
+		"currentPrimary": current,
+		"targetNode":     node,
+	})
+
+	// Refuse before demoting, so that a refused failover leaves the current
+	// primary alone.
+	promote := rolePrimary
+	if node != "" {
+		if !hasAssignment(resources, node) {
+			return fmt.Errorf("volume %s has no assignment on node %s", vol.ID, node)
+		}
+		if upToDate, diskful := upToDateReplicas(resources); upToDate*2 <= diskful {
+			if !force {
+				return fmt.Errorf("refusing to promote %s on node %s without quorum, only %d of %d replicas are up to date", vol.ID, node, upToDate, diskful)
+			}
+			promote = roleForcePrimary
+		}
+	}
+
+	if current != "" {
+		if err := s.requestRole(ctx, vol.ID, current, roleSecondary); err != nil {
+			return fmt.Errorf("failed to demote %s on node %s: %v", vol.ID, current, err)
+		}
+		log.Info("demoted volume")
+	}
+	if node == "" {
+		return nil
+	}
+
+	if err := s.requestRole(ctx, vol.ID, node, promote); err != nil {
+		return fmt.Errorf("failed to promote %s on node %s: %v", vol.ID, node, err)
+	}
+	log.Info("promoted volume")
+	return nil
+}
+
+// hasAssignment reports whether resources contain one on node.
+func hasAssignment(resources []lapi.Resource, node string) bool {
+	for _, r := range resources {
+		if r.NodeName == node {
+			return true
+		}
+	}
+	return false
+}
+
+// requestRole gives the resource on node role. It is applied right away on the
+// node this plugin is running on, other nodes are asked to through
+// RequestedRoleKey. It waits until they did or ctx is done, in which case the
+// request is withdrawn.
+func (s *Linstor) requestRole(ctx context.Context, resName, node, role string) error {
+	if node == s.nodeName {
+		return s.applyRole(resName, role)
+	}
+
+	err := s.client.Resources.Modify(ctx, resName, node, lapi.ResourceDefinitionModify{
+		GenericPropsModify: lapi.GenericPropsModify{
+			OverrideProps: map[string]string{linstor.RequestedRoleKey: role},
+			DeleteProps:   []string{linstor.RequestedRoleErrorKey},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to request role %s: %v", role, err)
+	}
+
+	err = s.waitForRole(ctx, resName, node, role)
+	if err != nil && ctx.Err() != nil {
+		// Don't leave a request behind that the node may act on later.
+		withdrawCtx, cancel := context.WithTimeout(context.Background(), s.lookupTimeout)
+		defer cancel()
+		werr := s.client.Resources.Modify(withdrawCtx, resName, node, lapi.ResourceDefinitionModify{
+			GenericPropsModify: lapi.GenericPropsModify{DeleteProps: []string{linstor.RequestedRoleKey}},
+		})
+		if werr != nil {
+			s.log.WithError(werr).WithFields(logrus.Fields{
+				"volume":     resName,
+				"targetNode": node,
+			}).Warn("unable to withdraw requested role")
+		}
+	}
+	return err
+}
+
+// waitForRole blocks until the node of the resource acted on the requested
+// role, or ctx is done.
+func (s *Linstor) waitForRole(ctx context.Context, resName, node, role string) error {
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+
+	for {
+		res, err := s.client.Resources.Get(ctx, resName, node)
+		if err == nil {
+			if msg := res.Props[linstor.RequestedRoleErrorKey]; msg != "" {
+				return fmt.Errorf("node %s was unable to apply role %s: %s", node, role, msg)
+			}
+			if res.Props[linstor.RequestedRoleKey] == "" && res.State.InUse == (role != roleSecondary) {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("node %s did not apply role %s, is the plugin running there: %v", node, role, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyRole gives the resource on the node this plugin is running on role.
+func (s *Linstor) applyRole(resName, role string) error {
+	switch role {
+	case rolePrimary:
+		if out, err := s.mounter.Run("drbdadm", "primary", resName); err != nil {
+			return fmt.Errorf("%v: %q", err, out)
+		}
+	case roleSecondary:
+		if out, err := s.mounter.Run("drbdadm", "secondary", resName); err != nil {
+			return fmt.Errorf("%v: %q", err, out)
+		}
+	case roleForcePrimary:
+		return s.ForcePrimary(&volume.Info{ID: resName}, s.nodeName)
+	default:
+		return fmt.Errorf("unknown role %q, must be one of %s, %s, or %s", role, rolePrimary, roleSecondary, roleForcePrimary)
+	}
+	return nil
+}
+
+// StartRoleWatcher applies the roles requested for the resources on the node
+// this plugin is running on by SetPrimary on other nodes, every
+// roleWatchInterval until Close is called. Calling it again while the watcher
+// is running does nothing.
+func (s *Linstor) StartRoleWatcher() {
+	s.roleWatcherMu.Lock()
+	defer s.roleWatcherMu.Unlock()
+
+	if s.roleWatcherStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	s.roleWatcherStop = stop
+
+	s.log.WithFields(logrus.Fields{
+		"node": s.nodeName,
+	}).Debug("starting watcher of requested roles")
+
+	go func() {
+		ticker := time.NewTicker(roleWatchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), s.attachTimeout)
+				if err := s.applyRequestedRoles(ctx); err != nil {
+					s.log.WithError(err).Warn("unable to apply requested roles")
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+// applyRequestedRoles applies the roles requested for the resources on the
+// node this plugin is running on, and removes the requests. Roles that can't
+// be applied are reported through RequestedRoleErrorKey.
+func (s *Linstor) applyRequestedRoles(ctx context.Context) error {
+	// The view is filtered here, see setPrimary.
+	resources, err := s.client.Resources.GetResourceView(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list assignments on node %s: %v", s.nodeName, err)
+	}
+
+	for _, r := range resources {
+		role := r.Props[linstor.RequestedRoleKey]
+		if r.NodeName != s.nodeName || role == "" {
+			continue
+		}
+
+		log := s.log.WithFields(logrus.Fields{
+			"volume": r.Name,
+			"role":   role,
+		})
+
+		modify := lapi.GenericPropsModify{DeleteProps: []string{linstor.RequestedRoleKey}}
+		if err := s.applyRole(r.Name, role); err != nil {
+			log.WithError(err).Warn("unable to apply requested role")
+			modify.OverrideProps = map[string]string{linstor.RequestedRoleErrorKey: err.Error()}
+		} else {
+			log.Info("applied requested role")
+		}
+
+		err := s.client.Resources.Modify(ctx, r.Name, s.nodeName, lapi.ResourceDefinitionModify{GenericPropsModify: modify})
+		if err != nil {
+			return fmt.Errorf("unable to remove requested role of %s: %v", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// upToDateReplicas returns the number of diskful replicas in resources whose
+// volumes are all up to date, and the number of diskful replicas.
+func upToDateReplicas(resources []lapi.Resource) (int, int) {
+	var upToDate, diskful int
+	for _, r := range resources {
+		var hasDisk, current = false, len(r.Volumes) != 0
+		for _, v := range r.Volumes {
+			if v.State.DiskState != "Diskless" {
+				hasDisk = true
+			}
+			if v.State.DiskState != "UpToDate" {
+				current = false
+			}
+		}
+		if hasDisk {
+			diskful++
+			if current {
+				upToDate++
+			}
+		}
+	}
+	return upToDate, diskful
+}
+
+// GetVolume looks up the volume with the CSI volume id and reports its
+// condition, as needed for ControllerGetVolume. Returns a VolumeNotFoundError
+// if there is no such volume.
+func (s *Linstor) GetVolume(ctx context.Context, id string) (*volume.Info, *volume.Condition, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.lookupTimeout)
+	defer cancel()
+
+	vol, cond, err := s.getVolume(ctx, id)
+	return vol, cond, timeoutErr(ctx, "lookup", id, err)
+}
+
+func (s *Linstor) getVolume(ctx context.Context, id string) (*volume.Info, *volume.Condition, error) {
+	vol, err := s.getByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if vol == nil || vol.Deleted {
+		return nil, nil, &VolumeNotFoundError{ID: id}
+	}
+
+	desired, err := s.DesiredReplicas(vol)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resources, err := s.client.Resources.GetResourceView(ctx, &lapi.ListOpts{Resource: []string{vol.ID}})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to find assignments of %s: %v", vol.ID, err)
+	}
+	return vol, volumeCondition(resources, desired), nil
+}
+
+// VolumeNotFoundError is returned by GetVolume if there is no volume with the
+// requested id.
+type VolumeNotFoundError struct {
+	ID string
+}
+
+func (e *VolumeNotFoundError) Error() string {
+	return fmt.Sprintf("volume %s not found", e.ID)
+}
+
+// volumeCondition derives the condition of a volume from its resources and
+// the number of diskful replicas it should have.
+func volumeCondition(resources []lapi.Resource, desired int) *volume.Condition {
+	for _, r := range resources {
+		for _, f := range r.Flags {
+			if f == apiconst.FlagFailedDeployment || f == apiconst.FlagFailedDisconnect {
+				return &volume.Condition{Abnormal: true, Message: fmt.Sprintf("replica on node %s is in state %s", r.NodeName, f)}
+			}
+		}
+	}
+
+	upToDate, diskful := upToDateReplicas(resources)
+	switch {
+	case upToDate == 0:
+		return &volume.Condition{Abnormal: true, Message: fmt.Sprintf("none of %d diskful replicas are up to date", diskful)}
+	case upToDate < desired:
+		return &volume.Condition{Abnormal: true, Message: fmt.Sprintf("degraded: %d of %d replicas are up to date", upToDate, desired)}
+	case upToDate < diskful:
+		return &volume.Condition{Abnormal: true, Message: fmt.Sprintf("%d of %d diskful replicas are up to date", upToDate, diskful)}
+	}
+	return &volume.Condition{Message: fmt.Sprintf("%d of %d diskful replicas are up to date", upToDate, diskful)}
+}
+
+// SetDesiredReplicas records that vol should have count diskful replicas and
+// adds or removes replicas to converge to it.
+func (s *Linstor) SetDesiredReplicas(ctx context.Context, vol *volume.Info, count int) error {
+	if count < 1 {
+		return fmt.Errorf("volume %s needs at least one replica, got %d", vol.ID, count)
+	}
+	if s.maxReplicas != 0 && count > int(s.maxReplicas) {
+		return fmt.Errorf("requested %d replicas, but at most %d are allowed", count, s.maxReplicas)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.createTimeout)
+	defer cancel()
+
+	vol.DesiredReplicas = count
+	if err := s.saveVolume(ctx, vol); err != nil {
+		return timeoutErr(ctx, "setting desired replicas", vol.ID, err)
+	}
+
+	return timeoutErr(ctx, "reconciling replicas", vol.ID, s.reconcileReplicas(ctx, vol))
+}
+
+// DesiredReplicas returns the number of diskful replicas vol should have,
+// which is its placementCount unless set with SetDesiredReplicas.
+func (s *Linstor) DesiredReplicas(vol *volume.Info) (int, error) {
+	if vol.DesiredReplicas != 0 {
+		return vol.DesiredReplicas, nil
+	}
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return 0, err
+	}
+	return int(params.PlacementCount), nil
+}
+
+// mutableParams are the Parameters fields that UpdateParameters changes on
+// existing volumes. All of them are DRBD options, except for IOWeight, which
+// applies the next time the volume is mounted.
+var mutableParams = map[string]bool{
+	"ALExtents":     true,
+	"CFillTarget":   true,
+	"CMaxRate":      true,
+	"DiskFlushes":   true,
+	"IOWeight":      true,
+	"MDFlushes":     true,
+	"ReadBalancing": true,
+	"SyncAfter":     true,
+	"SyncRate":      true,
+}
+
+// UpdateParameters changes parameters of an existing volume, like its DRBD
+// options, to the values in changes. Changes of parameters that would require
+// recreating the volume, like its storage pool or size, are refused, and
+// nothing is changed then. The new DRBD options and the stored volume are
+// updated in a single modification of its resource definition.
+func (s *Linstor) UpdateParameters(ctx context.Context, vol *volume.Info, changes map[string]string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.createTimeout)
+	defer cancel()
+
+	return timeoutErr(ctx, "updating parameters", vol.ID, s.updateParameters(ctx, vol, changes))
+}
+
+func (s *Linstor) updateParameters(ctx context.Context, vol *volume.Info, changes map[string]string) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	current, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	merged := volume.MergeParameters(vol.Parameters, changes)
+	desired, err := volume.NewParameters(merged)
+	if err != nil {
+		return err
+	}
+
+	// Desired replicas are reconciled separately, only compare parameters.
+	diffs, err := volume.DiffParameters(&volume.Info{Name: vol.Name, Parameters: vol.Parameters}, merged)
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		if !mutableParams[d.Key] {
+			return fmt.Errorf("unable to update parameters of %s: %s cannot be changed on existing volumes, it is %s, requested %s", vol.ID, d.Key, d.Current, d.Desired)
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+
+	updated := *vol
+	updated.Parameters = merged
+	serializedVol, err := s.serializeVolume(&updated)
+	if err != nil {
+		return err
+	}
+
+	props := desired.DrbdOptions()
+	var deleted []string
+	for k := range current.DrbdOptions() {
+		if _, ok := props[k]; !ok {
+			deleted = append(deleted, k)
+		}
+	}
+	props[linstor.AnnotationsKey] = serializedVol
+
+	if err := s.client.ResourceDefinitions.Modify(ctx, vol.ID, lapi.GenericPropsModify{
+		OverrideProps: props,
+		DeleteProps:   deleted,
+	}); err != nil {
+		return fmt.Errorf("unable to update parameters of %s: %v", vol.ID, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":  vol.ID,
+		"changes": diffs,
+	}).Info("updated volume parameters")
+
+	vol.Parameters = merged
+	return nil
+}
+
+// ReconcileReplicas adds or removes diskful replicas of vol until it has as
+// many as DesiredReplicas returns.
+func (s *Linstor) ReconcileReplicas(ctx context.Context, vol *volume.Info) error {
+	ctx, cancel := context.WithTimeout(ctx, s.createTimeout)
+	defer cancel()
+
+	return timeoutErr(ctx, "reconciling replicas", vol.ID, s.reconcileReplicas(ctx, vol))
+}
+
+func (s *Linstor) reconcileReplicas(ctx context.Context, vol *volume.Info) error {
+	desired, err := s.DesiredReplicas(vol)
+	if err != nil {
+		return err
+	}
+
+	resources, err := s.client.Resources.GetAll(ctx, vol.ID)
+	if err != nil {
+		return fmt.Errorf("unable to find assignments of %s: %v", vol.ID, err)
+	}
+	current := len(util.DeployedDiskfullyNodes(resources))
+
+	log := s.log.WithFields(logrus.Fields{
+		"volume":          vol.ID,
+		"currentReplicas": current,
+		"desiredReplicas": desired,
+	})
+
+	switch {
+	case current < desired:
+		log.Info("adding replicas")
+		s.emit(EventWarning, "Degraded", "volume %s has %d of %d replicas, adding replicas", vol.ID, current, desired)
+		apRequest, err := vol.ToAutoPlace()
+		if err != nil {
+			return err
+		}
+		// Autoplace counts the existing replicas towards the place count.
+		apRequest.SelectFilter.PlaceCount = int32(desired)
+		release, err := s.waitForResyncSlot(ctx, vol)
+		if err != nil {
+			return err
+		}
+		err = s.client.Autoplace(ctx, vol, apRequest)
+		release()
+		if err != nil {
+			return fmt.Errorf("failed to add replicas of %s: %v", vol.ID, err)
+		}
+	case current > desired:
+		log.Info("removing replicas")
+		for _, r := range surplusReplicas(resources, current-desired) {
+			// Keep nodes using the volume attached.
+			if r.State.InUse {
+				if err := s.makeDiskless(ctx, vol, r.NodeName); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.client.Resources.Delete(ctx, vol.ID, r.NodeName); nil404(err) != nil {
+				return fmt.Errorf("failed to remove replica of %s on node %s: %v", vol.ID, r.NodeName, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// surplusReplicas picks n diskful replicas to remove from resources, replicas
+// that are not in use first.
+func surplusReplicas(resources []lapi.Resource, n int) []lapi.Resource {
+	var diskful []lapi.Resource
+	for _, r := range resources {
+		if util.DeployedDiskfully(r) {
+			diskful = append(diskful, r)
+		}
+	}
+
+	sort.SliceStable(diskful, func(i, j int) bool {
+		if diskful[i].State.InUse != diskful[j].State.InUse {
+			return !diskful[i].State.InUse
+		}
+		return diskful[i].NodeName < diskful[j].NodeName
+	})
+
+	if n > len(diskful) {
+		n = len(diskful)
+	}
+	return diskful[:n]
+}
+
+// IsPinned returns true if the replicas of vol must stay on their nodes.
+func (s *Linstor) IsPinned(vol *volume.Info) (bool, error) {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return false, err
+	}
+	return params.Pinned, nil
+}
+
+// EvacuateNode moves the diskful replicas of all volumes on node to other
+// nodes. Pinned volumes and volumes with invalid parameters are left alone,
+// their IDs are returned.
+func (s *Linstor) EvacuateNode(ctx context.Context, node string) ([]string, error) {
+	vols, err := s.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := s.client.Resources.GetResourceView(ctx, &lapi.ListOpts{Node: []string{node}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find assignments on node %s: %v", node, err)
+	}
+
+	move, skip := evacuationCandidates(vols, resources)
+
+	var skipped = make([]string, 0, len(skip))
+	for _, vol := range skip {
+		s.log.WithFields(logrus.Fields{
+			"volume": vol.ID,
+			"node":   node,
+		}).Info("not evacuating volume, it is pinned or has invalid parameters")
+		skipped = append(skipped, vol.ID)
+	}
+
+	for _, vol := range move {
+		if err := s.evacuate(ctx, vol, node); err != nil {
+			return skipped, err
+		}
+	}
+
+	return skipped, nil
+}
+
+// evacuationCandidates splits the vols with a diskful replica in resources
+// into those that are moved on evacuation and those that are not.
+func evacuationCandidates(vols []*volume.Info, resources []lapi.Resource) ([]*volume.Info, []*volume.Info) {
+	var diskful = make(map[string]bool)
+	for _, r := range resources {
+		if util.DeployedDiskfully(r) {
+			diskful[r.Name] = true
+		}
+	}
+
+	var move, skip []*volume.Info
+	for _, vol := range vols {
+		if !diskful[vol.ID] {
+			continue
+		}
+		params, err := volume.NewParameters(vol.Parameters)
+		if err != nil || params.Pinned {
+			skip = append(skip, vol)
+			continue
+		}
+		move = append(move, vol)
+	}
+	return move, skip
+}
+
+// evacuate places an additional replica of vol, waits for it to sync, and
+// then removes the replica on node. Nodes using the volume keep it attached
+// disklessly.
+func (s *Linstor) evacuate(ctx context.Context, vol *volume.Info, node string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.createTimeout)
+	defer cancel()
+
+	log := s.log.WithFields(logrus.Fields{
+		"volume": vol.ID,
+		"node":   node,
+	})
+	log.Info("evacuating volume")
+
+	resources, err := s.client.Resources.GetAll(ctx, vol.ID)
+	if err != nil {
+		return fmt.Errorf("unable to find assignments of %s: %v", vol.ID, err)
+	}
+	before := util.DeployedDiskfullyNodes(resources)
+
+	apRequest, err := vol.ToAutoPlace()
+	if err != nil {
+		return err
+	}
+	// Autoplace counts the existing replicas towards the place count.
+	apRequest.SelectFilter.PlaceCount = int32(len(before) + 1)
+	release, err := s.waitForResyncSlot(ctx, vol)
+	if err != nil {
+		return timeoutErr(ctx, "evacuate", vol.ID, err)
+	}
+	err = s.client.Autoplace(ctx, vol, apRequest)
+	release()
+	if err != nil {
+		return timeoutErr(ctx, "evacuate", vol.ID, fmt.Errorf("failed to place replacement replica of %s: %v", vol.ID, err))
+	}
+
+	resources, err = s.client.Resources.GetAll(ctx, vol.ID)
+	if err != nil {
+		return fmt.Errorf("unable to find assignments of %s: %v", vol.ID, err)
+	}
+	for _, n := range util.DeployedDiskfullyNodes(resources) {
+		if containsOpt(before, n) {
+			continue
+		}
+		if err := s.waitForUpToDate(ctx, vol.ID, n); err != nil {
+			return timeoutErr(ctx, "evacuate", vol.ID, err)
+		}
+	}
+
+	for _, r := range resources {
+		if r.NodeName != node {
+			continue
+		}
+		if r.State.InUse {
+			return timeoutErr(ctx, "evacuate", vol.ID, s.makeDiskless(ctx, vol, node))
+		}
+		if err := s.client.Resources.Delete(ctx, vol.ID, node); nil404(err) != nil {
+			return fmt.Errorf("failed to remove replica of %s on node %s: %v", vol.ID, node, err)
+		}
+	}
+
+	return nil
+}
+
+// MakeDiskful converts the diskless assignment of vol on node into a diskful
+// one backed by pool and waits until its data is in sync. If pool is empty,
+// the volume's storage pool is used. Assignments that are already diskful are
+// left alone.
+func (s *Linstor) MakeDiskful(ctx context.Context, vol *volume.Info, node, pool string) error {
+	ctx, span := s.startSpan(ctx, "makeDiskful", vol.ID)
+	span.SetAttribute(SpanAttrNode, node)
+	ctx, cancel := context.WithTimeout(ctx, s.attachTimeout)
+	defer cancel()
+
+	err := timeoutErr(ctx, "make diskful", vol.ID, s.makeDiskful(ctx, vol, node, pool))
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) makeDiskful(ctx context.Context, vol *volume.Info, node, pool string) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	res, err := s.client.Resources.Get(ctx, vol.ID, node)
+	if err != nil {
+		return fmt.Errorf("unable to find assignment of %s on node %s: %v", vol.ID, node, err)
+	}
+
+	if util.DeployedDiskfully(res) {
+		return nil
+	}
+	if !util.DeployedDisklessly(res) {
+		return fmt.Errorf("assignment of %s on node %s is not a healthy diskless assignment, flags: %v", vol.ID, node, res.Flags)
+	}
+
+	if pool == "" {
+		params, err := volume.NewParameters(vol.Parameters)
+		if err != nil {
+			return err
+		}
+		pool = params.StoragePool
+	}
+	if pool != "" {
+		if _, err := s.client.Nodes.GetStoragePool(ctx, node, pool); err != nil {
+			return fmt.Errorf("unable to use storage pool %s on node %s: %v", pool, node, err)
+		}
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":      vol.ID,
+		"targetNode":  node,
+		"storagePool": pool,
+	}).Info("making assignment diskful")
+
+	release, err := s.waitForResyncSlot(ctx, vol, node)
+	if err != nil {
+		return err
+	}
+	err = s.client.Resources.Diskful(ctx, vol.ID, node, pool)
+	release()
+	if err != nil {
+		return fmt.Errorf("failed to make assignment of %s on node %s diskful: %v", vol.ID, node, err)
+	}
+
+	return s.waitForUpToDate(ctx, vol.ID, node)
+}
+
+// MakeDiskless converts the diskful assignment of vol on node into a diskless
+// one, reclaiming its local storage while keeping the node attached. It
+// refuses to do so unless another diskful assignment is UpToDate. Assignments
+// that are already diskless are left alone.
+func (s *Linstor) MakeDiskless(ctx context.Context, vol *volume.Info, node string) error {
+	ctx, span := s.startSpan(ctx, "makeDiskless", vol.ID)
+	span.SetAttribute(SpanAttrNode, node)
+	ctx, cancel := context.WithTimeout(ctx, s.attachTimeout)
+	defer cancel()
+
+	err := timeoutErr(ctx, "make diskless", vol.ID, s.makeDiskless(ctx, vol, node))
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) makeDiskless(ctx context.Context, vol *volume.Info, node string) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+
+	resources, err := s.client.Resources.GetAll(ctx, vol.ID)
+	if err != nil {
+		return fmt.Errorf("unable to find assignments of %s: %v", vol.ID, err)
+	}
+
+	var found, otherUpToDate bool
+	for _, r := range resources {
+		if r.NodeName == node {
+			found = true
+			if util.DeployedDisklessly(r) {
+				return nil
+			}
+			continue
+		}
+		if !util.DeployedDiskfully(r) {
+			continue
+		}
+		vols, err := s.client.Resources.GetVolumes(ctx, vol.ID, r.NodeName)
+		if err == nil && len(vols) != 0 && allUpToDate(vols) {
+			otherUpToDate = true
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("volume %s has no assignment on node %s", vol.ID, node)
+	}
+	if !otherUpToDate {
+		return fmt.Errorf("refusing to make assignment of %s on node %s diskless: no other UpToDate diskful assignment", vol.ID, node)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":              vol.ID,
+		"targetNode":          node,
+		"disklessStoragePool": params.DisklessStoragePool,
+	}).Info("making assignment diskless")
+
+	if err := s.client.Resources.Diskless(ctx, vol.ID, node, params.DisklessStoragePool); err != nil {
+		return fmt.Errorf("failed to make assignment of %s on node %s diskless: %v", vol.ID, node, err)
+	}
+
+	return nil
+}
+
+// waitForUpToDate blocks until all volumes of the resource on node are
+// UpToDate or ctx is done.
+func (s *Linstor) waitForUpToDate(ctx context.Context, resName, node string) error {
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+
+	for {
+		vols, err := s.client.Resources.GetVolumes(ctx, resName, node)
+		if err == nil && len(vols) != 0 && allUpToDate(vols) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("resource %s on node %s did not sync: %v", resName, node, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func allUpToDate(vols []lapi.Volume) bool {
+	for _, v := range vols {
+		if v.State.DiskState != "UpToDate" {
+			return false
+		}
+	}
+	return true
+}
+
+// CapacityBytes returns the amount of free space in the storage pool specified
+// the the params.
+func (s *Linstor) CapacityBytes(ctx context.Context, parameters map[string]string) (int64, error) {
+	params, err := volume.NewParameters(parameters)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get capacity: %v", err)
+	}
+	if params.StoragePool == "" {
+		params.StoragePool = s.defaultStoragePool
+	}
+
+	pools, err := s.client.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get capacity for storage pool %s: %v", params.StoragePool, err)
+	}
+
+	var total int64
+	for _, sp := range pools {
+		if params.StoragePool == sp.StoragePoolName || params.StoragePool == "" {
+			total += sp.FreeCapacity
+		}
+	}
+
+	return int64(data.NewKibiByte(data.KiB * data.ByteSize(total)).To(data.B)), nil
+}
+
+// CanPlace reports whether a volume of sizeBytes with the given parameters
+// could currently be placed, along with a human readable reason. It only reads
+// the state of nodes, storage pools, and resources, nothing is created. The
+// result is a best-effort simulation and LINSTOR may still decide otherwise.
+func (s *Linstor) CanPlace(ctx context.Context, parameters map[string]string, sizeBytes int64) (bool, string, error) {
+	params, err := volume.NewParameters(parameters)
+	if err != nil {
+		return false, "", err
+	}
+	if params.StoragePool == "" {
+		params.StoragePool = s.defaultStoragePool
+	}
+
+	sizeKiB, err := s.AllocationSizeKiB(sizeBytes, 0)
+	if err != nil {
+		return false, "", err
+	}
+
+	if s.maxReplicas != 0 && params.PlacementCount > s.maxReplicas {
+		if !s.clampReplicas {
+			return false, fmt.Sprintf("requested %d replicas, but at most %d are allowed", params.PlacementCount, s.maxReplicas), nil
+		}
+		params.PlacementCount = s.maxReplicas
+	}
+
+	var (
+		nodes     []lapi.Node
+		pools     []lapi.StoragePool
+		resources []lapi.Resource
+	)
+	g, egctx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		nodes, err = s.client.Nodes.GetAll(egctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		pools, err = s.client.Nodes.GetStoragePoolView(egctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		resources, err = s.client.Resources.GetResourceView(egctx)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return false, "", fmt.Errorf("unable to determine cluster state: %v", err)
+	}
+
+	return canPlace(params, sizeKiB, nodes, pools, resources)
+}
+
+// canPlace simulates placing a volume of sizeKiB with params onto nodes.
+func canPlace(params volume.Parameters, sizeKiB int64, nodes []lapi.Node, pools []lapi.StoragePool, resources []lapi.Resource) (bool, string, error) {
+	online := make(map[string]lapi.Node)
+	for _, n := range nodes {
+		if n.ConnectionStatus == "ONLINE" {
+			online[n.Name] = n
+		}
+	}
+
+	// Largest free space of a fitting diskful pool, per node.
+	free := make(map[string]int64)
+	for _, sp := range pools {
+		if sp.ProviderKind == lapi.DISKLESS {
+			continue
+		}
+		if params.StoragePool != "" && sp.StoragePoolName != params.StoragePool {
+			continue
+		}
+		if sp.FreeCapacity > free[sp.NodeName] {
+			free[sp.NodeName] = sp.FreeCapacity
+		}
+	}
+
+	if params.PlacementPolicy == topology.Manual {
+		for _, n := range params.NodeList {
+			if _, ok := online[n]; !ok {
+				return false, fmt.Sprintf("node %s is not online", n), nil
+			}
+			if free[n] < sizeKiB {
+				return false, fmt.Sprintf("node %s has only %d KiB of the required %d KiB free in storage pool %q", n, free[n], sizeKiB, params.StoragePool), nil
+			}
+		}
+		for _, n := range params.ClientList {
+			if _, ok := online[n]; !ok {
+				return false, fmt.Sprintf("client node %s is not online", n), nil
+			}
+		}
+		return true, fmt.Sprintf("all %d nodes of nodeList can hold the volume", len(params.NodeList)), nil
+	}
+
+	excluded, err := util.ExcludedNodes(params, resources)
+	if err != nil {
+		return false, "", err
+	}
+
+	var eligible []lapi.Node
+	for name, n := range online {
+		if !excluded[name] && free[name] >= sizeKiB {
+			eligible = append(eligible, n)
+		}
+	}
+
+	required := int(params.PlacementCount)
+	if len(eligible) < required {
+		return false, fmt.Sprintf("only %d nodes are online, have %d KiB free in storage pool %q, and host no resources matching doNotPlaceWithRegex, %d required",
+			len(eligible), sizeKiB, params.StoragePool, required), nil
+	}
+
+	if len(params.ReplicasOnSame) == 0 && len(params.ReplicasOnDifferent) == 0 {
+		return true, fmt.Sprintf("%d of %d eligible nodes required", required, len(eligible)), nil
+	}
+
+	best := placementCapacity(eligible, params)
+	if best < required {
+		return false, fmt.Sprintf("at most %d eligible nodes satisfy replicasOnSame %v and replicasOnDifferent %v, %d required",
+			best, params.ReplicasOnSame, params.ReplicasOnDifferent, required), nil
+	}
+
+	return true, fmt.Sprintf("%d of %d eligible nodes satisfying replicasOnSame and replicasOnDifferent required", required, best), nil
+}
+
+// placementCapacity returns how many of the eligible nodes could hold replicas
+// satisfying replicasOnSame and replicasOnDifferent of params.
+func placementCapacity(eligible []lapi.Node, params volume.Parameters) int {
+	if len(params.ReplicasOnSame) == 0 && len(params.ReplicasOnDifferent) == 0 {
+		return len(eligible)
+	}
+
+	// Replicas must share the values of ReplicasOnSame, so group the nodes
+	// by them and see if any group has enough nodes that differ in each
+	// property of ReplicasOnDifferent.
+	groups := make(map[string][]lapi.Node)
+	for _, n := range eligible {
+		key, ok := util.PlacementProps(n, params.ReplicasOnSame)
+		if ok {
+			groups[key] = append(groups[key], n)
+		}
+	}
+
+	best := 0
+	for _, group := range groups {
+		if c := differentCapacity(group, params.ReplicasOnDifferent); c > best {
+			best = c
+		}
+	}
+	return best
+}
+
+// differentCapacity returns how many of nodes could hold replicas that differ
+// in each of the props. It is bounded by the prop with the fewest distinct
+// values, which may overestimate if several props are given.
+func differentCapacity(nodes []lapi.Node, props []string) int {
+	var candidates []lapi.Node
+	for _, n := range nodes {
+		if _, ok := util.PlacementProps(n, props); ok {
+			candidates = append(candidates, n)
+		}
+	}
+
+	capacity := len(candidates)
+	for _, p := range props {
+		values := make(map[string]bool)
+		for _, n := range candidates {
+			v, _ := util.NodeAuxProp(n, p)
+			values[v] = true
+		}
+		if len(values) < capacity {
+			capacity = len(values)
+		}
+	}
+	return capacity
+}
+
+// NodePoolCapacity returns the free space in bytes of the named storage pool,
+// keyed by node name. Nodes where the pool doesn't exist are omitted.
+func (s *Linstor) NodePoolCapacity(ctx context.Context, pool string) (map[string]int64, error) {
+	return s.client.NodePoolCapacity(ctx, pool)
+}
+
+// PoolProvisioningType returns whether the named storage pool is thin or thick
+// provisioned. Results are cached, as the provider of a pool rarely changes.
+func (s *Linstor) PoolProvisioningType(ctx context.Context, pool string) (string, error) {
+	s.poolTypesMu.Lock()
+	t, ok := s.poolTypes[pool]
+	s.poolTypesMu.Unlock()
+	if ok {
+		return t, nil
+	}
+
+	// Like in ListNodes, the request may flush the caches.
+	gen := atomic.LoadUint64(&s.cacheGen)
+	t, err := s.client.PoolProvisioningType(ctx, pool)
+	if err != nil {
+		return "", err
+	}
+
+	s.poolTypesMu.Lock()
+	defer s.poolTypesMu.Unlock()
+	if atomic.LoadUint64(&s.cacheGen) == gen {
+		if s.poolTypes == nil {
+			s.poolTypes = make(map[string]string)
+		}
+		s.poolTypes[pool] = t
+	}
+
+	return t, nil
+}
+
+// SnapCreate calls linstor to create a new snapshot on the volume indicated by
+// the SourceVolumeId contained in the CSI Snapshot.
+func (s *Linstor) SnapCreate(ctx context.Context, snap *volume.SnapInfo) (*volume.SnapInfo, error) {
+	if err := s.checkMaintenance(); err != nil {
+		return nil, err
+	}
+
+	vol, err := s.GetByID(ctx, snap.CsiSnap.SourceVolumeId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve volume info from id %s", snap.CsiSnap.SourceVolumeId)
+	}
+
+	if err := s.checkSnapshotReserve(ctx, vol); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %v", err)
+	}
+
+	if err := s.client.Resources.CreateSnapshot(ctx, lapi.Snapshot{
+		Name:         snap.Name,
+		ResourceName: vol.ID,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %v", err)
+	}
+
+	linSnap, err := s.client.Resources.GetSnapshot(ctx, vol.ID, snap.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %v", err)
+	}
+
+	// Fill in missing snapshot fields on creation, keep original SourceVolumeId.
+	snap.CsiSnap = &csi.Snapshot{
+		SnapshotId:     linSnap.Name,
+		SourceVolumeId: snap.CsiSnap.SourceVolumeId,
+		SizeBytes:      int64(data.NewKibiByte(data.KiB * data.ByteSize(linSnap.VolumeDefinitions[0].SizeKib)).InclusiveBytes()),
+		CreationTime:   ptypes.TimestampNow(),
+		ReadyToUse:     true,
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"linstorSnapshot": fmt.Sprintf("%+v", linSnap),
+		"csiSnapshot":     fmt.Sprintf("%+v", *snap),
+	}).Debug("created new snapshot")
+
+	// Update volume information to reflect the newly-added snapshot.
+	vol.Snapshots = append(vol.Snapshots, snap)
+	if err := s.saveVolume(ctx, vol); err != nil {
+		// We should at least try to delete the snapshot here, even though it succeeded
+		// without error it's going be unregistered as far as the CO is concerned.
+		if err := s.client.Resources.DeleteSnapshot(ctx, vol.ID, snap.Name); nil404(err) != nil {
+			s.log.WithError(err).Error("failed to clean up snapshot after recording its metadata failed")
+		}
+		return nil, fmt.Errorf("unable to record new snapshot metadata: %v", err)
+	}
+
+	return snap, nil
+}
+
+// checkSnapshotReserve refuses snapshots of vol if any thin storage pool that
+// holds one of its replicas has less free space than its reserve.
+func (s *Linstor) checkSnapshotReserve(ctx context.Context, vol *volume.Info) error {
+	if s.snapshotReserve == 0 && len(s.poolSnapshotReserve) == 0 {
+		return nil
+	}
+
+	resources, err := s.client.Resources.GetAll(ctx, vol.ID)
+	if err != nil {
+		return fmt.Errorf("unable to determine storage pools of %s: %v", vol.ID, err)
+	}
+
+	pools, err := s.client.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to determine storage pool capacity: %v", err)
+	}
+
+	return snapshotReserveViolation(resources, pools, func(pool string) float64 {
+		if r, ok := s.poolSnapshotReserve[pool]; ok {
+			return r
+		}
+		return s.snapshotReserve
+	})
+}
+
+// snapshotReserveViolation returns an error describing the first thin storage
+// pool of the diskful resources that has less free space than its reserve.
+func snapshotReserveViolation(resources []lapi.Resource, pools []lapi.StoragePool, reserve func(pool string) float64) error {
+	type nodePool struct{ node, pool string }
+	var byNodePool = make(map[nodePool]lapi.StoragePool, len(pools))
+	for _, sp := range pools {
+		byNodePool[nodePool{sp.NodeName, sp.StoragePoolName}] = sp
+	}
+
+	for _, r := range resources {
+		if !util.DeployedDiskfully(r) {
+			continue
+		}
+		for _, v := range r.Volumes {
+			sp, ok := byNodePool[nodePool{r.NodeName, v.StoragePool}]
+			if !ok || (sp.ProviderKind != lapi.LVM_THIN && sp.ProviderKind != lapi.ZFS_THIN) || sp.TotalCapacity == 0 {
+				continue
+			}
+
+			free := float64(sp.FreeCapacity) / float64(sp.TotalCapacity) * 100
+			if free < reserve(sp.StoragePoolName) {
+				return fmt.Errorf("storage pool %s on node %s has %.1f%% free space, below its snapshot reserve of %.1f%%",
+					sp.StoragePoolName, r.NodeName, free, reserve(sp.StoragePoolName))
+			}
+		}
+	}
+
+	return nil
+}
+
+// SnapDelete calls LINSTOR to delete the snapshot based on the CSI Snapshot ID.
+func (s *Linstor) SnapDelete(ctx context.Context, snap *volume.SnapInfo) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	vol, err := s.GetByID(ctx, snap.CsiSnap.SourceVolumeId)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve volume info from id %s", snap.CsiSnap.SourceVolumeId)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"snapshot": fmt.Sprintf("%+v", snap),
+	}).Info("deleting snapshot")
+
+	if err := s.client.Resources.DeleteSnapshot(ctx, vol.Name, snap.Name); nil404(err) != nil {
+		return fmt.Errorf("failed to remove snaphsot: %v", err)
+	}
+
+	// Record the changes to the volume's snaphots
+	updatedSnaps := make([]*volume.SnapInfo, 0)
+	for _, s := range vol.Snapshots {
+		if s.CsiSnap.SnapshotId != snap.CsiSnap.SnapshotId {
+			updatedSnaps = append(updatedSnaps, s)
+		}
+	}
+	vol.Snapshots = updatedSnaps
+
+	// The volume was only kept for its snapshots. Volumes in their deletion
+	// grace period are left to the reaper, they may still be restored.
+	if vol.Deleted && vol.DeletionTime == nil && len(vol.Snapshots) == 0 {
+		s.log.WithFields(logrus.Fields{
+			"volume": vol.ID,
+		}).Info("last snapshot of deleted volume removed, deleting volume")
+		return s.deleteResourceDefinition(ctx, vol)
+	}
+
+	if err := s.saveVolume(ctx, vol); err != nil {
+		if err := s.client.Resources.DeleteSnapshot(ctx, vol.ID, snap.Name); nil404(err) != nil {
+			s.log.WithError(err).Error("failed to update snapshot list after recording its metadata failed")
+		}
+		return fmt.Errorf("unable to record new snapshot metadata: %v", err)
+	}
+
+	return nil
+}
+
+// VolFromSnap creates the volume using the data contained within the snapshot.
+func (s *Linstor) VolFromSnap(ctx context.Context, snap *volume.SnapInfo, vol *volume.Info) error {
+	ctx, span := s.startSpan(ctx, "createFromSnapshot", vol.Name)
+	ctx, cancel := context.WithTimeout(ctx, s.createTimeout)
+	defer cancel()
+
+	err := timeoutErr(ctx, "create", vol.Name, s.volFromSnap(ctx, snap, vol))
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) volFromSnap(ctx context.Context, snap *volume.SnapInfo, vol *volume.Info) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":   fmt.Sprintf("%+v", vol),
+		"snapshot": fmt.Sprintf("%+v", snap),
+	}).Info("creating volume from snapshot")
+
+	if err := s.createResourceDefinition(ctx, vol); err != nil {
+		return err
+	}
+
+	r, err := s.client.Resources.GetAll(ctx, vol.ID)
+	if err != nil {
+		return err
+	}
+
+	snapRestore := lapi.SnapshotRestore{
+		ToResource: vol.ID,
+		Nodes:      util.DeployedDiskfullyNodes(r),
+	}
+
+	if err := s.client.Resources.RestoreVolumeDefinitionSnapshot(ctx, snap.CsiSnap.SourceVolumeId, snap.Name, snapRestore); err != nil {
+		return err
+	}
+
+	if err := s.client.Resources.RestoreSnapshot(ctx, snap.CsiSnap.SourceVolumeId, snap.Name, snapRestore); err != nil {
+		return err
+	}
+
+	return s.growRestoredVolume(ctx, vol)
+}
+
+// growRestoredVolume resizes a restored volume to its requested size if the
+// snapshot it was restored from is smaller, and marks it so that the
+// filesystem is grown to fill the device on its first mount.
+func (s *Linstor) growRestoredVolume(ctx context.Context, vol *volume.Info) error {
+	volDefs, err := s.client.ResourceDefinitions.GetVolumeDefinitions(ctx, vol.ID)
+	if err != nil {
+		return fmt.Errorf("unable to determine size of restored volume: %v", err)
+	}
+
+	requiredKiB := uint64(data.NewKibiByte(data.ByteSize(vol.SizeBytes)).Value())
+	for _, vd := range volDefs {
+		if vd.SizeKib >= requiredKiB {
+			continue
+		}
+
+		s.log.WithFields(logrus.Fields{
+			"volume":       vol.ID,
+			"restoredKiB":  vd.SizeKib,
+			"requestedKiB": requiredKiB,
+		}).Info("restored volume is smaller than requested, growing it")
+
+		if err := s.client.ResourceDefinitions.ModifyVolumeDefinition(ctx, vol.ID, int(vd.VolumeNumber),
+			lapi.VolumeDefinitionModify{SizeKib: requiredKiB}); err != nil {
+			return fmt.Errorf("failed to grow restored volume to %d KiB: %v", requiredKiB, err)
+		}
+		vol.GrowFSOnMount = true
+	}
+
+	if !vol.GrowFSOnMount {
+		return nil
+	}
+	// The filesystem must not be grown beyond what some replica provides.
+	if err := s.waitForGrowth(ctx, vol.ID, int64(requiredKiB)); err != nil {
+		return err
+	}
+	s.emit(EventNormal, "Resized", "grew restored volume %s to %d KiB", vol.ID, requiredKiB)
+	return s.saveVolume(ctx, vol)
+}
+
+// OfflineExpandError is returned by Expand for volumes that can only be
+// expanded while they are not in use, but are.
+type OfflineExpandError struct {
+	Volume string
+	Node   string
+}
+
+func (e *OfflineExpandError) Error() string {
+	return fmt.Sprintf("volume %s can only be expanded while it is not in use, but it is in use on node %s", e.Volume, e.Node)
+}
+
+// Expand grows vol to sizeBytes and waits for its replicas to grow. Volumes
+// whose layers or filesystem don't support online expansion are refused while
+// they are in use.
+func (s *Linstor) Expand(ctx context.Context, vol *volume.Info, sizeBytes int64) error {
+	ctx, span := s.startSpan(ctx, "expand", vol.ID)
+	span.SetAttribute(SpanAttrSizeBytes, sizeBytes)
+	ctx, cancel := context.WithTimeout(ctx, s.createTimeout)
+	defer cancel()
+
+	err := timeoutErr(ctx, "expand", vol.ID, s.expand(ctx, vol, sizeBytes))
+	if err != nil {
+		s.emit(EventWarning, "ResizeFailed", "failed to expand volume %s: %v", vol.ID, err)
+	}
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) expand(ctx context.Context, vol *volume.Info, sizeBytes int64) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	if vol.SizeBytes >= sizeBytes {
+		return nil
+	}
+
+	online, err := volume.SupportsOnlineExpand(vol)
+	if err != nil {
+		return err
+	}
+	if !online {
+		resources, err := s.client.Resources.GetAll(ctx, vol.ID)
+		if err != nil {
+			return fmt.Errorf("unable to determine whether %s is in use: %v", vol.ID, err)
+		}
+		if node := primaryNode(resources); node != "" {
+			return &OfflineExpandError{Volume: vol.ID, Node: node}
+		}
+	}
+
+	volDefs, err := s.client.ResourceDefinitions.GetVolumeDefinitions(ctx, vol.ID)
+	if err != nil {
+		return fmt.Errorf("unable to determine size of %s: %v", vol.ID, err)
+	}
+
+	requiredKiB := uint64(data.NewKibiByte(data.ByteSize(sizeBytes)).Value())
+	for _, vd := range volDefs {
+		if vd.SizeKib >= requiredKiB {
+			continue
+		}
+
+		s.log.WithFields(logrus.Fields{
+			"volume":       vol.ID,
+			"currentKiB":   vd.SizeKib,
+			"requestedKiB": requiredKiB,
+		}).Info("expanding volume")
+
+		if err := s.client.ResourceDefinitions.ModifyVolumeDefinition(ctx, vol.ID, int(vd.VolumeNumber),
+			lapi.VolumeDefinitionModify{SizeKib: requiredKiB}); err != nil {
+			return fmt.Errorf("failed to expand %s to %d KiB: %v", vol.ID, requiredKiB, err)
+		}
+	}
+
+	// The filesystem must not be grown beyond what some replica provides.
+	if err := s.waitForGrowth(ctx, vol.ID, int64(requiredKiB)); err != nil {
+		s.emit(EventWarning, "ResizeFailed", "%v", err)
+		return err
+	}
+	s.emit(EventNormal, "Resized", "expanded volume %s to %d KiB", vol.ID, requiredKiB)
+
+	vol.SizeBytes = sizeBytes
+	return s.saveVolume(ctx, vol)
+}
+
+// waitForGrowth polls the diskful replicas of resName until all of them
+// report at least sizeKiB, for at most growTimeout.
+func (s *Linstor) waitForGrowth(ctx context.Context, resName string, sizeKiB int64) error {
+	ctx, cancel := context.WithTimeout(ctx, growTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+
+	var laggards []string
+	for {
+		// golinstor escapes the query of ListOpts into the path, so the view
+		// is filtered by laggardReplicas.
+		resources, err := s.client.Resources.GetResourceView(ctx)
+		if err == nil {
+			laggards = laggardReplicas(resources, resName, sizeKiB)
+			if len(laggards) == 0 {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if laggards == nil {
+				return fmt.Errorf("unable to verify that replicas of %s grew to %d KiB: %v", resName, sizeKiB, ctx.Err())
+			}
+			return fmt.Errorf("replicas of %s on nodes %v did not grow to %d KiB: %v", resName, laggards, sizeKiB, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// laggardReplicas returns the nodes of diskful resources of resName having a
+// volume smaller than sizeKiB.
+func laggardReplicas(resources []lapi.Resource, resName string, sizeKiB int64) []string {
+	var laggards = make([]string, 0)
+	for _, r := range resources {
+		if r.Name != resName || !util.DeployedDiskfully(r) {
+			continue
+		}
+		for _, v := range r.Volumes {
+			if v.UsableSizeKib < sizeKiB {
+				laggards = append(laggards, r.NodeName)
+				break
+			}
+		}
+	}
+	sort.Strings(laggards)
+	return laggards
+}
+
+// VolFromVol creates the volume using the data contained within the source volume.
+func (s *Linstor) VolFromVol(ctx context.Context, sourceVol, vol *volume.Info) error {
+	ctx, span := s.startSpan(ctx, "createFromVolume", vol.Name)
+	ctx, cancel := context.WithTimeout(ctx, s.createTimeout)
+	defer cancel()
+
+	err := timeoutErr(ctx, "create", vol.Name, s.volFromVol(ctx, sourceVol, vol))
+	span.End(err)
+	return err
+}
+
+func (s *Linstor) volFromVol(ctx context.Context, sourceVol, vol *volume.Info) error {
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":       fmt.Sprintf("%+v", vol),
+		"sourceVolume": fmt.Sprintf("%+v", sourceVol),
+	}).Info("creating volume from snapshot")
+
+	tmpName := s.fallbackPrefix + uuid.New()
+	if err := s.client.Resources.CreateSnapshot(ctx,
+		lapi.Snapshot{
+			Name:         tmpName,
+			ResourceName: sourceVol.ID,
+		}); err != nil {
+		return fmt.Errorf("failed to create snapshot: %v", err)
+	}
+
+	return s.volFromSnap(
+		ctx,
+		&volume.SnapInfo{Name: tmpName, CsiSnap: &csi.Snapshot{SourceVolumeId: sourceVol.ID}},
+		vol,
+	)
+}
+
+// Creates a resourceDefinition, updating the vol.ID if successful.
+func (s *Linstor) createResourceDefinition(ctx context.Context, vol *volume.Info) error {
+	// The creation time is part of the annotation, for reporting volume age.
+	if vol.CreationTime.IsZero() {
+		vol.CreationTime = time.Now()
+	}
+
+	resDefCreate, err := vol.ToResourceDefinitionCreate()
+	if err != nil {
+		return err
+	}
+
+	// Refuse oversized volumes before anything is created for them.
+	if _, err := s.serializeVolume(vol); err != nil {
+		return err
+	}
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if len(params.AddedLayers) != 0 {
+		s.log.WithFields(logrus.Fields{
+			"volume":      vol.Name,
+			"layerList":   params.LayerList,
+			"addedLayers": params.AddedLayers,
+		}).Warn("layer list lacks layers needed for the requested features, adding them")
+	}
+
+	if err := s.prepareEncryption(ctx, vol); err != nil {
+		return err
+	}
+
+	if err := s.validateSyncAfter(ctx, vol); err != nil {
+		return err
+	}
+
+	if err := s.checkCompression(ctx, vol); err != nil {
+		return err
+	}
+
+	if err := s.checkMetadataPool(ctx, vol); err != nil {
+		return err
+	}
+
+	if s.nameTemplate != nil || s.nameSanitizer != nil {
+		resDefCreate.ResourceDefinition.Name = s.templatedResourceName(ctx, vol)
+	}
+	resDefCreate.ResourceDefinition.Props[linstor.ManagedByKey] = s.managedBy
+
+	if params.ResourceDefinitionUUID != "" {
+		rds, err := s.client.ResourceDefinitions.GetAll(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to check resource definition UUID %s: %v", params.ResourceDefinitionUUID, err)
+		}
+		if owner := resDefWithUUID(rds, params.ResourceDefinitionUUID); owner != "" {
+			return fmt.Errorf("resource definition UUID %s requested for volume %s is already in use by %s",
+				params.ResourceDefinitionUUID, vol.Name, owner)
+		}
+	}
+
+	if err := s.client.ResourceDefinitions.Create(ctx, resDefCreate); err != nil {
+		return err
+	}
+
+	// Find the volume ID of the volume we just created.
+	rds, err := s.client.ResourceDefinitions.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, rd := range rds {
+		if rd.ExternalName == vol.Name {
+			vol.ID = rd.Name
+			// Older controllers generate the UUID regardless.
+			if params.ResourceDefinitionUUID != "" && !strings.EqualFold(rd.Uuid, params.ResourceDefinitionUUID) {
+				s.log.WithFields(logrus.Fields{
+					"volume":        vol.Name,
+					"requestedUUID": params.ResourceDefinitionUUID,
+					"actualUUID":    rd.Uuid,
+				}).Warn("LINSTOR did not use the requested resource definition UUID")
+			}
+		}
+	}
+
+	// Annotate resource definition with updated volume ID.
+	if err := s.saveVolume(ctx, vol); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateSyncAfter makes sure that the resource the volume should resync
+// after exists.
+func (s *Linstor) validateSyncAfter(ctx context.Context, vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if params.SyncAfter == "" {
+		return nil
+	}
+
+	if _, err := s.client.ResourceDefinitions.Get(ctx, params.SyncAfterResource()); err != nil {
+		return fmt.Errorf("unable to resync after %s: %v", params.SyncAfterResource(), err)
+	}
+	return nil
+}
+
+// prepareEncryption makes sure that encrypted volumes can be created: the LUKS
+// layer must be part of the layer list and the controller's master passphrase
+// must be unlocked, so that LINSTOR can generate a per-volume key. Volumes are
+// refused if the controller has no master passphrase, or if it is locked and
+// none is configured to unlock it.
+func (s *Linstor) prepareEncryption(ctx context.Context, vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+
+	if !params.Encryption {
+		return nil
+	}
+
+	state, err := s.masterPassphraseState(ctx)
+	if err != nil {
+		return fmt.Errorf("encryption requested for volume %s, but unable to determine the state of the master passphrase: %v",
+			vol.Name, err)
+	}
+
+	switch state {
+	case passphraseUnlocked:
+		return nil
+	case passphraseUnset:
+		return fmt.Errorf("encryption requested for volume %s, but the LINSTOR controller has no master passphrase", vol.Name)
+	}
+
+	if s.masterPassphrase == "" {
+		return fmt.Errorf("encryption requested for volume %s, but the master passphrase of the LINSTOR controller is not entered and none is configured",
+			vol.Name)
+	}
+
+	if err := s.client.Encryption.Enter(ctx, s.masterPassphrase); err != nil {
+		return fmt.Errorf("encryption requested for volume %s, but unable to unlock the master passphrase, is one configured on the controller? %v",
+			vol.Name, err)
+	}
+
+	return nil
+}
+
+// States of the controller's master passphrase, as reported by
+// masterPassphraseState.
+const (
+	passphraseUnset    = "UNSET"
+	passphraseLocked   = "LOCKED"
+	passphraseUnlocked = "UNLOCKED"
+	// passphraseUnknown is the state on controllers that do not report it.
+	passphraseUnknown = ""
+)
+
+// masterPassphraseState returns whether the controller's master passphrase
+// is set and unlocked.
+func (s *Linstor) masterPassphraseState(ctx context.Context) (string, error) {
+	var status struct {
+		Status string `json:"status"`
+	}
+	err := s.controllerGet(ctx, "/v1/encryption/passphrase", &status)
+	if err == lapi.NotFoundError {
+		return passphraseUnknown, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(status.Status), nil
+}
+
+// checkCompression makes sure that the storage pool of volumes requesting
+// compression supports it. Unsupported compression is only warned about,
+// unless the volume asks to be strict about it.
+func (s *Linstor) checkCompression(ctx context.Context, vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+
+	if params.Compression == "" {
+		return nil
+	}
+
+	pools, err := s.client.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to check compression support of storage pool %s: %v", params.StoragePool, err)
+	}
+
+	if err := compressionSupported(pools, params.StoragePool); err != nil {
+		if params.CompressionStrict {
+			return fmt.Errorf("compression requested for volume %s, but %v", vol.Name, err)
+		}
+		s.log.WithFields(logrus.Fields{
+			"volume":      vol.Name,
+			"compression": params.Compression,
+		}).WithError(err).Warn("ignoring compression of volume")
+	}
+
+	return nil
+}
+
+// compressionSupported returns an error unless every instance of the named
+// storage pool is backed by ZFS.
+func compressionSupported(pools []lapi.StoragePool, pool string) error {
+	if pool == "" {
+		return fmt.Errorf("no storagePool given, unable to tell whether it supports compression")
+	}
+
+	var found bool
+	for _, sp := range pools {
+		if sp.StoragePoolName != pool {
+			continue
+		}
+		if sp.ProviderKind != lapi.ZFS && sp.ProviderKind != lapi.ZFS_THIN {
+			return fmt.Errorf("storage pool %s on node %s is %s, only ZFS supports compression", pool, sp.NodeName, sp.ProviderKind)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("storage pool %s not found", pool)
+	}
+
+	return nil
+}
+
+// checkMetadataPool makes sure that enough nodes for the replicas of volumes
+// with external metadata have both the data and the metadata storage pool.
+func (s *Linstor) checkMetadataPool(ctx context.Context, vol *volume.Info) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+
+	if params.MetadataStoragePool == "" {
+		return nil
+	}
+
+	pools, err := s.client.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to check metadata storage pool %s: %v", params.MetadataStoragePool, err)
+	}
+
+	if err := metadataPoolAvailable(pools, params); err != nil {
+		return fmt.Errorf("external metadata requested for volume %s, but %v", vol.Name, err)
+	}
+	return nil
+}
+
+// resDefWithUUID returns the name of the resource definition with the given
+// UUID, or an empty string if there is none.
+func resDefWithUUID(rds []lapi.ResourceDefinition, id string) string {
+	for _, rd := range rds {
+		if strings.EqualFold(rd.Uuid, id) {
+			return rd.Name
+		}
+	}
+	return ""
+}
+
+// metadataPoolAvailable returns an error unless the data and metadata storage
+// pools of params are both present on all nodes of its node list, or on at
+// least as many nodes as it has replicas.
+func metadataPoolAvailable(pools []lapi.StoragePool, params volume.Parameters) error {
+	var hasData = make(map[string]bool)
+	var hasMeta = make(map[string]bool)
+	for _, sp := range pools {
+		// Without a storage pool, any other diskful one of the node holds
+		// the data.
+		if params.StoragePool == "" && sp.ProviderKind != lapi.DISKLESS && sp.StoragePoolName != params.MetadataStoragePool ||
+			sp.StoragePoolName == params.StoragePool {
+			hasData[sp.NodeName] = true
+		}
+		if sp.StoragePoolName == params.MetadataStoragePool {
+			hasMeta[sp.NodeName] = true
+		}
+	}
+
+	if len(params.NodeList) != 0 {
+		for _, n := range params.NodeList {
+			if !hasData[n] || !hasMeta[n] {
+				return fmt.Errorf("node %s lacks storage pool %q or metadata storage pool %s", n, params.StoragePool, params.MetadataStoragePool)
+			}
+		}
+		return nil
+	}
+
+	var nodes int
+	for n := range hasMeta {
+		if hasData[n] {
+			nodes++
+		}
+	}
+	if int32(nodes) < params.PlacementCount {
+		return fmt.Errorf("only %d nodes have storage pool %q and metadata storage pool %s, %d replicas requested",
+			nodes, params.StoragePool, params.MetadataStoragePool, params.PlacementCount)
+	}
+	return nil
+}
+
+// store a representation of a volume into the aux props of a resource definition.
+func (s *Linstor) saveVolume(ctx context.Context, vol *volume.Info) error {
+	serializedVol, err := s.serializeVolume(vol)
+	if err != nil {
+		return err
+	}
+	return s.setProps(ctx, vol, map[string]string{linstor.AnnotationsKey: serializedVol})
+}
+
+// serializeVolume returns the annotation stored for vol, or an error if it is
+// larger than maxAnnotationSize.
+func (s *Linstor) serializeVolume(vol *volume.Info) (string, error) {
+	serializedVol, err := json.Marshal(vol)
+	if err != nil {
+		return "", err
+	}
+	if s.maxAnnotationSize != 0 && len(serializedVol) > s.maxAnnotationSize {
+		return "", fmt.Errorf("unable to store volume %s: its serialization is %d bytes, more than the maximum of %d bytes, it has %d snapshots and %d parameters",
+			vol.ID, len(serializedVol), s.maxAnnotationSize, len(vol.Snapshots), len(vol.Parameters))
+	}
+	return string(serializedVol), nil
+}
+
+func (s *Linstor) setProps(ctx context.Context, vol *volume.Info, props map[string]string) error {
+	return s.client.ResourceDefinitions.Modify(ctx, vol.ID,
+		lapi.GenericPropsModify{
+			OverrideProps: props,
+		})
+}
+
+// templatedResourceName returns the resource name generated by the name
+// template for vol. It falls back to a random name if the generated one is
+// unusable or taken already.
+func (s *Linstor) templatedResourceName(ctx context.Context, vol *volume.Info) string {
+	name, err := templateResourceName(s.nameTemplate, vol, s.sanitizeName)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"volume": vol.Name,
+		}).WithError(err).Warn("unable to derive resource name, falling back to random name")
+		return s.fallbackPrefix + uuid.New()
+	}
+
+	if _, err := s.client.ResourceDefinitions.Get(ctx, name); err != lapi.NotFoundError {
+		s.log.WithFields(logrus.Fields{
+			"volume":       vol.Name,
+			"resourceName": name,
+		}).WithError(err).Warn("templated resource name is unavailable, falling back to random name")
+		return s.fallbackPrefix + uuid.New()
+	}
+
+	return name
+}
+
+// templateResourceName executes tmpl for vol, or takes the volume's name if
+// tmpl is nil, and turns the result into a valid, possibly shortened, LINSTOR
+// resource name with sanitize.
+func templateResourceName(tmpl *template.Template, vol *volume.Info, sanitize func(string) (string, error)) (string, error) {
+	var fields = struct {
+		Name         string
+		StorageClass string
+	}{Name: vol.Name}
+	if vol.Kubernetes != nil {
+		fields.StorageClass = vol.Kubernetes.StorageClass
+	}
+
+	var b strings.Builder
+	if tmpl == nil {
+		b.WriteString(vol.Name)
+	} else if err := tmpl.Execute(&b, fields); err != nil {
+		return "", err
+	}
+
+	name, err := sanitize(b.String())
+	if err == nil {
+		return name, nil
+	}
+
+	// Too long names can't be fixed up by linstorifying them, so shorten
+	// them, leaving room for the prefix linstorifying may add.
+	name, err = sanitize(truncate(b.String(), maxResourceNameLength))
+	if err == nil {
+		return name, nil
+	}
+	return sanitize(truncate(b.String(), maxResourceNameLength-len("LS_")))
+}
+
+// sanitizeName turns name into a valid LINSTOR resource name with the
+// configured NameSanitizer, or linstorifyResourceName if none is configured.
+func (s *Linstor) sanitizeName(name string) (string, error) {
+	if s.nameSanitizer == nil {
+		return linstorifyResourceName(name)
+	}
+
+	sanitized, err := s.nameSanitizer(name)
+	if err != nil {
+		return "", err
+	}
+	if err := validResourceName(sanitized); err != nil {
+		return "", fmt.Errorf("name sanitizer turned %q into invalid name %q: %v", name, sanitized, err)
+	}
+	return sanitized, nil
+}
+
+// maxResourceNameLength is the longest name LINSTOR accepts for resources.
+const maxResourceNameLength = 48
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// CanonicalizeSnapshotName makes sure that the snapshot name meets LINSTOR's
+// naming conventions.
+func (s *Linstor) CanonicalizeSnapshotName(ctx context.Context, suggestedName string) string {
+	// TODO: Snapshots actually have different naming requirements, it might
+	// be nice to conform to those eventually.
+	name, err := s.sanitizeName(suggestedName)
+	if err != nil {
+		return s.fallbackPrefix + uuid.New()
+	}
+	// We already handled the idempotency/existing case
+	// This is to make sure that nobody else created a snapshot with that name (e.g., another user/plugin)
+	existingSnap, err := s.GetSnapByName(ctx, name)
+	if existingSnap != nil || err != nil {
+		return s.fallbackPrefix + uuid.New()
+	}
+
+	return name
+}
+
+// ListVolumes returns all volumes that have metadata that is understandable
+// by this plugin, so volumes from multiple compatible plugins may be returned.
+func (s *Linstor) ListVolumes(ctx context.Context) ([]*volume.Info, error) {
+	allResDefs, err := s.client.ResourceDefinitions.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resource definitions: %v", err)
+	}
+
+	var vols = make([]*volume.Info, 0)
+
+	for _, rd := range allResDefs {
+		// If we encounter a failure here, we can assume that the resource was
+		// not created by a CSI driver, but we can't check here that it was
+		// created by this instance of the CSI driver in particular.
+		// Linstor names are CSI IDs.
+		vol, err := s.resourceDefinitionToVolume(rd)
+		if err != nil {
+			s.log.WithFields(logrus.Fields{
+				"resourceDefinition": fmt.Sprintf("%+v", rd),
+			}).WithError(err).Error("failed to internally represent volume but continuing — likely non-CSI volume")
+			continue
+		}
+
+		if vol != nil {
+			vols = append(vols, vol)
+		}
+	}
+
+	return vols, nil
+}
+
+// GetSnapByName retrieves a pointer to a volume.SnapInfo by its name.
+func (s *Linstor) GetSnapByName(ctx context.Context, name string) (*volume.SnapInfo, error) {
+	vols, err := s.ListVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.doGetSnapByName(vols, name), nil
+}
+
+func (s *Linstor) doGetSnapByName(vols []*volume.Info, name string) *volume.SnapInfo {
+	for _, vol := range vols {
+		for _, snap := range vol.Snapshots {
+			if snap.Name == name {
+				return snap
+			}
+		}
+	}
+	return nil
+}
+
+// GetSnapByID retrieves a pointer to a volume.SnapInfo by its id.
+func (s *Linstor) GetSnapByID(ctx context.Context, id string) (*volume.SnapInfo, error) {
+	vols, err := s.ListVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.doGetSnapByID(vols, id), nil
+}
+
+func (s *Linstor) doGetSnapByID(vols []*volume.Info, id string) *volume.SnapInfo {
+	for _, vol := range vols {
+		for _, snap := range vol.Snapshots {
+			if snap.CsiSnap.SnapshotId == id {
+				return snap
+			}
+		}
+	}
+	return nil
+}
+
+// SnapListForVolume returns the snapshots taken of vol, newest first.
+func (s *Linstor) SnapListForVolume(ctx context.Context, vol *volume.Info) ([]*volume.SnapInfo, error) {
+	vols, err := s.ListVolumes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots of %s: %v", vol.ID, err)
+	}
+
+	for _, v := range vols {
+		if v.ID == vol.ID {
+			snaps := snapsForVolume(vols, vol.ID)
+			if err := s.fillSnapshotUsage(ctx, snaps); err != nil {
+				return nil, fmt.Errorf("failed to list snapshots of %s: %v", vol.ID, err)
+			}
+			return snaps, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to list snapshots of %s: volume not found", vol.ID)
+}
+
+// fillSnapshotUsage sets the space consumption of snaps from the LINSTOR
+// snapshots of their source volumes.
+func (s *Linstor) fillSnapshotUsage(ctx context.Context, snaps []*volume.SnapInfo) error {
+	var bySource = make(map[string][]lapi.Snapshot)
+	for _, snap := range snaps {
+		source := snap.CsiSnap.SourceVolumeId
+		if _, ok := bySource[source]; ok {
+			continue
+		}
+		resSnaps, err := s.client.Resources.GetSnapshots(ctx, source)
+		if nil404(err) != nil {
+			return err
+		}
+		bySource[source] = resSnaps
+	}
+
+	for _, snap := range snaps {
+		for _, ls := range bySource[snap.CsiSnap.SourceVolumeId] {
+			if ls.Name == snap.Name {
+				snap.UsedBytes, snap.UsedBytesEstimated = snapshotUsage(ls)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// snapshotUsage returns the space a LINSTOR snapshot consumes on all its
+// nodes. LINSTOR reports only the logical size of snapshot volumes, not how
+// much they diverged from their resource, so the consumption is estimated
+// from the logical size for now.
+func snapshotUsage(snap lapi.Snapshot) (int64, bool) {
+	var sizeKiB uint64
+	for _, vd := range snap.VolumeDefinitions {
+		sizeKiB += vd.SizeKib
+	}
+	nodes := len(snap.Nodes)
+	if nodes == 0 {
+		nodes = 1
+	}
+	return int64(sizeKiB) * 1024 * int64(nodes), true
+}
+
+// snapsForVolume returns the snapshots whose source volume is id, newest first.
+func snapsForVolume(vols []*volume.Info, id string) []*volume.SnapInfo {
+	var snaps = make([]*volume.SnapInfo, 0)
+	for _, vol := range vols {
+		for _, snap := range vol.Snapshots {
+			if snap.CsiSnap != nil && snap.CsiSnap.SourceVolumeId == id {
+				snaps = append(snaps, snap)
+			}
+		}
+	}
+	volume.SnapSort(snaps)
+	for i, j := 0, len(snaps)-1; i < j; i, j = i+1, j-1 {
+		snaps[i], snaps[j] = snaps[j], snaps[i]
+	}
+	return snaps
+}
+
+// ListSnaps returns list of pointers to volume.SnapInfo based off of the
+// serialized snapshot info stored in resource definitions.
+func (s *Linstor) ListSnaps(ctx context.Context) ([]*volume.SnapInfo, error) {
+
+	vols, err := s.ListVolumes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %v", err)
+	}
+
+	g, egctx := errgroup.WithContext(ctx)
+	allSnaps := make(chan lapi.Snapshot, 1)
+	for _, vol := range vols {
+		res := vol.ID
+		g.Go(func() error {
+			resSnaps, err := s.client.Resources.GetSnapshots(egctx, res)
+			if err == nil {
+				for _, s := range resSnaps {
+					allSnaps <- s
+				}
+			}
+			return nil404(err)
+		})
+	}
+
+	var snaps = make([]*volume.SnapInfo, 0)
+	var done = make(chan bool, 1)
+	go func() {
+		for snap := range allSnaps {
+			snapCreatedByMe := s.doGetSnapByName(vols, snap.Name)
+			if snapCreatedByMe != nil {
+				snapCreatedByMe.UsedBytes, snapCreatedByMe.UsedBytesEstimated = snapshotUsage(snap)
+				snaps = append(snaps, snapCreatedByMe)
+			} else {
+				s.log.WithFields(logrus.Fields{
+					"missingSnapshot": fmt.Sprintf("%+v", snap),
+				}).Debug("unable to look up snap by its Name, potentially not made by me")
+			}
+		}
+		done <- true
+	}()
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	close(allSnaps)
+
+	<-done
+
+	return snaps, nil
+}
+
+// NodeAvailable makes sure that LINSTOR considers that the node is in an ONLINE
+// state.
+func (s *Linstor) NodeAvailable(ctx context.Context, node string) error {
+	n, err := s.client.Nodes.Get(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	if n.ConnectionStatus != "ONLINE" {
+		return fmt.Errorf("node %s", n.ConnectionStatus)
+	}
+
+	return nil
+}
+
+// NodeInfo describes a node known to LINSTOR.
+type NodeInfo struct {
+	Name string
+	// Type is the kind of node, e.g. SATELLITE, CONTROLLER, or COMBINED.
+	Type string
+	// ConnectionStatus is ONLINE if the controller is connected to the node.
+	ConnectionStatus string
+	// Address is the address the controller connects to, empty if unknown.
+	Address string
+}
+
+// ListNodes returns all nodes known to LINSTOR. Results are briefly cached to
+// spare the controller from frequent polling.
+func (s *Linstor) ListNodes(ctx context.Context) ([]NodeInfo, error) {
+	s.nodesMu.Lock()
+	if s.nodes != nil && !time.Now().After(s.nodesExpiry) {
+		var infos = make([]NodeInfo, len(s.nodes))
+		copy(infos, s.nodes)
+		s.nodesMu.Unlock()
+		return infos, nil
+	}
+	s.nodesMu.Unlock()
+
+	// The request may see the controller change, which flushes the caches,
+	// so s.nodesMu must not be held while it is made.
+	gen := atomic.LoadUint64(&s.cacheGen)
+	nodes, err := s.client.Nodes.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list nodes: %v", err)
+	}
+	infos := toNodeInfos(nodes)
+
+	s.nodesMu.Lock()
+	defer s.nodesMu.Unlock()
+	if atomic.LoadUint64(&s.cacheGen) == gen {
+		s.nodes = infos
+		s.nodesExpiry = time.Now().Add(s.nextNodesTTL())
+	}
+
+	var result = make([]NodeInfo, len(infos))
+	copy(result, infos)
+	return result, nil
+}
+
+// nextNodesTTL returns how long a fresh node list is cached and doubles the
+// TTL of the next one, up to nodeCacheTTL. s.nodesMu must be held.
+func (s *Linstor) nextNodesTTL() time.Duration {
+	ttl := s.nodesTTL
+	if ttl <= 0 || ttl >= nodeCacheTTL {
+		return nodeCacheTTL
+	}
+	s.nodesTTL = 2 * ttl
+	return ttl
+}
+
+func toNodeInfos(nodes []lapi.Node) []NodeInfo {
+	var infos = make([]NodeInfo, 0, len(nodes))
+	for _, n := range nodes {
+		info := NodeInfo{
+			Name:             n.Name,
+			Type:             n.Type,
+			ConnectionStatus: n.ConnectionStatus,
+		}
+		for _, nic := range n.NetInterfaces {
+			// Prefer the interface used for the satellite connection.
+			if info.Address == "" || nic.IsActive {
+				info.Address = nic.Address
+			}
+			if nic.IsActive {
+				break
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Device path schemes reported by DevicePathScheme.
+const (
+	// DevicePathMinor is /dev/drbd<minor>.
+	DevicePathMinor = "minor"
+	// DevicePathByRes is /dev/drbd/by-res/<resource>/<volume>.
+	DevicePathByRes = "by-res"
+	// DevicePathBacking is the backing device of volumes without DRBD, like
+	// /dev/<vg>/<lv>.
+	DevicePathBacking = "backing"
+)
+
+// DevicePathScheme returns the scheme of the device paths LINSTOR reports for
+// volumes on node: DevicePathMinor, DevicePathByRes, or DevicePathBacking if
+// none of them have DRBD. With mixed schemes, the most common DRBD scheme is
+// returned. It is an error if node has no volumes to judge by.
+func (s *Linstor) DevicePathScheme(ctx context.Context, node string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.lookupTimeout)
+	defer cancel()
+
+	resources, err := s.client.Resources.GetResourceView(ctx, &lapi.ListOpts{Node: []string{node}})
+	if err != nil {
+		return "", timeoutErr(ctx, "lookup", node, fmt.Errorf("unable to find resources on node %s: %v", node, err))
+	}
+
+	scheme := devicePathScheme(resources)
+	if scheme == "" {
+		return "", fmt.Errorf("unable to determine device path scheme of node %s, it has no volumes", node)
+	}
+	return scheme, nil
+}
+
+// devicePathScheme returns the most common device path scheme of the volumes
+// of resources, preferring DRBD schemes, or "" if there are no device paths.
+func devicePathScheme(resources []lapi.Resource) string {
+	var counts = make(map[string]int)
+	for _, r := range resources {
+		for _, v := range r.Volumes {
+			switch {
+			case v.DevicePath == "":
+			case strings.HasPrefix(v.DevicePath, "/dev/drbd/by-res/"):
+				counts[DevicePathByRes]++
+			case drbdMinorPath.MatchString(v.DevicePath):
+				counts[DevicePathMinor]++
+			default:
+				counts[DevicePathBacking]++
+			}
+		}
+	}
+
+	switch {
+	case len(counts) == 0:
+		return ""
+	case counts[DevicePathMinor] == 0 && counts[DevicePathByRes] == 0:
+		return DevicePathBacking
+	case counts[DevicePathByRes] > counts[DevicePathMinor]:
+		return DevicePathByRes
+	}
+	return DevicePathMinor
+}
+
+// drbdMinorPath matches DRBD device paths by minor number.
+var drbdMinorPath = regexp.MustCompile(`^/dev/drbd[0-9]+$`)
+
+// GetAssignmentOnNode returns a pointer to a volume.Assignment for a given node.
+func (s *Linstor) GetAssignmentOnNode(ctx context.Context, vol *volume.Info, node string) (*volume.Assignment, error) {
+	s.log.WithFields(logrus.Fields{
+		"volume":     fmt.Sprintf("%+v", vol),
+		"targetNode": node,
+	}).Debug("getting assignment info")
+
+	linVol, err := s.client.Resources.GetVolume(ctx, vol.ID, node, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	va := &volume.Assignment{
+		Vol:  vol,
+		Node: node,
+		Path: linVol.DevicePath,
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volumeAssignment": fmt.Sprintf("%+v", va),
+	}).Debug("found assignment info")
+
+	return va, nil
+}
+
+// sysBlockPath is where the kernel exposes block device statistics.
+const sysBlockPath = "/sys/block"
+
+// IOStats are the cumulative IO statistics of a volume's device on a node
+// since the device was created.
+type IOStats struct {
+	ReadOps    uint64
+	ReadBytes  uint64
+	WriteOps   uint64
+	WriteBytes uint64
+	InFlight   uint64
+	// QueueTime is the time requests spent waiting and being served.
+	QueueTime time.Duration
+}
+
+// VolumeIOStats returns the IO statistics of the volume's device on node. The
+// statistics are read from sysfs, so node must be the node this plugin is
+// running on.
+func (s *Linstor) VolumeIOStats(ctx context.Context, vol *volume.Info, node string) (*IOStats, error) {
+	if s.nodeName != "" && node != s.nodeName {
+		return nil, fmt.Errorf("IO statistics of %s can only be read on node %s, not %s", vol.ID, s.nodeName, node)
+	}
+
+	va, err := s.GetAssignmentOnNode(ctx, vol, node)
+	if err != nil {
+		return nil, fmt.Errorf("volume %s is not attached on node %s: %v", vol.ID, node, err)
+	}
+	if va.Path == "" {
+		return nil, fmt.Errorf("volume %s has no device on node %s", vol.ID, node)
+	}
+
+	device, err := filepath.EvalSymlinks(va.Path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve device %s: %v", va.Path, err)
+	}
+
+	stat, err := ioutil.ReadFile(filepath.Join(sysBlockPath, filepath.Base(device), "stat"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read IO statistics of %s: %v", device, err)
+	}
+
+	return parseBlockStat(string(stat))
+}
+
+// parseBlockStat parses the contents of a /sys/block/<dev>/stat file.
+func parseBlockStat(stat string) (*IOStats, error) {
+	// Sector counts in the stat file are always in 512 byte units.
+	const sectorSize = 512
+
+	fields := strings.Fields(stat)
+	if len(fields) < 11 {
+		return nil, fmt.Errorf("malformed block device statistics %q", stat)
+	}
+
+	var values [11]uint64
+	for i := range values {
+		v, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed block device statistics %q: %v", stat, err)
+		}
+		values[i] = v
+	}
+
+	return &IOStats{
+		ReadOps:    values[0],
+		ReadBytes:  values[2] * sectorSize,
+		WriteOps:   values[4],
+		WriteBytes: values[6] * sectorSize,
+		InFlight:   values[8],
+		QueueTime:  time.Duration(values[10]) * time.Millisecond,
+	}, nil
+}
+
+// Mount makes volumes consumable from the source to the target.
+// Filesystems are formatted and block devics are bind mounted.
+// Operates locally on the machines where it is called. The result describes
+// what was done, up to a failure.
+func (s *Linstor) Mount(vol *volume.Info, source, target, fsType string, options []string) (volume.MountResult, error) {
+	return s.mount(vol, source, target, fsType, options, nil)
+}
+
+// MountWithSecrets mounts like Mount, replacing references like
+// ${secret:key} in the mount options with the value of key in secrets. The
+// values are neither logged nor part of returned errors.
+func (s *Linstor) MountWithSecrets(vol *volume.Info, source, target, fsType string, options []string, secrets map[string]string) (volume.MountResult, error) {
+	return s.mount(vol, source, target, fsType, options, secrets)
+}
+
+func (s *Linstor) mount(vol *volume.Info, source, target, fsType string, options []string, secrets map[string]string) (volume.MountResult, error) {
+	var res volume.MountResult
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return res, fmt.Errorf("mounting volume failed: %v", err)
+	}
+
+	// Override default CSI fsType with the one passed in the StorageClass
+	if len(params.FSCandidates) > 1 {
+		fsType, err = s.chooseFilesystem(vol, source, params.FSCandidates)
+		if err != nil {
+			return res, fmt.Errorf("mounting volume failed: %v", err)
+		}
+	} else if params.FS != "" {
+		fsType = params.FS
+	}
+	// If there is no fsType, then this is a block mode volume.
+	var block bool
+	if fsType == "" {
+		block = true
+	}
+	res.FSType = fsType
+
+	// Merge mount options from Storage Classes and CSI calls.
+	options, err = mergeMountOptions(options, params.MountOpts)
+	if err != nil {
+		return res, fmt.Errorf("mounting volume failed: %v", err)
+	}
+
+	if params.FSErrors != "" && !block {
+		if !strings.HasPrefix(fsType, "ext") {
+			return res, fmt.Errorf("mounting volume failed: fsErrors is only supported on ext filesystems, not %q", fsType)
+		}
+		options = append(options, "errors="+params.FSErrors)
+	}
+
+	if params.Discard != volume.DiscardOff && !block {
+		if !supportsDiscard(fsType) {
+			return res, fmt.Errorf("mounting volume failed: discard is only supported on %v filesystems, not %q", discardFilesystems, fsType)
+		}
+		if params.Discard == volume.DiscardMount && !containsOpt(options, "discard") {
+			options = append(options, "discard")
+		}
+	}
+
+	if params.Barriers != "" && !block {
+		opt, err := barrierOpt(fsType, params.Barriers)
+		if err != nil {
+			return res, fmt.Errorf("mounting volume failed: %v", err)
+		}
+		if opt != "" {
+			options = append(options, opt)
+		}
+		if params.Barriers == volume.BarriersOff {
+			s.log.WithFields(logrus.Fields{
+				"volume": vol.ID,
+				"target": target,
+			}).Warn("mounting with write barriers disabled, data may be lost on power failure unless the storage has a non-volatile write cache")
+		}
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"volume":          fmt.Sprintf("%+v", vol),
+		"source":          source,
+		"target":          target,
+		"options":         options,
+		"filesystem":      fsType,
+		"blockAccessMode": block,
+	}).Info("mounting volume")
+
+	// Resolved options are only ever passed to mount, as they contain secrets.
+	mountOpts, secretValues, err := resolveSecretOpts(options, secrets)
+	if err != nil {
+		return res, fmt.Errorf("mounting volume failed: %v", err)
+	}
+
+	// Check if the path is a device
+	isDevice, err := s.mounter.PathIsDevice(source)
+	if err != nil {
+		return res, fmt.Errorf("checking device path failed: %v", err)
+	}
+	if !isDevice {
+		return res, fmt.Errorf("%s does not appear to be a block device", source)
+	}
+
+	// Determine if we have exclusive access to the device. This is mostly
+	// a way to determine if a disklessly attached device's connection is down.
+	opened, err := s.mounter.DeviceOpened(source)
+	if err != nil {
+		return res, fmt.Errorf("checking for exclusive open failed: %v, check device health", err)
+	}
+
+	// This is a regular filesystem so format the device and create the mountpoint.
+	if !block {
+		formatted, err := s.formatDevice(vol, source, fsType)
+		if err != nil {
+			return res, fmt.Errorf("mounting volume failed: %v", err)
+		}
+		res.Formatted = formatted
+		if err := s.mounter.MakeDir(target); err != nil {
+			return res, fmt.Errorf("could not create target directory %s, %v", target, err)
+		}
+		// This is a block volume so create a file to bindmount to.
+	} else {
+		err := s.mounter.MakeFile(target)
+		if err != nil {
+			return res, fmt.Errorf("could not create bind target for block volume %s, %v", target, err)
+		}
+	}
+
+	needsMount, err := s.mounter.IsNotMountPoint(target)
+	if err != nil {
+		return res, fmt.Errorf("unable to determine mount status of %s %v", target, err)
+	}
 
-// GetByName retrives a volume.Info that has a name that matches the CSI volume
-// Name, not nessesarily the LINSTOR resource name or UUID.
-func (s *Linstor) GetByName(ctx context.Context, name string) (*volume.Info, error) {
-	s.log.WithFields(logrus.Fields{
-		"csiVolumeName": name,
-	}).Debug("looking up resource by CSI volume name")
+	if !needsMount {
+		res.AlreadyMounted = true
+		return res, nil
+	}
 
-	list, err := s.client.ResourceDefinitions.GetAll(ctx)
-	if err != nil {
-		return nil, nil404(err)
+	retry := func(mount func() error) error {
+		return s.retryMount(vol.ID, params.MountRetryTimeout, params.MountRetryInterval, func() error {
+			return redactSecrets(mount(), secretValues)
+		})
 	}
 
-	for _, rd := range list {
-		vol, err := s.resourceDefinitionToVolume(rd)
-		// Probably found a resource we didn't create.
-		if err != nil || vol == nil {
-			continue
+	if block {
+		if err := retry(func() error { return s.mounter.Mount(source, target, fsType, mountOpts) }); err != nil {
+			return res, err
 		}
-
-		if vol.Name == name {
-			return vol, nil
+		if params.TargetMode != 0 || params.TargetUID != -1 || params.TargetGID != -1 {
+			s.log.WithField("target", target).Info("target permissions only apply to filesystem volumes, ignoring them")
+		}
+		s.setIOWeight(source, target, params.IOWeight)
+		if params.RemountOnRecovery && !containsOpt(options, "ro") {
+			s.startRemountWatcher(source, target, true)
 		}
+		return res, nil
 	}
-	return nil, nil
-}
 
-// GetByID retrives a volume.Info that has an id that matches the CSI volume
-// id. Matches the LINSTOR resource name.
-func (s *Linstor) GetByID(ctx context.Context, id string) (*volume.Info, error) {
-	s.log.WithFields(logrus.Fields{
-		"csiVolumeID": id,
-	}).Debug("looking up resource by CSI volume id")
+	// Checking a filesystem that is in use elsewhere would do more harm than good.
+	if params.FSCKOnMount != volume.FSCKOff && !opened {
+		if err := s.checkFilesystem(source, fsType, params.FSCKOnMount); err != nil {
+			return res, fmt.Errorf("mounting volume failed: %v", err)
+		}
+	}
 
-	res, err := s.client.ResourceDefinitions.Get(ctx, id)
-	if err != nil {
-		return nil, nil404(err)
+	if err := retry(func() error { return s.mounter.FormatAndMount(source, target, fsType, mountOpts) }); err != nil {
+		return res, err
 	}
 
-	return s.resourceDefinitionToVolume(res)
-}
+	if err := s.setTargetPermissions(target, params, options); err != nil {
+		return res, fmt.Errorf("mounting volume failed: %v", err)
+	}
 
-// Create creates the resource definition, volume definition, and assigns the
-// resulting resource to LINSTOR nodes.
-func (s *Linstor) Create(ctx context.Context, vol *volume.Info, req *csi.CreateVolumeRequest) error {
-	s.log.WithFields(logrus.Fields{
-		"volume": fmt.Sprintf("%+v", vol),
-	}).Info("creating volume")
+	s.setIOWeight(source, target, params.IOWeight)
 
-	if err := s.createResourceDefinition(ctx, vol); err != nil {
-		return err
+	if vol.GrowFSOnMount {
+		if err := s.growFS(vol, source, target); err != nil {
+			return res, fmt.Errorf("mounting volume failed: %v", err)
+		}
 	}
 
-	// Create the volume definition, now that vol has been updated with its ID.
-	if err := s.client.ResourceDefinitions.CreateVolumeDefinition(ctx, vol.ID,
-		lapi.VolumeDefinitionCreate{
-			VolumeDefinition: lapi.VolumeDefinition{SizeKib: uint64(data.NewKibiByte(data.ByteSize(vol.SizeBytes)).Value())}}); err != nil {
-		return err
+	if params.RemountOnRecovery && !containsOpt(options, "ro") {
+		s.startRemountWatcher(source, target, false)
 	}
 
-	volumeScheduler, err := s.schedulerByPlacementPolicy(vol)
-	if err != nil {
-		return err
+	if params.Discard == volume.DiscardFstrim && !containsOpt(options, "ro") {
+		s.startFstrimmer(target)
 	}
-	return volumeScheduler.Create(ctx, vol, req)
+
+	return res, nil
 }
 
-// Delete removes a resource, all of its volumes, and snapshots from LINSTOR.
-func (s *Linstor) Delete(ctx context.Context, vol *volume.Info) error {
-	s.log.WithFields(logrus.Fields{
-		"volume": fmt.Sprintf("%+v", vol),
-	}).Info("deleting volume")
+// transientMountErrors are parts of mount errors that go away by themselves,
+// e.g. while DRBD is still promoting the device or udev is still creating its
+// device node. Anything else, like an unknown filesystem, fails the same way
+// when retried.
+var transientMountErrors = []string{
+	"device or resource busy",
+	"no such device or address",
+	"no such file or directory",
+	"no medium found",
+	"wrong medium type",
+	"not ready",
+}
 
-	// Resources with snapshots cannot be deleted so we have to remove those first.
-	snaps, err := s.client.Resources.GetSnapshots(ctx, vol.ID)
-	if nil404(err) != nil {
-		return err
+func isTransientMountError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, e := range transientMountErrors {
+		if strings.Contains(msg, e) {
+			return true
+		}
 	}
+	return false
+}
 
-	g, egctx := errgroup.WithContext(ctx)
-	for _, snap := range snaps {
-		ss := snap.Name
-		g.Go(func() error {
-			if err := s.client.Resources.DeleteSnapshot(egctx, vol.ID, ss); nil404(err) != nil {
-				return err
+// retryMount calls mount until it succeeds, fails with an error that is not
+// transient, or timeout has passed, waiting interval between attempts.
+func (s *Linstor) retryMount(volID string, timeout, interval time.Duration, mount func() error) error {
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		err := mount()
+		if err == nil || !isTransientMountError(err) || time.Now().Add(interval).After(deadline) {
+			return err
+		}
+		s.log.WithFields(logrus.Fields{
+			"volume":  volID,
+			"attempt": attempt,
+		}).WithError(err).Info("mounting volume failed transiently, retrying")
+		time.Sleep(interval)
+	}
+}
+
+// secretRef matches references to secrets in mount options, like
+// password=${secret:key}.
+var secretRef = regexp.MustCompile(`\$\{secret:([^}]*)\}`)
+
+// resolveSecretOpts replaces secret references in options with their values
+// in secrets. It returns the resolved options and the values that were used.
+// Options without references are returned unchanged.
+func resolveSecretOpts(options []string, secrets map[string]string) ([]string, []string, error) {
+	var resolved = make([]string, len(options))
+	var values []string
+	for i, opt := range options {
+		var missing, invalid string
+		resolved[i] = secretRef.ReplaceAllStringFunc(opt, func(ref string) string {
+			key := secretRef.FindStringSubmatch(ref)[1]
+			v, ok := secrets[key]
+			if !ok {
+				missing = key
+				return ref
 			}
-			return nil
+			// mount joins options with commas, so a comma would split the
+			// value into further, unchecked options.
+			if strings.Contains(v, ",") {
+				invalid = key
+				return ref
+			}
+			values = append(values, v)
+			return v
 		})
+		if missing != "" {
+			return nil, nil, fmt.Errorf("mount option %q references secret %q, which is missing", opt, missing)
+		}
+		if invalid != "" {
+			return nil, nil, fmt.Errorf("mount option %q references secret %q, whose value contains a comma", opt, invalid)
+		}
 	}
-	if err := g.Wait(); err != nil {
+	return resolved, values, nil
+}
+
+// redactSecrets masks the secret values in the message of err, as mount
+// errors include the mount options.
+func redactSecrets(err error, values []string) error {
+	if len(values) == 0 {
 		return err
 	}
-
-	// No snapshots, remove the resource.
-	return nil404(s.client.ResourceDefinitions.Delete(ctx, vol.ID))
+	msg := err.Error()
+	for _, v := range values {
+		if v != "" {
+			msg = strings.Replace(msg, v, "<redacted>", -1)
+		}
+	}
+	return errors.New(msg)
 }
 
-// AccessibleTopologies returns a list of pointers to csi.Topology from where the
-// volume is reachable, based on the localStoragePolicy reported by the volume.
-func (s *Linstor) AccessibleTopologies(ctx context.Context, vol *volume.Info) ([]*csi.Topology, error) {
-	volumeScheduler, err := s.schedulerByPlacementPolicy(vol)
+// chooseFilesystem returns the filesystem of a volume with several candidate
+// filesystems and records it on the volume, so that later mounts use it too.
+func (s *Linstor) chooseFilesystem(vol *volume.Info, source string, candidates []string) (string, error) {
+	deviceFS, err := s.mounter.GetDiskFormat(source)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("unable to determine filesystem type of %s: %v", source, err)
 	}
-	return volumeScheduler.AccessibleTopologies(ctx, vol)
-}
 
-func (s *Linstor) schedulerByPlacementPolicy(vol *volume.Info) (scheduler.Interface, error) {
-	params, err := volume.NewParameters(vol.Parameters)
+	fsType, err := pickFilesystem(candidates, vol.FSType, deviceFS, mkfsInstalled)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	switch params.PlacementPolicy {
-	case topology.AutoPlace:
-		return autoplace.NewScheduler(s.client), nil
-	case topology.Manual:
-		return manual.NewScheduler(s.client), nil
-	case topology.FollowTopology:
-		return followtopology.NewScheduler(s.client, s.log), nil
-	case topology.Balanced:
-		return balancer.NewScheduler(s.client, s.log)
-	default:
-		return nil, fmt.Errorf("unsupported volume scheduler: %s", params.PlacementPolicy)
+	if fsType == vol.FSType {
+		return fsType, nil
 	}
-}
 
-// Attach idempotently creates a resource on the given node disklessly.
-func (s *Linstor) Attach(ctx context.Context, vol *volume.Info, node string) error {
 	s.log.WithFields(logrus.Fields{
-		"volume":     fmt.Sprintf("%+v", vol),
-		"targetNode": node,
-	}).Info("attaching volume")
+		"volume":     vol.ID,
+		"candidates": candidates,
+		"filesystem": fsType,
+	}).Info("chose filesystem")
 
-	// If the resource is already on the node, don't worry about attaching.
-	res, err := s.client.Resources.Get(ctx, vol.ID, node)
-	if nil404(err) != nil {
-		return err
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.mountTimeout)
+	defer cancel()
 
-	if res.NodeName == node {
+	vol.FSType = fsType
+	if err := timeoutErr(ctx, "mount", vol.ID, s.saveVolume(ctx, vol)); err != nil {
+		// Once formatted, the filesystem on the device is chosen anyway.
 		s.log.WithFields(logrus.Fields{
-			"volume":     fmt.Sprintf("%+v", vol),
-			"resource":   fmt.Sprintf("%+v", res),
-			"targetNode": node,
-		}).Info("volume already attached")
-		return nil
+			"volume": vol.ID,
+		}).WithError(err).Warn("unable to record chosen filesystem")
 	}
+	return fsType, nil
+}
 
-	rc, err := vol.ToDisklessResourceCreate(node)
-	if err != nil {
-		return err
+// pickFilesystem returns the filesystem on the device or, failing that, the
+// recorded filesystem, if it is one of the candidates. Otherwise, it returns
+// the first candidate that is installed.
+func pickFilesystem(candidates []string, recorded, deviceFS string, installed func(string) bool) (string, error) {
+	for _, fs := range []string{deviceFS, recorded} {
+		if fs != "" && containsOpt(candidates, fs) {
+			return fs, nil
+		}
+	}
+	for _, fs := range candidates {
+		if installed(fs) {
+			return fs, nil
+		}
 	}
-	return s.client.Resources.Create(ctx, rc)
+	return "", fmt.Errorf("none of the filesystems %v can be created on this node", candidates)
 }
 
-// Detach removes a volume from the node.
-func (s *Linstor) Detach(ctx context.Context, vol *volume.Info, node string) error {
-	res, err := s.client.Resources.Get(ctx, vol.ID, node)
-	if err != nil {
-		return err
+// mkfsInstalled returns true if the mkfs tool of fsType is installed.
+func mkfsInstalled(fsType string) bool {
+	_, err := exec.LookPath("mkfs." + fsType)
+	return err == nil
+}
+
+// discardFilesystems are the filesystems that support discard, be it as mount
+// option or through fstrim.
+var discardFilesystems = []string{"btrfs", "ext4", "xfs"}
+
+func supportsDiscard(fsType string) bool {
+	for _, fs := range discardFilesystems {
+		if fs == fsType {
+			return true
+		}
 	}
-	s.log.WithFields(logrus.Fields{
-		"resource":   fmt.Sprintf("%+v", res),
-		"targetNode": node,
-	}).Info("detaching volume")
+	return false
+}
 
-	if util.DeployedDiskfully(res) {
-		s.log.WithFields(logrus.Fields{
-			"resource":   fmt.Sprintf("%+v", res),
-			"targetNode": node,
-		}).Info("volume is diskfull on node, refusing to detach")
+// barrierOpt returns the mount option that sets write barriers of fsType on or
+// off. Modern xfs always uses barriers and no longer accepts turning them off.
+func barrierOpt(fsType, barriers string) (string, error) {
+	switch {
+	case strings.HasPrefix(fsType, "ext"):
+		if barriers == volume.BarriersOff {
+			return "barrier=0", nil
+		}
+		return "barrier=1", nil
+	case fsType == "xfs":
+		if barriers == volume.BarriersOff {
+			return "", fmt.Errorf("xfs does not support disabling write barriers")
+		}
+		return "", nil
+	default:
+		return "", fmt.Errorf("barriers is only supported on ext and xfs filesystems, not %q", fsType)
+	}
+}
+
+// setTargetPermissions sets the mode and owner requested by the volume's
+// parameters on the root directory of the freshly mounted filesystem at target.
+func (s *Linstor) setTargetPermissions(target string, params volume.Parameters, options []string) error {
+	if params.TargetMode == 0 && params.TargetUID == -1 && params.TargetGID == -1 {
 		return nil
 	}
 
-	return s.client.Resources.Delete(ctx, vol.ID, node)
+	if containsOpt(options, "ro") {
+		s.log.WithField("target", target).Warn("filesystem mounted read-only, not setting target permissions")
+		return nil
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"target": target,
+		"mode":   fmt.Sprintf("%#o", params.TargetMode),
+		"uid":    params.TargetUID,
+		"gid":    params.TargetGID,
+	}).Debug("setting target permissions")
+
+	if params.TargetMode != 0 {
+		if err := os.Chmod(target, params.TargetMode); err != nil {
+			return fmt.Errorf("unable to set mode of %s: %v", target, err)
+		}
+	}
+
+	if params.TargetUID != -1 || params.TargetGID != -1 {
+		if err := os.Chown(target, params.TargetUID, params.TargetGID); err != nil {
+			return fmt.Errorf("unable to set owner of %s: %v", target, err)
+		}
+	}
+
+	return nil
 }
 
-// CapacityBytes returns the amount of free space in the storage pool specified
-// the the params.
-func (s *Linstor) CapacityBytes(ctx context.Context, parameters map[string]string) (int64, error) {
-	params, err := volume.NewParameters(parameters)
+// ExpandFilesystem grows the filesystem mounted at target to the size of its
+// device, and fails if it did not grow. The devices of block volumes are
+// expanded already, there is nothing to grow for them.
+func (s *Linstor) ExpandFilesystem(target string) error {
+	info, err := os.Stat(target)
 	if err != nil {
-		return 0, fmt.Errorf("unable to get capacity: %v", err)
+		return fmt.Errorf("unable to expand filesystem at %s: %v", target, err)
+	}
+	if !info.IsDir() {
+		return nil
 	}
 
-	pools, err := s.client.Nodes.GetStoragePoolView(ctx)
+	mountPoints, err := s.mounter.List()
 	if err != nil {
-		return 0, fmt.Errorf("unable to get capacity for storage pool %s: %v", params.StoragePool, err)
+		return fmt.Errorf("unable to expand filesystem at %s: %v", target, err)
 	}
-
-	var total int64
-	for _, sp := range pools {
-		if params.StoragePool == sp.StoragePoolName || params.StoragePool == "" {
-			total += sp.FreeCapacity
+	var source string
+	for _, mp := range mountPoints {
+		if mp.Path == target {
+			source = mp.Device
 		}
 	}
+	if source == "" {
+		return fmt.Errorf("unable to expand filesystem at %s: nothing is mounted there", target)
+	}
 
-	return int64(data.NewKibiByte(data.KiB * data.ByteSize(total)).To(data.B)), nil
+	s.log.WithFields(logrus.Fields{
+		"source": source,
+		"target": target,
+	}).Info("growing filesystem of expanded volume to device size")
+
+	return s.resizeFilesystem(source, target)
 }
 
-// SnapCreate calls linstor to create a new snapshot on the volume indicated by
-// the SourceVolumeId contained in the CSI Snapshot.
-func (s *Linstor) SnapCreate(ctx context.Context, snap *volume.SnapInfo) (*volume.SnapInfo, error) {
-	vol, err := s.GetByID(ctx, snap.CsiSnap.SourceVolumeId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve volume info from id %s", snap.CsiSnap.SourceVolumeId)
+// growFS grows the filesystem of a cloned volume to the size of its device
+// and clears the mark that requested it.
+func (s *Linstor) growFS(vol *volume.Info, source, target string) error {
+	s.log.WithFields(logrus.Fields{
+		"volume": vol.ID,
+		"source": source,
+		"target": target,
+	}).Info("growing filesystem of cloned volume to device size")
+
+	if err := s.resizeFilesystem(source, target); err != nil {
+		return err
 	}
 
-	if err := s.client.Resources.CreateSnapshot(ctx, lapi.Snapshot{
-		Name:         snap.Name,
-		ResourceName: vol.ID,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to create snapshot: %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), s.mountTimeout)
+	defer cancel()
+
+	vol.GrowFSOnMount = false
+	if err := timeoutErr(ctx, "mount", vol.ID, s.saveVolume(ctx, vol)); err != nil {
+		// Growing again on the next mount is harmless.
+		s.log.WithFields(logrus.Fields{
+			"volume": vol.ID,
+		}).WithError(err).Warn("unable to clear filesystem grow mark")
 	}
 
-	linSnap, err := s.client.Resources.GetSnapshot(ctx, vol.ID, snap.Name)
+	return nil
+}
+
+// resizeFilesystem grows the filesystem on source mounted at target to the size
+// of source, and verifies that it grew.
+func (s *Linstor) resizeFilesystem(source, target string) error {
+	before, err := filesystemSize(target)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create snapshot: %v", err)
+		return fmt.Errorf("unable to determine filesystem size on %s: %v", source, err)
 	}
 
-	// Fill in missing snapshot fields on creation, keep original SourceVolumeId.
-	snap.CsiSnap = &csi.Snapshot{
-		SnapshotId:     linSnap.Name,
-		SourceVolumeId: snap.CsiSnap.SourceVolumeId,
-		SizeBytes:      int64(data.NewKibiByte(data.KiB * data.ByteSize(linSnap.VolumeDefinitions[0].SizeKib)).InclusiveBytes()),
-		CreationTime:   ptypes.TimestampNow(),
-		ReadyToUse:     true,
+	if _, err := s.mounter.Resize(source, target); err != nil {
+		return fmt.Errorf("unable to grow filesystem on %s: %v", source, err)
 	}
 
-	s.log.WithFields(logrus.Fields{
-		"linstorSnapshot": fmt.Sprintf("%+v", linSnap),
-		"csiSnapshot":     fmt.Sprintf("%+v", *snap),
-	}).Debug("created new snapshot")
+	after, err := filesystemSize(target)
+	if err != nil {
+		return fmt.Errorf("unable to verify grown filesystem on %s: %v", source, err)
+	}
+	deviceBytes, err := deviceSize(source)
+	if err != nil {
+		return fmt.Errorf("unable to verify grown filesystem on %s: %v", source, err)
+	}
+	if err := checkGrown(before, after, deviceBytes); err != nil {
+		return fmt.Errorf("unable to grow filesystem on %s: %v", source, err)
+	}
+	return nil
+}
 
-	// Update volume information to reflect the newly-added snapshot.
-	vol.Snapshots = append(vol.Snapshots, snap)
-	if err := s.saveVolume(ctx, vol); err != nil {
-		// We should at least try to delete the snapshot here, even though it succeeded
-		// without error it's going be unregistered as far as the CO is concerned.
-		if err := s.client.Resources.DeleteSnapshot(ctx, vol.ID, snap.Name); nil404(err) != nil {
-			s.log.WithError(err).Error("failed to clean up snapshot after recording its metadata failed")
-		}
-		return nil, fmt.Errorf("unable to record new snapshot metadata: %v", err)
+// grownFilesystemTolerance is how much smaller than their device filesystems
+// may be without growing, for the space taken by their metadata.
+const grownFilesystemTolerance = 0.1
+
+// filesystemSize returns the total size in bytes of the filesystem mounted
+// at target.
+func filesystemSize(target string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(target, &st); err != nil {
+		return 0, err
 	}
+	return int64(st.Blocks) * int64(st.Bsize), nil
+}
 
-	return snap, nil
+// deviceSize returns the size in bytes of the block device at path.
+func deviceSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return f.Seek(0, io.SeekEnd)
+}
+
+// checkGrown returns an error if a filesystem that had before bytes didn't
+// grow to after bytes on a device of deviceBytes, as resize tools may succeed
+// without growing it. Filesystems that already filled their device need not
+// grow.
+func checkGrown(before, after, deviceBytes int64) error {
+	if after > before || float64(before) >= float64(deviceBytes)*(1-grownFilesystemTolerance) {
+		return nil
+	}
+	return fmt.Errorf("filesystem did not grow, it has %d bytes on a device of %d", after, deviceBytes)
 }
 
-// SnapDelete calls LINSTOR to delete the snapshot based on the CSI Snapshot ID.
-func (s *Linstor) SnapDelete(ctx context.Context, snap *volume.SnapInfo) error {
-	vol, err := s.GetByID(ctx, snap.CsiSnap.SourceVolumeId)
+// formatDevice creates a fsType filesystem on source, unless it has one
+// already. It returns true if it created one.
+func (s *Linstor) formatDevice(vol *volume.Info, source, fsType string) (bool, error) {
+	// Format device with Storage Class's filesystem options.
+	deviceFS, err := s.mounter.GetDiskFormat(source)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve volume info from id %s", snap.CsiSnap.SourceVolumeId)
+		return false, fmt.Errorf("unable to determine filesystem type of %s: %v", source, err)
 	}
 
-	s.log.WithFields(logrus.Fields{
-		"snapshot": fmt.Sprintf("%+v", snap),
-	}).Info("deleting snapshot")
-
-	if err := s.client.Resources.DeleteSnapshot(ctx, vol.Name, snap.Name); nil404(err) != nil {
-		return fmt.Errorf("failed to remove snaphsot: %v", err)
+	// Device is formatted correctly already.
+	if deviceFS == fsType {
+		s.log.WithFields(logrus.Fields{
+			"deviceFS":    deviceFS,
+			"requestedFS": fsType,
+			"device":      source,
+		}).Debug("device already formatted with requested filesystem")
+		return false, nil
 	}
 
-	// Record the changes to the volume's snaphots
-	updatedSnaps := make([]*volume.SnapInfo, 0)
-	for _, s := range vol.Snapshots {
-		if s.CsiSnap.SourceVolumeId != snap.CsiSnap.SourceVolumeId {
-			updatedSnaps = append(updatedSnaps, s)
-		}
+	if deviceFS != "" && deviceFS != fsType {
+		return false, fmt.Errorf("device %q already formatted with %q filesystem, refusing to overwrite with %q filesystem", source, deviceFS, fsType)
 	}
-	vol.Snapshots = updatedSnaps
-	if err := s.saveVolume(ctx, vol); err != nil {
-		if err := s.client.Resources.DeleteSnapshot(ctx, vol.ID, snap.Name); nil404(err) != nil {
-			s.log.WithError(err).Error("failed to update snapshot list after recording its metadata failed")
-		}
-		return fmt.Errorf("unable to record new snapshot metadata: %v", err)
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return false, fmt.Errorf("formatting device failed: %v", err)
 	}
 
-	return nil
-}
+	args := mkfsArgs(params.FSOpts, source)
+	// mkfs discards devices by default, which would give back the space of
+	// preallocated volumes.
+	if vol.Preallocated {
+		args = append(nodiscardArgs(fsType), args...)
+	}
+	cmd := "mkfs." + fsType
 
-// VolFromSnap creates the volume using the data contained within the snapshot.
-func (s *Linstor) VolFromSnap(ctx context.Context, snap *volume.SnapInfo, vol *volume.Info) error {
 	s.log.WithFields(logrus.Fields{
-		"volume":   fmt.Sprintf("%+v", vol),
-		"snapshot": fmt.Sprintf("%+v", snap),
-	}).Info("creating volume from snapshot")
+		"command": cmd,
+		"args":    args,
+	}).Debug("creating filesystem")
 
-	if err := s.createResourceDefinition(ctx, vol); err != nil {
-		return err
+	out, err := s.mounter.Run(cmd, args...)
+	if err != nil {
+		return false, fmt.Errorf("couldn't create %s filesystem on %s: %v: %q", fsType, source, err, out)
 	}
 
-	r, err := s.client.Resources.GetAll(ctx, vol.ID)
+	return true, nil
+}
+
+// checkFilesystem checks the filesystem on source before it is mounted and
+// repairs it if requested. Devices without a filesystem are skipped.
+func (s *Linstor) checkFilesystem(source, fsType, mode string) error {
+	deviceFS, err := s.mounter.GetDiskFormat(source)
 	if err != nil {
-		return err
+		return fmt.Errorf("unable to determine filesystem type of %s: %v", source, err)
 	}
-
-	snapRestore := lapi.SnapshotRestore{
-		ToResource: vol.ID,
-		Nodes:      util.DeployedDiskfullyNodes(r),
+	if deviceFS == "" {
+		return nil
 	}
 
-	if err := s.client.Resources.RestoreVolumeDefinitionSnapshot(ctx, snap.CsiSnap.SourceVolumeId, snap.Name, snapRestore); err != nil {
+	cmd, args, err := fsckArgs(deviceFS, mode, source)
+	if err != nil {
 		return err
 	}
 
-	if err := s.client.Resources.RestoreSnapshot(ctx, snap.CsiSnap.SourceVolumeId, snap.Name, snapRestore); err != nil {
-		return err
+	// A read-only check can't replay the journal and reports the changes
+	// still in it as errors. Mounting replays it, so there is nothing to check.
+	if mode == volume.FSCKCheck && deviceFS != "xfs" && s.needsJournalRecovery(source) {
+		s.log.WithField("device", source).Info("journal of filesystem needs recovery, skipping read-only check")
+		return nil
 	}
 
-	return nil
-}
-
-// VolFromVol creates the volume using the data contained within the source volume.
-func (s *Linstor) VolFromVol(ctx context.Context, sourceVol, vol *volume.Info) error {
 	s.log.WithFields(logrus.Fields{
-		"volume":       fmt.Sprintf("%+v", vol),
-		"sourceVolume": fmt.Sprintf("%+v", sourceVol),
-	}).Info("creating volume from snapshot")
+		"command": cmd,
+		"args":    args,
+	}).Info("checking filesystem")
 
-	tmpName := s.fallbackPrefix + uuid.New()
-	if err := s.client.Resources.CreateSnapshot(ctx,
-		lapi.Snapshot{
-			Name:         tmpName,
-			ResourceName: sourceVol.ID,
-		}); err != nil {
-		return fmt.Errorf("failed to create snapshot: %v", err)
+	out, err := s.mounter.Run(cmd, args...)
+	if err == nil {
+		return nil
 	}
 
-	return s.VolFromSnap(
-		ctx,
-		&volume.SnapInfo{Name: tmpName, CsiSnap: &csi.Snapshot{SourceVolumeId: sourceVol.ID}},
-		vol,
-	)
+	code, ok := exitStatus(err)
+	if !ok {
+		return fmt.Errorf("couldn't check filesystem on %s: %v", source, err)
+	}
+	if fsckSucceeded(deviceFS, mode, code) {
+		s.log.WithFields(logrus.Fields{
+			"device": source,
+			"output": string(out),
+		}).Warn("repaired filesystem errors")
+		return nil
+	}
+
+	return fmt.Errorf("filesystem on %s is damaged, refusing to mount: %v: %q", source, err, out)
 }
 
-// Creates a resourceDefinition, updating the vol.ID if successful.
-func (s *Linstor) createResourceDefinition(ctx context.Context, vol *volume.Info) error {
-	resDefCreate, err := vol.ToResourceDefinitionCreate()
+// needsJournalRecovery reports whether the ext filesystem on source has a
+// journal that was not replayed yet, as after a crash of the node it was
+// mounted on. Filesystems that can't be inspected count as recovered.
+func (s *Linstor) needsJournalRecovery(source string) bool {
+	out, err := s.mounter.Run("dumpe2fs", "-h", source)
 	if err != nil {
-		return err
+		return false
 	}
-
-	if err := s.client.ResourceDefinitions.Create(ctx, resDefCreate); err != nil {
-		return err
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "Filesystem features:") {
+			return containsOpt(strings.Fields(strings.TrimPrefix(line, "Filesystem features:")), "needs_recovery")
+		}
 	}
+	return false
+}
 
-	// Find the volume ID of the volume we just created.
-	rds, err := s.client.ResourceDefinitions.GetAll(ctx)
-	if err != nil {
-		return err
+// exitStatus returns the exit code of the failed command of err.
+func exitStatus(err error) (int, bool) {
+	switch e := err.(type) {
+	case interface{ ExitStatus() int }:
+		// Commands run through k8s.io/utils/exec.
+		return e.ExitStatus(), true
+	case *exec.ExitError:
+		return e.ExitCode(), true
 	}
+	return 0, false
+}
 
-	for _, rd := range rds {
-		if rd.ExternalName == vol.Name {
-			vol.ID = rd.Name
-		}
+// fsckArgs returns the command and arguments that check, or repair, a
+// filesystem of type fsType on source.
+func fsckArgs(fsType, mode, source string) (string, []string, error) {
+	switch {
+	case fsType == "xfs" && mode == volume.FSCKRepair:
+		return "xfs_repair", []string{source}, nil
+	case fsType == "xfs":
+		return "xfs_repair", []string{"-n", source}, nil
+	case mode == volume.FSCKRepair:
+		return "fsck", []string{"-t", fsType, "-p", source}, nil
+	case mode == volume.FSCKCheck:
+		return "fsck", []string{"-t", fsType, "-n", source}, nil
 	}
+	return "", nil, fmt.Errorf("unknown filesystem check mode %q", mode)
+}
 
-	// Annotate resource definition with updated volume ID.
-	if err := s.saveVolume(ctx, vol); err != nil {
-		return err
+// fsckSucceeded reports whether a non-zero exit code of a filesystem check
+// still means that the filesystem can be mounted. fsck exits with 1 if errors
+// were corrected, and 2 if the system should be rebooted on top of that.
+func fsckSucceeded(fsType, mode string, code int) bool {
+	if fsType == "xfs" || mode != volume.FSCKRepair {
+		return false
 	}
+	return code == 1 || code == 2
+}
 
+// Build mkfs args in the form [opt1, opt2, opt3..., source].
+// nodiscardArgs returns the mkfs arguments that keep mkfs.fsType from
+// discarding the device.
+func nodiscardArgs(fsType string) []string {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		return []string{"-E", "nodiscard"}
+	case "xfs":
+		return []string{"-K"}
+	case "btrfs":
+		return []string{"--nodiscard"}
+	}
 	return nil
 }
 
-// store a representation of a volume into the aux props of a resource definition.
-func (s *Linstor) saveVolume(ctx context.Context, vol *volume.Info) error {
-	serializedVol, err := json.Marshal(vol)
-	if err != nil {
-		return err
+func mkfsArgs(opts, source string) []string {
+	if opts == "" {
+		return []string{source}
 	}
-	return s.setProps(ctx, vol, map[string]string{linstor.AnnotationsKey: string(serializedVol)})
-}
 
-func (s *Linstor) setProps(ctx context.Context, vol *volume.Info, props map[string]string) error {
-	return s.client.ResourceDefinitions.Modify(ctx, vol.ID,
-		lapi.GenericPropsModify{
-			OverrideProps: props,
-		})
+	return append(strings.Split(opts, " "), source)
 }
 
-// CanonicalizeSnapshotName makes sure that the snapshot name meets LINSTOR's
-// naming conventions.
-func (s *Linstor) CanonicalizeSnapshotName(ctx context.Context, suggestedName string) string {
-	// TODO: Snapshots actually have different naming requirements, it might
-	// be nice to conform to those eventually.
-	name, err := linstorifyResourceName(suggestedName)
+// CheckDevice returns an error if source is not an existing block device, as
+// the device of a resource may be missing or not yet created when a volume is
+// published. Operates locally on the machines where it is called.
+func (s *Linstor) CheckDevice(source string) error {
+	info, err := os.Stat(source)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("device %s does not exist, the resource may not be ready yet", source)
+	}
 	if err != nil {
-		return s.fallbackPrefix + uuid.New()
+		return fmt.Errorf("unable to check device %s: %v", source, err)
 	}
-	// We already handled the idempotency/existing case
-	// This is to make sure that nobody else created a snapshot with that name (e.g., another user/plugin)
-	existingSnap, err := s.GetSnapByName(ctx, name)
-	if existingSnap != nil || err != nil {
-		return s.fallbackPrefix + uuid.New()
+
+	mode := info.Mode()
+	if mode&os.ModeDevice == 0 || mode&os.ModeCharDevice != 0 {
+		return fmt.Errorf("device %s is not a block device", source)
 	}
 
-	return name
+	return nil
 }
 
-// ListVolumes returns all volumes that have metadata that is understandable
-// by this plugin, so volumes from multiple compatible plugins may be returned.
-func (s *Linstor) ListVolumes(ctx context.Context) ([]*volume.Info, error) {
-	allResDefs, err := s.client.ResourceDefinitions.GetAll(ctx)
+//Unmount unmounts the target. Operates locally on the machines where it is called.
+func (s *Linstor) Unmount(target string) error {
+	s.log.WithFields(logrus.Fields{
+		"target": target,
+	}).Info("unmounting volume")
+
+	notMounted, err := s.mounter.IsNotMountPoint(target)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve resource definitions: %v", err)
+		return fmt.Errorf("unable to determine mount status of %s %v", target, err)
 	}
 
-	var vols = make([]*volume.Info, 0)
+	if notMounted {
+		return nil
+	}
 
-	for _, rd := range allResDefs {
-		// If we encounter a failure here, we can assume that the resource was
-		// not created by a CSI driver, but we can't check here that it was
-		// created by this instance of the CSI driver in particular.
-		// Linstor names are CSI IDs.
-		vol, err := s.resourceDefinitionToVolume(rd)
-		if err != nil {
-			s.log.WithFields(logrus.Fields{
-				"resourceDefinition": fmt.Sprintf("%+v", rd),
-			}).WithError(err).Error("failed to internally represent volume but continuing — likely non-CSI volume")
-			continue
-		}
+	s.stopRemountWatcher(target)
+	s.stopFstrimmer(target)
 
-		if vol != nil {
-			vols = append(vols, vol)
-		}
+	if err := s.mounter.Unmount(target); err != nil {
+		return err
 	}
 
-	return vols, nil
+	s.resetIOWeight(target)
+
+	return nil
 }
 
-// GetSnapByName retrieves a pointer to a volume.SnapInfo by its name.
-func (s *Linstor) GetSnapByName(ctx context.Context, name string) (*volume.SnapInfo, error) {
-	vols, err := s.ListVolumes(ctx)
-	if err != nil {
-		return nil, err
+// MountSnapshot mounts snap read-only to target on node, without restoring it
+// into a volume. The snapshot is restored into a temporary resource, which is
+// removed again by UnmountSnapshot. Operates locally on the machines where it
+// is called.
+func (s *Linstor) MountSnapshot(ctx context.Context, snap *volume.SnapInfo, node, target string) error {
+	if node != s.nodeName {
+		return fmt.Errorf("unable to mount snapshot %s on node %s, only possible on node %q", snap.Name, node, s.nodeName)
 	}
 
-	return s.doGetSnapByName(vols, name), nil
-}
+	ctx, cancel := context.WithTimeout(ctx, s.mountTimeout)
+	defer cancel()
 
-func (s *Linstor) doGetSnapByName(vols []*volume.Info, name string) *volume.SnapInfo {
-	for _, vol := range vols {
-		for _, snap := range vol.Snapshots {
-			if snap.Name == name {
-				return snap
-			}
-		}
-	}
-	return nil
+	return timeoutErr(ctx, "mount snapshot", snap.Name, s.mountSnapshot(ctx, snap, node, target))
 }
 
-// GetSnapByID retrieves a pointer to a volume.SnapInfo by its id.
-func (s *Linstor) GetSnapByID(ctx context.Context, id string) (*volume.SnapInfo, error) {
-	vols, err := s.ListVolumes(ctx)
+func (s *Linstor) mountSnapshot(ctx context.Context, snap *volume.SnapInfo, node, target string) error {
+	srcID := snap.CsiSnap.SourceVolumeId
+	tmpName := s.fallbackPrefix + uuid.New()
+
+	s.log.WithFields(logrus.Fields{
+		"snapshot":  snap.Name,
+		"volume":    srcID,
+		"resource":  tmpName,
+		"target":    target,
+		"localNode": node,
+	}).Info("mounting snapshot read-only")
+
+	srcVol, err := s.getByID(ctx, srcID)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if srcVol == nil {
+		return fmt.Errorf("unable to find source volume %s of snapshot %s", srcID, snap.Name)
+	}
+	linSnap, err := s.client.Resources.GetSnapshot(ctx, srcID, snap.Name)
+	if err != nil {
+		return fmt.Errorf("unable to find snapshot %s: %v", snap.Name, err)
 	}
 
-	return s.doGetSnapByID(vols, id), nil
-}
+	if err := s.client.ResourceDefinitions.Create(ctx, lapi.ResourceDefinitionCreate{
+		ResourceDefinition: lapi.ResourceDefinition{
+			Name: tmpName,
+			Props: map[string]string{
+				linstor.SnapshotMountKey:     target,
+				linstor.SnapshotMountNodeKey: node,
+			},
+		},
+	}); err != nil {
+		return fmt.Errorf("unable to create resource for snapshot %s: %v", snap.Name, err)
+	}
 
-func (s *Linstor) doGetSnapByID(vols []*volume.Info, id string) *volume.SnapInfo {
-	for _, vol := range vols {
-		for _, snap := range vol.Snapshots {
-			if snap.CsiSnap.SnapshotId == id {
-				return snap
-			}
-		}
+	if err := s.snapshotToTarget(ctx, srcVol, linSnap, tmpName, node, target); err != nil {
+		s.removeSnapshotResource(tmpName)
+		return err
 	}
 	return nil
 }
 
-// ListSnaps returns list of pointers to volume.SnapInfo based off of the
-// serialized snapshot info stored in resource definitions.
-func (s *Linstor) ListSnaps(ctx context.Context) ([]*volume.SnapInfo, error) {
+// snapshotToTarget restores linSnap into the resource definition tmpName,
+// assigns it to node and mounts its device read-only to target.
+func (s *Linstor) snapshotToTarget(ctx context.Context, srcVol *volume.Info, linSnap lapi.Snapshot, tmpName, node, target string) error {
+	snapRestore := lapi.SnapshotRestore{
+		ToResource: tmpName,
+		Nodes:      linSnap.Nodes,
+	}
+	if err := s.client.Resources.RestoreVolumeDefinitionSnapshot(ctx, srcVol.ID, linSnap.Name, snapRestore); err != nil {
+		return fmt.Errorf("unable to restore snapshot %s: %v", linSnap.Name, err)
+	}
+	if err := s.client.Resources.RestoreSnapshot(ctx, srcVol.ID, linSnap.Name, snapRestore); err != nil {
+		return fmt.Errorf("unable to restore snapshot %s: %v", linSnap.Name, err)
+	}
 
-	vols, err := s.ListVolumes(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list snapshots: %v", err)
+	if !containsOpt(linSnap.Nodes, node) {
+		tmpVol := &volume.Info{ID: tmpName, Parameters: srcVol.Parameters}
+		rc, err := tmpVol.ToDisklessResourceCreate(node)
+		if err != nil {
+			return err
+		}
+		if err := s.client.Resources.Create(ctx, rc); err != nil {
+			return fmt.Errorf("unable to attach snapshot %s to node %s: %v", linSnap.Name, node, err)
+		}
 	}
 
-	g, egctx := errgroup.WithContext(ctx)
-	allSnaps := make(chan lapi.Snapshot, 1)
-	for _, vol := range vols {
-		res := vol.ID
-		g.Go(func() error {
-			resSnaps, err := s.client.Resources.GetSnapshots(egctx, res)
-			if err == nil {
-				for _, s := range resSnaps {
-					allSnaps <- s
-				}
-			}
-			return nil404(err)
-		})
+	if err := s.waitForUsable(ctx, tmpName, node); err != nil {
+		return err
+	}
+	vols, err := s.client.Resources.GetVolumes(ctx, tmpName, node)
+	if err != nil || len(vols) == 0 {
+		return fmt.Errorf("unable to find device of snapshot %s on node %s: %v", linSnap.Name, node, err)
+	}
+	source := vols[0].DevicePath
+
+	fsType, err := s.mounter.GetDiskFormat(source)
+	if err != nil {
+		return fmt.Errorf("unable to determine filesystem of snapshot %s: %v", linSnap.Name, err)
 	}
 
-	var snaps = make([]*volume.SnapInfo, 0)
-	var done = make(chan bool, 1)
-	go func() {
-		for snap := range allSnaps {
-			snapCreatedByMe := s.doGetSnapByName(vols, snap.Name)
-			if snapCreatedByMe != nil {
-				snaps = append(snaps, snapCreatedByMe)
-			} else {
-				s.log.WithFields(logrus.Fields{
-					"missingSnapshot": fmt.Sprintf("%+v", snap),
-				}).Debug("unable to look up snap by its Name, potentially not made by me")
-			}
+	if fsType == "" {
+		// No filesystem, expose the device like a block volume.
+		if err := s.mounter.MakeFile(target); err != nil {
+			return fmt.Errorf("could not create bind target for snapshot %s, %v", linSnap.Name, err)
 		}
-		done <- true
-	}()
-
-	if err := g.Wait(); err != nil {
-		return nil, err
+		return s.mounter.Mount(source, target, "", []string{"bind", "ro"})
 	}
-	close(allSnaps)
 
-	<-done
+	if err := s.mounter.MakeDir(target); err != nil {
+		return fmt.Errorf("could not create target directory %s, %v", target, err)
+	}
+	return s.mounter.Mount(source, target, fsType, readOnlySnapshotOpts(fsType))
+}
 
-	return snaps, nil
+// readOnlySnapshotOpts returns the mount options for mounting a snapshot with
+// fsType read-only. Journals are not replayed, as that would write to the
+// device.
+func readOnlySnapshotOpts(fsType string) []string {
+	switch {
+	case strings.HasPrefix(fsType, "ext3"), strings.HasPrefix(fsType, "ext4"):
+		return []string{"ro", "noload"}
+	case fsType == "xfs":
+		return []string{"ro", "norecovery", "nouuid"}
+	default:
+		return []string{"ro"}
+	}
 }
 
-// NodeAvailable makes sure that LINSTOR considers that the node is in an ONLINE
-// state.
-func (s *Linstor) NodeAvailable(ctx context.Context, node string) error {
-	n, err := s.client.Nodes.Get(ctx, node)
-	if err != nil {
+// UnmountSnapshot unmounts a snapshot mounted by MountSnapshot from target and
+// removes its temporary resource.
+func (s *Linstor) UnmountSnapshot(ctx context.Context, target string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.mountTimeout)
+	defer cancel()
+
+	if err := s.Unmount(target); err != nil {
 		return err
 	}
 
-	if n.ConnectionStatus != "ONLINE" {
-		return fmt.Errorf("node %s", n.ConnectionStatus)
+	resDefs, err := s.client.ResourceDefinitions.GetAll(ctx)
+	if err != nil {
+		return timeoutErr(ctx, "unmount snapshot", target, err)
+	}
+	for _, resName := range snapshotMounts(resDefs, s.nodeName, target) {
+		if err := s.client.ResourceDefinitions.Delete(ctx, resName); nil404(err) != nil {
+			return timeoutErr(ctx, "unmount snapshot", target,
+				fmt.Errorf("unable to remove resource %s of snapshot mounted to %s: %v", resName, target, err))
+		}
 	}
 
 	return nil
 }
 
-// GetAssignmentOnNode returns a pointer to a volume.Assignment for a given node.
-func (s *Linstor) GetAssignmentOnNode(ctx context.Context, vol *volume.Info, node string) (*volume.Assignment, error) {
-	s.log.WithFields(logrus.Fields{
-		"volume":     fmt.Sprintf("%+v", vol),
-		"targetNode": node,
-	}).Debug("getting assignment info")
-
-	linVol, err := s.client.Resources.GetVolume(ctx, vol.ID, node, 0)
-	if err != nil {
-		return nil, err
+// snapshotMounts returns the names of the temporary resources of snapshots
+// mounted to target on node. The same target may be in use on other nodes.
+func snapshotMounts(resDefs []lapi.ResourceDefinition, node, target string) []string {
+	var names []string
+	for _, rd := range resDefs {
+		if rd.Props[linstor.SnapshotMountKey] == target && rd.Props[linstor.SnapshotMountNodeKey] == node {
+			names = append(names, rd.Name)
+		}
 	}
+	return names
+}
 
-	va := &volume.Assignment{
-		Vol:  vol,
-		Node: node,
-		Path: linVol.DevicePath,
-	}
+// removeSnapshotResource removes the temporary resource of a snapshot that
+// could not be mounted. Failures are only logged.
+func (s *Linstor) removeSnapshotResource(resName string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.deleteTimeout)
+	defer cancel()
 
-	s.log.WithFields(logrus.Fields{
-		"volumeAssignment": fmt.Sprintf("%+v", va),
-	}).Debug("found assignment info")
+	if err := s.client.ResourceDefinitions.Delete(ctx, resName); nil404(err) != nil {
+		s.log.WithFields(logrus.Fields{
+			"resource": resName,
+		}).WithError(err).Warn("unable to remove resource of snapshot that failed to mount")
+	}
+}
 
-	return va, nil
+// ioWeightDevice is a device whose IO weight was set in a pod cgroup.
+type ioWeightDevice struct {
+	cgroup string
+	device string
 }
 
-// Mount makes volumes consumable from the source to the target.
-// Filesystems are formatted and block devics are bind mounted.
-// Operates locally on the machines where it is called.
-func (s *Linstor) Mount(vol *volume.Info, source, target, fsType string, options []string) error {
-	params, err := volume.NewParameters(vol.Parameters)
+// setIOWeight sets the IO weight of the source device in the cgroup of the pod
+// that target is mounted for, if requested. Nodes that don't support
+// per-device IO weights are skipped.
+func (s *Linstor) setIOWeight(source, target string, weight int) {
+	if weight == 0 {
+		return
+	}
+
+	uid, ok := podUID(target)
+	if !ok {
+		s.log.WithField("target", target).Warn("unable to determine pod of mount target, not setting IO weight")
+		return
+	}
+	cgroup, err := podCgroup(s.ioWeightCgroup, uid)
 	if err != nil {
-		return fmt.Errorf("mounting volume failed: %v", err)
+		s.log.WithFields(logrus.Fields{
+			"target": target,
+			"cgroup": s.ioWeightCgroup,
+		}).WithError(err).Warn("unable to find pod cgroup, not setting IO weight")
+		return
 	}
 
-	// Override default CSI fsType with the one passed in the StorageClass
-	if params.FS != "" {
-		fsType = params.FS
+	var st syscall.Stat_t
+	if err := syscall.Stat(source, &st); err != nil {
+		s.log.WithError(err).WithField("source", source).Warn("unable to determine device number, not setting IO weight")
+		return
 	}
-	// If there is no fsType, then this is a block mode volume.
-	var block bool
-	if fsType == "" {
-		block = true
+	device := fmt.Sprintf("%d:%d", devMajor(uint64(st.Rdev)), devMinor(uint64(st.Rdev)))
+
+	if err := writeIOWeight(cgroup, device, strconv.Itoa(weight)); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"source": source,
+			"cgroup": cgroup,
+		}).WithError(err).Warn("IO weights not supported, not setting IO weight")
+		return
 	}
 
-	// Merge mount options from Storage Classes and CSI calls.
-	options = append(options, params.MountOpts)
+	s.ioWeightMu.Lock()
+	defer s.ioWeightMu.Unlock()
+	if s.ioWeightDevices == nil {
+		s.ioWeightDevices = make(map[string]ioWeightDevice)
+	}
+	s.ioWeightDevices[target] = ioWeightDevice{cgroup: cgroup, device: device}
+}
 
-	s.log.WithFields(logrus.Fields{
-		"volume":          fmt.Sprintf("%+v", vol),
-		"source":          source,
-		"target":          target,
-		"options":         options,
-		"filesystem":      fsType,
-		"blockAccessMode": block,
-	}).Info("mounting volume")
+// resetIOWeight resets the IO weight of the device mounted at target, if it
+// was set when mounting.
+func (s *Linstor) resetIOWeight(target string) {
+	s.ioWeightMu.Lock()
+	dev, ok := s.ioWeightDevices[target]
+	delete(s.ioWeightDevices, target)
+	s.ioWeightMu.Unlock()
 
-	// Check if the path is a device
-	isDevice, err := s.mounter.PathIsDevice(source)
-	if err != nil {
-		return fmt.Errorf("checking device path failed: %v", err)
+	if !ok {
+		return
 	}
-	if !isDevice {
-		return fmt.Errorf("%s does not appear to be a block device", source)
+
+	// The cgroup is gone with its pod already, and the weight with it.
+	if err := writeIOWeight(dev.cgroup, dev.device, "default"); err != nil && !os.IsNotExist(err) {
+		s.log.WithFields(logrus.Fields{
+			"device": dev.device,
+			"cgroup": dev.cgroup,
+		}).WithError(err).Warn("unable to reset IO weight")
 	}
+}
 
-	// Determine if we have exclusive access to the device. This is mostly
-	// a way to determine if a disklessly attached device's connection is down.
-	_, err = s.mounter.DeviceOpened(source)
-	if err != nil {
-		return fmt.Errorf("checking for exclusive open failed: %v, check device health", err)
+func writeIOWeight(cgroup, device, weight string) error {
+	return ioutil.WriteFile(filepath.Join(cgroup, "io.weight"), []byte(device+" "+weight+"\n"), 0644)
+}
+
+// podUIDPattern matches the pod UIDs in the mount targets kubelet chooses,
+// .../pods/<uid>/volumes/... for filesystems and
+// .../volumeDevices/publish/<volume>/<uid> for block volumes.
+var podUIDPattern = regexp.MustCompile(`/pods/([0-9a-f-]{36})/volumes/|/volumeDevices/publish/[^/]+/([0-9a-f-]{36})$`)
+
+// podUID returns the UID of the pod that the volume is mounted at target for.
+func podUID(target string) (string, bool) {
+	m := podUIDPattern.FindStringSubmatch(filepath.Clean(target))
+	if m == nil {
+		return "", false
+	}
+	if m[1] != "" {
+		return m[1], true
 	}
+	return m[2], true
+}
 
-	// This is a regular filesystem so format the device and create the mountpoint.
-	if !block {
-		if err := s.formatDevice(vol, source, fsType); err != nil {
-			return fmt.Errorf("mounting volume failed: %v", err)
+// podCgroup returns the cgroup below root of the pod with uid. Pods are in
+// QoS class cgroups, unless they are guaranteed, named after the cgroupfs or
+// the systemd cgroup driver.
+func podCgroup(root, uid string) (string, error) {
+	systemdUID := strings.Replace(uid, "-", "_", -1)
+	candidates := []string{
+		filepath.Join(root, "pod"+uid),
+		filepath.Join(root, "burstable", "pod"+uid),
+		filepath.Join(root, "besteffort", "pod"+uid),
+		filepath.Join(root, "kubepods-pod"+systemdUID+".slice"),
+		filepath.Join(root, "kubepods-burstable.slice", "kubepods-burstable-pod"+systemdUID+".slice"),
+		filepath.Join(root, "kubepods-besteffort.slice", "kubepods-besteffort-pod"+systemdUID+".slice"),
+	}
+	for _, c := range candidates {
+		if fi, err := os.Stat(c); err == nil && fi.IsDir() {
+			return c, nil
 		}
-		if err := s.mounter.MakeDir(target); err != nil {
-			return fmt.Errorf("could not create target directory %s, %v", target, err)
+	}
+	return "", fmt.Errorf("no cgroup of pod %s in %s", uid, root)
+}
+
+// devMajor and devMinor decode Linux device numbers.
+func devMajor(dev uint64) uint64 {
+	return ((dev >> 8) & 0xfff) | ((dev >> 32) &^ 0xfff)
+}
+
+func devMinor(dev uint64) uint64 {
+	return (dev & 0xff) | ((dev >> 12) &^ 0xff)
+}
+
+// EventType describes what happened to a resource.
+type EventType string
+
+// Types of events emitted by WatchEvents.
+const (
+	// ResourceCreated is emitted when a resource is assigned to a node.
+	ResourceCreated EventType = "ResourceCreated"
+	// ResourceDeleted is emitted when a resource is removed from a node.
+	ResourceDeleted EventType = "ResourceDeleted"
+	// FlagsChanged is emitted when the flags of a resource change, e.g. when
+	// it becomes diskless or is being deleted.
+	FlagsChanged EventType = "FlagsChanged"
+	// InUseChanged is emitted when a resource is promoted or demoted.
+	InUseChanged EventType = "InUseChanged"
+	// DiskStateChanged is emitted when the disk state of a volume changes.
+	DiskStateChanged EventType = "DiskStateChanged"
+	// WatchFailed is emitted when the state of the resource could not be retrieved.
+	WatchFailed EventType = "WatchFailed"
+)
+
+// Event is a change to a resource of a volume.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Node is the node of the resource the event is about. Empty for
+	// WatchFailed events.
+	Node    string
+	Message string
+}
+
+// resourceState is the part of a resource's state that is watched for changes.
+type resourceState struct {
+	flags      string
+	inUse      bool
+	diskStates map[int32]string
+}
+
+func toResourceStates(resources []lapi.Resource) map[string]resourceState {
+	var states = make(map[string]resourceState, len(resources))
+	for _, r := range resources {
+		flags := append([]string(nil), r.Flags...)
+		sort.Strings(flags)
+
+		state := resourceState{
+			flags:      strings.Join(flags, ","),
+			inUse:      r.State.InUse,
+			diskStates: make(map[int32]string, len(r.Volumes)),
 		}
-		// This is a block volume so create a file to bindmount to.
-	} else {
-		err := s.mounter.MakeFile(target)
-		if err != nil {
-			return fmt.Errorf("could not create bind target for block volume %s, %v", target, err)
+		for _, v := range r.Volumes {
+			state.diskStates[v.VolumeNumber] = v.State.DiskState
 		}
+		states[r.NodeName] = state
 	}
+	return states
+}
 
-	needsMount, err := s.mounter.IsNotMountPoint(target)
-	if err != nil {
-		return fmt.Errorf("unable to determine mount status of %s %v", target, err)
+// diffResourceStates returns the events that lead from the previous to the current
+// resource states, sorted by node.
+func diffResourceStates(prev, cur map[string]resourceState, now time.Time) []Event {
+	var events = make([]Event, 0)
+
+	for node, n := range cur {
+		o, ok := prev[node]
+		if !ok {
+			events = append(events, Event{Type: ResourceCreated, Time: now, Node: node,
+				Message: fmt.Sprintf("resource assigned with flags [%s]", n.flags)})
+			o = resourceState{}
+		}
+		if ok && o.flags != n.flags {
+			events = append(events, Event{Type: FlagsChanged, Time: now, Node: node,
+				Message: fmt.Sprintf("flags changed from [%s] to [%s]", o.flags, n.flags)})
+		}
+		if o.inUse != n.inUse {
+			events = append(events, Event{Type: InUseChanged, Time: now, Node: node,
+				Message: fmt.Sprintf("in use changed to %t", n.inUse)})
+		}
+		var vols = make([]int, 0, len(n.diskStates))
+		for vnr := range n.diskStates {
+			vols = append(vols, int(vnr))
+		}
+		sort.Ints(vols)
+		for _, vnr := range vols {
+			oldState, newState := o.diskStates[int32(vnr)], n.diskStates[int32(vnr)]
+			if oldState != newState {
+				events = append(events, Event{Type: DiskStateChanged, Time: now, Node: node,
+					Message: fmt.Sprintf("volume %d disk state changed from %q to %q", vnr, oldState, newState)})
+			}
+		}
 	}
 
-	if !needsMount {
-		return nil
+	for node := range prev {
+		if _, ok := cur[node]; !ok {
+			events = append(events, Event{Type: ResourceDeleted, Time: now, Node: node,
+				Message: "resource removed"})
+		}
 	}
 
-	if block {
-		return s.mounter.Mount(source, target, fsType, options)
+	sort.SliceStable(events, func(j, k int) bool {
+		return events[j].Node < events[k].Node
+	})
+
+	return events
+}
+
+// WatchEvents polls the resources of vol and emits an event for every change
+// until ctx is cancelled, at which point the channel is closed. The resources
+// already present when the watch starts are reported as created.
+func (s *Linstor) WatchEvents(ctx context.Context, vol *volume.Info) (<-chan Event, error) {
+	if vol.ID == "" {
+		return nil, fmt.Errorf("unable to watch events of volume %s: it has no ID", vol.Name)
 	}
 
-	return s.mounter.FormatAndMount(source, target, fsType, options)
+	events := make(chan Event)
+
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(eventPollInterval)
+		defer ticker.Stop()
+
+		var states = make(map[string]resourceState)
+		for {
+			var batch []Event
+
+			resources, err := s.client.Resources.GetAll(ctx, vol.ID)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case nil404(err) != nil:
+				batch = []Event{{Type: WatchFailed, Time: time.Now(), Message: err.Error()}}
+			default:
+				newStates := toResourceStates(resources)
+				batch = diffResourceStates(states, newStates, time.Now())
+				states = newStates
+			}
+
+			for _, e := range batch {
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
 }
 
-func (s *Linstor) formatDevice(vol *volume.Info, source, fsType string) error {
-	// Format device with Storage Class's filesystem options.
-	deviceFS, err := s.mounter.GetDiskFormat(source)
-	if err != nil {
-		return fmt.Errorf("unable to determine filesystem type of %s: %v", source, err)
+// startRemountWatcher periodically checks if the filesystem mounted at target
+// was remounted read-only and tries to remount it read-write once the source
+// device is usable again. bind is true for the bind mounts of block volumes.
+// Only one watcher runs per target.
+func (s *Linstor) startRemountWatcher(source, target string, bind bool) {
+	s.remountMu.Lock()
+	defer s.remountMu.Unlock()
+
+	if s.remountWatchers == nil {
+		s.remountWatchers = make(map[string]chan struct{})
+	}
+	if _, ok := s.remountWatchers[target]; ok {
+		return
 	}
 
-	// Device is formatted correctly already.
-	if deviceFS == fsType {
+	stop := make(chan struct{})
+	s.remountWatchers[target] = stop
+
+	s.log.WithFields(logrus.Fields{
+		"source": source,
+		"target": target,
+	}).Debug("starting remount watcher")
+
+	go func() {
+		ticker := time.NewTicker(remountWatchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.remountIfReadonly(source, target, bind)
+			}
+		}
+	}()
+}
+
+// stopRemountWatcher stops the watcher for target, if there is one.
+func (s *Linstor) stopRemountWatcher(target string) {
+	s.remountMu.Lock()
+	defer s.remountMu.Unlock()
+
+	if stop, ok := s.remountWatchers[target]; ok {
+		close(stop)
+		delete(s.remountWatchers, target)
 		s.log.WithFields(logrus.Fields{
-			"deviceFS":    deviceFS,
-			"requestedFS": fsType,
-			"device":      source,
-		}).Debug("device already formatted with requested filesystem")
-		return nil
+			"target": target,
+		}).Debug("stopped remount watcher")
 	}
+}
 
-	if deviceFS != "" && deviceFS != fsType {
-		return fmt.Errorf("device %q already formatted with %q filesystem, refusing to overwrite with %q filesystem", source, deviceFS, fsType)
-	}
+// startFstrimmer runs fstrim on the filesystem mounted at target every
+// fstrimInterval, until stopFstrimmer is called for target.
+func (s *Linstor) startFstrimmer(target string) {
+	s.fstrimMu.Lock()
+	defer s.fstrimMu.Unlock()
 
-	params, err := volume.NewParameters(vol.Parameters)
-	if err != nil {
-		return fmt.Errorf("formatting device failed: %v", err)
+	if s.fstrimmers == nil {
+		s.fstrimmers = make(map[string]chan struct{})
+	}
+	if _, ok := s.fstrimmers[target]; ok {
+		return
 	}
 
-	args := mkfsArgs(params.FSOpts, source)
-	cmd := "mkfs." + fsType
+	stop := make(chan struct{})
+	s.fstrimmers[target] = stop
 
 	s.log.WithFields(logrus.Fields{
-		"command": cmd,
-		"args":    args,
-	}).Debug("creating filesystem")
-
-	out, err := s.mounter.Exec.Run(cmd, args...)
-	if err != nil {
-		return fmt.Errorf("couldn't create %s filesystem on %s: %v: %q", fsType, source, err, out)
-	}
+		"target": target,
+	}).Debug("starting periodic fstrim")
 
-	return nil
+	go func() {
+		ticker := time.NewTicker(fstrimInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.fstrim(target)
+			}
+		}
+	}()
 }
 
-// Build mkfs args in the form [opt1, opt2, opt3..., source].
-func mkfsArgs(opts, source string) []string {
-	if opts == "" {
-		return []string{source}
-	}
+// stopFstrimmer stops the periodic fstrim of target, if there is one.
+func (s *Linstor) stopFstrimmer(target string) {
+	s.fstrimMu.Lock()
+	defer s.fstrimMu.Unlock()
 
-	return append(strings.Split(opts, " "), source)
+	if stop, ok := s.fstrimmers[target]; ok {
+		close(stop)
+		delete(s.fstrimmers, target)
+		s.log.WithFields(logrus.Fields{
+			"target": target,
+		}).Debug("stopped periodic fstrim")
+	}
 }
 
-//Unmount unmounts the target. Operates locally on the machines where it is called.
-func (s *Linstor) Unmount(target string) error {
-	s.log.WithFields(logrus.Fields{
+// fstrim discards the unused blocks of the filesystem mounted at target.
+// Failures are only logged, the next run may succeed.
+func (s *Linstor) fstrim(target string) {
+	log := s.log.WithFields(logrus.Fields{
 		"target": target,
-	}).Info("unmounting volume")
+	})
 
-	notMounted, err := s.mounter.IsNotMountPoint(target)
+	out, err := s.mounter.Run("fstrim", target)
 	if err != nil {
-		return fmt.Errorf("unable to determine mount status of %s %v", target, err)
+		log.WithError(err).WithField("output", string(out)).Warn("unable to trim filesystem")
+		return
+	}
+	log.Debug("trimmed filesystem")
+}
+
+func (s *Linstor) remountIfReadonly(source, target string, bind bool) {
+	mountPoints, err := s.mounter.List()
+	if err != nil {
+		s.log.WithError(err).Warn("unable to list mounts for remount watcher")
+		return
 	}
 
-	if notMounted {
+	for _, mp := range mountPoints {
+		if mp.Path != target || !containsOpt(mp.Opts, "ro") {
+			continue
+		}
+
+		// Device is still unusable, try again later.
+		if _, err := s.mounter.DeviceOpened(source); err != nil {
+			s.log.WithFields(logrus.Fields{
+				"source": source,
+				"target": target,
+			}).WithError(err).Debug("device not yet recovered, not remounting")
+			return
+		}
+
+		// The read-only flag of bind mounts is their own, not that of the
+		// device's filesystem.
+		opts := []string{"remount", "rw"}
+		if bind {
+			opts = []string{"remount", "bind", "rw"}
+		}
+		if err := s.mounter.Mount(source, target, "", opts); err != nil {
+			s.log.WithFields(logrus.Fields{
+				"source": source,
+				"target": target,
+			}).WithError(err).Warn("failed to remount filesystem read-write")
+			return
+		}
+
+		s.log.WithFields(logrus.Fields{
+			"source": source,
+			"target": target,
+		}).Info("remounted filesystem read-write after device recovery")
+		return
+	}
+}
+
+// exclusiveMountOpts are pairs of mount options that cancel each other out.
+var exclusiveMountOpts = [][2]string{
+	{"ro", "rw"},
+	{"atime", "noatime"},
+	{"dev", "nodev"},
+	{"exec", "noexec"},
+	{"suid", "nosuid"},
+	{"sync", "async"},
+}
+
+// mergeMountOptions combines the mount options of a CSI call with the comma
+// separated mount options of a Storage Class. Duplicates are removed and
+// options of the CSI call take precedence over conflicting ones of the
+// Storage Class. Conflicting options from the same source are an error.
+func mergeMountOptions(csiOpts []string, scOpts string) ([]string, error) {
+	var merged = make([]string, 0, len(csiOpts))
+	// fromCSI is the number of options in merged that come from the CSI call.
+	var fromCSI int
+
+	add := func(opt string, fromSC bool) error {
+		opt = strings.TrimSpace(opt)
+		if opt == "" || containsOpt(merged, opt) {
+			return nil
+		}
+		for _, pair := range exclusiveMountOpts {
+			other := pair[0]
+			if opt == pair[0] {
+				other = pair[1]
+			} else if opt != pair[1] {
+				continue
+			}
+
+			for i, o := range merged {
+				if o != other {
+					continue
+				}
+				if fromSC && i < fromCSI {
+					// The CSI call wins.
+					return nil
+				}
+				return fmt.Errorf("conflicting mount options %q and %q", other, opt)
+			}
+		}
+		merged = append(merged, opt)
 		return nil
 	}
 
-	return s.mounter.Unmount(target)
+	for _, opt := range csiOpts {
+		if err := add(opt, false); err != nil {
+			return nil, err
+		}
+	}
+	fromCSI = len(merged)
+	for _, opt := range strings.Split(scOpts, ",") {
+		if err := add(opt, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return merged, nil
+}
+
+func containsOpt(options []string, opt string) bool {
+	for _, o := range options {
+		// Options may be given joined, like "ro,noatime".
+		for _, part := range strings.Split(o, ",") {
+			if part == opt {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // validResourceName returns an error if the input string is not a valid LINSTOR name
@@ -19,8 +19,34 @@ along with this program; if not, see <http://www.gnu.org/licenses/>.
 package client
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"text/template"
+	"time"
+
+	apiconst "github.com/LINBIT/golinstor"
+	lapi "github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/linstor-csi/pkg/linstor"
+	lc "github.com/LINBIT/linstor-csi/pkg/linstor/highlevelclient"
+	"github.com/LINBIT/linstor-csi/pkg/topology"
+	"github.com/LINBIT/linstor-csi/pkg/volume"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/util/mount"
 )
 
 func TestAllocationSizeKiB(t *testing.T) {
@@ -53,6 +79,26 @@ func TestAllocationSizeKiB(t *testing.T) {
 	if err == nil {
 		t.Errorf("Expected limitBytes to be respected!")
 	}
+	_, err = l.AllocationSizeKiB(1024, 2048)
+	if err == nil {
+		t.Errorf("Expected limits below the minimum volume size to be refused")
+	}
+
+	l, err = NewLinstor(ClampMinimumSize(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := l.AllocationSizeKiB(1024, 2048)
+	if err != nil {
+		t.Errorf("Expected limits below the minimum volume size to be raised, got %v", err)
+	}
+	if actual != 4 {
+		t.Errorf("Expected the minimum volume size of 4 KiB, got %d", actual)
+	}
+	_, err = l.AllocationSizeKiB(4097, 2048)
+	if err == nil {
+		t.Errorf("Expected requests larger than the raised limit to be refused")
+	}
 }
 
 func TestValidResourceName(t *testing.T) {
@@ -145,3 +191,2634 @@ func TestMkfsArgs(t *testing.T) {
 		}
 	}
 }
+
+// fakeMounter is a diskMounter that records mounts and commands instead of
+// touching real devices.
+type fakeMounter struct {
+	*mount.FakeMounter
+	// diskFormat is the filesystem reported for every device.
+	diskFormat string
+	mounts     []fakeMount
+	commands   []string
+	// runErr fails the commands it names, runOut is their output.
+	runErr map[string]error
+	runOut map[string]string
+	// mountPoint reports every path as mount point, unmountErr fails their
+	// unmounts.
+	mountPoint bool
+	unmountErr error
+	unmounts   []string
+}
+
+type fakeMount struct {
+	source, target, fsType string
+	options                []string
+	format                 bool
+}
+
+func (f *fakeMounter) Mount(source, target, fsType string, options []string) error {
+	f.mounts = append(f.mounts, fakeMount{source: source, target: target, fsType: fsType, options: options})
+	return nil
+}
+
+func (f *fakeMounter) FormatAndMount(source, target, fsType string, options []string) error {
+	f.mounts = append(f.mounts, fakeMount{source: source, target: target, fsType: fsType, options: options, format: true})
+	return nil
+}
+
+func (f *fakeMounter) IsNotMountPoint(dir string) (bool, error) {
+	return !f.mountPoint, nil
+}
+
+func (f *fakeMounter) Unmount(target string) error {
+	f.unmounts = append(f.unmounts, target)
+	return f.unmountErr
+}
+
+func (f *fakeMounter) GetDiskFormat(disk string) (string, error) {
+	return f.diskFormat, nil
+}
+
+func (f *fakeMounter) Run(cmd string, args ...string) ([]byte, error) {
+	f.commands = append(f.commands, cmd)
+	var out []byte
+	if o, ok := f.runOut[cmd]; ok {
+		out = []byte(o)
+	}
+	return out, f.runErr[cmd]
+}
+
+func (f *fakeMounter) RunContext(ctx context.Context, cmd string, args ...string) ([]byte, error) {
+	return f.Run(cmd, args...)
+}
+
+func (f *fakeMounter) Resize(devicePath, deviceMountPath string) (bool, error) {
+	return true, nil
+}
+
+func TestMount(t *testing.T) {
+	var tableTests = []struct {
+		name       string
+		params     map[string]string
+		fsType     string
+		options    []string
+		diskFormat string
+		mounts     []fakeMount
+		commands   []string
+		fail       bool
+		// formatted is the expected MountResult.Formatted.
+		formatted bool
+	}{
+		{
+			name:      "storage class overrides fsType and adds mount options",
+			params:    map[string]string{"fs": "xfs", "mountOpts": "noatime"},
+			fsType:    "ext4",
+			options:   []string{"ro"},
+			mounts:    []fakeMount{{"/dev/drbd1000", "/target", "xfs", []string{"ro", "noatime"}, true}},
+			commands:  []string{"mkfs.xfs"},
+			formatted: true,
+		},
+		{
+			name:       "formatted device is not formatted again",
+			params:     map[string]string{"mountOpts": "noatime"},
+			fsType:     "ext4",
+			diskFormat: "ext4",
+			mounts:     []fakeMount{{"/dev/drbd1000", "/target", "ext4", []string{"noatime"}, true}},
+		},
+		{
+			name:      "fsErrors is added to mount options",
+			params:    map[string]string{"fsErrors": "remount-ro", "mountOpts": "noatime"},
+			fsType:    "ext4",
+			mounts:    []fakeMount{{"/dev/drbd1000", "/target", "ext4", []string{"noatime", "errors=remount-ro"}, true}},
+			commands:  []string{"mkfs.ext4"},
+			formatted: true,
+		},
+		{
+			name:      "barriers are disabled on ext4",
+			params:    map[string]string{"barriers": "off", "mountOpts": "noatime"},
+			fsType:    "ext4",
+			mounts:    []fakeMount{{"/dev/drbd1000", "/target", "ext4", []string{"noatime", "barrier=0"}, true}},
+			commands:  []string{"mkfs.ext4"},
+			formatted: true,
+		},
+		{
+			name:   "disabling barriers on xfs is refused",
+			params: map[string]string{"barriers": "off"},
+			fsType: "xfs",
+			fail:   true,
+		},
+		{
+			name:      "discard is added to mount options",
+			params:    map[string]string{"discard": "mount"},
+			fsType:    "xfs",
+			mounts:    []fakeMount{{"/dev/drbd1000", "/target", "xfs", []string{"discard"}, true}},
+			commands:  []string{"mkfs.xfs"},
+			formatted: true,
+		},
+		{
+			name:   "discard on unsupported filesystems is refused",
+			params: map[string]string{"discard": "mount"},
+			fsType: "ext2",
+			fail:   true,
+		},
+		{
+			name:    "block volumes are bind mounted",
+			params:  map[string]string{"mountOpts": "bind"},
+			options: []string{"ro"},
+			mounts:  []fakeMount{{"/dev/drbd1000", "/target", "", []string{"ro", "bind"}, false}},
+		},
+		{
+			name:       "device with another filesystem is refused",
+			params:     map[string]string{"fs": "xfs"},
+			fsType:     "ext4",
+			diskFormat: "ext4",
+			fail:       true,
+		},
+	}
+
+	for _, tt := range tableTests {
+		m := &fakeMounter{FakeMounter: &mount.FakeMounter{}, diskFormat: tt.diskFormat}
+		l := &Linstor{log: logrus.NewEntry(logrus.New()), mounter: m}
+		vol := &volume.Info{ID: "pvc-1", Parameters: tt.params}
+
+		res, err := l.Mount(vol, "/dev/drbd1000", "/target", tt.fsType, tt.options)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected Mount to fail", tt.name)
+			}
+			if len(m.mounts) != 0 {
+				t.Errorf("%s: expected no mounts, got %+v", tt.name, m.mounts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.mounts, m.mounts) {
+			t.Errorf("%s: expected mounts\n\t%+v\nbut got\n\t%+v", tt.name, tt.mounts, m.mounts)
+		}
+		if !reflect.DeepEqual(tt.commands, m.commands) {
+			t.Errorf("%s: expected commands %v, got %v", tt.name, tt.commands, m.commands)
+		}
+		if tt.formatted != res.Formatted {
+			t.Errorf("%s: expected formatted to be %t, got %t", tt.name, tt.formatted, res.Formatted)
+		}
+	}
+}
+
+func TestMountRemountWatcher(t *testing.T) {
+	var tableTests = []struct {
+		name     string
+		params   map[string]string
+		fsType   string
+		options  []string
+		expected bool
+	}{
+		{name: "filesystem", params: map[string]string{"remountOnRecovery": "true"}, fsType: "ext4", expected: true},
+		{name: "block volume", params: map[string]string{"remountOnRecovery": "true", "mountOpts": "bind"}, expected: true},
+		{name: "read-only in joined mount options", params: map[string]string{"remountOnRecovery": "true", "mountOpts": "noatime,ro"}, fsType: "ext4"},
+		{name: "read-only block volume", params: map[string]string{"remountOnRecovery": "true", "mountOpts": "bind"}, options: []string{"ro"}},
+		{name: "not requested", fsType: "ext4"},
+	}
+
+	for _, tt := range tableTests {
+		m := &fakeMounter{FakeMounter: &mount.FakeMounter{}}
+		l := &Linstor{log: logrus.NewEntry(logrus.New()), mounter: m}
+		vol := &volume.Info{ID: "pvc-1", Parameters: tt.params}
+
+		if _, err := l.Mount(vol, "/dev/drbd1000", "/target", tt.fsType, tt.options); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if _, actual := l.remountWatchers["/target"]; actual != tt.expected {
+			t.Errorf("%s: expected remount watcher: %t, got %t", tt.name, tt.expected, actual)
+		}
+		l.stopRemountWatcher("/target")
+	}
+}
+
+func TestRemountIfReadonly(t *testing.T) {
+	var tableTests = []struct {
+		opts     []string
+		bind     bool
+		expected []fakeMount
+	}{
+		{[]string{"rw"}, false, nil},
+		{[]string{"ro", "relatime"}, false, []fakeMount{{"/dev/drbd1000", "/target", "", []string{"remount", "rw"}, false}}},
+		{[]string{"ro"}, true, []fakeMount{{"/dev/drbd1000", "/target", "", []string{"remount", "bind", "rw"}, false}}},
+	}
+
+	for _, tt := range tableTests {
+		m := &fakeMounter{FakeMounter: &mount.FakeMounter{MountPoints: []mount.MountPoint{{Device: "/dev/drbd1000", Path: "/target", Opts: tt.opts}}}}
+		l := &Linstor{log: logrus.NewEntry(logrus.New()), mounter: m}
+
+		l.remountIfReadonly("/dev/drbd1000", "/target", tt.bind)
+		if !reflect.DeepEqual(tt.expected, m.mounts) {
+			t.Errorf("Expected mounts %+v for options %v (bind: %t), got %+v", tt.expected, tt.opts, tt.bind, m.mounts)
+		}
+	}
+}
+
+func TestContainsOpt(t *testing.T) {
+	var tableTests = []struct {
+		options  []string
+		expected bool
+	}{
+		{[]string{"ro"}, true},
+		{[]string{"noatime,ro"}, true},
+		{[]string{"noatime", "errors=remount-ro"}, false},
+		{nil, false},
+	}
+
+	for _, tt := range tableTests {
+		if actual := containsOpt(tt.options, "ro"); actual != tt.expected {
+			t.Errorf("Expected %v to contain ro: %t, got %t", tt.options, tt.expected, actual)
+		}
+	}
+}
+
+func TestPodUID(t *testing.T) {
+	const uid = "0d5e4ad2-7c3e-4a8e-9b1f-3f6c2a1b9e10"
+	var tableTests = []struct {
+		target   string
+		expected string
+		ok       bool
+	}{
+		{"/var/lib/kubelet/pods/" + uid + "/volumes/kubernetes.io~csi/pvc-1/mount", uid, true},
+		{"/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-1/" + uid, uid, true},
+		{"/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1/globalmount", "", false},
+		{"/target", "", false},
+	}
+
+	for _, tt := range tableTests {
+		actual, ok := podUID(tt.target)
+		if actual != tt.expected || ok != tt.ok {
+			t.Errorf("Expected pod UID %q (%t) of %s, got %q (%t)", tt.expected, tt.ok, tt.target, actual, ok)
+		}
+	}
+}
+
+func TestPodCgroup(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{
+		"podaaaaaaaa-0000-0000-0000-000000000000",
+		"burstable/podbbbbbbbb-0000-0000-0000-000000000000",
+		"kubepods-besteffort.slice/kubepods-besteffort-podcccccccc_0000_0000_0000_000000000000.slice",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tableTests = []struct {
+		uid      string
+		expected string
+	}{
+		{"aaaaaaaa-0000-0000-0000-000000000000", "podaaaaaaaa-0000-0000-0000-000000000000"},
+		{"bbbbbbbb-0000-0000-0000-000000000000", "burstable/podbbbbbbbb-0000-0000-0000-000000000000"},
+		{"cccccccc-0000-0000-0000-000000000000", "kubepods-besteffort.slice/kubepods-besteffort-podcccccccc_0000_0000_0000_000000000000.slice"},
+		{"dddddddd-0000-0000-0000-000000000000", ""},
+	}
+
+	for _, tt := range tableTests {
+		actual, err := podCgroup(root, tt.uid)
+		if tt.expected == "" {
+			if err == nil {
+				t.Errorf("Expected no cgroup of pod %s, got %s", tt.uid, actual)
+			}
+			continue
+		}
+		if err != nil || actual != filepath.Join(root, tt.expected) {
+			t.Errorf("Expected cgroup %s of pod %s, got %s, %v", tt.expected, tt.uid, actual, err)
+		}
+	}
+}
+
+func TestParseBlockStat(t *testing.T) {
+	var tableTests = []struct {
+		stat     string
+		expected *IOStats
+	}{
+		{
+			"    4196     1031   274518     1654     8391     6359   170472    25247        2    22760    26902\n",
+			&IOStats{ReadOps: 4196, ReadBytes: 274518 * 512, WriteOps: 8391, WriteBytes: 170472 * 512,
+				InFlight: 2, QueueTime: 26902 * time.Millisecond},
+		},
+		{
+			// Newer kernels append discard and flush statistics.
+			"1 0 8 0 2 0 16 0 0 3 4 0 0 0 0 0 0\n",
+			&IOStats{ReadOps: 1, ReadBytes: 8 * 512, WriteOps: 2, WriteBytes: 16 * 512,
+				QueueTime: 4 * time.Millisecond},
+		},
+		{"1 0 8 0 2 0 16\n", nil},
+		{"1 0 8 0 2 0 sixteen 0 0 3 4\n", nil},
+	}
+
+	for _, tt := range tableTests {
+		actual, err := parseBlockStat(tt.stat)
+		if tt.expected == nil {
+			if err == nil {
+				t.Errorf("Expected parseBlockStat(%q) to fail, but got %+v", tt.stat, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBlockStat(%q) failed: %v", tt.stat, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected that parseBlockStat(%q) results in\n\t%+v\nbut got\n\t%+v\n", tt.stat, tt.expected, actual)
+		}
+	}
+}
+
+// exitError is the error of commands run through k8s.io/utils/exec that exit
+// with code.
+type exitError int
+
+func (e exitError) Error() string   { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitError) ExitStatus() int { return int(e) }
+
+func TestCheckFilesystem(t *testing.T) {
+	const recovering = "Filesystem volume name:   <none>\nFilesystem features:      has_journal ext_attr needs_recovery extent\n"
+	const clean = "Filesystem volume name:   <none>\nFilesystem features:      has_journal ext_attr extent\n"
+
+	var tableTests = []struct {
+		name     string
+		fsType   string
+		mode     string
+		dumpe2fs string
+		fsckErr  error
+		commands []string
+		errExp   bool
+	}{
+		{name: "clean", fsType: "ext4", mode: volume.FSCKCheck, dumpe2fs: clean, commands: []string{"dumpe2fs", "fsck"}},
+		{name: "damaged", fsType: "ext4", mode: volume.FSCKCheck, dumpe2fs: clean, fsckErr: exitError(4), commands: []string{"dumpe2fs", "fsck"}, errExp: true},
+		{name: "pending journal recovery", fsType: "ext4", mode: volume.FSCKCheck, dumpe2fs: recovering, commands: []string{"dumpe2fs"}},
+		{name: "repair replays the journal", fsType: "ext4", mode: volume.FSCKRepair, dumpe2fs: recovering, commands: []string{"fsck"}},
+		{name: "repaired", fsType: "ext4", mode: volume.FSCKRepair, fsckErr: exitError(1), commands: []string{"fsck"}},
+		{name: "xfs", fsType: "xfs", mode: volume.FSCKCheck, commands: []string{"xfs_repair"}},
+	}
+
+	for _, tt := range tableTests {
+		m := &fakeMounter{
+			diskFormat: tt.fsType,
+			runOut:     map[string]string{"dumpe2fs": tt.dumpe2fs},
+			runErr:     map[string]error{"fsck": tt.fsckErr},
+		}
+		l := &Linstor{log: logrus.NewEntry(logrus.New()), mounter: m}
+
+		err := l.checkFilesystem("/dev/drbd1000", tt.fsType, tt.mode)
+		if tt.errExp != (err != nil) {
+			t.Errorf("%s: expected error: %t, got %v", tt.name, tt.errExp, err)
+		}
+		if !reflect.DeepEqual(tt.commands, m.commands) {
+			t.Errorf("%s: expected commands %v, got %v", tt.name, tt.commands, m.commands)
+		}
+	}
+}
+
+func TestFsckArgs(t *testing.T) {
+	var tableTests = []struct {
+		fsType, mode string
+		cmd          string
+		args         []string
+	}{
+		{"ext4", "check", "fsck", []string{"-t", "ext4", "-n", "/dev/path"}},
+		{"ext4", "repair", "fsck", []string{"-t", "ext4", "-p", "/dev/path"}},
+		{"xfs", "check", "xfs_repair", []string{"-n", "/dev/path"}},
+		{"xfs", "repair", "xfs_repair", []string{"/dev/path"}},
+	}
+
+	for _, tt := range tableTests {
+		cmd, args, err := fsckArgs(tt.fsType, tt.mode, "/dev/path")
+		if err != nil {
+			t.Fatalf("Expected fsckArgs(%q, %q) to succeed, got %v", tt.fsType, tt.mode, err)
+		}
+		if cmd != tt.cmd || !reflect.DeepEqual(tt.args, args) {
+			t.Errorf("Expected that fsckArgs(%q, %q) results in\n\t%s %v\nbut got\n\t%s %v\n",
+				tt.fsType, tt.mode, tt.cmd, tt.args, cmd, args)
+		}
+	}
+
+	if _, _, err := fsckArgs("ext4", "off", "/dev/path"); err == nil {
+		t.Errorf("Expected fsckArgs to refuse unknown modes")
+	}
+}
+
+func TestResourceDefinitionToVolumeCorrupt(t *testing.T) {
+	l := &Linstor{log: logrus.NewEntry(logrus.New())}
+
+	corrupt := lapi.ResourceDefinition{
+		Name:  "corrupt",
+		Props: map[string]string{linstor.AnnotationsKey: "{not json"},
+	}
+	vol, err := l.resourceDefinitionToVolume(corrupt)
+	if err != nil || vol != nil {
+		t.Fatalf("Expected corrupt annotations to be skipped, got %+v, %v", vol, err)
+	}
+	if names := l.CorruptAnnotations(); !reflect.DeepEqual(names, []string{"corrupt"}) {
+		t.Fatalf("Expected corrupt resource to be recorded, got %v", names)
+	}
+
+	corrupt.Props[linstor.AnnotationsKey] = `{"name":"vol","id":"corrupt"}`
+	vol, err = l.resourceDefinitionToVolume(corrupt)
+	if err != nil || vol == nil {
+		t.Fatalf("Expected repaired annotations to be read, got %+v, %v", vol, err)
+	}
+	if names := l.CorruptAnnotations(); len(names) != 0 {
+		t.Fatalf("Expected repaired resource to be forgotten, got %v", names)
+	}
+}
+
+func TestDiffResourceStates(t *testing.T) {
+	now := time.Now()
+	old := map[string]resourceState{
+		"node-a": {flags: "", diskStates: map[int32]string{0: "Inconsistent"}},
+		"node-b": {flags: "", diskStates: map[int32]string{0: "UpToDate"}},
+		"node-c": {flags: "DISKLESS", diskStates: map[int32]string{0: "Diskless"}},
+	}
+	cur := map[string]resourceState{
+		"node-a": {flags: "", inUse: true, diskStates: map[int32]string{0: "UpToDate"}},
+		"node-b": {flags: "", diskStates: map[int32]string{0: "UpToDate"}},
+		"node-d": {flags: "DISKLESS", diskStates: map[int32]string{0: "Diskless"}},
+	}
+
+	var expected = []struct {
+		eventType EventType
+		node      string
+	}{
+		{InUseChanged, "node-a"},
+		{DiskStateChanged, "node-a"},
+		{ResourceDeleted, "node-c"},
+		{ResourceCreated, "node-d"},
+		{DiskStateChanged, "node-d"},
+	}
+
+	actual := diffResourceStates(old, cur, now)
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %d events, but got %+v", len(expected), actual)
+	}
+	for k, e := range expected {
+		if actual[k].Type != e.eventType || actual[k].Node != e.node || !actual[k].Time.Equal(now) {
+			t.Errorf("Expected event %d to be %s on %s, but got %+v", k, e.eventType, e.node, actual[k])
+		}
+	}
+
+	if events := diffResourceStates(cur, cur, now); len(events) != 0 {
+		t.Errorf("Expected no events for unchanged resources, but got %+v", events)
+	}
+}
+
+func TestSnapshotReserveViolation(t *testing.T) {
+	resources := []lapi.Resource{
+		{Name: "res", NodeName: "node-a", Volumes: []lapi.Volume{{StoragePool: "thin"}}},
+		{Name: "res", NodeName: "node-b", Volumes: []lapi.Volume{{StoragePool: "thick"}}},
+		{Name: "res", NodeName: "node-c", Flags: []string{"DISKLESS"}, Volumes: []lapi.Volume{{StoragePool: "thin"}}},
+	}
+	pools := []lapi.StoragePool{
+		{StoragePoolName: "thin", NodeName: "node-a", ProviderKind: lapi.LVM_THIN, FreeCapacity: 15, TotalCapacity: 100},
+		{StoragePoolName: "thick", NodeName: "node-b", ProviderKind: lapi.LVM, FreeCapacity: 1, TotalCapacity: 100},
+		{StoragePoolName: "thin", NodeName: "node-c", ProviderKind: lapi.LVM_THIN, FreeCapacity: 1, TotalCapacity: 100},
+	}
+
+	var tableTests = []struct {
+		reserve float64
+		errExp  bool
+	}{
+		{0, false},
+		{10, false},
+		{20, true},
+	}
+
+	for _, tt := range tableTests {
+		err := snapshotReserveViolation(resources, pools, func(string) float64 { return tt.reserve })
+		if tt.errExp != (err != nil) {
+			t.Errorf("Expected error: %t for a reserve of %v%%, got %v", tt.errExp, tt.reserve, err)
+		}
+	}
+}
+
+func TestCanPlace(t *testing.T) {
+	nodes := []lapi.Node{
+		{Name: "node-a", ConnectionStatus: "ONLINE", Props: map[string]string{"Aux/zone": "z1", "Aux/rack": "r1"}},
+		{Name: "node-b", ConnectionStatus: "ONLINE", Props: map[string]string{"Aux/zone": "z1", "Aux/rack": "r2"}},
+		{Name: "node-c", ConnectionStatus: "ONLINE", Props: map[string]string{"Aux/zone": "z2", "Aux/rack": "r3"}},
+		{Name: "node-d", ConnectionStatus: "OFFLINE", Props: map[string]string{"Aux/zone": "z2", "Aux/rack": "r4"}},
+	}
+	pools := []lapi.StoragePool{
+		{StoragePoolName: "pool", NodeName: "node-a", ProviderKind: lapi.LVM_THIN, FreeCapacity: 100},
+		{StoragePoolName: "pool", NodeName: "node-b", ProviderKind: lapi.LVM_THIN, FreeCapacity: 100},
+		{StoragePoolName: "pool", NodeName: "node-c", ProviderKind: lapi.LVM_THIN, FreeCapacity: 10},
+		{StoragePoolName: "pool", NodeName: "node-d", ProviderKind: lapi.LVM_THIN, FreeCapacity: 100},
+		{StoragePoolName: "DfltDisklessStorPool", NodeName: "node-c", ProviderKind: lapi.DISKLESS, FreeCapacity: 1000},
+	}
+	resources := []lapi.Resource{
+		{Name: "db-primary", NodeName: "node-a"},
+	}
+
+	var tableTests = []struct {
+		params   map[string]string
+		sizeKiB  int64
+		expected bool
+	}{
+		{map[string]string{"storagePool": "pool", "placementCount": "2"}, 50, true},
+		{map[string]string{"storagePool": "pool", "placementCount": "3"}, 50, false},
+		{map[string]string{"storagePool": "pool", "placementCount": "3"}, 10, true},
+		{map[string]string{"storagePool": "pool", "placementCount": "2", "doNotPlaceWithRegex": "^db-"}, 50, false},
+		{map[string]string{"storagePool": "pool", "placementCount": "2", "replicasOnSame": "zone"}, 10, true},
+		{map[string]string{"storagePool": "pool", "placementCount": "3", "replicasOnSame": "zone"}, 10, false},
+		{map[string]string{"storagePool": "pool", "placementCount": "2", "replicasOnSame": "zone=z2"}, 10, false},
+		{map[string]string{"storagePool": "pool", "placementCount": "3", "replicasOnDifferent": "Aux/rack"}, 10, true},
+		{map[string]string{"storagePool": "pool", "placementCount": "3", "replicasOnDifferent": "zone"}, 10, false},
+		{map[string]string{"placementPolicy": "Manual", "storagePool": "pool", "nodeList": "node-a node-b"}, 50, true},
+		{map[string]string{"placementPolicy": "Manual", "storagePool": "pool", "nodeList": "node-a node-d"}, 50, false},
+		{map[string]string{"placementPolicy": "Manual", "storagePool": "pool", "nodeList": "node-a node-c"}, 50, false},
+	}
+
+	for _, tt := range tableTests {
+		params, err := volume.NewParameters(tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, reason, err := canPlace(params, tt.sizeKiB, nodes, pools, resources)
+		if err != nil {
+			t.Errorf("canPlace(%v, %d) failed: %v", tt.params, tt.sizeKiB, err)
+			continue
+		}
+		if actual != tt.expected {
+			t.Errorf("Expected canPlace(%v, %d) to be %t, but got %t: %s", tt.params, tt.sizeKiB, tt.expected, actual, reason)
+		}
+	}
+}
+
+func TestAttachConflicts(t *testing.T) {
+	resources := []lapi.Resource{
+		{Name: "db-primary", NodeName: "node-a"},
+		{Name: "db-replica", NodeName: "node-a"},
+		{Name: "web", NodeName: "node-a"},
+	}
+
+	var tableTests = []struct {
+		id       string
+		params   map[string]string
+		expected []string
+	}{
+		{"db-replica", map[string]string{"doNotPlaceWithRegex": "^db-"}, []string{"db-primary"}},
+		{"cache", map[string]string{"doNotPlaceWithRegex": "^db-"}, []string{"db-primary", "db-replica"}},
+		{"cache", map[string]string{"doNotPlaceWithRegex": "^cache$"}, nil},
+		{"cache", map[string]string{}, nil},
+		// Manually placed volumes ignore the regex.
+		{"cache", map[string]string{"doNotPlaceWithRegex": "^db-", "nodeList": "node-a"}, nil},
+	}
+
+	for _, tt := range tableTests {
+		vol := &volume.Info{ID: tt.id, Parameters: tt.params}
+		actual, err := attachConflicts(vol, resources)
+		if err != nil {
+			t.Errorf("attachConflicts(%s, %v) failed: %v", tt.id, tt.params, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected attachConflicts(%s, %v) to be %v, but got %v", tt.id, tt.params, tt.expected, actual)
+		}
+	}
+
+	vol := &volume.Info{ID: "cache", Parameters: map[string]string{"doNotPlaceWithRegex": "("}}
+	if _, err := attachConflicts(vol, resources); err == nil {
+		t.Errorf("Expected attachConflicts to fail for an invalid regex")
+	}
+}
+
+func TestRebuildVolume(t *testing.T) {
+	rd := lapi.ResourceDefinition{
+		Name:         "pvc-1",
+		ExternalName: "pvc-1-external",
+		LayerData:    []lapi.ResourceDefinitionLayer{{Type: lapi.DRBD}, {Type: lapi.LUKS}, {Type: lapi.STORAGE}},
+	}
+	volDef := lapi.VolumeDefinition{SizeKib: 1024}
+	resources := []lapi.Resource{
+		{Name: "pvc-1", NodeName: "node-a", Volumes: []lapi.Volume{{StoragePool: "pool"}}},
+		{Name: "pvc-1", NodeName: "node-b", Volumes: []lapi.Volume{{StoragePool: "pool"}}},
+		{Name: "pvc-1", NodeName: "node-c", Flags: []string{"DISKLESS"}, Volumes: []lapi.Volume{{StoragePool: "diskless"}}},
+	}
+	snaps := []lapi.Snapshot{
+		{Name: "snap-1", ResourceName: "pvc-1", VolumeDefinitions: []lapi.SnapshotVolumeDefinition{{SizeKib: 1024}}},
+	}
+
+	vol := rebuildVolume(rd, volDef, resources, snaps, "linstor.csi.linbit.com")
+
+	if vol.Name != "pvc-1-external" || vol.ID != "pvc-1" || vol.CreatedBy != "linstor.csi.linbit.com" {
+		t.Errorf("Unexpected identity of rebuilt volume: %+v", vol)
+	}
+	if vol.SizeBytes != 1024*1024 {
+		t.Errorf("Expected rebuilt volume to have %d bytes, got %d", 1024*1024, vol.SizeBytes)
+	}
+
+	expected := map[string]string{
+		"layerList":           "drbd luks storage",
+		"encryption":          "true",
+		"storagePool":         "pool",
+		"disklessStoragePool": "diskless",
+		"placementCount":      "2",
+	}
+	if !reflect.DeepEqual(expected, vol.Parameters) {
+		t.Errorf("Expected rebuilt parameters\n\t%v\nbut got\n\t%v", expected, vol.Parameters)
+	}
+	if _, err := volume.NewParameters(vol.Parameters); err != nil {
+		t.Errorf("Rebuilt parameters are invalid: %v", err)
+	}
+
+	if len(vol.Snapshots) != 1 || vol.Snapshots[0].CsiSnap.SnapshotId != "snap-1" || vol.Snapshots[0].CsiSnap.SourceVolumeId != "pvc-1" {
+		t.Errorf("Unexpected snapshots of rebuilt volume: %+v", vol.Snapshots)
+	}
+}
+
+func TestNewLinstorLogsNoSecrets(t *testing.T) {
+	u, err := url.Parse("http://linstor-controller:3370")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	_, err = NewLinstor(
+		LogOut(&out),
+		LogLevel("debug"),
+		MasterPassphrase("master-secret"),
+		ControllerAPI(u, "admin", "controller-secret"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "generated new linstor client") {
+		t.Fatalf("expected the client to be logged, got %q", out.String())
+	}
+	for _, secret := range []string{"master-secret", "controller-secret"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("log output contains %q: %s", secret, out.String())
+		}
+	}
+}
+
+func TestTemplateResourceName(t *testing.T) {
+	var tableTests = []struct {
+		tmpl         string
+		name         string
+		storageClass string
+		expected     string
+	}{
+		{"{{.StorageClass}}-{{.Name}}", "pvc-1", "gold", "gold-pvc-1"},
+		{"{{.StorageClass}}-{{.Name}}", "pvc-1", "gold.fast", "gold_fast-pvc-1"},
+		{"{{.StorageClass}}-{{.Name}}", "pvc-1", "", "LS_-pvc-1"},
+		{"{{.StorageClass}}-{{.Name}}", "pvc-16fa96a3-6e13-4b9f-a0ea-7b3da5cd1e87", "platinum", "platinum-pvc-16fa96a3-6e13-4b9f-a0ea-7b3da5cd1e8"},
+		{"{{.StorageClass}}{{.Name}}", "-16fa96a3-6e13-4b9f-a0ea-7b3da5cd1e87-extra-long", "1", "LS_1-16fa96a3-6e13-4b9f-a0ea-7b3da5cd1e87-extra-"},
+	}
+
+	for _, tt := range tableTests {
+		tmpl := template.Must(template.New("").Parse(tt.tmpl))
+		vol := &volume.Info{Name: tt.name}
+		if tt.storageClass != "" {
+			vol.Kubernetes = &volume.KubernetesRef{StorageClass: tt.storageClass}
+		}
+
+		actual, err := templateResourceName(tmpl, vol, linstorifyResourceName)
+		if err != nil {
+			t.Errorf("templateResourceName(%q) for %s failed: %v", tt.tmpl, tt.name, err)
+			continue
+		}
+		if actual != tt.expected {
+			t.Errorf("Expected templateResourceName(%q) for %s to be %q, but got %q", tt.tmpl, tt.name, tt.expected, actual)
+		}
+		if err := validResourceName(actual); err != nil {
+			t.Errorf("templateResourceName(%q) for %s returned invalid name %q: %v", tt.tmpl, tt.name, actual, err)
+		}
+	}
+}
+
+func TestForcePrimary(t *testing.T) {
+	var tableTests = []struct {
+		allow  bool
+		node   string
+		errExp bool
+	}{
+		{false, "node-a", true},
+		{true, "node-b", true},
+		{true, "node-a", false},
+	}
+
+	for _, tt := range tableTests {
+		m := &fakeMounter{FakeMounter: &mount.FakeMounter{}}
+		l := &Linstor{log: logrus.NewEntry(logrus.New()), mounter: m, nodeName: "node-a", allowForcePrimary: tt.allow}
+
+		err := l.ForcePrimary(&volume.Info{ID: "pvc-1"}, tt.node)
+		if tt.errExp != (err != nil) {
+			t.Errorf("Expected error: %t when forcing primary on %s with allow: %t, got %v", tt.errExp, tt.node, tt.allow, err)
+		}
+
+		var expected []string
+		if !tt.errExp {
+			expected = []string{"drbdadm"}
+		}
+		if !reflect.DeepEqual(expected, m.commands) {
+			t.Errorf("Expected commands %v when forcing primary on %s with allow: %t, got %v", expected, tt.node, tt.allow, m.commands)
+		}
+	}
+}
+
+func TestUpToDateReplicas(t *testing.T) {
+	replica := func(node string, inUse bool, states ...string) lapi.Resource {
+		r := lapi.Resource{Name: "pvc-1", NodeName: node, State: lapi.ResourceState{InUse: inUse}}
+		for _, state := range states {
+			r.Volumes = append(r.Volumes, lapi.Volume{State: lapi.VolumeState{DiskState: state}})
+		}
+		return r
+	}
+	var tableTests = []struct {
+		resources []lapi.Resource
+		primary   string
+		upToDate  int
+		diskful   int
+	}{
+		{
+			resources: []lapi.Resource{replica("node-a", false, "UpToDate"), replica("node-b", true, "UpToDate"), replica("node-c", false, "Diskless")},
+			primary:   "node-b",
+			upToDate:  2,
+			diskful:   2,
+		},
+		{
+			resources: []lapi.Resource{replica("node-a", false, "UpToDate", "Outdated"), replica("node-b", false, "Inconsistent"), replica("node-c", false)},
+			upToDate:  0,
+			diskful:   2,
+		},
+	}
+
+	for _, tt := range tableTests {
+		if primary := primaryNode(tt.resources); tt.primary != primary {
+			t.Errorf("Expected primary %q, got %q", tt.primary, primary)
+		}
+		upToDate, diskful := upToDateReplicas(tt.resources)
+		if tt.upToDate != upToDate || tt.diskful != diskful {
+			t.Errorf("Expected %d of %d replicas up to date, got %d of %d", tt.upToDate, tt.diskful, upToDate, diskful)
+		}
+	}
+}
+
+func TestSetPrimary(t *testing.T) {
+	var requested string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/view/resources":
+			fmt.Fprint(w, `[
+				{"name": "pvc-1", "node_name": "node-a", "volumes": [{"state": {"disk_state": "UpToDate"}}]},
+				{"name": "pvc-1", "node_name": "node-b", "state": {"in_use": true}, "volumes": [{"state": {"disk_state": "UpToDate"}}]},
+				{"name": "pvc-2", "node_name": "node-c", "volumes": [{"state": {"disk_state": "UpToDate"}}]}
+			]`)
+		case r.URL.Path == "/v1/resource-definitions/pvc-1/resources/node-b" && r.Method == http.MethodPut:
+			var modify lapi.ResourceDefinitionModify
+			if err := json.NewDecoder(r.Body).Decode(&modify); err != nil {
+				t.Error(err)
+			}
+			requested = modify.OverrideProps[linstor.RequestedRoleKey]
+		case r.URL.Path == "/v1/resource-definitions/pvc-1/resources/node-b":
+			// The role watcher of node-b demoted the resource.
+			fmt.Fprint(w, `{"name": "pvc-1", "node_name": "node-b", "state": {"in_use": false}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLinstor(APIClient(c), NodeName("node-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close() //nolint:errcheck
+	m := &fakeMounter{FakeMounter: &mount.FakeMounter{}}
+	l.mounter = m
+
+	if err := l.SetPrimary(context.Background(), &volume.Info{ID: "pvc-1"}, "node-a", false); err != nil {
+		t.Fatal(err)
+	}
+	if requested != "Secondary" {
+		t.Errorf("Expected node-b to be asked to demote the volume, got role %q", requested)
+	}
+	if !reflect.DeepEqual([]string{"drbdadm"}, m.commands) {
+		t.Errorf("Expected the volume to be promoted on node-a, got commands %v", m.commands)
+	}
+
+	if err := l.SetPrimary(context.Background(), &volume.Info{ID: "pvc-1"}, "node-c", false); err == nil {
+		t.Errorf("Expected promotion on a node without assignment to be refused")
+	}
+}
+
+func TestApplyRequestedRoles(t *testing.T) {
+	var tableTests = []struct {
+		role    string
+		runErr  error
+		errProp bool
+	}{
+		{role: "Secondary"},
+		{role: "Primary", runErr: errors.New("exit status 11"), errProp: true},
+		{role: "Tertiary", errProp: true},
+	}
+
+	for _, tt := range tableTests {
+		var modify lapi.ResourceDefinitionModify
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/v1/view/resources":
+				fmt.Fprintf(w, `[{"name": "pvc-1", "node_name": "node-a", "props": {%q: %q}}, {"name": "pvc-1", "node_name": "node-b", "props": {%q: "Primary"}}, {"name": "pvc-2", "node_name": "node-a"}]`, linstor.RequestedRoleKey, tt.role, linstor.RequestedRoleKey)
+			case r.URL.Path == "/v1/resource-definitions/pvc-1/resources/node-a" && r.Method == http.MethodPut:
+				if err := json.NewDecoder(r.Body).Decode(&modify); err != nil {
+					t.Error(err)
+				}
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := NewLinstor(APIClient(c), NodeName("node-a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.mounter = &fakeMounter{FakeMounter: &mount.FakeMounter{}, runErr: map[string]error{"drbdadm": tt.runErr}}
+
+		if err := l.applyRequestedRoles(context.Background()); err != nil {
+			t.Errorf("Expected requested role %q to be handled, got %v", tt.role, err)
+		}
+		if !reflect.DeepEqual([]string{linstor.RequestedRoleKey}, []string(modify.DeleteProps)) {
+			t.Errorf("Expected requested role %q to be removed, got %+v", tt.role, modify)
+		}
+		if _, ok := modify.OverrideProps[linstor.RequestedRoleErrorKey]; tt.errProp != ok {
+			t.Errorf("Expected error reported: %t for requested role %q, got %+v", tt.errProp, tt.role, modify)
+		}
+
+		l.Close() //nolint:errcheck
+		srv.Close()
+	}
+}
+
+func TestSurplusReplicas(t *testing.T) {
+	resources := []lapi.Resource{
+		{Name: "pvc-1", NodeName: "node-c"},
+		{Name: "pvc-1", NodeName: "node-a", State: lapi.ResourceState{InUse: true}},
+		{Name: "pvc-1", NodeName: "node-b"},
+		{Name: "pvc-1", NodeName: "node-d", Flags: []string{"DISKLESS"}},
+	}
+
+	var tableTests = []struct {
+		n        int
+		expected []string
+	}{
+		{0, []string{}},
+		{1, []string{"node-b"}},
+		{2, []string{"node-b", "node-c"}},
+		{3, []string{"node-b", "node-c", "node-a"}},
+		{4, []string{"node-b", "node-c", "node-a"}},
+	}
+
+	for _, tt := range tableTests {
+		var actual = make([]string, 0)
+		for _, r := range surplusReplicas(resources, tt.n) {
+			actual = append(actual, r.NodeName)
+		}
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected surplusReplicas(%d) to remove %v, but got %v", tt.n, tt.expected, actual)
+		}
+	}
+}
+
+func TestCompressionSupported(t *testing.T) {
+	pools := []lapi.StoragePool{
+		{StoragePoolName: "zfs", NodeName: "node-a", ProviderKind: lapi.ZFS},
+		{StoragePoolName: "zfs", NodeName: "node-b", ProviderKind: lapi.ZFS_THIN},
+		{StoragePoolName: "mixed", NodeName: "node-a", ProviderKind: lapi.ZFS},
+		{StoragePoolName: "mixed", NodeName: "node-b", ProviderKind: lapi.LVM_THIN},
+		{StoragePoolName: "lvm", NodeName: "node-a", ProviderKind: lapi.LVM},
+	}
+
+	var tableTests = []struct {
+		pool   string
+		errExp bool
+	}{
+		{"zfs", false},
+		{"mixed", true},
+		{"lvm", true},
+		{"missing", true},
+		{"", true},
+	}
+
+	for _, tt := range tableTests {
+		err := compressionSupported(pools, tt.pool)
+		if tt.errExp != (err != nil) {
+			t.Errorf("Expected error: %t for compression in storage pool %q, got %v", tt.errExp, tt.pool, err)
+		}
+	}
+}
+
+func TestPoolFits(t *testing.T) {
+	capacity := map[string]int64{"node-a": 100, "node-b": 50, "node-c": 10}
+
+	var tableTests = []struct {
+		size     int64
+		count    int
+		nodes    []string
+		expected bool
+	}{
+		{50, 2, nil, true},
+		{50, 3, nil, false},
+		{100, 1, nil, true},
+		{101, 1, nil, false},
+		{50, 0, []string{"node-a", "node-b"}, true},
+		{50, 0, []string{"node-a", "node-c"}, false},
+		{50, 0, []string{"node-d"}, false},
+	}
+
+	for _, tt := range tableTests {
+		actual := poolFits(capacity, tt.size, tt.count, tt.nodes)
+		if actual != tt.expected {
+			t.Errorf("Expected poolFits(%d, %d, %v) to be %t, but got %t", tt.size, tt.count, tt.nodes, tt.expected, actual)
+		}
+	}
+}
+
+func TestToNodeInfos(t *testing.T) {
+	nodes := []lapi.Node{
+		{Name: "node-b", Type: "SATELLITE", ConnectionStatus: "OFFLINE", NetInterfaces: []lapi.NetInterface{
+			{Name: "default", Address: "10.0.0.2"},
+			{Name: "replication", Address: "10.1.0.2", IsActive: true},
+			{Name: "backup", Address: "10.2.0.2"},
+		}},
+		{Name: "node-a", Type: "COMBINED", ConnectionStatus: "ONLINE", NetInterfaces: []lapi.NetInterface{
+			{Name: "default", Address: "10.0.0.1"},
+		}},
+		{Name: "node-c", Type: "CONTROLLER"},
+	}
+
+	expected := []NodeInfo{
+		{Name: "node-a", Type: "COMBINED", ConnectionStatus: "ONLINE", Address: "10.0.0.1"},
+		{Name: "node-b", Type: "SATELLITE", ConnectionStatus: "OFFLINE", Address: "10.1.0.2"},
+		{Name: "node-c", Type: "CONTROLLER"},
+	}
+
+	actual := toNodeInfos(nodes)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected node infos\n\t%+v\nbut got\n\t%+v", expected, actual)
+	}
+}
+
+func TestLaggardReplicas(t *testing.T) {
+	resources := []lapi.Resource{
+		{Name: "foo", NodeName: "node-c", Volumes: []lapi.Volume{{UsableSizeKib: 1024}}},
+		{Name: "foo", NodeName: "node-a", Volumes: []lapi.Volume{{UsableSizeKib: 2048}}},
+		{Name: "foo", NodeName: "node-b", Volumes: []lapi.Volume{{UsableSizeKib: 1024}}},
+		{Name: "foo", NodeName: "node-d", Flags: []string{"DISKLESS"}, Volumes: []lapi.Volume{{}}},
+		{Name: "bar", NodeName: "node-e", Volumes: []lapi.Volume{{UsableSizeKib: 1024}}},
+	}
+
+	var tableTests = []struct {
+		sizeKiB  int64
+		expected []string
+	}{
+		{sizeKiB: 1024, expected: []string{}},
+		{sizeKiB: 2048, expected: []string{"node-b", "node-c"}},
+		{sizeKiB: 4096, expected: []string{"node-a", "node-b", "node-c"}},
+	}
+
+	for _, tt := range tableTests {
+		actual := laggardReplicas(resources, "foo", tt.sizeKiB)
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected laggards growing to %d KiB to be %v, but got %v", tt.sizeKiB, tt.expected, actual)
+		}
+	}
+}
+
+func TestEvacuationCandidates(t *testing.T) {
+	vols := []*volume.Info{
+		{ID: "pvc-1"},
+		{ID: "pvc-2", Parameters: map[string]string{"pinned": "true"}},
+		{ID: "pvc-3", Parameters: map[string]string{"pinned": "false"}},
+		{ID: "pvc-4", Parameters: map[string]string{"pinned": "maybe"}},
+		{ID: "pvc-5"},
+		{ID: "pvc-6"},
+	}
+	resources := []lapi.Resource{
+		{Name: "pvc-1", NodeName: "node-a"},
+		{Name: "pvc-2", NodeName: "node-a"},
+		{Name: "pvc-3", NodeName: "node-a"},
+		{Name: "pvc-4", NodeName: "node-a"},
+		{Name: "pvc-5", NodeName: "node-a", Flags: []string{"DISKLESS"}},
+	}
+
+	move, skip := evacuationCandidates(vols, resources)
+
+	var ids = func(vols []*volume.Info) []string {
+		var ids []string
+		for _, v := range vols {
+			ids = append(ids, v.ID)
+		}
+		return ids
+	}
+	if expected := []string{"pvc-1", "pvc-3"}; !reflect.DeepEqual(expected, ids(move)) {
+		t.Errorf("Expected to move %v, but got %v", expected, ids(move))
+	}
+	if expected := []string{"pvc-2", "pvc-4"}; !reflect.DeepEqual(expected, ids(skip)) {
+		t.Errorf("Expected to skip %v, but got %v", expected, ids(skip))
+	}
+}
+
+func TestReadOnlySnapshotOpts(t *testing.T) {
+	var tableTests = []struct {
+		fsType   string
+		expected []string
+	}{
+		{fsType: "ext4", expected: []string{"ro", "noload"}},
+		{fsType: "ext3", expected: []string{"ro", "noload"}},
+		{fsType: "ext2", expected: []string{"ro"}},
+		{fsType: "xfs", expected: []string{"ro", "norecovery", "nouuid"}},
+		{fsType: "btrfs", expected: []string{"ro"}},
+	}
+
+	for _, tt := range tableTests {
+		actual := readOnlySnapshotOpts(tt.fsType)
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected read-only options for %s to be %v, but got %v", tt.fsType, tt.expected, actual)
+		}
+	}
+}
+
+func TestVolumeAge(t *testing.T) {
+	if _, ok := VolumeAge(&volume.Info{}); ok {
+		t.Errorf("Expected age of volume without creation time to be unknown")
+	}
+
+	age, ok := VolumeAge(&volume.Info{CreationTime: time.Now().Add(-time.Hour)})
+	if !ok || age < time.Hour || age > 2*time.Hour {
+		t.Errorf("Expected age of volume created an hour ago to be about an hour, but got %v (known: %v)", age, ok)
+	}
+}
+
+func TestMergeMountOptions(t *testing.T) {
+	var tableTests = []struct {
+		name     string
+		csiOpts  []string
+		scOpts   string
+		expected []string
+		fail     bool
+	}{
+		{
+			name:     "storage class options are appended",
+			csiOpts:  []string{"ro"},
+			scOpts:   "noatime,discard",
+			expected: []string{"ro", "noatime", "discard"},
+		},
+		{
+			name:     "empty options are dropped",
+			csiOpts:  []string{""},
+			scOpts:   "",
+			expected: []string{},
+		},
+		{
+			name:     "duplicates are removed",
+			csiOpts:  []string{"noatime", "noatime"},
+			scOpts:   "noatime, discard,discard",
+			expected: []string{"noatime", "discard"},
+		},
+		{
+			name:     "csi call wins over storage class",
+			csiOpts:  []string{"ro", "atime"},
+			scOpts:   "rw,noatime,nodev",
+			expected: []string{"ro", "atime", "nodev"},
+		},
+		{
+			name:    "conflict within csi call",
+			csiOpts: []string{"ro", "rw"},
+			fail:    true,
+		},
+		{
+			name:   "conflict within storage class",
+			scOpts: "atime,noatime",
+			fail:   true,
+		},
+	}
+
+	for _, tt := range tableTests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := mergeMountOptions(tt.csiOpts, tt.scOpts)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("Expected merging %v and %q to fail, but got %v", tt.csiOpts, tt.scOpts, actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.expected, actual) {
+				t.Errorf("Expected merged options %v, but got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPreallocateArgs(t *testing.T) {
+	expected := []string{"if=/dev/urandom", "of=/dev/drbd1000", "bs=1M", "count=1073741824", "iflag=count_bytes", "oflag=direct", "conv=fsync"}
+	actual := preallocateArgs("/dev/drbd1000", 1048576)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected dd arguments %v, but got %v", expected, actual)
+	}
+}
+
+func TestCheckPreallocated(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLinstor(APIClient(c), NodeName("node-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close() //nolint:errcheck
+
+	params := map[string]string{"preallocate": "true"}
+	if err := l.checkPreallocated(&volume.Info{ID: "pvc-1", Parameters: params, Preallocated: true}); err != nil {
+		t.Errorf("Expected preallocated volumes to be attachable, got %v", err)
+	}
+	if err := l.checkPreallocated(&volume.Info{ID: "pvc-2"}); err != nil {
+		t.Errorf("Expected volumes without preallocation to be attachable, got %v", err)
+	}
+
+	err = l.checkPreallocated(&volume.Info{ID: "pvc-3", Parameters: params})
+	if _, ok := err.(*PreallocatingError); !ok {
+		t.Errorf("Expected a PreallocatingError for volumes that are not preallocated yet, got %v", err)
+	}
+}
+
+func TestRemovalOrder(t *testing.T) {
+	resources := []lapi.Resource{
+		{Name: "pvc-1", NodeName: "node-a"},
+		{Name: "pvc-1", NodeName: "node-b", Flags: []string{"DISKLESS"}},
+		{Name: "pvc-1", NodeName: "node-c"},
+		{Name: "pvc-1", NodeName: "node-d", Flags: []string{"DISKLESS"}},
+	}
+
+	var nodes []string
+	for _, r := range removalOrder(resources) {
+		nodes = append(nodes, r.NodeName)
+	}
+
+	expected := []string{"node-b", "node-d", "node-a", "node-c"}
+	if !reflect.DeepEqual(expected, nodes) {
+		t.Errorf("Expected removal order %v, but got %v", expected, nodes)
+	}
+	if resources[0].NodeName != "node-a" {
+		t.Errorf("Expected input to be left alone, but got %v", resources)
+	}
+}
+
+func TestMetadataPoolAvailable(t *testing.T) {
+	pools := []lapi.StoragePool{
+		{StoragePoolName: "bulk", NodeName: "node-a"},
+		{StoragePoolName: "bulk", NodeName: "node-b"},
+		{StoragePoolName: "bulk", NodeName: "node-c"},
+		{StoragePoolName: "nvme", NodeName: "node-a"},
+		{StoragePoolName: "nvme", NodeName: "node-b"},
+		{StoragePoolName: "nvme", NodeName: "node-d"},
+		{StoragePoolName: "DfltDisklessStorPool", NodeName: "node-d", ProviderKind: lapi.DISKLESS},
+	}
+
+	var tableTests = []struct {
+		name   string
+		params volume.Parameters
+		fail   bool
+	}{
+		{
+			name:   "enough nodes with both pools",
+			params: volume.Parameters{StoragePool: "bulk", MetadataStoragePool: "nvme", PlacementCount: 2},
+		},
+		{
+			name:   "too few nodes with both pools",
+			params: volume.Parameters{StoragePool: "bulk", MetadataStoragePool: "nvme", PlacementCount: 3},
+			fail:   true,
+		},
+		{
+			name:   "any data pool",
+			params: volume.Parameters{MetadataStoragePool: "nvme", PlacementCount: 2},
+		},
+		{
+			name:   "diskless and metadata pools hold no data",
+			params: volume.Parameters{MetadataStoragePool: "nvme", PlacementCount: 3},
+			fail:   true,
+		},
+		{
+			name:   "missing metadata pool",
+			params: volume.Parameters{StoragePool: "bulk", MetadataStoragePool: "ssd", PlacementCount: 1},
+			fail:   true,
+		},
+		{
+			name:   "node list with both pools",
+			params: volume.Parameters{StoragePool: "bulk", MetadataStoragePool: "nvme", NodeList: []string{"node-a", "node-b"}},
+		},
+		{
+			name:   "node list with node lacking metadata pool",
+			params: volume.Parameters{StoragePool: "bulk", MetadataStoragePool: "nvme", NodeList: []string{"node-a", "node-c"}},
+			fail:   true,
+		},
+	}
+
+	for _, tt := range tableTests {
+		err := metadataPoolAvailable(pools, tt.params)
+		if tt.fail && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+		if !tt.fail && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+	}
+}
+
+type closeRecorder struct {
+	http.RoundTripper
+	closed int
+}
+
+func (c *closeRecorder) CloseIdleConnections() {
+	c.closed++
+}
+
+func TestClose(t *testing.T) {
+	transport := &closeRecorder{}
+	l, err := NewLinstor(Transport(&HeaderTransport{Base: transport}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.startRemountWatcher("/dev/drbd1000", "/target", false)
+	l.nodes = []NodeInfo{{Name: "node-a"}}
+
+	for i := 0; i < 2; i++ {
+		if err := l.Close(); err != nil {
+			t.Fatalf("Expected Close to succeed, got %v", err)
+		}
+	}
+
+	if len(l.remountWatchers) != 0 {
+		t.Errorf("Expected remount watchers to be stopped, got %v", l.remountWatchers)
+	}
+	if l.nodes != nil {
+		t.Errorf("Expected node cache to be dropped, got %v", l.nodes)
+	}
+	if transport.closed != 2 {
+		t.Errorf("Expected idle connections to be closed on every Close, got %d", transport.closed)
+	}
+}
+
+func TestSnapsForVolume(t *testing.T) {
+	snap := func(name, source string, created int64) *volume.SnapInfo {
+		return &volume.SnapInfo{Name: name, CsiSnap: &csi.Snapshot{
+			SnapshotId:     name,
+			SourceVolumeId: source,
+			CreationTime:   &timestamp.Timestamp{Seconds: created},
+		}}
+	}
+	vols := []*volume.Info{
+		{ID: "pvc-a", Snapshots: []*volume.SnapInfo{snap("a-1", "pvc-a", 1), snap("a-3", "pvc-a", 3)}},
+		{ID: "pvc-b", Snapshots: []*volume.SnapInfo{snap("b-2", "pvc-b", 2), snap("a-2", "pvc-a", 2)}},
+		{ID: "pvc-c", Snapshots: []*volume.SnapInfo{}},
+	}
+
+	var names []string
+	for _, s := range snapsForVolume(vols, "pvc-a") {
+		names = append(names, s.Name)
+	}
+	if expected := []string{"a-3", "a-2", "a-1"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected snapshots %v, got %v", expected, names)
+	}
+
+	if snaps := snapsForVolume(vols, "pvc-c"); snaps == nil || len(snaps) != 0 {
+		t.Errorf("Expected an empty list of snapshots, got %v", snaps)
+	}
+}
+
+func TestResDefWithUUID(t *testing.T) {
+	rds := []lapi.ResourceDefinition{
+		{Name: "pvc-a", Uuid: "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		{Name: "pvc-b", Uuid: "6ba7b811-9dad-11d1-80b4-00c04fd430c8"},
+	}
+
+	if owner := resDefWithUUID(rds, "6BA7B811-9DAD-11D1-80B4-00C04FD430C8"); owner != "pvc-b" {
+		t.Errorf("Expected UUID to be in use by pvc-b, got %q", owner)
+	}
+	if owner := resDefWithUUID(rds, "6ba7b812-9dad-11d1-80b4-00c04fd430c8"); owner != "" {
+		t.Errorf("Expected UUID to be unused, got %q", owner)
+	}
+}
+
+func TestPreferredNode(t *testing.T) {
+	segments := func(node string) *csi.Topology {
+		return &csi.Topology{Segments: map[string]string{topology.LinstorNodeKey: node}}
+	}
+	var tableTests = []struct {
+		req      *csi.CreateVolumeRequest
+		expected string
+	}{
+		{req: &csi.CreateVolumeRequest{}, expected: ""},
+		{req: &csi.CreateVolumeRequest{AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{segments("node-a"), segments("node-b")},
+		}}, expected: ""},
+		{req: &csi.CreateVolumeRequest{AccessibilityRequirements: &csi.TopologyRequirement{
+			Preferred: []*csi.Topology{
+				{Segments: map[string]string{"topology.kubernetes.io/zone": "a"}},
+				segments("node-b"),
+				segments("node-a"),
+			},
+		}}, expected: "node-b"},
+	}
+
+	for _, tt := range tableTests {
+		if actual := preferredNode(tt.req); tt.expected != actual {
+			t.Errorf("Expected preferred node %q for %+v, got %q", tt.expected, tt.req, actual)
+		}
+	}
+}
+
+func TestResolveSecretOpts(t *testing.T) {
+	secrets := map[string]string{"user": "admin", "password": "hunter2", "injected": "x,uid=0"}
+	var tableTests = []struct {
+		options  []string
+		expected []string
+		values   []string
+		errExp   bool
+	}{
+		{options: []string{"noatime", "ro"}, expected: []string{"noatime", "ro"}},
+		{
+			options:  []string{"noatime", "username=${secret:user},password=${secret:password}"},
+			expected: []string{"noatime", "username=admin,password=hunter2"},
+			values:   []string{"admin", "hunter2"},
+		},
+		{options: []string{"password=${secret:token}"}, errExp: true},
+		{options: []string{"password=${secret:injected}"}, errExp: true},
+	}
+
+	for _, tt := range tableTests {
+		actual, values, err := resolveSecretOpts(tt.options, secrets)
+		if tt.errExp != (err != nil) {
+			t.Errorf("Expected error: %t for %v, got %v", tt.errExp, tt.options, err)
+			continue
+		}
+		if tt.errExp {
+			if strings.Contains(err.Error(), "uid=0") {
+				t.Errorf("Expected error to omit the secret value, got %v", err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(tt.expected, actual) || !reflect.DeepEqual(tt.values, values) {
+			t.Errorf("Expected options %v with secrets %v, got %v with %v", tt.expected, tt.values, actual, values)
+		}
+	}
+
+	err := redactSecrets(errors.New("mount failed: -o username=admin,password=hunter2"), []string{"admin", "hunter2"})
+	if expected := "mount failed: -o username=<redacted>,password=<redacted>"; err.Error() != expected {
+		t.Errorf("Expected error %q, got %q", expected, err)
+	}
+}
+
+func TestTiebreakerNode(t *testing.T) {
+	pools := []lapi.StoragePool{
+		{NodeName: "node-a", StoragePoolName: "quorum", ProviderKind: lapi.DISKLESS},
+		{NodeName: "node-d", StoragePoolName: "quorum", ProviderKind: lapi.DISKLESS},
+		{NodeName: "node-c", StoragePoolName: "quorum", ProviderKind: lapi.DISKLESS},
+		{NodeName: "node-b", StoragePoolName: "thin", ProviderKind: lapi.LVM_THIN},
+		{NodeName: "node-e", StoragePoolName: "quorum", ProviderKind: lapi.LVM},
+	}
+	resources := []lapi.Resource{{Name: "pvc-1", NodeName: "node-a"}, {Name: "pvc-1", NodeName: "node-b"}}
+
+	if node := tiebreakerNode(pools, resources, "quorum"); node != "node-c" {
+		t.Errorf("Expected tiebreaker on node-c, got %q", node)
+	}
+	if node := tiebreakerNode(pools, resources, "thin"); node != "" {
+		t.Errorf("Expected no tiebreaker node for a diskful pool, got %q", node)
+	}
+}
+
+func TestEventSink(t *testing.T) {
+	l, err := NewLinstor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without a sink, events are dropped.
+	l.emit(EventNormal, "Attached", "attached %s to node %s", "pvc-1", "node-a")
+
+	var events []string
+	l, err = NewLinstor(EventSink(func(level, reason, message string) {
+		events = append(events, level+" "+reason+" "+message)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.emit(EventNormal, "Attached", "attached %s to node %s", "pvc-1", "node-a")
+	l.emit(EventWarning, "Degraded", "volume %s has %d of %d replicas, adding replicas", "pvc-1", 1, 2)
+
+	expected := []string{
+		"Normal Attached attached pvc-1 to node node-a",
+		"Warning Degraded volume pvc-1 has 1 of 2 replicas, adding replicas",
+	}
+	if !reflect.DeepEqual(expected, events) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+}
+
+func TestPickFilesystem(t *testing.T) {
+	installed := func(fs string) bool { return fs == "ext4" || fs == "btrfs" }
+	var tableTests = []struct {
+		candidates []string
+		recorded   string
+		deviceFS   string
+		expected   string
+		errExp     bool
+	}{
+		{candidates: []string{"xfs", "ext4"}, expected: "ext4"},
+		{candidates: []string{"btrfs", "ext4"}, expected: "btrfs"},
+		{candidates: []string{"xfs", "ext4"}, deviceFS: "xfs", expected: "xfs"},
+		{candidates: []string{"xfs", "ext4"}, recorded: "xfs", expected: "xfs"},
+		{candidates: []string{"xfs", "ext4"}, recorded: "ext4", deviceFS: "xfs", expected: "xfs"},
+		{candidates: []string{"xfs", "ext4"}, deviceFS: "vfat", expected: "ext4"},
+		{candidates: []string{"xfs", "f2fs"}, errExp: true},
+	}
+
+	for _, tt := range tableTests {
+		actual, err := pickFilesystem(tt.candidates, tt.recorded, tt.deviceFS, installed)
+		if tt.errExp != (err != nil) {
+			t.Errorf("Expected error: %t for %+v, got %v", tt.errExp, tt, err)
+			continue
+		}
+		if tt.expected != actual {
+			t.Errorf("Expected filesystem %q for %+v, got %q", tt.expected, tt, actual)
+		}
+	}
+}
+
+func TestOrphanedAssignments(t *testing.T) {
+	rds := []lapi.ResourceDefinition{
+		{Name: "pvc-live"},
+		{Name: "pvc-deleting", Flags: []string{"DELETE"}},
+	}
+	resources := []lapi.Resource{
+		{Name: "pvc-live", NodeName: "node-a"},
+		{Name: "pvc-gone", NodeName: "node-b"},
+		{Name: "pvc-deleting", NodeName: "node-b"},
+		{Name: "pvc-deleting", NodeName: "node-a"},
+	}
+
+	expected := []OrphanAssignment{
+		{Node: "node-a", Resource: "pvc-deleting"},
+		{Node: "node-b", Resource: "pvc-deleting"},
+		{Node: "node-b", Resource: "pvc-gone"},
+	}
+	if actual := orphanedAssignments(resources, rds); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected orphaned assignments %v, got %v", expected, actual)
+	}
+}
+
+func TestApplyDefaultStoragePool(t *testing.T) {
+	var tableTests = []struct {
+		defaultPool string
+		params      map[string]string
+		expected    string
+	}{
+		{defaultPool: "", params: map[string]string{}, expected: ""},
+		{defaultPool: "thin", params: map[string]string{}, expected: "thin"},
+		{defaultPool: "thin", params: map[string]string{"storagePool": "thick"}, expected: "thick"},
+		{defaultPool: "thin", params: map[string]string{"tier": "gold"}, expected: ""},
+	}
+
+	for _, tt := range tableTests {
+		l, err := NewLinstor(DefaultStoragePool(tt.defaultPool))
+		if err != nil {
+			t.Fatal(err)
+		}
+		vol := &volume.Info{Parameters: tt.params}
+		if err := l.applyDefaultStoragePool(vol); err != nil {
+			t.Fatal(err)
+		}
+
+		params, err := volume.NewParameters(vol.Parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.expected != params.StoragePool {
+			t.Errorf("Expected storage pool %q with default %q and %v, got %q", tt.expected, tt.defaultPool, tt.params, params.StoragePool)
+		}
+	}
+}
+
+func TestEligibleNodeCount(t *testing.T) {
+	nodes := []lapi.Node{
+		{Name: "node-a", ConnectionStatus: "ONLINE", Props: map[string]string{"Aux/zone": "z1"}},
+		{Name: "node-b", ConnectionStatus: "ONLINE", Props: map[string]string{"Aux/zone": "z1"}},
+		{Name: "node-c", ConnectionStatus: "ONLINE", Props: map[string]string{"Aux/zone": "z2"}},
+		{Name: "node-d", ConnectionStatus: "OFFLINE", Props: map[string]string{"Aux/zone": "z2"}},
+		{Name: "node-e", ConnectionStatus: "ONLINE", Props: map[string]string{"Aux/zone": "z3"}},
+	}
+	pools := []lapi.StoragePool{
+		{StoragePoolName: "pool", NodeName: "node-a", ProviderKind: lapi.LVM_THIN},
+		{StoragePoolName: "pool", NodeName: "node-b", ProviderKind: lapi.LVM_THIN},
+		{StoragePoolName: "pool", NodeName: "node-c", ProviderKind: lapi.LVM_THIN},
+		{StoragePoolName: "pool", NodeName: "node-d", ProviderKind: lapi.LVM_THIN},
+		{StoragePoolName: "other", NodeName: "node-e", ProviderKind: lapi.LVM},
+		{StoragePoolName: "DfltDisklessStorPool", NodeName: "node-e", ProviderKind: lapi.DISKLESS},
+	}
+	resources := []lapi.Resource{
+		{Name: "db-primary", NodeName: "node-a"},
+	}
+
+	var tableTests = []struct {
+		params   map[string]string
+		expected int
+	}{
+		{map[string]string{"storagePool": "pool"}, 3},
+		{map[string]string{}, 4},
+		{map[string]string{"storagePool": "DfltDisklessStorPool"}, 0},
+		{map[string]string{"storagePool": "pool", "doNotPlaceWithRegex": "^db-"}, 2},
+		{map[string]string{"storagePool": "pool", "excludeNodes": "node-b,node-c"}, 1},
+		{map[string]string{"storagePool": "pool", "replicasOnDifferent": "zone"}, 2},
+		{map[string]string{"storagePool": "pool", "replicasOnSame": "zone"}, 2},
+	}
+
+	for _, tt := range tableTests {
+		params, err := volume.NewParameters(tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := eligibleNodeCount(params, nodes, pools, resources)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.expected != actual {
+			t.Errorf("Expected %d eligible nodes for %v, got %d", tt.expected, tt.params, actual)
+		}
+	}
+}
+
+func TestAttachRollback(t *testing.T) {
+	var tableTests = []struct {
+		name       string
+		createFail bool
+		diskState  string
+		notReady   bool
+		errExp     bool
+	}{
+		{name: "device ready", diskState: "Diskless"},
+		{name: "device never appears", diskState: "Negotiating", notReady: true, errExp: true},
+		{name: "assignment fails", createFail: true, errExp: true},
+	}
+
+	for _, tt := range tableTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deleted bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/v1/resource-definitions/pvc-1/resources/node-a":
+					http.NotFound(w, r)
+				case r.Method == http.MethodPost && tt.createFail:
+					w.WriteHeader(http.StatusInternalServerError)
+					w.Write([]byte(`[{"message": "no diskless pool"}]`)) //nolint:errcheck
+				case r.Method == http.MethodGet && r.URL.Path == "/v1/resource-definitions/pvc-1/resources/node-a/volumes":
+					w.Write([]byte(`[{"device_path": "/dev/drbd1000", "state": {"disk_state": "` + tt.diskState + `"}}]`)) //nolint:errcheck
+				case r.Method == http.MethodDelete:
+					deleted = true
+					w.Write([]byte("[]")) //nolint:errcheck
+				default:
+					w.Write([]byte("[]")) //nolint:errcheck
+				}
+			}))
+			defer srv.Close()
+
+			u, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l, err := NewLinstor(APIClient(c), AttachTimeout(100*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = l.Attach(context.Background(), &volume.Info{ID: "pvc-1", Parameters: map[string]string{}}, "node-a")
+			if tt.errExp != (err != nil) {
+				t.Fatalf("Expected error: %t, got %v", tt.errExp, err)
+			}
+			if _, notReady := err.(*DeviceNotReadyError); tt.notReady != notReady {
+				t.Errorf("Expected device not ready: %t, got %v", tt.notReady, err)
+			}
+			if tt.notReady != deleted {
+				t.Errorf("Expected assignment to be rolled back: %t, got %t", tt.notReady, deleted)
+			}
+		})
+	}
+}
+
+func TestVolumeCondition(t *testing.T) {
+	replica := func(node string, state string, flags ...string) lapi.Resource {
+		return lapi.Resource{Name: "pvc-1", NodeName: node, Flags: flags, Volumes: []lapi.Volume{{State: lapi.VolumeState{DiskState: state}}}}
+	}
+	var tableTests = []struct {
+		resources []lapi.Resource
+		desired   int
+		abnormal  bool
+	}{
+		{resources: []lapi.Resource{replica("node-a", "UpToDate"), replica("node-b", "UpToDate"), replica("node-c", "Diskless")}, desired: 2},
+		{resources: []lapi.Resource{replica("node-a", "UpToDate"), replica("node-b", "Inconsistent")}, desired: 2, abnormal: true},
+		{resources: []lapi.Resource{replica("node-a", "UpToDate")}, desired: 2, abnormal: true},
+		{resources: []lapi.Resource{replica("node-a", "UpToDate"), replica("node-b", "UpToDate", apiconst.FlagFailedDeployment)}, desired: 2, abnormal: true},
+		{resources: []lapi.Resource{}, desired: 1, abnormal: true},
+	}
+
+	for _, tt := range tableTests {
+		cond := volumeCondition(tt.resources, tt.desired)
+		if tt.abnormal != cond.Abnormal {
+			t.Errorf("Expected abnormal: %t, got %+v", tt.abnormal, cond)
+		}
+		if cond.Message == "" {
+			t.Errorf("Expected a message, got %+v", cond)
+		}
+	}
+}
+
+func TestPoolVolumeQuota(t *testing.T) {
+	if _, err := NewLinstor(PoolVolumeQuota(map[string]int{"pool": -1})); err == nil {
+		t.Errorf("Expected negative quota to be refused")
+	}
+
+	vols := []*volume.Info{{ID: "pvc-1"}, {ID: "pvc-2"}, {ID: "pvc-3"}}
+	if n := countOthers(vols, "pvc-2"); n != 2 {
+		t.Errorf("Expected retried volume not to count towards the quota, got %d volumes", n)
+	}
+	if n := countOthers(vols, "pvc-4"); n != 3 {
+		t.Errorf("Expected 3 volumes, got %d", n)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/resource-definitions" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "[]")
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLinstor(APIClient(c), PoolVolumeQuota(map[string]int{"pool": 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close() //nolint:errcheck
+
+	vol := &volume.Info{ID: "pvc-5", Parameters: map[string]string{"storagePool": "pool"}}
+	if err := l.checkPoolQuota(context.Background(), vol); err == nil {
+		t.Errorf("Expected volumes to be refused if the volumes in the pool can't be counted")
+	}
+}
+
+func TestDevicePathScheme(t *testing.T) {
+	res := func(paths ...string) lapi.Resource {
+		r := lapi.Resource{Name: "pvc-1", NodeName: "node-a"}
+		for _, p := range paths {
+			r.Volumes = append(r.Volumes, lapi.Volume{DevicePath: p})
+		}
+		return r
+	}
+
+	var tableTests = []struct {
+		resources []lapi.Resource
+		expected  string
+	}{
+		{[]lapi.Resource{res("/dev/drbd1000"), res("/dev/drbd1001", "")}, DevicePathMinor},
+		{[]lapi.Resource{res("/dev/drbd/by-res/pvc-1/0"), res("/dev/drbd/by-res/pvc-2/0", "/dev/drbd1002")}, DevicePathByRes},
+		{[]lapi.Resource{res("/dev/vg/pvc-1_00000"), res("/dev/drbd1000")}, DevicePathMinor},
+		{[]lapi.Resource{res("/dev/vg/pvc-1_00000")}, DevicePathBacking},
+		{[]lapi.Resource{res(""), res()}, ""},
+	}
+
+	for _, tt := range tableTests {
+		if actual := devicePathScheme(tt.resources); tt.expected != actual {
+			t.Errorf("Expected device path scheme %q for %+v, got %q", tt.expected, tt.resources, actual)
+		}
+	}
+}
+
+func TestManagedBy(t *testing.T) {
+	l := &Linstor{log: logrus.NewEntry(logrus.New()), managedBy: DefaultManagedBy}
+	annotation := `{"name": "pvc-1", "id": "pvc-1"}`
+
+	var tableTests = []struct {
+		name  string
+		props map[string]string
+		ours  bool
+	}{
+		{"own marker", map[string]string{linstor.AnnotationsKey: annotation, linstor.ManagedByKey: DefaultManagedBy}, true},
+		{"created before markers", map[string]string{linstor.AnnotationsKey: annotation}, true},
+		{"other marker", map[string]string{linstor.AnnotationsKey: annotation, linstor.ManagedByKey: "other.csi.example.com"}, false},
+	}
+
+	for _, tt := range tableTests {
+		vol, err := l.resourceDefinitionToVolume(lapi.ResourceDefinition{Name: "pvc-1", Props: tt.props})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if tt.ours != (vol != nil) {
+			t.Errorf("%s: expected volume to be ours: %t, got %+v", tt.name, tt.ours, vol)
+		}
+	}
+
+	if _, err := NewLinstor(ManagedBy("")); err == nil {
+		t.Errorf("Expected an empty managed-by marker to be refused")
+	}
+}
+
+func TestControllerChanged(t *testing.T) {
+	l, err := NewLinstor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.nodes = []NodeInfo{{Name: "node-a"}}
+	l.nodesExpiry = time.Now().Add(time.Hour)
+	l.poolTypes = map[string]string{"thin": "Thin"}
+
+	l.ControllerChanged("10.0.0.1:3370", "10.0.0.2:3370")
+	if l.nodes != nil || l.poolTypes != nil {
+		t.Fatalf("Expected caches to be flushed, got nodes %v and pool types %v", l.nodes, l.poolTypes)
+	}
+
+	var ttls []time.Duration
+	for i := 0; i < 7; i++ {
+		ttls = append(ttls, l.nextNodesTTL())
+	}
+	expected := []time.Duration{
+		250 * time.Millisecond, 500 * time.Millisecond, time.Second,
+		2 * time.Second, 4 * time.Second, nodeCacheTTL, nodeCacheTTL,
+	}
+	if !reflect.DeepEqual(expected, ttls) {
+		t.Errorf("Expected node cache TTLs %v, got %v", expected, ttls)
+	}
+}
+
+// failingTransport fails requests while fail is set.
+type failingTransport struct {
+	fail int32
+}
+
+func (f *failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if atomic.LoadInt32(&f.fail) != 0 {
+		return nil, errors.New("connection refused")
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestExpand(t *testing.T) {
+	var tableTests = []struct {
+		name   string
+		params map[string]string
+		inUse  bool
+		// expanded is the expected size of the volume definition in KiB,
+		// zero if it must not change.
+		expanded uint64
+		offline  bool
+		// stuck replicas keep reporting the old size.
+		stuck bool
+	}{
+		{name: "online", params: map[string]string{}, inUse: true, expanded: 2048},
+		{name: "offline while unused", params: map[string]string{"layerList": "nvme storage"}, expanded: 2048},
+		{name: "offline while in use", params: map[string]string{"layerList": "nvme storage"}, inUse: true, offline: true},
+		{name: "replicas not growing", params: map[string]string{}, expanded: 2048, stuck: true},
+	}
+
+	for _, tt := range tableTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var modified uint64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				usable := int64(modified)
+				if tt.stuck {
+					usable = 1024
+				}
+				resources := []lapi.Resource{{Name: "pvc-1", NodeName: "node-a", State: lapi.ResourceState{InUse: tt.inUse},
+					Volumes: []lapi.Volume{{UsableSizeKib: usable}}}}
+				switch {
+				case r.Method == http.MethodPut && r.URL.Path == "/v1/resource-definitions/pvc-1/volume-definitions/0":
+					var vdm lapi.VolumeDefinitionModify
+					json.NewDecoder(r.Body).Decode(&vdm)
+					modified = vdm.SizeKib
+					w.Write([]byte("[]"))
+				case r.URL.Path == "/v1/resource-definitions/pvc-1/volume-definitions":
+					json.NewEncoder(w).Encode([]lapi.VolumeDefinition{{VolumeNumber: 0, SizeKib: 1024}})
+				case r.URL.Path == "/v1/resource-definitions/pvc-1/resources", r.URL.Path == "/v1/view/resources":
+					json.NewEncoder(w).Encode(resources)
+				default:
+					w.Write([]byte("[]"))
+				}
+			}))
+			defer srv.Close()
+
+			u, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l, err := NewLinstor(APIClient(c), CreateTimeout(time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			vol := &volume.Info{ID: "pvc-1", SizeBytes: 1024 * 1024, Parameters: tt.params}
+			err = l.Expand(context.Background(), vol, 2*1024*1024)
+			if _, ok := err.(*OfflineExpandError); ok != tt.offline {
+				t.Fatalf("Expected offline expansion error: %t, got %v", tt.offline, err)
+			}
+			if tt.stuck {
+				if err == nil || !strings.Contains(err.Error(), "timed out") || vol.SizeBytes != 1024*1024 {
+					t.Errorf("Expected expansion to fail until all replicas grew, got %v with size %d", err, vol.SizeBytes)
+				}
+			} else if !tt.offline && err != nil {
+				t.Fatal(err)
+			}
+			if modified != tt.expanded {
+				t.Errorf("Expected volume definition to be resized to %d KiB, got %d", tt.expanded, modified)
+			}
+		})
+	}
+}
+
+func TestExpandFilesystem(t *testing.T) {
+	target, err := ioutil.TempDir("", "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+
+	// The temporary directory's filesystem fills a small device, and can't
+	// fill a huge one without the resize growing it.
+	device, err := ioutil.TempFile("", "device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(device.Name())
+	device.Close()
+	if err := os.Truncate(device.Name(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &fakeMounter{FakeMounter: &mount.FakeMounter{MountPoints: []mount.MountPoint{{Device: device.Name(), Path: target}}}}
+	l := &Linstor{log: logrus.NewEntry(logrus.New()), mounter: m}
+	if err := l.ExpandFilesystem(target); err != nil {
+		t.Errorf("Expected filesystem at %s to be expanded, got %v", target, err)
+	}
+
+	fsBytes, err := filesystemSize(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(device.Name(), 2*fsBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.ExpandFilesystem(target); err == nil {
+		t.Errorf("Expected a resize that didn't grow the filesystem at %s to fail", target)
+	}
+
+	// Block volumes are bind mounted to files.
+	block := filepath.Join(target, "block")
+	if err := ioutil.WriteFile(block, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.ExpandFilesystem(block); err != nil {
+		t.Errorf("Expected nothing to expand for block volume at %s, got %v", block, err)
+	}
+
+	if err := l.ExpandFilesystem(filepath.Join(target, "missing")); err == nil {
+		t.Errorf("Expected expanding a missing target to fail")
+	}
+}
+
+func TestControllerChangeDuringListNodes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]lapi.Node{{Name: "node-a"}})
+	}))
+	defer srv.Close()
+
+	base := &failingTransport{}
+	transport := &HeaderTransport{Base: base}
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := lc.NewHighLevelClient(lapi.BaseURL(u), lapi.HTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLinstor(APIClient(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.OnControllerChange = l.ControllerChanged
+
+	listNodes := func() error {
+		l.nodesMu.Lock()
+		l.nodesExpiry = time.Time{}
+		l.nodesMu.Unlock()
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := l.ListNodes(context.Background())
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("ListNodes did not return, it deadlocked on the controller change")
+			return nil
+		}
+	}
+
+	if err := listNodes(); err != nil {
+		t.Fatal(err)
+	}
+	// The controller becomes unreachable, its next answer is a change.
+	atomic.StoreInt32(&base.fail, 1)
+	if err := listNodes(); err == nil {
+		t.Fatal("Expected listing nodes of an unreachable controller to fail")
+	}
+	atomic.StoreInt32(&base.fail, 0)
+	if err := listNodes(); err != nil {
+		t.Fatal(err)
+	}
+
+	l.nodesMu.Lock()
+	defer l.nodesMu.Unlock()
+	if l.nodes != nil {
+		t.Errorf("Expected the nodes listed before the controller change not to be cached, got %v", l.nodes)
+	}
+}
+
+func TestWipeVolume(t *testing.T) {
+	var tableTests = []struct {
+		name     string
+		local    bool
+		runErr   error
+		requests []string
+		errExp   bool
+	}{
+		{
+			name:     "volume is attached to this node for wiping",
+			requests: []string{"POST /v1/resource-definitions/pvc-1/resources/node-a", "DELETE /v1/resource-definitions/pvc-1/resources/node-a"},
+		},
+		{name: "local resource is used", local: true},
+		{
+			name:     "failed discards are an error",
+			runErr:   errors.New("exit status 1"),
+			requests: []string{"POST /v1/resource-definitions/pvc-1/resources/node-a", "DELETE /v1/resource-definitions/pvc-1/resources/node-a"},
+			errExp:   true,
+		},
+	}
+
+	for _, tt := range tableTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet {
+					requests = append(requests, r.Method+" "+r.URL.Path)
+				}
+				switch r.URL.Path {
+				case "/v1/resource-definitions/pvc-1/resources/node-a":
+					if r.Method == http.MethodGet && !tt.local {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					json.NewEncoder(w).Encode(lapi.Resource{Name: "pvc-1", NodeName: "node-a"}) //nolint:errcheck
+				case "/v1/resource-definitions/pvc-1/resources/node-a/volumes":
+					json.NewEncoder(w).Encode([]lapi.Volume{{State: lapi.VolumeState{DiskState: "Diskless"}}}) //nolint:errcheck
+				case "/v1/resource-definitions/pvc-1/resources/node-a/volumes/0":
+					json.NewEncoder(w).Encode(lapi.Volume{DevicePath: "/dev/drbd1000"}) //nolint:errcheck
+				default:
+					w.Write([]byte("[]")) //nolint:errcheck
+				}
+			}))
+			defer srv.Close()
+
+			u, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+			if err != nil {
+				t.Fatal(err)
+			}
+			mounter := &fakeMounter{runErr: map[string]error{"blkdiscard": tt.runErr}}
+			l, err := NewLinstor(APIClient(c), NodeName("node-a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.mounter = mounter
+
+			err = l.wipeVolume(context.Background(), &volume.Info{ID: "pvc-1"})
+			if tt.errExp != (err != nil) {
+				t.Fatalf("Expected error: %t, got %v", tt.errExp, err)
+			}
+			if !reflect.DeepEqual([]string{"blkdiscard"}, mounter.commands) {
+				t.Errorf("Expected blkdiscard to run, got commands %v", mounter.commands)
+			}
+			if !reflect.DeepEqual(tt.requests, requests) {
+				t.Errorf("Expected requests %v, got %v", tt.requests, requests)
+			}
+		})
+	}
+}
+
+func TestPrepareEncryption(t *testing.T) {
+	var tableTests = []struct {
+		name       string
+		status     int
+		state      string
+		passphrase string
+		entered    bool
+		errExp     bool
+	}{
+		{name: "unlocked controller", status: http.StatusOK, state: "UNLOCKED"},
+		{name: "no master passphrase", status: http.StatusOK, state: "UNSET", passphrase: "secret", errExp: true},
+		{name: "locked without passphrase", status: http.StatusOK, state: "LOCKED", errExp: true},
+		{name: "locked with passphrase", status: http.StatusOK, state: "LOCKED", passphrase: "secret", entered: true},
+		{name: "unknown state without passphrase", status: http.StatusNotFound, errExp: true},
+		{name: "unknown state with passphrase", status: http.StatusNotFound, passphrase: "secret", entered: true},
+		{name: "failed query", status: http.StatusInternalServerError, passphrase: "secret", errExp: true},
+	}
+
+	for _, tt := range tableTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entered bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/encryption/passphrase" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if r.Method == http.MethodPatch {
+					entered = true
+					return
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprintf(w, `{"status": %q}`, tt.state)
+			}))
+			defer srv.Close()
+
+			u, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l, err := NewLinstor(APIClient(c), ControllerAPI(u, "", ""), MasterPassphrase(tt.passphrase))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			vol := &volume.Info{Name: "pvc-1", Parameters: map[string]string{"encryption": "true"}}
+			err = l.prepareEncryption(context.Background(), vol)
+			if tt.errExp != (err != nil) {
+				t.Errorf("Expected error: %t, got %v", tt.errExp, err)
+			}
+			if tt.entered != entered {
+				t.Errorf("Expected master passphrase to be entered: %t, got %t", tt.entered, entered)
+			}
+		})
+	}
+}
+
+func TestRetryMount(t *testing.T) {
+	busy := errors.New("mount failed: exit status 32\nmount: /target: /dev/drbd1000 is busy: Device or resource busy")
+	badFS := errors.New("mount failed: exit status 32\nmount: /target: wrong fs type, bad option, bad superblock on /dev/drbd1000")
+
+	var tableTests = []struct {
+		name    string
+		timeout time.Duration
+		errs    []error
+		// attempts is the expected number of mount calls, zero means fewer
+		// than there are errs.
+		attempts int
+		fail     bool
+	}{
+		{name: "success needs no retries", timeout: time.Second, attempts: 1},
+		{name: "transient errors are retried", timeout: time.Second, errs: []error{busy, busy}, attempts: 3},
+		{name: "permanent errors are not retried", timeout: time.Second, errs: []error{badFS}, attempts: 1, fail: true},
+		{name: "no timeout means no retries", errs: []error{busy}, attempts: 1, fail: true},
+		{name: "retries stop at the timeout", timeout: 5 * time.Millisecond, errs: []error{busy, busy, busy, busy, busy, busy, busy, busy, busy, busy, busy, busy}, fail: true},
+	}
+
+	l, err := NewLinstor()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tableTests {
+		var attempts int
+		err := l.retryMount("pvc-1", tt.timeout, time.Millisecond, func() error {
+			attempts++
+			if attempts <= len(tt.errs) {
+				return tt.errs[attempts-1]
+			}
+			return nil
+		})
+		if tt.fail != (err != nil) {
+			t.Errorf("%s: Expected failure: %t, got %v", tt.name, tt.fail, err)
+		}
+		if tt.attempts == 0 && attempts >= len(tt.errs) || tt.attempts != 0 && attempts != tt.attempts {
+			t.Errorf("%s: Expected %d attempts, got %d", tt.name, tt.attempts, attempts)
+		}
+	}
+}
+
+func TestInTierPool(t *testing.T) {
+	pools := map[string][]string{"ssd": {"node-a", "node-b"}, "nvme": {"node-c"}}
+
+	var tableTests = []struct {
+		pool     string
+		node     string
+		expected bool
+	}{
+		{"", "node-a", true},
+		{"", "node-c", true},
+		{"", "node-d", false},
+		{"ssd", "node-b", true},
+		{"ssd", "node-c", false},
+		{"hdd", "node-a", false},
+	}
+
+	for _, tt := range tableTests {
+		if actual := inTierPool(pools, tt.pool, tt.node); actual != tt.expected {
+			t.Errorf("Expected node %s to have storage pool %q of %v: %t, got %t", tt.node, tt.pool, pools, tt.expected, actual)
+		}
+	}
+}
+
+func TestSnapshotUsage(t *testing.T) {
+	var tableTests = []struct {
+		snap     lapi.Snapshot
+		expected int64
+	}{
+		{lapi.Snapshot{}, 0},
+		{lapi.Snapshot{VolumeDefinitions: []lapi.SnapshotVolumeDefinition{{SizeKib: 4}}}, 4096},
+		{lapi.Snapshot{Nodes: []string{"node-a", "node-b"}, VolumeDefinitions: []lapi.SnapshotVolumeDefinition{{SizeKib: 4}, {SizeKib: 8}}}, 2 * 12 * 1024},
+	}
+
+	for _, tt := range tableTests {
+		actual, estimated := snapshotUsage(tt.snap)
+		if actual != tt.expected || !estimated {
+			t.Errorf("Expected estimated usage of %d bytes for %+v, got %d (estimated: %t)", tt.expected, tt.snap, actual, estimated)
+		}
+	}
+}
+
+func TestUpdateParameters(t *testing.T) {
+	var tableTests = []struct {
+		name    string
+		changes map[string]string
+		props   map[string]string
+		deleted []string
+		errExp  bool
+	}{
+		{
+			name:    "DRBD options are changed",
+			changes: map[string]string{"syncRate": "100M", "diskFlushes": "on"},
+			props: map[string]string{
+				"DrbdOptions/PeerDevice/resync-rate": "102400",
+				"DrbdOptions/Disk/disk-flushes":      "yes",
+				"DrbdOptions/Disk/read-balancing":    "prefer-local",
+			},
+		},
+		{
+			name:    "removed DRBD options are deleted",
+			changes: map[string]string{"readBalancing": ""},
+			props:   map[string]string{},
+			deleted: []string{"DrbdOptions/Disk/read-balancing"},
+		},
+		{name: "unchanged parameters are not written", changes: map[string]string{"storagePool": "thin"}},
+		{name: "storage pool is immutable", changes: map[string]string{"storagePool": "thick"}, errExp: true},
+		{name: "invalid values are refused", changes: map[string]string{"syncRate": "fast"}, errExp: true},
+	}
+
+	for _, tt := range tableTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var modified *lapi.GenericPropsModify
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPut && r.URL.Path == "/v1/resource-definitions/pvc-1" {
+					modified = &lapi.GenericPropsModify{}
+					if err := json.NewDecoder(r.Body).Decode(modified); err != nil {
+						t.Error(err)
+					}
+				}
+				w.Write([]byte("[]")) //nolint:errcheck
+			}))
+			defer srv.Close()
+
+			u, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l, err := NewLinstor(APIClient(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			vol := &volume.Info{ID: "pvc-1", Parameters: map[string]string{"storagePool": "thin", "readBalancing": "prefer-local"}}
+			err = l.UpdateParameters(context.Background(), vol, tt.changes)
+			if tt.errExp != (err != nil) {
+				t.Fatalf("Expected error: %t, got %v", tt.errExp, err)
+			}
+			if tt.props == nil {
+				if modified != nil {
+					t.Errorf("Expected no modification, got %+v", modified)
+				}
+				return
+			}
+			if modified == nil {
+				t.Fatalf("Expected the resource definition to be modified")
+			}
+
+			annotation := modified.OverrideProps[linstor.AnnotationsKey]
+			delete(modified.OverrideProps, linstor.AnnotationsKey)
+			if !reflect.DeepEqual(tt.props, map[string]string(modified.OverrideProps)) || !reflect.DeepEqual(tt.deleted, []string(modified.DeleteProps)) {
+				t.Errorf("Expected props %v and deleted props %v, got %v and %v", tt.props, tt.deleted, modified.OverrideProps, modified.DeleteProps)
+			}
+			var stored volume.Info
+			if err := json.Unmarshal([]byte(annotation), &stored); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(vol.Parameters, stored.Parameters) {
+				t.Errorf("Expected stored parameters %v, got %v", vol.Parameters, stored.Parameters)
+			}
+		})
+	}
+}
+
+func TestMaxAnnotationSize(t *testing.T) {
+	vol := &volume.Info{ID: "pvc-1", Name: "pvc-1", Parameters: map[string]string{"storagePool": "thin"}}
+	serialized, err := json.Marshal(vol)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tableTests = []struct {
+		max    int
+		errExp bool
+	}{
+		{0, false},
+		{len(serialized), false},
+		{len(serialized) - 1, true},
+	}
+
+	for _, tt := range tableTests {
+		l, err := NewLinstor(MaxAnnotationSize(tt.max))
+		if err != nil {
+			t.Fatal(err)
+		}
+		annotation, err := l.serializeVolume(vol)
+		if tt.errExp != (err != nil) {
+			t.Errorf("Expected error for a maximum of %d bytes: %t, got %v", tt.max, tt.errExp, err)
+		}
+		if !tt.errExp && annotation != string(serialized) {
+			t.Errorf("Expected annotation %s, got %s", serialized, annotation)
+		}
+	}
+
+	if _, err := NewLinstor(MaxAnnotationSize(-1)); err == nil {
+		t.Errorf("Expected a negative maximum annotation size to be refused")
+	}
+}
+
+func TestAssignmentsOnNode(t *testing.T) {
+	vols := []*volume.Info{{ID: "pvc-1"}, {ID: "pvc-2"}, {ID: "pvc-3"}}
+	resources := []lapi.Resource{
+		{Name: "pvc-1", NodeName: "node-a", Volumes: []lapi.Volume{{DevicePath: "/dev/drbd1000"}}},
+		{Name: "pvc-2", NodeName: "node-a", Flags: []string{apiconst.FlagDiskless}, Volumes: []lapi.Volume{{DevicePath: "/dev/drbd1001"}}},
+		{Name: "pvc-3", NodeName: "node-b", Volumes: []lapi.Volume{{DevicePath: "/dev/drbd1002"}}},
+		{Name: "unmanaged", NodeName: "node-a"},
+	}
+
+	actual := assignmentsOnNode(vols, resources, "node-a")
+	expected := []*volume.Assignment{
+		{Vol: vols[0], Node: "node-a", Path: "/dev/drbd1000"},
+		{Vol: vols[1], Node: "node-a", Path: "/dev/drbd1001", Diskless: true},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected assignments %+v, got %+v", expected, actual)
+	}
+}
+
+func TestReplicaUsage(t *testing.T) {
+	resources := []lapi.Resource{
+		{Name: "pvc-1", NodeName: "node-a", Volumes: []lapi.Volume{{AllocatedSizeKib: 1024}, {AllocatedSizeKib: 512}}},
+		{Name: "pvc-1", NodeName: "node-b", Volumes: []lapi.Volume{{AllocatedSizeKib: 8192}}},
+		{Name: "pvc-1", NodeName: "node-c", Flags: []string{apiconst.FlagDiskless}, Volumes: []lapi.Volume{{}}},
+		{Name: "pvc-2", NodeName: "node-a", Volumes: []lapi.Volume{{AllocatedSizeKib: 4096}}},
+	}
+
+	actual := replicaUsage(resources, "pvc-1")
+	expected := map[string]int64{"node-a": 1536 << 10, "node-b": 8192 << 10}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected usage %v, got %v", expected, actual)
+	}
+}
+
+func TestSnapshotMounts(t *testing.T) {
+	target := "/var/lib/kubelet/plugins/snap"
+	resDefs := []lapi.ResourceDefinition{
+		{Name: "csi-1", Props: map[string]string{linstor.SnapshotMountKey: target, linstor.SnapshotMountNodeKey: "node-a"}},
+		{Name: "csi-2", Props: map[string]string{linstor.SnapshotMountKey: target, linstor.SnapshotMountNodeKey: "node-b"}},
+		{Name: "csi-3", Props: map[string]string{linstor.SnapshotMountKey: "/other", linstor.SnapshotMountNodeKey: "node-a"}},
+		{Name: "pvc-1"},
+	}
+
+	actual := snapshotMounts(resDefs, "node-a", target)
+	expected := []string{"csi-1"}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected snapshot mounts %v, got %v", expected, actual)
+	}
+}
+
+func TestPlacementError(t *testing.T) {
+	pools := []lapi.StoragePool{
+		{StoragePoolName: "ssd", NodeName: "node-a", ProviderKind: lapi.LVM_THIN},
+		{StoragePoolName: "hdd", NodeName: "node-b", ProviderKind: lapi.LVM},
+		{StoragePoolName: volume.DefaultDisklessStoragePoolName, NodeName: "node-c", ProviderKind: lapi.DISKLESS},
+	}
+
+	var tableTests = []struct {
+		placement string
+		errExp    bool
+	}{
+		{`{"diskful": {"node-a": "ssd", "node-b": "hdd"}, "diskless": ["node-c"]}`, false},
+		{`{"diskful": {"node-a": "", "node-b": ""}}`, false},
+		{`{"diskful": {"node-a": "hdd"}}`, true},
+		{`{"diskful": {"node-d": "ssd"}}`, true},
+		{`{"diskful": {"node-c": ""}}`, true},
+		{`{"diskful": {"node-a": "ssd"}, "diskless": ["node-b"]}`, true},
+	}
+
+	for _, tt := range tableTests {
+		params, err := volume.NewParameters(map[string]string{"placement": tt.placement})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := placementError(params, pools); tt.errExp != (err != nil) {
+			t.Errorf("Expected error for placement %s: %t, got %v", tt.placement, tt.errExp, err)
+		}
+	}
+}
+
+func TestReapable(t *testing.T) {
+	now := time.Now()
+	recently := now.Add(-time.Minute)
+	longAgo := now.Add(-time.Hour)
+	vols := []*volume.Info{
+		{ID: "pvc-1", Deleted: true, DeletionTime: &longAgo},
+		{ID: "pvc-2", Deleted: true, DeletionTime: &recently},
+		{ID: "pvc-3", Deleted: true},
+		{ID: "pvc-4", DeletionTime: &longAgo},
+	}
+
+	actual := reapable(vols, 10*time.Minute, now)
+	expected := []*volume.Info{vols[0]}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected reapable volumes %+v, got %+v", expected, actual)
+	}
+}
+
+func TestRestorable(t *testing.T) {
+	deleted := []*volume.Info{
+		{ID: "pvc-1", Name: "pvc-1", SizeBytes: 1 << 30, Parameters: map[string]string{"storagePool": "ssd"}},
+		{ID: "pvc-2", Name: "pvc-2", SizeBytes: 1 << 30},
+	}
+
+	var tableTests = []struct {
+		vol      *volume.Info
+		expected *volume.Info
+		errExp   bool
+	}{
+		{&volume.Info{Name: "pvc-1", SizeBytes: 1 << 30, Parameters: map[string]string{"storagePool": "ssd"}}, deleted[0], false},
+		{&volume.Info{Name: "pvc-1", SizeBytes: 1 << 30, Parameters: map[string]string{"storagepool": "ssd", "csi.storage.k8s.io/pvc/name": "data"}}, deleted[0], false},
+		{&volume.Info{Name: "pvc-1", SizeBytes: 1 << 30, Parameters: map[string]string{"storagePool": "hdd"}}, nil, true},
+		{&volume.Info{Name: "pvc-2", SizeBytes: 2 << 30}, nil, true},
+		{&volume.Info{Name: "pvc-3", SizeBytes: 1 << 30}, nil, false},
+	}
+
+	for _, tt := range tableTests {
+		actual, err := restorable(deleted, tt.vol)
+		if tt.errExp != (err != nil) {
+			t.Errorf("Expected error restoring %+v: %t, got %v", tt.vol, tt.errExp, err)
+		}
+		if tt.expected != actual {
+			t.Errorf("Expected to restore %+v for %+v, got %+v", tt.expected, tt.vol, actual)
+		}
+	}
+}
+
+func TestMinorNumberConflict(t *testing.T) {
+	var tableTests = []struct {
+		err      error
+		expected bool
+	}{
+		{errors.New("Message: 'The specified minor number '1000' is already in use.'"), true},
+		{errors.New("Message: 'Invalid minor number: 1048576'"), true},
+		{errors.New("Message: 'Not enough free space in storage pool'"), false},
+		{lapi.NotFoundError, false},
+	}
+
+	for _, tt := range tableTests {
+		if actual := minorNumberConflict(tt.err); actual != tt.expected {
+			t.Errorf("Expected %q to be a minor number conflict: %t, got %t", tt.err, tt.expected, actual)
+		}
+	}
+}
+
+func TestCheckGrown(t *testing.T) {
+	const gib = 1 << 30
+	var tableTests = []struct {
+		before, after, deviceBytes int64
+		errExp                     bool
+	}{
+		{gib, 2 * gib, 2 * gib, false},
+		{gib, 2*gib - 100<<20, 2 * gib, false},
+		{2*gib - 100<<20, 2*gib - 100<<20, 2 * gib, false},
+		{gib, gib, 2 * gib, true},
+	}
+
+	for _, tt := range tableTests {
+		if err := checkGrown(tt.before, tt.after, tt.deviceBytes); tt.errExp != (err != nil) {
+			t.Errorf("Expected error for a filesystem grown from %d to %d bytes on a device of %d: %t, got %v", tt.before, tt.after, tt.deviceBytes, tt.errExp, err)
+		}
+	}
+
+	if size, err := filesystemSize(os.TempDir()); err != nil || size <= 0 {
+		t.Errorf("Expected the size of the filesystem of %s, got %d, %v", os.TempDir(), size, err)
+	}
+
+	f, err := ioutil.TempFile("", "device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if err := f.Truncate(4096); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if size, err := deviceSize(f.Name()); err != nil || size != 4096 {
+		t.Errorf("Expected a size of 4096 bytes for %s, got %d, %v", f.Name(), size, err)
+	}
+}
+
+func TestCheckDevice(t *testing.T) {
+	f, err := ioutil.TempFile("", "device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	l := &Linstor{log: logrus.NewEntry(logrus.New())}
+	for _, source := range []string{f.Name(), f.Name() + "-missing", "/dev/null"} {
+		if err := l.CheckDevice(source); err == nil {
+			t.Errorf("Expected %s not to be a block device", source)
+		}
+	}
+
+	if _, err := os.Stat("/dev/loop0"); err != nil {
+		t.Skipf("no block device to check: %v", err)
+	}
+	if err := l.CheckDevice("/dev/loop0"); err != nil {
+		t.Errorf("Expected /dev/loop0 to be a block device, got %v", err)
+	}
+}
+
+func TestNameSanitizer(t *testing.T) {
+	sanitize := func(name string) (string, error) {
+		if name == "" {
+			return "", errors.New("empty name")
+		}
+		return "team-" + name, nil
+	}
+	l, err := NewLinstor(NameSanitizer(sanitize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tableTests = []struct {
+		in       string
+		expected string
+		errExp   bool
+	}{
+		{"pvc-1", "team-pvc-1", false},
+		{"", "", true},
+		// The result must still be a valid LINSTOR name.
+		{"pvc.1", "", true},
+	}
+
+	for _, tt := range tableTests {
+		actual, err := l.sanitizeName(tt.in)
+		if tt.errExp != (err != nil) {
+			t.Errorf("Expected error for %q: %t, got %v", tt.in, tt.errExp, err)
+		}
+		if actual != tt.expected {
+			t.Errorf("Expected %q to be sanitized to %q, got %q", tt.in, tt.expected, actual)
+		}
+	}
+
+	name, err := templateResourceName(nil, &volume.Info{Name: "pvc-1"}, l.sanitizeName)
+	if err != nil || name != "team-pvc-1" {
+		t.Errorf("Expected resource name team-pvc-1 without a template, got %q, %v", name, err)
+	}
+}
+
+func TestMakeDiskfulTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/resources/node-a"):
+			fmt.Fprint(w, `{"name": "pvc-1", "node_name": "node-a", "flags": ["DISKLESS"]}`)
+		case strings.HasSuffix(r.URL.Path, "/volumes"):
+			// The new replica never finishes its resync.
+			fmt.Fprint(w, `[{"volume_number": 0, "state": {"disk_state": "Inconsistent"}}]`)
+		default:
+			fmt.Fprint(w, "[]")
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLinstor(APIClient(c), AttachTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close() //nolint:errcheck
+
+	vol := &volume.Info{ID: "pvc-1", Parameters: map[string]string{}}
+	err = l.MakeDiskful(context.Background(), vol, "node-a", "")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected MakeDiskful to time out waiting for the resync, got %v", err)
+	}
+}
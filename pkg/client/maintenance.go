@@ -0,0 +1,109 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MaintenanceError is returned by operations that change volumes, snapshots,
+// or assignments while the client is in maintenance mode.
+type MaintenanceError struct{}
+
+func (e *MaintenanceError) Error() string {
+	return "maintenance in progress, retry later"
+}
+
+// Temporary reports that the operation may succeed when retried later.
+func (e *MaintenanceError) Temporary() bool {
+	return true
+}
+
+// SetMaintenance turns maintenance mode on or off. While it is on, creating,
+// deleting, attaching, and detaching volumes and snapshots fails with a
+// MaintenanceError before anything is changed. Lookups keep working.
+func (s *Linstor) SetMaintenance(on bool) {
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+
+	if s.maintenance != on {
+		s.log.WithField("maintenance", on).Info("changing maintenance mode")
+	}
+	s.maintenance = on
+}
+
+// InMaintenance returns true while maintenance mode is on.
+func (s *Linstor) InMaintenance() bool {
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+
+	return s.maintenance
+}
+
+func (s *Linstor) checkMaintenance() error {
+	if s.InMaintenance() {
+		return &MaintenanceError{}
+	}
+	return nil
+}
+
+// MaintenanceHandler is a http.Handler to query maintenance mode with GET and
+// change it with PUT, e.g. PUT /maintenance?on=true. It responds with the
+// current mode, "true" or "false".
+func (s *Linstor) MaintenanceHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			on, err := strconv.ParseBool(r.URL.Query().Get("on"))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid on %q, must be a boolean", r.URL.Query().Get("on")), http.StatusBadRequest)
+				return
+			}
+			s.SetMaintenance(on)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fmt.Fprintln(w, s.InMaintenance())
+	})
+}
+
+// RequireToken wraps h so that only requests authorized with the bearer
+// token are served. With an empty token, all requests are served.
+func RequireToken(h http.Handler, token string) http.Handler {
+	if token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
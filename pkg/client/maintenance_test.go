@@ -0,0 +1,143 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LINBIT/linstor-csi/pkg/volume"
+)
+
+func TestMaintenance(t *testing.T) {
+	l, err := NewLinstor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetMaintenance(true)
+
+	vol := &volume.Info{Name: "pvc-1", ID: "pvc-1", Parameters: map[string]string{}}
+	if err := l.Create(context.Background(), vol, nil); err == nil {
+		t.Errorf("Expected Create to be refused during maintenance")
+	} else if _, ok := err.(*MaintenanceError); !ok {
+		t.Errorf("Expected a MaintenanceError, got %v", err)
+	}
+	if err := l.Attach(context.Background(), vol, "node-a"); err == nil {
+		t.Errorf("Expected Attach to be refused during maintenance")
+	}
+	if err := l.MakeDiskful(context.Background(), vol, "node-a", ""); err == nil {
+		t.Errorf("Expected MakeDiskful to be refused during maintenance")
+	}
+	if err := l.MakeDiskless(context.Background(), vol, "node-a"); err == nil {
+		t.Errorf("Expected MakeDiskless to be refused during maintenance")
+	}
+	if err := l.Expand(context.Background(), vol, 1<<30); err == nil {
+		t.Errorf("Expected Expand to be refused during maintenance")
+	}
+
+	l.SetMaintenance(false)
+	if err := l.checkMaintenance(); err != nil {
+		t.Errorf("Expected operations to be accepted after maintenance, got %v", err)
+	}
+}
+
+func TestMaintenanceHandler(t *testing.T) {
+	l, err := NewLinstor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(l.MaintenanceHandler())
+	defer srv.Close()
+
+	var tableTests = []struct {
+		method   string
+		query    string
+		status   int
+		expected string
+	}{
+		{http.MethodGet, "", http.StatusOK, "false"},
+		{http.MethodPut, "?on=true", http.StatusOK, "true"},
+		{http.MethodGet, "", http.StatusOK, "true"},
+		{http.MethodPut, "?on=maybe", http.StatusBadRequest, ""},
+		{http.MethodPost, "?on=false", http.StatusMethodNotAllowed, ""},
+		{http.MethodPut, "?on=false", http.StatusOK, "false"},
+	}
+
+	for _, tt := range tableTests {
+		req, err := http.NewRequest(tt.method, srv.URL+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.status != resp.StatusCode {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.query, tt.status, resp.StatusCode)
+		}
+		if tt.expected != "" && tt.expected != strings.TrimSpace(string(body)) {
+			t.Errorf("%s %s: expected %q, got %q", tt.method, tt.query, tt.expected, body)
+		}
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(RequireToken(ok, "s3cret"))
+	defer srv.Close()
+
+	var tableTests = []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic s3cret", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	}
+
+	for _, tt := range tableTests {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if tt.status != resp.StatusCode {
+			t.Errorf("Authorization %q: expected status %d, got %d", tt.auth, tt.status, resp.StatusCode)
+		}
+	}
+}
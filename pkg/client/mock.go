@@ -202,9 +202,25 @@ func (s *MockStorage) CapacityBytes(ctx context.Context, params map[string]strin
 	return 50000000, nil
 }
 
-func (s *MockStorage) Mount(vol *volume.Info, source, target, fsType string, options []string) error {
-	return nil
+func (s *MockStorage) Mount(vol *volume.Info, source, target, fsType string, options []string) (volume.MountResult, error) {
+	return volume.MountResult{FSType: fsType}, nil
+}
+func (s *MockStorage) MountWithSecrets(vol *volume.Info, source, target, fsType string, options []string, secrets map[string]string) (volume.MountResult, error) {
+	return s.Mount(vol, source, target, fsType, options)
 }
 func (s *MockStorage) Unmount(target string) error {
 	return nil
 }
+func (s *MockStorage) ExpandFilesystem(target string) error {
+	return nil
+}
+func (s *MockStorage) CheckDevice(source string) error {
+	return nil
+}
+
+func (s *MockStorage) Expand(ctx context.Context, vol *volume.Info, sizeBytes int64) error {
+	if sizeBytes > vol.SizeBytes {
+		vol.SizeBytes = sizeBytes
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+// diskMounter formats, mounts, and unmounts devices on the local node. The
+// default is the OS backed safeMounter, tests replace it with a fake so the
+// mount logic can be exercised without real devices.
+type diskMounter interface {
+	mount.Interface
+	mount.Exec
+	// FormatAndMount mounts source, formatting it with fstype first if it has
+	// no filesystem yet.
+	FormatAndMount(source, target, fstype string, options []string) error
+	// GetDiskFormat returns the filesystem on disk, or "" if it is unformatted.
+	GetDiskFormat(disk string) (string, error)
+	// Resize grows the filesystem on devicePath mounted at deviceMountPath to
+	// the size of the device.
+	Resize(devicePath, deviceMountPath string) (bool, error)
+	// RunContext is like Run, but kills the command when ctx is done.
+	RunContext(ctx context.Context, cmd string, args ...string) ([]byte, error)
+}
+
+// safeMounter is a diskMounter using the OS's mount and filesystem tools.
+type safeMounter struct {
+	*mount.SafeFormatAndMount
+}
+
+func newSafeMounter() safeMounter {
+	return safeMounter{&mount.SafeFormatAndMount{
+		Interface: mount.New("/bin/mount"),
+		Exec:      mount.NewOsExec(),
+	}}
+}
+
+// RunContext runs cmd, killing it when ctx is done.
+func (m safeMounter) RunContext(ctx context.Context, cmd string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, cmd, args...).CombinedOutput()
+	if ctx.Err() != nil {
+		return out, fmt.Errorf("%s canceled: %v", cmd, ctx.Err())
+	}
+	return out, err
+}
+
+// GetDiskFormat returns the filesystem on disk, or "" if it has none. Minimal
+// node images may lack blkid, so lsblk and finally the superblock of disk are
+// consulted if blkid fails or does not recognize a filesystem.
+func (m safeMounter) GetDiskFormat(disk string) (string, error) {
+	return detectFilesystem(disk, m.SafeFormatAndMount.GetDiskFormat, m.lsblkFilesystem, deviceFilesystem)
+}
+
+// Exit codes of fsck -a.
+const (
+	fsckErrorsCorrected   = 1
+	fsckErrorsUncorrected = 4
+)
+
+// FormatAndMount mounts source to target, creating a fstype filesystem on it
+// first if it has none. It works like the embedded SafeFormatAndMount, but
+// looks for an existing filesystem with GetDiskFormat, so that devices are not
+// reformatted or refused on nodes without blkid.
+func (m safeMounter) FormatAndMount(source, target, fstype string, options []string) error {
+	readOnly := containsOpt(options, "ro")
+	options = append(options, "defaults")
+
+	if !readOnly {
+		// Repair what fsck can repair on its own, like the embedded
+		// SafeFormatAndMount.
+		out, err := m.Run("fsck", "-a", source)
+		if code, ok := exitStatus(err); ok && code == fsckErrorsUncorrected {
+			return fmt.Errorf("fsck found errors on device %s but could not correct them: %q", source, out)
+		}
+	}
+
+	mountErr := m.Mount(source, target, fstype, options)
+	if mountErr == nil {
+		return nil
+	}
+
+	// The mount failed, either because source is unformatted or because it
+	// holds an unexpected filesystem.
+	existing, err := m.GetDiskFormat(source)
+	if err != nil {
+		return fmt.Errorf("unable to mount %s: %v, %v", source, mountErr, err)
+	}
+	if existing != "" {
+		if fstype == "" || fstype == existing {
+			return mountErr
+		}
+		return fmt.Errorf("failed to mount the volume as %q, it already contains %s. Mount error: %v", fstype, existing, mountErr)
+	}
+	if readOnly {
+		return fmt.Errorf("failed to mount unformatted volume %s as read only: %v", source, mountErr)
+	}
+
+	if fstype == "" {
+		fstype = "ext4"
+	}
+	args := []string{source}
+	if fstype == "ext4" || fstype == "ext3" {
+		args = []string{"-F", "-m0", source}
+	}
+	if out, err := m.Run("mkfs."+fstype, args...); err != nil {
+		return fmt.Errorf("couldn't create %s filesystem on %s: %v: %q", fstype, source, err, out)
+	}
+
+	return m.Mount(source, target, fstype, options)
+}
+
+// Resize grows the filesystem on devicePath mounted at deviceMountPath. It
+// reports false if there is no filesystem to grow.
+func (m safeMounter) Resize(devicePath, deviceMountPath string) (bool, error) {
+	format, err := m.GetDiskFormat(devicePath)
+	if err != nil {
+		return false, fmt.Errorf("unable to determine filesystem of %s: %v", devicePath, err)
+	}
+
+	var out []byte
+	switch format {
+	case "":
+		// mkfs uses the whole device, there is nothing to grow.
+		return false, nil
+	case "ext3", "ext4":
+		out, err = m.Run("resize2fs", devicePath)
+	case "xfs":
+		out, err = m.Run("xfs_growfs", "-d", deviceMountPath)
+	default:
+		return false, fmt.Errorf("resizing %s filesystems is not supported, device %s mounted at %s", format, devicePath, deviceMountPath)
+	}
+	if err != nil {
+		return false, fmt.Errorf("resizing %s failed: %v: %q", devicePath, err, out)
+	}
+	return true, nil
+}
+
+// lsblkFilesystem returns the filesystem of disk as reported by lsblk.
+func (m safeMounter) lsblkFilesystem(disk string) (string, error) {
+	out, err := m.Run("lsblk", "-n", "-o", "FSTYPE", disk)
+	if err != nil {
+		return "", fmt.Errorf("lsblk failed: %v: %q", err, out)
+	}
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0]), nil
+}
+
+// detectFilesystem returns the first filesystem of disk found by detectors,
+// which are tried in order. The result is "" without an error if a detector
+// succeeded, but none found a filesystem. It is an error if all of them
+// failed.
+func detectFilesystem(disk string, detectors ...func(string) (string, error)) (string, error) {
+	var errs []string
+	for _, detect := range detectors {
+		fs, err := detect(disk)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if fs != "" {
+			return fs, nil
+		}
+	}
+
+	if len(errs) == len(detectors) {
+		return "", fmt.Errorf("unable to detect filesystem of %s: %s", disk, strings.Join(errs, "; "))
+	}
+	return "", nil
+}
+
+// deviceFilesystem returns the filesystem of disk, as determined by its
+// superblock.
+func deviceFilesystem(disk string) (string, error) {
+	f, err := os.Open(disk)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return superblockFilesystem(f)
+}
+
+// Superblock locations and flags of the filesystems recognized by
+// superblockFilesystem.
+const (
+	extSuperblockOffset = 1024
+	extMagic            = 0xEF53
+	// Features of ext superblocks.
+	extCompatHasJournal = 0x4
+	extIncompatExtents  = 0x40
+	extIncompat64Bit    = 0x80
+	extIncompatFlexBG   = 0x200
+
+	btrfsMagicOffset = 0x10040
+	btrfsMagic       = "_BHRfS_M"
+
+	xfsMagic = "XFSB"
+)
+
+// superblockFilesystem recognizes xfs, btrfs, and ext filesystems by their
+// superblocks in r. It returns "" if r holds none of them.
+func superblockFilesystem(r io.ReaderAt) (string, error) {
+	magic := make([]byte, len(xfsMagic))
+	if ok, err := readAt(r, magic, 0); err != nil || !ok {
+		return "", err
+	}
+	if string(magic) == xfsMagic {
+		return "xfs", nil
+	}
+
+	ext := make([]byte, 0x68)
+	ok, err := readAt(r, ext, extSuperblockOffset)
+	if err != nil {
+		return "", err
+	}
+	if ok && binary.LittleEndian.Uint16(ext[0x38:]) == extMagic {
+		compat := binary.LittleEndian.Uint32(ext[0x5C:])
+		incompat := binary.LittleEndian.Uint32(ext[0x60:])
+		switch {
+		case incompat&(extIncompatExtents|extIncompat64Bit|extIncompatFlexBG) != 0:
+			return "ext4", nil
+		case compat&extCompatHasJournal != 0:
+			return "ext3", nil
+		default:
+			return "ext2", nil
+		}
+	}
+
+	btrfs := make([]byte, len(btrfsMagic))
+	ok, err = readAt(r, btrfs, btrfsMagicOffset)
+	if err != nil {
+		return "", err
+	}
+	if ok && bytes.Equal(btrfs, []byte(btrfsMagic)) {
+		return "btrfs", nil
+	}
+
+	return "", nil
+}
+
+// readAt fills buf from r at off. It returns false if r ends before.
+func readAt(r io.ReaderAt, buf []byte, off int64) (bool, error) {
+	_, err := r.ReadAt(buf, off)
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,164 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+func TestDetectFilesystem(t *testing.T) {
+	found := func(fs string) func(string) (string, error) {
+		return func(string) (string, error) { return fs, nil }
+	}
+	failed := func(string) (string, error) { return "", errors.New("executable file not found") }
+
+	var tableTests = []struct {
+		name      string
+		detectors []func(string) (string, error)
+		expected  string
+		errExp    bool
+	}{
+		{name: "first detector wins", detectors: []func(string) (string, error){found("xfs"), found("ext4")}, expected: "xfs"},
+		{name: "failing detector falls back", detectors: []func(string) (string, error){failed, found("ext4")}, expected: "ext4"},
+		{name: "empty result falls back", detectors: []func(string) (string, error){found(""), found("btrfs")}, expected: "btrfs"},
+		{name: "no filesystem", detectors: []func(string) (string, error){failed, found("")}},
+		{name: "all detectors fail", detectors: []func(string) (string, error){failed, failed}, errExp: true},
+	}
+
+	for _, tt := range tableTests {
+		fs, err := detectFilesystem("/dev/drbd1000", tt.detectors...)
+		if tt.errExp != (err != nil) {
+			t.Errorf("%s: expected error: %t, got %v", tt.name, tt.errExp, err)
+		}
+		if tt.expected != fs {
+			t.Errorf("%s: expected filesystem %q, got %q", tt.name, tt.expected, fs)
+		}
+	}
+}
+
+func TestSuperblockFilesystem(t *testing.T) {
+	ext := func(compat, incompat uint32) []byte {
+		img := make([]byte, 4096)
+		binary.LittleEndian.PutUint16(img[extSuperblockOffset+0x38:], extMagic)
+		binary.LittleEndian.PutUint32(img[extSuperblockOffset+0x5C:], compat)
+		binary.LittleEndian.PutUint32(img[extSuperblockOffset+0x60:], incompat)
+		return img
+	}
+	xfs := append([]byte(xfsMagic), make([]byte, 4092)...)
+	btrfs := make([]byte, btrfsMagicOffset+4096)
+	copy(btrfs[btrfsMagicOffset:], btrfsMagic)
+
+	var tableTests = []struct {
+		name     string
+		img      []byte
+		expected string
+	}{
+		{"xfs", xfs, "xfs"},
+		{"ext4", ext(extCompatHasJournal, extIncompatExtents|extIncompatFlexBG), "ext4"},
+		{"ext3", ext(extCompatHasJournal, 0), "ext3"},
+		{"ext2", ext(0, 0), "ext2"},
+		{"btrfs", btrfs, "btrfs"},
+		{"zeroed device", make([]byte, btrfsMagicOffset+4096), ""},
+		{"tiny device", make([]byte, 2), ""},
+	}
+
+	for _, tt := range tableTests {
+		fs, err := superblockFilesystem(bytes.NewReader(tt.img))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if tt.expected != fs {
+			t.Errorf("%s: expected filesystem %q, got %q", tt.name, tt.expected, fs)
+		}
+	}
+}
+
+// unformattedMounter fails to mount devices until they were formatted.
+type unformattedMounter struct {
+	*mount.FakeMounter
+	formatted bool
+}
+
+func (m *unformattedMounter) Mount(source, target, fstype string, options []string) error {
+	if !m.formatted {
+		return errors.New("wrong fs type")
+	}
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func TestSafeMounterFormatAndMount(t *testing.T) {
+	ext4 := make([]byte, 4096)
+	binary.LittleEndian.PutUint16(ext4[extSuperblockOffset+0x38:], extMagic)
+	binary.LittleEndian.PutUint32(ext4[extSuperblockOffset+0x60:], extIncompatExtents)
+
+	var tableTests = []struct {
+		name     string
+		img      []byte
+		fsType   string
+		commands []string
+		errExp   bool
+	}{
+		{name: "unformatted", img: make([]byte, 4096), fsType: "xfs", commands: []string{"fsck", "blkid", "lsblk", "mkfs.xfs"}},
+		{name: "other filesystem", img: ext4, fsType: "xfs", commands: []string{"fsck", "blkid", "lsblk"}, errExp: true},
+	}
+
+	for _, tt := range tableTests {
+		f, err := ioutil.TempFile("", "device")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write(tt.img); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		mounter := &unformattedMounter{FakeMounter: &mount.FakeMounter{}}
+		var commands []string
+		// Neither blkid nor lsblk are available, only the superblock tells.
+		exec := mount.NewFakeExec(func(cmd string, args ...string) ([]byte, error) {
+			commands = append(commands, cmd)
+			if cmd == "mkfs."+tt.fsType {
+				mounter.formatted = true
+				return nil, nil
+			}
+			return nil, errors.New("executable file not found")
+		})
+		m := safeMounter{&mount.SafeFormatAndMount{Interface: mounter, Exec: exec}}
+
+		err = m.FormatAndMount(f.Name(), "/mnt/target", tt.fsType, nil)
+		if tt.errExp != (err != nil) {
+			t.Errorf("%s: expected error: %t, got %v", tt.name, tt.errExp, err)
+		}
+		if !reflect.DeepEqual(tt.commands, commands) {
+			t.Errorf("%s: expected commands %v, got %v", tt.name, tt.commands, commands)
+		}
+		if mounted := len(mounter.MountPoints) == 1; mounted == tt.errExp {
+			t.Errorf("%s: expected mounted: %t, got mount points %v", tt.name, !tt.errExp, mounter.MountPoints)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+// Batching of exported spans.
+const (
+	otlpExportInterval = 5 * time.Second
+	otlpBatchSize      = 512
+	// Spans that arrive while this many are waiting for export are dropped.
+	otlpQueueSize = 2048
+)
+
+// DefaultOTLPServiceName is the service.name the spans of the plugin are
+// exported with.
+const DefaultOTLPServiceName = "linstor-csi"
+
+// OTLPTracer is a Tracer that exports spans to an OpenTelemetry collector,
+// using the JSON encoding of the OTLP/HTTP protocol. Spans continue the W3C
+// trace context of the gRPC request in ctx, if it carries one.
+type OTLPTracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+	log      *logrus.Entry
+
+	mu      sync.Mutex
+	queue   []otlpSpan
+	dropped int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewOTLPTracer returns a Tracer exporting spans to endpoint, the URL of the
+// collector's traces receiver, e.g. http://otel-collector:4318/v1/traces.
+// headers are sent along with each export. Spans are exported in batches in
+// the background until Shutdown is called.
+func NewOTLPTracer(endpoint, service string, headers map[string]string, log *logrus.Entry) *OTLPTracer {
+	if service == "" {
+		service = DefaultOTLPServiceName
+	}
+	t := &OTLPTracer{
+		endpoint: endpoint,
+		headers:  headers,
+		service:  service,
+		client:   &http.Client{Timeout: otlpExportInterval},
+		log:      log,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// OTLPTracesEndpoint returns the traces receiver of the collector at endpoint,
+// the base URL in OTEL_EXPORTER_OTLP_ENDPOINT.
+func OTLPTracesEndpoint(endpoint string) string {
+	if endpoint == "" {
+		return ""
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+}
+
+// Start begins a span called name. Its parent is the span in ctx, or the
+// traceparent of the incoming gRPC request.
+func (t *OTLPTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, ok := ctx.Value(otlpSpanKey{}).(otlpSpanContext)
+	if !ok {
+		parent, _ = incomingTraceParent(ctx)
+	}
+
+	sc := otlpSpanContext{traceID: parent.traceID, spanID: randomHex(8)}
+	if sc.traceID == "" {
+		sc.traceID = randomHex(16)
+	}
+
+	span := &otlpActiveSpan{
+		tracer: t,
+		span: otlpSpan{
+			TraceID:           sc.traceID,
+			SpanID:            sc.spanID,
+			ParentSpanID:      parent.spanID,
+			Name:              name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(time.Now().UnixNano(), 10),
+		},
+	}
+	return context.WithValue(ctx, otlpSpanKey{}, sc), span
+}
+
+// Shutdown exports the spans that are still queued and stops the background
+// export.
+func (t *OTLPTracer) Shutdown(ctx context.Context) error {
+	close(t.stop)
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.flush(ctx)
+}
+
+func (t *OTLPTracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(otlpExportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), otlpExportInterval)
+		if err := t.flush(ctx); err != nil {
+			t.log.WithError(err).Warn("unable to export spans")
+		}
+		cancel()
+	}
+}
+
+// enqueue queues span for export, dropping it if the queue is full.
+func (t *OTLPTracer) enqueue(span otlpSpan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.queue) >= otlpQueueSize {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, span)
+}
+
+// flush exports all queued spans, in batches of at most otlpBatchSize.
+func (t *OTLPTracer) flush(ctx context.Context) error {
+	t.mu.Lock()
+	spans := t.queue
+	dropped := t.dropped
+	t.queue, t.dropped = nil, 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		t.log.WithField("spans", dropped).Warn("dropped spans, the export queue was full")
+	}
+
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > otlpBatchSize {
+			n = otlpBatchSize
+		}
+		if err := t.export(ctx, spans[:n]); err != nil {
+			return fmt.Errorf("dropped %d spans: %v", len(spans), err)
+		}
+		spans = spans[n:]
+	}
+	return nil
+}
+
+// export sends spans to the collector.
+func (t *OTLPTracer) export(ctx context.Context, spans []otlpSpan) error {
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("export to %s failed with status %s", t.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// request returns the ExportTraceServiceRequest for spans.
+func (t *OTLPTracer) request(spans []otlpSpan) otlpExportRequest {
+	return otlpExportRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpKeyValue{otlpAttribute("service.name", t.service)}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/LINBIT/linstor-csi/pkg/client"},
+				Spans: spans,
+			}},
+		}},
+	}
+}
+
+type otlpSpanKey struct{}
+
+// otlpSpanContext identifies a span, as lowercase hex strings.
+type otlpSpanContext struct {
+	traceID string
+	spanID  string
+}
+
+// incomingTraceParent returns the span context in the traceparent metadata of
+// the gRPC request in ctx.
+func incomingTraceParent(ctx context.Context) (otlpSpanContext, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get("traceparent")) == 0 {
+		return otlpSpanContext{}, false
+	}
+	return parseTraceParent(md.Get("traceparent")[0])
+}
+
+// parseTraceParent parses a W3C traceparent header, like
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func parseTraceParent(header string) (otlpSpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return otlpSpanContext{}, false
+	}
+	for _, id := range parts[1:3] {
+		b, err := hex.DecodeString(id)
+		if err != nil || bytes.Equal(b, make([]byte, len(b))) || strings.ToLower(id) != id {
+			return otlpSpanContext{}, false
+		}
+	}
+	return otlpSpanContext{traceID: parts[1], spanID: parts[2]}, true
+}
+
+// randomHex returns n random bytes as a hex string.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	//nolint:errcheck
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type otlpActiveSpan struct {
+	tracer *OTLPTracer
+	once   sync.Once
+	mu     sync.Mutex
+	span   otlpSpan
+}
+
+func (s *otlpActiveSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.span.Attributes = append(s.span.Attributes, otlpAttribute(key, value))
+}
+
+func (s *otlpActiveSpan) End(err error) {
+	s.once.Do(func() {
+		s.mu.Lock()
+		span := s.span
+		s.mu.Unlock()
+
+		span.EndTimeUnixNano = strconv.FormatInt(time.Now().UnixNano(), 10)
+		if err != nil {
+			span.Status = &otlpStatus{Code: otlpStatusError, Message: err.Error()}
+		}
+		s.tracer.enqueue(span)
+	})
+}
+
+// The OTLP JSON encoding of the spans of an ExportTraceServiceRequest.
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+const otlpSpanKindInternal = 1
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const otlpStatusError = 2
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// otlpAttribute returns the attribute key with value. Values of other types
+// than strings, bools, and numbers are recorded as their string form.
+func otlpAttribute(key string, value interface{}) otlpKeyValue {
+	var v otlpAnyValue
+	switch val := value.(type) {
+	case string:
+		v.StringValue = &val
+	case bool:
+		v.BoolValue = &val
+	case int:
+		i := strconv.FormatInt(int64(val), 10)
+		v.IntValue = &i
+	case int32:
+		i := strconv.FormatInt(int64(val), 10)
+		v.IntValue = &i
+	case int64:
+		i := strconv.FormatInt(val, 10)
+		v.IntValue = &i
+	case float64:
+		v.DoubleValue = &val
+	default:
+		s := fmt.Sprintf("%v", val)
+		v.StringValue = &s
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
@@ -0,0 +1,111 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestOTLPTracer(t *testing.T) {
+	var requests []otlpExportRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Api-Key") != "secret" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var req otlpExportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests = append(requests, req)
+	}))
+	defer srv.Close()
+
+	tracer := NewOTLPTracer(OTLPTracesEndpoint(srv.URL+"/"), "", map[string]string{"Api-Key": "secret"}, logrus.NewEntry(logrus.New()))
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	ctx, parent := tracer.Start(ctx, "linstor.create")
+	parent.SetAttribute(SpanAttrVolumeID, "pvc-1")
+	_, child := tracer.Start(ctx, "linstor.attach")
+	child.SetAttribute("replicas", 3)
+	child.End(errors.New("no space left"))
+	parent.End(nil)
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected spans to be exported, got %v", err)
+	}
+
+	if len(requests) != 1 || len(requests[0].ResourceSpans) != 1 || len(requests[0].ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Expected a single export request, got %+v", requests)
+	}
+	rs := requests[0].ResourceSpans[0]
+	if attrs := rs.Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || *attrs[0].Value.StringValue != DefaultOTLPServiceName {
+		t.Errorf("Expected service.name %s, got %+v", DefaultOTLPServiceName, attrs)
+	}
+
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %+v", spans)
+	}
+	c, p := spans[0], spans[1]
+	if p.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || p.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("Expected span to continue the incoming trace, got %+v", p)
+	}
+	if c.TraceID != p.TraceID || c.ParentSpanID != p.SpanID {
+		t.Errorf("Expected span %+v to be a child of %+v", c, p)
+	}
+	if p.Status != nil || c.Status == nil || c.Status.Code != otlpStatusError || c.Status.Message != "no space left" {
+		t.Errorf("Expected only the child span to fail, got %+v and %+v", p.Status, c.Status)
+	}
+	if len(c.Attributes) != 1 || c.Attributes[0].Value.IntValue == nil || *c.Attributes[0].Value.IntValue != "3" {
+		t.Errorf("Expected integer attribute replicas, got %+v", c.Attributes)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	var tableTests = []struct {
+		header string
+		okExp  bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6-00f067aa0ba902b7-01", false},
+		{"", false},
+	}
+
+	for _, tt := range tableTests {
+		if _, ok := parseTraceParent(tt.header); ok != tt.okExp {
+			t.Errorf("Expected traceparent %q to be valid: %t, got %t", tt.header, tt.okExp, ok)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	lapi "github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/linstor-csi/pkg/linstor/util"
+	"github.com/LINBIT/linstor-csi/pkg/volume"
+	"github.com/sirupsen/logrus"
+)
+
+// resyncQueue orders the operations waiting to start resyncs by priority,
+// highest first, and by arrival among equal priorities.
+type resyncQueue struct {
+	mu      sync.Mutex
+	seq     uint64
+	waiters []*resyncWaiter
+}
+
+type resyncWaiter struct {
+	priority int
+	seq      uint64
+}
+
+// enqueue adds a waiter with the given priority to the queue.
+func (q *resyncQueue) enqueue(priority int) *resyncWaiter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	w := &resyncWaiter{priority: priority, seq: q.seq}
+	q.waiters = append(q.waiters, w)
+	return w
+}
+
+// first returns true if no waiter in the queue goes before w.
+func (q *resyncQueue) first(w *resyncWaiter) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, o := range q.waiters {
+		if o.priority > w.priority || (o.priority == w.priority && o.seq < w.seq) {
+			return false
+		}
+	}
+	return true
+}
+
+// remove drops w from the queue.
+func (q *resyncQueue) remove(w *resyncWaiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, o := range q.waiters {
+		if o == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// waitForResyncSlot waits until vol is first in line to start a resync and no
+// node that new replicas of vol end up on resyncs maxConcurrentResyncs volumes
+// or more. These are the given nodes or, if none are given because autoplace
+// picks them, all nodes without a replica of vol. The returned function must
+// be called once the replicas that resync were created, so that the next
+// operation sees their resync.
+func (s *Linstor) waitForResyncSlot(ctx context.Context, vol *volume.Info, nodes ...string) (func(), error) {
+	if s.maxConcurrentResyncs == 0 {
+		return func() {}, nil
+	}
+
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	w := s.resyncQueue.enqueue(params.ResyncPriority)
+	release := func() { s.resyncQueue.remove(w) }
+
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+
+	var logged bool
+	for {
+		if s.resyncQueue.first(w) {
+			resources, err := s.client.Resources.GetResourceView(ctx)
+			if err == nil && busiestResyncNode(resources, resyncTargets(resources, vol.ID, nodes)) < s.maxConcurrentResyncs {
+				return release, nil
+			}
+		}
+
+		if !logged {
+			s.log.WithFields(logrus.Fields{
+				"volume":               vol.Name,
+				"resyncPriority":       params.ResyncPriority,
+				"maxConcurrentResyncs": s.maxConcurrentResyncs,
+			}).Info("waiting for other resyncs to finish")
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			release()
+			return nil, fmt.Errorf("gave up waiting to start resync of %s: %v", vol.Name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// resyncTargets returns whether a node may get a new replica of the volume
+// volID: one of nodes or, if there are none, any node without a diskful
+// replica of it in resources.
+func resyncTargets(resources []lapi.Resource, volID string, nodes []string) func(string) bool {
+	var targets = make(map[string]bool)
+	if len(nodes) > 0 {
+		for _, n := range nodes {
+			targets[n] = true
+		}
+		return func(node string) bool { return targets[node] }
+	}
+
+	for _, r := range resources {
+		if r.Name == volID && util.DeployedDiskfully(r) {
+			targets[r.NodeName] = true
+		}
+	}
+	return func(node string) bool { return !targets[node] }
+}
+
+// busiestResyncNode returns the highest number of volumes resyncing on a
+// single node that is a target.
+func busiestResyncNode(resources []lapi.Resource, target func(string) bool) int {
+	var resyncing = make(map[string]int)
+	var busiest int
+	for _, r := range resources {
+		if !target(r.NodeName) {
+			continue
+		}
+		for _, v := range r.Volumes {
+			if v.State.DiskState == "Inconsistent" || strings.HasPrefix(v.State.DiskState, "SyncTarget") {
+				resyncing[r.NodeName]++
+				if resyncing[r.NodeName] > busiest {
+					busiest = resyncing[r.NodeName]
+				}
+			}
+		}
+	}
+	return busiest
+}
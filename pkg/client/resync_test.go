@@ -0,0 +1,85 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"testing"
+
+	lapi "github.com/LINBIT/golinstor/client"
+)
+
+func TestResyncQueue(t *testing.T) {
+	var q resyncQueue
+	low := q.enqueue(0)
+	high := q.enqueue(10)
+	alsoHigh := q.enqueue(10)
+
+	if q.first(low) || q.first(alsoHigh) || !q.first(high) {
+		t.Errorf("Expected the earliest waiter with highest priority to go first")
+	}
+
+	q.remove(high)
+	if q.first(low) || !q.first(alsoHigh) {
+		t.Errorf("Expected the next waiter with highest priority to go first")
+	}
+
+	q.remove(alsoHigh)
+	if !q.first(low) {
+		t.Errorf("Expected the last waiter to go first")
+	}
+}
+
+func TestBusiestResyncNode(t *testing.T) {
+	volumes := func(states ...string) []lapi.Volume {
+		var vols []lapi.Volume
+		for _, state := range states {
+			vols = append(vols, lapi.Volume{State: lapi.VolumeState{DiskState: state}})
+		}
+		return vols
+	}
+	resources := []lapi.Resource{
+		{Name: "pvc-1", NodeName: "node-a", Volumes: volumes("Inconsistent")},
+		{Name: "pvc-1", NodeName: "node-b", Volumes: volumes("UpToDate")},
+		{Name: "pvc-2", NodeName: "node-a", Volumes: volumes("UpToDate", "SyncTarget(12.5%)")},
+		{Name: "pvc-2", NodeName: "node-b", Volumes: volumes("Inconsistent")},
+		{Name: "pvc-3", NodeName: "node-c", Volumes: volumes("Diskless")},
+	}
+
+	var tableTests = []struct {
+		vol      string
+		nodes    []string
+		expected int
+	}{
+		{"pvc-4", nil, 2},
+		{"pvc-4", []string{"node-b", "node-c"}, 1},
+		{"pvc-4", []string{"node-c"}, 0},
+		// pvc-2 has replicas on node-a and node-b already.
+		{"pvc-2", nil, 0},
+		{"pvc-3", nil, 2},
+	}
+
+	for _, tt := range tableTests {
+		if busiest := busiestResyncNode(resources, resyncTargets(resources, tt.vol, tt.nodes)); busiest != tt.expected {
+			t.Errorf("Expected %d resyncs on the busiest target node of %s %v, got %d", tt.expected, tt.vol, tt.nodes, busiest)
+		}
+	}
+	if busiest := busiestResyncNode(nil, resyncTargets(nil, "pvc-1", nil)); busiest != 0 {
+		t.Errorf("Expected no resyncs, got %d", busiest)
+	}
+}
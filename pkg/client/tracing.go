@@ -0,0 +1,67 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import "context"
+
+// Tracer starts spans around client operations. It is implemented by adapters
+// to tracing libraries such as OpenTelemetry, which continue the trace carried
+// by ctx, if any, and export the spans.
+type Tracer interface {
+	// Start begins a span called name as a child of the span in ctx and
+	// returns a context carrying the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation.
+type Span interface {
+	// SetAttribute records a key value pair on the span.
+	SetAttribute(key string, value interface{})
+	// End finishes the span, recording err as its result.
+	End(err error)
+}
+
+// Span attributes recorded by the client.
+const (
+	SpanAttrVolumeID  = "linstor.volume.id"
+	SpanAttrNode      = "linstor.node"
+	SpanAttrSizeBytes = "linstor.volume.size_bytes"
+)
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+
+func (noopSpan) End(err error) {}
+
+// startSpan starts a span for the operation op on the volume with id.
+func (s *Linstor) startSpan(ctx context.Context, op, id string) (context.Context, Span) {
+	if s.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	ctx, span := s.tracer.Start(ctx, "linstor."+op)
+	span.SetAttribute(SpanAttrVolumeID, id)
+	return ctx, span
+}
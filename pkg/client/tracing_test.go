@@ -0,0 +1,98 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	lapi "github.com/LINBIT/golinstor/client"
+	lc "github.com/LINBIT/linstor-csi/pkg/linstor/highlevelclient"
+	"github.com/LINBIT/linstor-csi/pkg/volume"
+)
+
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	name  string
+	attrs map[string]interface{}
+	ended bool
+	err   error
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordingSpan{name: name, attrs: make(map[string]interface{})}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *recordingSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+func TestTracing(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := lc.NewHighLevelClient(lapi.BaseURL(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := &recordingTracer{}
+	l, err := NewLinstor(APIClient(c), Tracing(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Detach(context.Background(), &volume.Info{ID: "pvc-1"}, "node-a")
+	if err == nil {
+		t.Fatal("Expected detaching a missing volume to fail")
+	}
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("Expected a single span, got %d", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span.name != "linstor.detach" {
+		t.Errorf("Expected span linstor.detach, got %s", span.name)
+	}
+	expected := map[string]interface{}{SpanAttrVolumeID: "pvc-1", SpanAttrNode: "node-a"}
+	if !reflect.DeepEqual(expected, span.attrs) {
+		t.Errorf("Expected span attributes %v, got %v", expected, span.attrs)
+	}
+	if !span.ended || span.err != err {
+		t.Errorf("Expected span to end with %v, got ended: %t, err: %v", err, span.ended, span.err)
+	}
+}
@@ -0,0 +1,275 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseHeaders(t *testing.T) {
+	var tableTests = []struct {
+		in       string
+		expected map[string]string
+		errExp   bool
+	}{
+		{"", map[string]string{}, false},
+		{"X-A=1", map[string]string{"X-A": "1"}, false},
+		{"X-A=1, X-B = a=b", map[string]string{"X-A": "1", "X-B": "a=b"}, false},
+		{"X-A", nil, true},
+		{"=1", nil, true},
+	}
+
+	for _, tt := range tableTests {
+		actual, err := ParseHeaders(tt.in)
+		if tt.errExp != (err != nil) {
+			t.Fatalf("Expected error: %t, got %v for %q", tt.errExp, err, tt.in)
+		}
+		if !tt.errExp && !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected that ParseHeaders(%q) results in %v, but got %v", tt.in, tt.expected, actual)
+		}
+	}
+}
+
+func TestHeaderTransport(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "linstor-csi-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &HeaderTransport{
+		Headers:      map[string]string{"X-Custom": "value"},
+		TokenFile:    tokenFile,
+		TokenRefresh: time.Nanosecond,
+	}
+	c := &http.Client{Transport: transport}
+
+	if _, err := c.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("X-Custom") != "value" || got.Get("Authorization") != "Bearer first" {
+		t.Fatalf("Expected custom header and bearer token, got %v", got)
+	}
+
+	// Rotate the token.
+	if err := ioutil.WriteFile(tokenFile, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := c.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("Authorization") != "Bearer second" {
+		t.Fatalf("Expected rotated bearer token, got %v", got)
+	}
+}
+
+func TestPooledTransportReusesConnections(t *testing.T) {
+	const concurrent = 8
+
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c := &http.Client{Transport: NewPooledTransport(nil, DefaultMaxIdleConns)}
+	for round := 0; round < 3; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < concurrent; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := c.Get(srv.URL)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}()
+		}
+		wg.Wait()
+	}
+
+	if n := atomic.LoadInt32(&conns); n > concurrent {
+		t.Errorf("Expected at most %d connections for %d rounds of %d concurrent requests, but got %d", concurrent, 3, concurrent, n)
+	}
+}
+
+// BenchmarkTransport compares rounds of concurrent API calls over TLS with
+// net/http's default transport settings and the pooled transport.
+func BenchmarkTransport(b *testing.B) {
+	const concurrent = 8
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	tlsConfig := srv.Client().Transport.(*http.Transport).TLSClientConfig
+
+	var transports = []struct {
+		name      string
+		transport *http.Transport
+	}{
+		{"default", &http.Transport{TLSClientConfig: tlsConfig}},
+		{"pooled", NewPooledTransport(tlsConfig, DefaultMaxIdleConns)},
+	}
+
+	for _, tt := range transports {
+		b.Run(tt.name, func(b *testing.B) {
+			c := &http.Client{Transport: tt.transport}
+			defer tt.transport.CloseIdleConnections()
+
+			for n := 0; n < b.N; n++ {
+				var wg sync.WaitGroup
+				for i := 0; i < concurrent; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						resp, err := c.Get(srv.URL)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						ioutil.ReadAll(resp.Body)
+						resp.Body.Close()
+					}()
+				}
+				wg.Wait()
+			}
+		})
+	}
+}
+
+func TestHeaderTransportControllerChange(t *testing.T) {
+	var changes []string
+	transport := &HeaderTransport{
+		OnControllerChange: func(from, to string) {
+			changes = append(changes, from+">"+to)
+		},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	c := &http.Client{Transport: transport}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	addr := srv.Listener.Addr().String()
+	if transport.controller != addr || len(changes) != 0 {
+		t.Fatalf("Expected controller %s without changes, got %s and %v", addr, transport.controller, changes)
+	}
+
+	var steps = []struct {
+		addr     string
+		ok       bool
+		canceled bool
+		expected []string
+	}{
+		// Canceled requests and answers of the same controller are no change.
+		{"", false, true, nil},
+		{addr, true, false, nil},
+		// Another controller answers.
+		{"10.0.0.2:3370", true, false, []string{addr + ">10.0.0.2:3370"}},
+		// The controller was unreachable for a while, e.g. behind a service.
+		{"", false, false, nil},
+		{"10.0.0.2:3370", true, false, []string{"10.0.0.2:3370>10.0.0.2:3370"}},
+		{"10.0.0.2:3370", true, false, nil},
+	}
+
+	for i, s := range steps {
+		changes = nil
+		transport.noteController(s.addr, s.ok, s.canceled)
+		if !reflect.DeepEqual(s.expected, changes) {
+			t.Errorf("Expected changes %v after step %d, got %v", s.expected, i, changes)
+		}
+	}
+}
+
+func TestHeaderTransportTrace(t *testing.T) {
+	large := strings.Repeat("x", 2*maxTraceBodySize)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `[{"message": "`+large+`"}]`)
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	trace := logrus.New()
+	trace.SetOutput(&out)
+	trace.SetLevel(logrus.TraceLevel)
+
+	client := &http.Client{Transport: &HeaderTransport{Trace: trace}}
+	body := `{"new_passphrase": "hunter2", "props": {"Aux/safe": "value"}}`
+	resp, err := client.Post(srv.URL+"/v1/encryption/passphrase", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	got, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), large) {
+		t.Errorf("Expected the complete response body to reach the caller, got %d bytes", len(got))
+	}
+
+	logged := out.String()
+	for _, want := range []string{"POST", "/v1/encryption/passphrase", "status=201", "Aux/safe", redacted, "bytes truncated"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("Expected trace to contain %q, got %s", want, logged)
+		}
+	}
+	if strings.Contains(logged, "hunter2") {
+		t.Errorf("Expected passphrase to be redacted, got %s", logged)
+	}
+}
@@ -51,6 +51,7 @@ type Driver struct {
 	Assignments volume.AttacherDettacher
 	Mounter     volume.Mounter
 	Snapshots   volume.SnapshotCreateDeleter
+	Expander    volume.Expander
 	srv         *grpc.Server
 	log         *logrus.Entry
 	version     string
@@ -76,6 +77,7 @@ func NewDriver(options ...func(*Driver) error) (*Driver, error) {
 		Assignments: mockStorage,
 		Mounter:     mockStorage,
 		Snapshots:   mockStorage,
+		Expander:    mockStorage,
 		log:         logrus.NewEntry(logrus.New()),
 	}
 
@@ -127,6 +129,14 @@ func Snapshots(s volume.SnapshotCreateDeleter) func(*Driver) error {
 	}
 }
 
+// Expander configures the volume expansion service backend.
+func Expander(e volume.Expander) func(*Driver) error {
+	return func(d *Driver) error {
+		d.Expander = e
+		return nil
+	}
+}
+
 // Mounter configures the volume mounting service backend.
 func Mounter(m volume.Mounter) func(*Driver) error {
 	return func(d *Driver) error {
@@ -215,6 +225,11 @@ func (d Driver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCap
 				Service: &csi.PluginCapability_Service{
 					Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
 				}}},
+			// Volumes that can't be expanded online are refused while in use.
+			{Type: &csi.PluginCapability_VolumeExpansion_{
+				VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+					Type: csi.PluginCapability_VolumeExpansion_ONLINE,
+				}}},
 		},
 	}, nil
 }
@@ -276,10 +291,22 @@ func (d Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolum
 		return nil, status.Errorf(codes.Internal, "NodePublishVolume failed for %s: %v", req.GetVolumeId(), err)
 	}
 
-	err = d.Mounter.Mount(existingVolume, assignment.Path, req.GetTargetPath(), fsType, mntOpts)
+	// Fail with a clear error if the device isn't there, rather than a failed mount.
+	if err := d.Mounter.CheckDevice(assignment.Path); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "NodePublishVolume failed for %s: %v", req.GetVolumeId(), err)
+	}
+
+	res, err := d.Mounter.MountWithSecrets(existingVolume, assignment.Path, req.GetTargetPath(), fsType, mntOpts, req.GetSecrets())
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "NodePublishVolume failed for %s: %v", req.GetVolumeId(), err)
 	}
+	d.log.WithFields(logrus.Fields{
+		"volumeID":       req.GetVolumeId(),
+		"targetPath":     req.GetTargetPath(),
+		"filesystem":     res.FSType,
+		"formatted":      res.Formatted,
+		"alreadyMounted": res.AlreadyMounted,
+	}).Info("published volume")
 
 	return &csi.NodePublishVolumeResponse{}, nil
 }
@@ -309,7 +336,15 @@ func (d Driver) NodeGetVolumeStats(context.Context, *csi.NodeGetVolumeStatsReque
 
 // NodeGetCapabilities https://github.com/container-storage-interface/spec/blob/v1.1.0/spec.md#nodegetcapabilities
 func (d Driver) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	return &csi.NodeGetCapabilitiesResponse{Capabilities: []*csi.NodeServiceCapability{}}, nil
+	return &csi.NodeGetCapabilitiesResponse{
+		Capabilities: []*csi.NodeServiceCapability{
+			// Tell the CO we can grow filesystems of expanded volumes.
+			{Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+				}}},
+		},
+	}, nil
 }
 
 // NodeGetInfo https://github.com/container-storage-interface/spec/blob/v1.1.0/spec.md#nodegetinfo
@@ -412,7 +447,8 @@ func (d Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 	}).Debug("found existing volume")
 
 	if err := d.Storage.Delete(ctx, existingVolume); err != nil {
-		return nil, err
+		return nil, status.Errorf(errCode(err, codes.Internal),
+			"DeleteVolume failed for %s: %v", req.GetVolumeId(), err)
 	}
 	return &csi.DeleteVolumeResponse{}, nil
 }
@@ -459,7 +495,7 @@ func (d Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controller
 
 	err = d.Assignments.Attach(ctx, existingVolume, req.GetNodeId())
 	if err != nil {
-		return nil, status.Errorf(codes.Internal,
+		return nil, status.Errorf(errCode(err, codes.Internal),
 			"ControllerPublishVolume failed for %s: %v", req.GetVolumeId(), err)
 	}
 
@@ -494,7 +530,7 @@ func (d Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Controll
 	}).Debug("found existing volume")
 
 	if err := d.Assignments.Detach(ctx, vol, req.GetNodeId()); err != nil {
-		return nil, status.Errorf(codes.Internal,
+		return nil, status.Errorf(errCode(err, codes.Internal),
 			"ControllerpublishVolume failed for %s: %v", req.GetVolumeId(), err)
 	}
 
@@ -656,6 +692,11 @@ func (d Driver) ControllerGetCapabilities(ctx context.Context, req *csi.Controll
 				Rpc: &csi.ControllerServiceCapability_RPC{
 					Type: csi.ControllerServiceCapability_RPC_PUBLISH_READONLY,
 				}}},
+			// Tell the CO we can expand volumes.
+			{Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{
+					Type: csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+				}}},
 		},
 	}, nil
 }
@@ -693,7 +734,7 @@ func (d Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotReque
 		CsiSnap: &csi.Snapshot{SourceVolumeId: req.GetSourceVolumeId()},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create snapshot: %v", err)
+		return nil, status.Errorf(errCode(err, codes.Internal), "failed to create snapshot: %v", err)
 	}
 
 	return &csi.CreateSnapshotResponse{Snapshot: snap.CsiSnap}, nil
@@ -718,7 +759,7 @@ func (d Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotReque
 	}
 
 	if err := d.Snapshots.SnapDelete(ctx, snap); err != nil {
-		return nil, status.Errorf(codes.Internal, "unable to delete snapshot %s: %v",
+		return nil, status.Errorf(errCode(err, codes.Internal), "unable to delete snapshot %s: %v",
 			req.GetSnapshotId(), err)
 	}
 	return &csi.DeleteSnapshotResponse{}, nil
@@ -794,18 +835,65 @@ func (d Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest
 	return &csi.ListSnapshotsResponse{Entries: entries, NextToken: nextToken}, nil
 }
 
-// NodeExpandVolume https://github.com/container-storage-interface/spec/blob/v1.1.0/spec.md#nodeexpandvolume
-func (d Driver) NodeExpandVolume(context.Context, *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "")
+// NodeExpandVolume https://github.com/container-storage-interface/spec/blob/v1.2.0/spec.md#nodeexpandvolume
+func (d Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, missingAttr("NodeExpandVolume", req.GetVolumeId(), "VolumeId")
+	}
+	if req.GetVolumePath() == "" {
+		return nil, missingAttr("NodeExpandVolume", req.GetVolumeId(), "VolumePath")
+	}
+
+	// The device of block volumes grew already, they have no filesystem.
+	if req.GetVolumeCapability().GetBlock() != nil {
+		return &csi.NodeExpandVolumeResponse{}, nil
+	}
+
+	if err := d.Mounter.ExpandFilesystem(req.GetVolumePath()); err != nil {
+		return nil, status.Errorf(codes.Internal, "NodeExpandVolume failed for %s: %v", req.GetVolumeId(), err)
+	}
+
+	return &csi.NodeExpandVolumeResponse{}, nil
 }
 
-// ControllerExpandVolume https://github.com/container-storage-interface/spec/blob/v1.1.0/spec.md#controllerexpandvolume
-func (d Driver) ControllerExpandVolume(context.Context, *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "")
+// ControllerExpandVolume https://github.com/container-storage-interface/spec/blob/v1.2.0/spec.md#controllerexpandvolume
+func (d Driver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, missingAttr("ControllerExpandVolume", req.GetVolumeId(), "VolumeId")
+	}
+	if req.GetCapacityRange() == nil {
+		return nil, missingAttr("ControllerExpandVolume", req.GetVolumeId(), "CapacityRange")
+	}
+
+	vol, err := d.Storage.GetByID(ctx, req.GetVolumeId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerExpandVolume failed for %s: %v", req.GetVolumeId(), err)
+	}
+	if vol == nil {
+		return nil, status.Errorf(codes.NotFound, "ControllerExpandVolume failed for %s: volume not found", req.GetVolumeId())
+	}
+
+	requiredKiB, err := d.Storage.AllocationSizeKiB(req.GetCapacityRange().GetRequiredBytes(), req.GetCapacityRange().GetLimitBytes())
+	if err != nil {
+		return nil, status.Errorf(codes.OutOfRange, "ControllerExpandVolume failed for %s: %v", req.GetVolumeId(), err)
+	}
+	volumeSize := data.NewKibiByte(data.KiB * data.ByteSize(requiredKiB))
+
+	if err := d.Expander.Expand(ctx, vol, int64(volumeSize.InclusiveBytes())); err != nil {
+		return nil, status.Errorf(errCode(err, codes.Internal), "ControllerExpandVolume failed for %s: %v", req.GetVolumeId(), err)
+	}
+
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes: vol.SizeBytes,
+		// Only filesystems need to be grown on the node. COs that don't send
+		// a capability get the node expansion, which is safe for block
+		// volumes too.
+		NodeExpansionRequired: req.GetVolumeCapability().GetBlock() == nil,
+	}, nil
 }
 
 // Run the server.
-func (d Driver) Run() error {
+func (d *Driver) Run() error {
 	d.log.Debug("Preparing to start server")
 
 	u, err := url.Parse(d.endpoint)
@@ -864,7 +952,7 @@ func (d Driver) Run() error {
 }
 
 // Stop the server.
-func (d Driver) Stop() error {
+func (d *Driver) Stop() error {
 	d.srv.GracefulStop()
 	return nil
 }
@@ -877,17 +965,17 @@ func (d Driver) createNewVolume(ctx context.Context, req *csi.CreateVolumeReques
 
 	volumeSize := data.NewKibiByte(data.KiB * data.ByteSize(requiredKiB))
 
+	kubernetesRef, params := volume.SplitKubernetesRef(req.Parameters)
+
 	// Volume id is currently filled in when the volume is created.
 	vol := &volume.Info{
 		Name:         req.GetName(),
 		SizeBytes:    int64(volumeSize.InclusiveBytes()),
 		CreatedBy:    d.name,
 		CreationTime: time.Now(),
-		Parameters:   make(map[string]string),
+		Parameters:   params,
 		Snapshots:    []*volume.SnapInfo{},
-	}
-	for k, v := range req.Parameters {
-		vol.Parameters[k] = v
+		Kubernetes:   kubernetesRef,
 	}
 	d.log.WithFields(logrus.Fields{
 		"newVolume": fmt.Sprintf("%+v", vol),
@@ -925,7 +1013,7 @@ func (d Driver) createNewVolume(ctx context.Context, req *csi.CreateVolumeReques
 
 			if err := d.Snapshots.VolFromSnap(ctx, snap, vol); err != nil {
 				d.failpathDelete(ctx, vol)
-				return &csi.CreateVolumeResponse{}, status.Errorf(codes.Internal,
+				return &csi.CreateVolumeResponse{}, status.Errorf(errCode(err, codes.Internal),
 					"CreateVolume failed for %s: %v", req.GetName(), err)
 			}
 			// We're cloning from a whole volume.
@@ -941,7 +1029,7 @@ func (d Driver) createNewVolume(ctx context.Context, req *csi.CreateVolumeReques
 			}
 			if err := d.Snapshots.VolFromVol(ctx, sourceVol, vol); err != nil {
 				d.failpathDelete(ctx, vol)
-				return &csi.CreateVolumeResponse{}, status.Errorf(codes.Internal,
+				return &csi.CreateVolumeResponse{}, status.Errorf(errCode(err, codes.Internal),
 					"CreateVolume failed for %s: %v", req.GetName(), err)
 			}
 		default:
@@ -953,7 +1041,7 @@ func (d Driver) createNewVolume(ctx context.Context, req *csi.CreateVolumeReques
 		err := d.Storage.Create(ctx, vol, req)
 		if err != nil {
 			d.failpathDelete(ctx, vol)
-			return &csi.CreateVolumeResponse{}, status.Errorf(codes.Internal,
+			return &csi.CreateVolumeResponse{}, status.Errorf(errCode(err, codes.Internal),
 				"CreateVolume failed for %s: %v", req.GetName(), err)
 		}
 	}
@@ -1003,3 +1091,17 @@ func (d Driver) failpathDelete(ctx context.Context, vol *volume.Info) {
 		}).WithError(err).Error("failed to clean up volume")
 	}
 }
+
+// errCode returns codes.Unavailable for errors of operations that were
+// refused for now, like during maintenance, so that they are retried,
+// codes.FailedPrecondition for those that wait for the volume to be unused,
+// and fallback for all others.
+func errCode(err error, fallback codes.Code) codes.Code {
+	switch err.(type) {
+	case *client.MaintenanceError, *client.PreallocatingError:
+		return codes.Unavailable
+	case *client.OfflineExpandError:
+		return codes.FailedPrecondition
+	}
+	return fallback
+}
@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	lapi "github.com/LINBIT/golinstor/client"
 	"github.com/LINBIT/linstor-csi/pkg/client"
 	lc "github.com/LINBIT/linstor-csi/pkg/linstor/highlevelclient"
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-test/pkg/sanity"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/time/rate"
@@ -120,6 +122,48 @@ func TestDriver(t *testing.T) {
 	sanity.Test(t, cfg)
 }
 
+func TestControllerExpandVolume(t *testing.T) {
+	driver, err := NewDriver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mountCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}
+	blockCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+
+	created, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 20},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCap},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tableTests = []struct {
+		capability   *csi.VolumeCapability
+		nodeRequired bool
+	}{
+		{capability: mountCap, nodeRequired: true},
+		{capability: blockCap, nodeRequired: false},
+		{capability: nil, nodeRequired: true},
+	}
+
+	for _, tt := range tableTests {
+		resp, err := driver.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+			VolumeId:         created.GetVolume().GetVolumeId(),
+			CapacityRange:    &csi.CapacityRange{RequiredBytes: 2 << 20},
+			VolumeCapability: tt.capability,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.NodeExpansionRequired != tt.nodeRequired {
+			t.Errorf("Expected node expansion required: %t for capability %v, got %t", tt.nodeRequired, tt.capability, resp.NodeExpansionRequired)
+		}
+	}
+}
+
 // Make a custom function, so we don't have to worry about the test complaining
 // the directories it created exist.
 func createTargetDir(targetPath string) error {
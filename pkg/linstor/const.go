@@ -21,3 +21,43 @@ package linstor
 // AnnotationsKey is the Aux props key in linstor where serialized CSI volumes
 // are stored.
 const AnnotationsKey = "Aux/csi-volume-annotations"
+
+// ManagedByKey is the Aux props key of resource definitions that names the
+// plugin instance managing them. Resource definitions managed by others are
+// left alone.
+const ManagedByKey = "Aux/csi-managed-by"
+
+// TierKey is the Aux props key of storage pools and nodes that names their
+// storage tier, like gold or silver. The key of a storage pool takes precedence
+// over the key of its node.
+const TierKey = "Aux/tier"
+
+// ZfsCreateOptionsKey is the property holding additional options that LINSTOR
+// passes to zfs create when creating volumes in ZFS storage pools.
+const ZfsCreateOptionsKey = "StorDriver/ZfscreateOptions"
+
+// SnapshotMountKey is the Aux props key of resource definitions that were
+// restored from a snapshot to be mounted read-only. It holds the mount target.
+const SnapshotMountKey = "Aux/csi-snapshot-mount"
+
+// SnapshotMountNodeKey is the Aux props key of resource definitions that were
+// restored from a snapshot to be mounted read-only. It holds the node the
+// snapshot is mounted on.
+const SnapshotMountNodeKey = "Aux/csi-snapshot-mount-node"
+
+// DrbdMetaStoragePoolKey is the property naming the storage pool that holds
+// external DRBD metadata. Metadata is internal if it is unset.
+const DrbdMetaStoragePoolKey = "StorPoolNameDrbdMeta"
+
+// CachePoolKey is the property naming the storage pool that holds the cache of
+// volumes with a cache layer.
+const CachePoolKey = "Cache/CachePool"
+
+// RequestedRoleKey is the Aux props key of resources that asks the plugin on
+// their node to make them Primary, Secondary, or ForcePrimary. The plugin
+// removes it once it acted on the request.
+const RequestedRoleKey = "Aux/csi-requested-role"
+
+// RequestedRoleErrorKey is the Aux props key of resources whose requested role
+// could not be applied. It holds the error.
+const RequestedRoleErrorKey = "Aux/csi-requested-role-error"
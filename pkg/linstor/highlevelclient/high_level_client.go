@@ -21,12 +21,16 @@ package highlevelclient
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 
 	lapi "github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/linstor-csi/pkg/linstor"
 	"github.com/LINBIT/linstor-csi/pkg/linstor/util"
 	"github.com/LINBIT/linstor-csi/pkg/topology"
 	"github.com/LINBIT/linstor-csi/pkg/volume"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/haySwim/data"
 )
 
 // HighLevelClient is a golinstor client with convience functions.
@@ -78,6 +82,378 @@ func (c *HighLevelClient) GenericAccessibleTopologies(ctx context.Context, vol *
 	return topos, nil
 }
 
+// NodePoolCapacity returns the free capacity in bytes of the named storage pool,
+// keyed by node name. Nodes without that storage pool are omitted.
+func (c *HighLevelClient) NodePoolCapacity(ctx context.Context, pool string) (map[string]int64, error) {
+	pools, err := c.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get capacity for storage pool %s: %v", pool, err)
+	}
+	return nodePoolCapacity(pools, pool), nil
+}
+
+func nodePoolCapacity(pools []lapi.StoragePool, pool string) map[string]int64 {
+	var capacity = make(map[string]int64)
+	for _, sp := range pools {
+		if sp.StoragePoolName != pool || sp.ProviderKind == lapi.DISKLESS {
+			continue
+		}
+		capacity[sp.NodeName] += int64(data.NewKibiByte(data.KiB * data.ByteSize(sp.FreeCapacity)).To(data.B))
+	}
+	return capacity
+}
+
+// Autoplace places replicas of vol according to apRequest through LINSTOR's
+// autoplace. LINSTOR cannot exclude nodes by name or place by tier, so volumes
+// with excludeNodes or a tier have their replicas created on the remaining
+// nodes with the most free space in the storage pool instead. These nodes
+// still have to satisfy doNotPlaceWithRegex, replicasOnSame, and
+// replicasOnDifferent, the other fields of apRequest are not honored for them.
+func (c *HighLevelClient) Autoplace(ctx context.Context, vol *volume.Info, apRequest lapi.AutoPlaceRequest) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	if len(params.ExcludeNodes) == 0 && params.Tier == "" {
+		return c.Resources.Autoplace(ctx, vol.ID, apRequest)
+	}
+
+	pools, err := c.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to place %s on eligible nodes: %v", vol.ID, err)
+	}
+	resources, err := c.Resources.GetAll(ctx, vol.ID)
+	if err != nil {
+		return fmt.Errorf("unable to place %s on eligible nodes: %v", vol.ID, err)
+	}
+	nodes, err := c.Nodes.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to place %s on eligible nodes: %v", vol.ID, err)
+	}
+	if params.Tier != "" {
+		pools = tierStoragePools(nodes, pools, params.Tier)
+	}
+
+	// doNotPlaceWithRegex is about the resources of other volumes.
+	allResources := resources
+	if params.DoNotPlaceWithRegex != "" {
+		allResources, err = c.Resources.GetResourceView(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to place %s on eligible nodes: %v", vol.ID, err)
+		}
+	}
+	excluded, err := util.ExcludedNodes(params, allResources)
+	if err != nil {
+		return err
+	}
+
+	nodesByName := make(map[string]lapi.Node, len(nodes))
+	for _, n := range nodes {
+		nodesByName[n.Name] = n
+	}
+	placed := util.DeployedDiskfullyNodes(resources)
+	candidates, candidatePools := placementCandidates(pools, resources, apRequest.SelectFilter.StoragePool, vol.SizeBytes, excluded)
+	candidates = sameCandidates(candidates, nodesByName, placed, params)
+
+	// Autoplace counts the existing replicas towards the place count.
+	remaining := int(apRequest.SelectFilter.PlaceCount) - len(placed)
+	var createErrs []string
+	for remaining > 0 {
+		node, ok := differentCandidate(candidates, nodesByName, placed, params.ReplicasOnDifferent)
+		if !ok {
+			break
+		}
+		candidates = remove(candidates, node)
+
+		drc, err := vol.ToDiskfullResourceCreateInPool(node, candidatePools[node])
+		if err != nil {
+			return err
+		}
+		if err := c.Resources.Create(ctx, drc); err != nil {
+			createErrs = append(createErrs, fmt.Sprintf("%s: %v", node, err))
+			continue
+		}
+		placed = append(placed, node)
+		remaining--
+	}
+
+	if remaining > 0 {
+		var failed string
+		if len(createErrs) > 0 {
+			failed = fmt.Sprintf(", creating replicas failed on %s", strings.Join(createErrs, "; "))
+		}
+		if params.Tier != "" {
+			return fmt.Errorf("unable to place %d more replicas of %s in tier %q outside of excluded nodes %v satisfying the placement constraints%s",
+				remaining, vol.ID, params.Tier, params.ExcludeNodes, failed)
+		}
+		return fmt.Errorf("unable to place %d more replicas of %s outside of excluded nodes %v satisfying the placement constraints%s",
+			remaining, vol.ID, params.ExcludeNodes, failed)
+	}
+	return nil
+}
+
+// sameCandidates returns the candidates that share the values of the
+// replicasOnSame properties of params with the placed replicas. Without placed
+// replicas, it returns the group of candidates sharing values that could hold
+// the most replicas. The order of candidates is kept.
+func sameCandidates(candidates []string, nodes map[string]lapi.Node, placed []string, params volume.Parameters) []string {
+	if len(params.ReplicasOnSame) == 0 {
+		return candidates
+	}
+
+	groups := make(map[string][]string)
+	var keys []string
+	for _, name := range candidates {
+		key, ok := util.PlacementProps(nodes[name], params.ReplicasOnSame)
+		if !ok {
+			continue
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], name)
+	}
+
+	if len(placed) > 0 {
+		key, ok := util.PlacementProps(nodes[placed[0]], params.ReplicasOnSame)
+		if !ok {
+			return nil
+		}
+		return groups[key]
+	}
+
+	var best []string
+	bestCount := 0
+	for _, key := range keys {
+		if count := len(differentCandidates(groups[key], nodes, params.ReplicasOnDifferent)); count > bestCount {
+			best, bestCount = groups[key], count
+		}
+	}
+	return best
+}
+
+// differentCandidate returns the first of candidates that differs from all
+// placed nodes in each of props.
+func differentCandidate(candidates []string, nodes map[string]lapi.Node, placed []string, props []string) (string, bool) {
+	for _, name := range candidates {
+		if differsFrom(nodes[name], placed, nodes, props) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// differentCandidates returns the candidates that would be chosen one after
+// another, if each has to differ from those chosen before in each of props.
+func differentCandidates(candidates []string, nodes map[string]lapi.Node, props []string) []string {
+	var chosen []string
+	for _, name := range candidates {
+		if differsFrom(nodes[name], chosen, nodes, props) {
+			chosen = append(chosen, name)
+		}
+	}
+	return chosen
+}
+
+// differsFrom reports whether node has all of props, with values other than
+// those of the placed nodes.
+func differsFrom(node lapi.Node, placed []string, nodes map[string]lapi.Node, props []string) bool {
+	for _, p := range props {
+		v, ok := util.NodeAuxProp(node, p)
+		if !ok {
+			return false
+		}
+		for _, name := range placed {
+			if pv, ok := util.NodeAuxProp(nodes[name], p); ok && pv == v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TierPools returns the diskful storage pools of a tier, as the sorted names
+// of the nodes that have them in the tier keyed by storage pool name.
+func (c *HighLevelClient) TierPools(ctx context.Context, tier string) (map[string][]string, error) {
+	nodes, err := c.Nodes.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get storage pools of tier %q: %v", tier, err)
+	}
+	pools, err := c.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get storage pools of tier %q: %v", tier, err)
+	}
+
+	var tierPools = make(map[string][]string)
+	for _, sp := range tierStoragePools(nodes, pools, tier) {
+		tierPools[sp.StoragePoolName] = append(tierPools[sp.StoragePoolName], sp.NodeName)
+	}
+	for _, nodes := range tierPools {
+		sort.Strings(nodes)
+	}
+	return tierPools, nil
+}
+
+// TierNodes returns the nodes that have pool in tier, or any storage pool in
+// tier if pool is empty, mapped to the storage pool of the tier their replicas
+// go to: pool, or the one with the most free space on the node. It returns nil
+// if tier is empty, as then all nodes are eligible.
+func (c *HighLevelClient) TierNodes(ctx context.Context, tier, pool string) (map[string]string, error) {
+	if tier == "" {
+		return nil, nil
+	}
+	nodes, err := c.Nodes.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get storage pools of tier %q: %v", tier, err)
+	}
+	pools, err := c.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get storage pools of tier %q: %v", tier, err)
+	}
+	return mostFreePools(tierStoragePools(nodes, pools, tier), pool), nil
+}
+
+// mostFreePools maps the nodes of pools to their storage pool named pool, or
+// to the diskful one with the most free space if pool is empty.
+func mostFreePools(pools []lapi.StoragePool, pool string) map[string]string {
+	var free = make(map[string]int64)
+	var nodePools = make(map[string]string)
+	for _, sp := range pools {
+		if sp.ProviderKind == lapi.DISKLESS || (pool != "" && sp.StoragePoolName != pool) {
+			continue
+		}
+		if _, ok := nodePools[sp.NodeName]; !ok || sp.FreeCapacity > free[sp.NodeName] ||
+			(sp.FreeCapacity == free[sp.NodeName] && sp.StoragePoolName < nodePools[sp.NodeName]) {
+			free[sp.NodeName] = sp.FreeCapacity
+			nodePools[sp.NodeName] = sp.StoragePoolName
+		}
+	}
+	return nodePools
+}
+
+// tierStoragePools returns the diskful storage pools of tier. A storage pool is
+// in the tier named by its TierKey property, or by that of its node if it has
+// none.
+func tierStoragePools(nodes []lapi.Node, pools []lapi.StoragePool, tier string) []lapi.StoragePool {
+	var nodeTiers = make(map[string]string, len(nodes))
+	for _, n := range nodes {
+		nodeTiers[n.Name] = n.Props[linstor.TierKey]
+	}
+
+	var inTier = make([]lapi.StoragePool, 0, len(pools))
+	for _, sp := range pools {
+		if sp.ProviderKind == lapi.DISKLESS {
+			continue
+		}
+		t, ok := sp.Props[linstor.TierKey]
+		if !ok {
+			t = nodeTiers[sp.NodeName]
+		}
+		if t == tier {
+			inTier = append(inTier, sp)
+		}
+	}
+	return inTier
+}
+
+// placementCandidates returns the nodes that could get a replica of a volume
+// of requiredBytes in pool, ordered from most to least free space, and the
+// storage pool each of them would get it in. Nodes that are excluded or
+// already have a resource of the volume are not candidates. Any diskful
+// storage pool qualifies if pool is empty, the one with the most free space
+// is used then.
+func placementCandidates(pools []lapi.StoragePool, resources []lapi.Resource, pool string, requiredBytes int64, excluded map[string]bool) ([]string, map[string]string) {
+	var hasResource = make(map[string]bool, len(resources))
+	for _, r := range resources {
+		hasResource[r.NodeName] = true
+	}
+
+	var free = make(map[string]int64)
+	var nodePools = make(map[string]string)
+	for _, sp := range pools {
+		if sp.ProviderKind == lapi.DISKLESS || (pool != "" && sp.StoragePoolName != pool) {
+			continue
+		}
+		if hasResource[sp.NodeName] || excluded[sp.NodeName] {
+			continue
+		}
+		bytes := int64(data.NewKibiByte(data.KiB * data.ByteSize(sp.FreeCapacity)).To(data.B))
+		if bytes >= requiredBytes && bytes > free[sp.NodeName] {
+			free[sp.NodeName] = bytes
+			nodePools[sp.NodeName] = sp.StoragePoolName
+		}
+	}
+
+	var nodes = make([]string, 0, len(free))
+	for node := range free {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(j, k int) bool {
+		if free[nodes[j]] == free[nodes[k]] {
+			return nodes[j] < nodes[k]
+		}
+		return free[nodes[j]] > free[nodes[k]]
+	})
+	return nodes, nodePools
+}
+
+// Provisioning types of storage pools.
+const (
+	ProvisioningThin  = "thin"
+	ProvisioningThick = "thick"
+)
+
+// PoolProvisioningType returns whether the named storage pool is thin or thick
+// provisioned.
+func (c *HighLevelClient) PoolProvisioningType(ctx context.Context, pool string) (string, error) {
+	pools, err := c.Nodes.GetStoragePoolView(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to determine provisioning type of storage pool %s: %v", pool, err)
+	}
+	return poolProvisioningType(pools, pool)
+}
+
+func poolProvisioningType(pools []lapi.StoragePool, pool string) (string, error) {
+	var provisioning string
+	for _, sp := range pools {
+		if sp.StoragePoolName != pool {
+			continue
+		}
+
+		var t string
+		switch sp.ProviderKind {
+		case lapi.LVM_THIN, lapi.ZFS_THIN:
+			t = ProvisioningThin
+		case lapi.LVM, lapi.ZFS:
+			t = ProvisioningThick
+		default:
+			return "", fmt.Errorf("storage pool %s on node %s has provider kind %s, which has no provisioning type",
+				pool, sp.NodeName, sp.ProviderKind)
+		}
+
+		if provisioning != "" && provisioning != t {
+			return "", fmt.Errorf("storage pool %s is thin provisioned on some nodes and thick on others", pool)
+		}
+		provisioning = t
+	}
+
+	if provisioning == "" {
+		return "", fmt.Errorf("unknown storage pool %s", pool)
+	}
+	return provisioning, nil
+}
+
+// remove returns strs without s.
+func remove(strs []string, s string) []string {
+	var rest = make([]string, 0, len(strs))
+	for _, str := range strs {
+		if str != s {
+			rest = append(rest, str)
+		}
+	}
+	return rest
+}
+
 // remove duplicates from a slice.
 func uniq(strs []string) []string {
 	var seen = make(map[string]bool, len(strs))
@@ -21,6 +21,11 @@ package highlevelclient
 import (
 	"reflect"
 	"testing"
+
+	lapi "github.com/LINBIT/golinstor/client"
+	"github.com/LINBIT/linstor-csi/pkg/linstor"
+	"github.com/LINBIT/linstor-csi/pkg/linstor/util"
+	"github.com/LINBIT/linstor-csi/pkg/volume"
 )
 
 func TestUniq(t *testing.T) {
@@ -54,3 +59,225 @@ func TestUniq(t *testing.T) {
 		}
 	}
 }
+
+func TestNodePoolCapacity(t *testing.T) {
+	pools := []lapi.StoragePool{
+		{StoragePoolName: "thin", NodeName: "node-a", ProviderKind: lapi.LVM_THIN, FreeCapacity: 1},
+		{StoragePoolName: "thin", NodeName: "node-b", ProviderKind: lapi.LVM_THIN, FreeCapacity: 2},
+		{StoragePoolName: "thick", NodeName: "node-c", ProviderKind: lapi.LVM, FreeCapacity: 4},
+		{StoragePoolName: "thin", NodeName: "node-d", ProviderKind: lapi.DISKLESS},
+	}
+
+	var tableTests = []struct {
+		pool     string
+		expected map[string]int64
+	}{
+		{"thin", map[string]int64{"node-a": 1024, "node-b": 2048}},
+		{"thick", map[string]int64{"node-c": 4096}},
+		{"missing", map[string]int64{}},
+	}
+
+	for _, tt := range tableTests {
+		actual := nodePoolCapacity(pools, tt.pool)
+
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Fatalf("Expected that nodePoolCapacity(%q) results in\n\t%v\nbut got\n\t%v", tt.pool, tt.expected, actual)
+		}
+	}
+}
+
+func TestPlacementCandidates(t *testing.T) {
+	pools := []lapi.StoragePool{
+		{StoragePoolName: "thin", NodeName: "node-a", ProviderKind: lapi.LVM_THIN, FreeCapacity: 1},
+		{StoragePoolName: "thin", NodeName: "node-b", ProviderKind: lapi.LVM_THIN, FreeCapacity: 2},
+		{StoragePoolName: "thin", NodeName: "node-c", ProviderKind: lapi.LVM_THIN, FreeCapacity: 4},
+		{StoragePoolName: "thin", NodeName: "node-d", ProviderKind: lapi.LVM_THIN, FreeCapacity: 8},
+		{StoragePoolName: "thick", NodeName: "node-a", ProviderKind: lapi.LVM, FreeCapacity: 2},
+		{StoragePoolName: "thick", NodeName: "node-e", ProviderKind: lapi.LVM, FreeCapacity: 16},
+		{StoragePoolName: "thin", NodeName: "node-f", ProviderKind: lapi.DISKLESS},
+	}
+	resources := []lapi.Resource{{Name: "pvc-1", NodeName: "node-c"}}
+
+	var tableTests = []struct {
+		pool          string
+		requiredBytes int64
+		exclude       string
+		expected      []string
+		expectedPools map[string]string
+	}{
+		{"thin", 0, "node-d", []string{"node-b", "node-a"}, map[string]string{"node-a": "thin", "node-b": "thin"}},
+		{"thin", 2048, "node-a", []string{"node-d", "node-b"}, map[string]string{"node-b": "thin", "node-d": "thin"}},
+		{"", 0, "node-e node-b", []string{"node-d", "node-a"}, map[string]string{"node-a": "thick", "node-d": "thin"}},
+	}
+
+	for _, tt := range tableTests {
+		params, err := volume.NewParameters(map[string]string{"excludeNodes": tt.exclude})
+		if err != nil {
+			t.Fatal(err)
+		}
+		excluded, err := util.ExcludedNodes(params, resources)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, actualPools := placementCandidates(pools, resources, tt.pool, tt.requiredBytes, excluded)
+
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected candidates %v for pool %q excluding %q, got %v", tt.expected, tt.pool, tt.exclude, actual)
+		}
+		if !reflect.DeepEqual(tt.expectedPools, actualPools) {
+			t.Errorf("Expected candidate storage pools %v for pool %q excluding %q, got %v", tt.expectedPools, tt.pool, tt.exclude, actualPools)
+		}
+	}
+}
+
+func TestPlacementConstraints(t *testing.T) {
+	node := func(name, zone, rack string) lapi.Node {
+		return lapi.Node{Name: name, Props: map[string]string{"Aux/zone": zone, "Aux/rack": rack}}
+	}
+	nodes := map[string]lapi.Node{
+		"node-a": node("node-a", "z1", "r1"),
+		"node-b": node("node-b", "z1", "r1"),
+		"node-c": node("node-c", "z2", "r1"),
+		"node-d": node("node-d", "z2", "r2"),
+		"node-e": node("node-e", "z2", "r3"),
+		"node-f": {Name: "node-f"},
+	}
+	candidates := []string{"node-a", "node-b", "node-c", "node-d", "node-e", "node-f"}
+
+	var tableTests = []struct {
+		params   map[string]string
+		placed   []string
+		expected []string
+	}{
+		{map[string]string{}, nil, candidates},
+		{map[string]string{"replicasOnSame": "zone"}, nil, []string{"node-c", "node-d", "node-e"}},
+		{map[string]string{"replicasOnSame": "zone"}, []string{"node-b"}, []string{"node-a"}},
+		{map[string]string{"replicasOnSame": "zone", "replicasOnDifferent": "rack"}, nil, []string{"node-c", "node-d", "node-e"}},
+		{map[string]string{"replicasOnSame": "zone", "replicasOnDifferent": "rack"}, []string{"node-a"}, nil},
+		{map[string]string{"replicasOnSame": "zone", "replicasOnDifferent": "rack"}, []string{"node-d"}, []string{"node-c", "node-e"}},
+		{map[string]string{"replicasOnDifferent": "zone"}, nil, []string{"node-a", "node-c"}},
+		{map[string]string{"replicasOnDifferent": "rack"}, []string{"node-c"}, []string{"node-d", "node-e"}},
+	}
+
+	for _, tt := range tableTests {
+		params, err := volume.NewParameters(tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Place replicas one after another, like Autoplace.
+		available := sameCandidates(candidates, nodes, tt.placed, params)
+		placed := append([]string{}, tt.placed...)
+		var actual []string
+		for _, p := range tt.placed {
+			available = remove(available, p)
+		}
+		for {
+			c, ok := differentCandidate(available, nodes, placed, params.ReplicasOnDifferent)
+			if !ok {
+				break
+			}
+			available = remove(available, c)
+			placed = append(placed, c)
+			actual = append(actual, c)
+		}
+
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected placement parameters %v with placed %v to choose %v, got %v", tt.params, tt.placed, tt.expected, actual)
+		}
+	}
+}
+
+func TestPoolProvisioningType(t *testing.T) {
+	pools := []lapi.StoragePool{
+		{StoragePoolName: "thin", NodeName: "node-a", ProviderKind: lapi.LVM_THIN},
+		{StoragePoolName: "thin", NodeName: "node-b", ProviderKind: lapi.ZFS_THIN},
+		{StoragePoolName: "thick", NodeName: "node-a", ProviderKind: lapi.ZFS},
+		{StoragePoolName: "mixed", NodeName: "node-a", ProviderKind: lapi.LVM},
+		{StoragePoolName: "mixed", NodeName: "node-b", ProviderKind: lapi.LVM_THIN},
+		{StoragePoolName: "diskless", NodeName: "node-a", ProviderKind: lapi.DISKLESS},
+	}
+
+	var tableTests = []struct {
+		pool     string
+		expected string
+		errExp   bool
+	}{
+		{"thin", ProvisioningThin, false},
+		{"thick", ProvisioningThick, false},
+		{"mixed", "", true},
+		{"diskless", "", true},
+		{"missing", "", true},
+	}
+
+	for _, tt := range tableTests {
+		actual, err := poolProvisioningType(pools, tt.pool)
+		if tt.errExp != (err != nil) {
+			t.Fatalf("Expected error: %t, got %v for pool %q", tt.errExp, err, tt.pool)
+		}
+		if tt.expected != actual {
+			t.Fatalf("Expected that poolProvisioningType(%q) results in %q, but got %q", tt.pool, tt.expected, actual)
+		}
+	}
+}
+
+func TestTierStoragePools(t *testing.T) {
+	nodes := []lapi.Node{
+		{Name: "node-a", Props: map[string]string{linstor.TierKey: "gold"}},
+		{Name: "node-b", Props: map[string]string{linstor.TierKey: "silver"}},
+		{Name: "node-c"},
+	}
+	pools := []lapi.StoragePool{
+		{StoragePoolName: "ssd", NodeName: "node-a", ProviderKind: lapi.LVM_THIN},
+		{StoragePoolName: "hdd", NodeName: "node-a", ProviderKind: lapi.LVM, Props: map[string]string{linstor.TierKey: "bronze"}},
+		{StoragePoolName: "ssd", NodeName: "node-b", ProviderKind: lapi.LVM_THIN},
+		{StoragePoolName: "nvme", NodeName: "node-c", ProviderKind: lapi.LVM_THIN, Props: map[string]string{linstor.TierKey: "gold"}},
+		{StoragePoolName: "diskless", NodeName: "node-a", ProviderKind: lapi.DISKLESS},
+	}
+
+	var tableTests = []struct {
+		tier     string
+		expected []string
+	}{
+		{"gold", []string{"node-a/ssd", "node-c/nvme"}},
+		{"silver", []string{"node-b/ssd"}},
+		{"bronze", []string{"node-a/hdd"}},
+		{"platinum", []string{}},
+	}
+
+	for _, tt := range tableTests {
+		var actual = []string{}
+		for _, sp := range tierStoragePools(nodes, pools, tt.tier) {
+			actual = append(actual, sp.NodeName+"/"+sp.StoragePoolName)
+		}
+
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected storage pools %v in tier %q, got %v", tt.expected, tt.tier, actual)
+		}
+	}
+}
+
+func TestMostFreePools(t *testing.T) {
+	pools := []lapi.StoragePool{
+		{StoragePoolName: "ssd", NodeName: "node-a", ProviderKind: lapi.LVM_THIN, FreeCapacity: 1},
+		{StoragePoolName: "nvme", NodeName: "node-a", ProviderKind: lapi.LVM_THIN, FreeCapacity: 2},
+		{StoragePoolName: "ssd", NodeName: "node-b", ProviderKind: lapi.LVM_THIN, FreeCapacity: 4},
+		{StoragePoolName: "nvme", NodeName: "node-b", ProviderKind: lapi.LVM_THIN, FreeCapacity: 4},
+		{StoragePoolName: "nvme", NodeName: "node-c", ProviderKind: lapi.DISKLESS},
+	}
+
+	var tableTests = []struct {
+		pool     string
+		expected map[string]string
+	}{
+		{"", map[string]string{"node-a": "nvme", "node-b": "nvme"}},
+		{"ssd", map[string]string{"node-a": "ssd", "node-b": "ssd"}},
+		{"hdd", map[string]string{}},
+	}
+
+	for _, tt := range tableTests {
+		if actual := mostFreePools(pools, tt.pool); !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected storage pools %v for pool %q, got %v", tt.expected, tt.pool, actual)
+		}
+	}
+}
@@ -19,6 +19,10 @@ along with this program; if not, see <http://www.gnu.org/licenses/>.
 package util
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+
 	apiconst "github.com/LINBIT/golinstor"
 	lapi "github.com/LINBIT/golinstor/client"
 	"github.com/LINBIT/linstor-csi/pkg/volume"
@@ -124,3 +128,59 @@ nextCandidate:
 	}
 	return true
 }
+
+// ExcludedNodes returns the excludeNodes of params and the nodes hosting
+// resources that match its doNotPlaceWithRegex.
+func ExcludedNodes(params volume.Parameters, resources []lapi.Resource) (map[string]bool, error) {
+	excluded := make(map[string]bool)
+	for _, n := range params.ExcludeNodes {
+		excluded[n] = true
+	}
+	if params.DoNotPlaceWithRegex == "" {
+		return excluded, nil
+	}
+
+	re, err := regexp.Compile(params.DoNotPlaceWithRegex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid doNotPlaceWithRegex %q: %v", params.DoNotPlaceWithRegex, err)
+	}
+	for _, r := range resources {
+		if re.MatchString(r.Name) {
+			excluded[r.NodeName] = true
+		}
+	}
+	return excluded, nil
+}
+
+// PlacementProps returns the values of props on node, joined into a single
+// key. It is false if any of the props is missing on the node.
+func PlacementProps(node lapi.Node, props []string) (string, bool) {
+	values := make([]string, 0, len(props))
+	for _, p := range props {
+		v, ok := NodeAuxProp(node, p)
+		if !ok {
+			return "", false
+		}
+		values = append(values, v)
+	}
+	return strings.Join(values, "\x00"), true
+}
+
+// NodeAuxProp returns the value of the placement property prop of node. Like
+// in LINSTOR, prop may omit the Aux/ namespace and may require a particular
+// value in the form of key=value.
+func NodeAuxProp(node lapi.Node, prop string) (string, bool) {
+	key, want := prop, ""
+	if i := strings.Index(prop, "="); i >= 0 {
+		key, want = prop[:i], prop[i+1:]
+	}
+
+	v, ok := node.Props[key]
+	if !ok {
+		v, ok = node.Props["Aux/"+key]
+	}
+	if !ok || (want != "" && v != want) {
+		return "", false
+	}
+	return v, true
+}
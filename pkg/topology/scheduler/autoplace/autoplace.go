@@ -40,7 +40,7 @@ func (s *Scheduler) Create(ctx context.Context, vol *volume.Info, req *csi.Creat
 	if err != nil {
 		return err
 	}
-	return s.Resources.Autoplace(ctx, vol.ID, apRequest)
+	return s.Autoplace(ctx, vol, apRequest)
 }
 
 func (s *Scheduler) AccessibleTopologies(ctx context.Context, vol *volume.Info) ([]*csi.Topology, error) {
@@ -52,11 +52,40 @@ func (s *Scheduler) Create(ctx context.Context, vol *volume.Info, req *csi.Creat
 
 	remainingAssignments := params.PlacementCount
 
+	// Skip preferred nodes that don't have enough room for the volume, if we
+	// know which storage pool the volume is going to be placed in.
+	var headroom map[string]int64
+	if params.StoragePool != "" {
+		headroom, err = s.NodePoolCapacity(ctx, params.StoragePool)
+		if err != nil {
+			s.log.WithError(err).Info("unable to determine storage pool capacity, not filtering preferred nodes")
+			headroom = nil
+		}
+	}
+
+	tierNodes, err := s.TierNodes(ctx, params.Tier, params.StoragePool)
+	if err != nil {
+		return err
+	}
+
 	for i, pref := range topos.GetPreferred() {
 		// While there are still preferred nodes and remainingAssignments
 		// attach resources diskfully to those nodes in order of most to least preferred.
 		if p, ok := pref.GetSegments()[topology.LinstorNodeKey]; ok && remainingAssignments > 0 {
-			drc, err := vol.ToDiskfullResourceCreate(p)
+			if params.Excludes(p) || (tierNodes != nil && tierNodes[p] == "") {
+				continue
+			}
+			if headroom != nil && headroom[p] < vol.SizeBytes {
+				s.log.WithFields(logrus.Fields{
+					"volumeID":           vol.ID,
+					"topologyPreference": i,
+					"topologyNode":       p,
+					"freeBytes":          headroom[p],
+					"requiredBytes":      vol.SizeBytes,
+				}).Info("not enough free space on preferred node")
+				continue
+			}
+			drc, err := vol.ToDiskfullResourceCreateInPool(p, tierNodes[p])
 			if err != nil {
 				return err
 			}
@@ -93,7 +122,7 @@ func (s *Scheduler) Create(ctx context.Context, vol *volume.Info, req *csi.Creat
 	if err != nil {
 		return err
 	}
-	return s.Resources.Autoplace(ctx, vol.ID, apRequest)
+	return s.Autoplace(ctx, vol, apRequest)
 }
 
 func (s *Scheduler) AccessibleTopologies(ctx context.Context, vol *volume.Info) ([]*csi.Topology, error) {
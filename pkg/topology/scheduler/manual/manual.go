@@ -20,7 +20,9 @@ package manual
 
 import (
 	"context"
+	"time"
 
+	lapi "github.com/LINBIT/golinstor/client"
 	lc "github.com/LINBIT/linstor-csi/pkg/linstor/highlevelclient"
 	"github.com/LINBIT/linstor-csi/pkg/volume"
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -31,6 +33,10 @@ type Scheduler struct {
 	*lc.HighLevelClient
 }
 
+// rollbackTimeout bounds the removal of partial placements. It has its own
+// deadline, as placements often fail by running out of that of the create call.
+const rollbackTimeout = time.Minute
+
 func NewScheduler(c *lc.HighLevelClient) *Scheduler {
 	return &Scheduler{HighLevelClient: c}
 }
@@ -41,15 +47,48 @@ func (s *Scheduler) Create(ctx context.Context, vol *volume.Info, req *csi.Creat
 		return err
 	}
 
-	for _, placement := range manualPlacements {
+	// Replicas of a tier without a storage pool go to a storage pool of the
+	// tier on their node.
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+	tierNodes, err := s.TierNodes(ctx, params.Tier, params.StoragePool)
+	if err != nil {
+		return err
+	}
+	if tierNodes != nil {
+		// The diskful placements of the nodeList come first.
+		for i, node := range params.NodeList {
+			manualPlacements[i], err = vol.ToDiskfullResourceCreateInPool(node, tierNodes[node])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, placement := range manualPlacements {
 		err := s.Resources.Create(ctx, placement)
 		if err != nil {
+			s.rollback(vol, manualPlacements[:i])
 			return err
 		}
 	}
 	return nil
 }
 
+// rollback removes the placements that were made already, so that retries
+// start clean. Diskless assignments come last and go first.
+func (s *Scheduler) rollback(vol *volume.Info, placed []lapi.ResourceCreate) {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	for j := len(placed) - 1; j >= 0; j-- {
+		//nolint:errcheck
+		s.Resources.Delete(ctx, vol.ID, placed[j].Resource.NodeName)
+	}
+}
+
 func (s *Scheduler) AccessibleTopologies(ctx context.Context, vol *volume.Info) ([]*csi.Topology, error) {
 	return s.GenericAccessibleTopologies(ctx, vol)
 }
@@ -0,0 +1,124 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package mostfree
+
+import (
+	"context"
+	"sort"
+
+	lc "github.com/LINBIT/linstor-csi/pkg/linstor/highlevelclient"
+	"github.com/LINBIT/linstor-csi/pkg/volume"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/sirupsen/logrus"
+)
+
+// Scheduler places volumes on the nodes with the most free space in the
+// volume's storage pool.
+type Scheduler struct {
+	*lc.HighLevelClient
+	log *logrus.Entry
+}
+
+func NewScheduler(c *lc.HighLevelClient, l *logrus.Entry) *Scheduler {
+	return &Scheduler{HighLevelClient: c, log: l}
+}
+
+func (s *Scheduler) Create(ctx context.Context, vol *volume.Info, req *csi.CreateVolumeRequest) error {
+	params, err := volume.NewParameters(vol.Parameters)
+	if err != nil {
+		return err
+	}
+
+	remainingAssignments := params.PlacementCount
+
+	// Without a storage pool there is no capacity to compare.
+	if params.StoragePool != "" {
+		capacity, err := s.NodePoolCapacity(ctx, params.StoragePool)
+		if err != nil {
+			s.log.WithError(err).Info("unable to determine storage pool capacity, falling back to autoplace")
+		}
+
+		tierNodes, err := s.TierNodes(ctx, params.Tier, params.StoragePool)
+		if err != nil {
+			return err
+		}
+
+		for _, node := range mostFreeNodes(capacity, vol.SizeBytes) {
+			if remainingAssignments == 0 {
+				return nil
+			}
+			if params.Excludes(node) || (tierNodes != nil && tierNodes[node] == "") {
+				continue
+			}
+
+			drc, err := vol.ToDiskfullResourceCreate(node)
+			if err != nil {
+				return err
+			}
+			// If attachment fails move onto the node with the next most free space.
+			if err := s.Resources.Create(ctx, drc); err != nil {
+				s.log.WithFields(logrus.Fields{
+					"volumeID":                   vol.ID,
+					"node":                       node,
+					"freeBytes":                  capacity[node],
+					"totalVolumeCount":           params.PlacementCount,
+					"remainingVolumeAssignments": remainingAssignments,
+					"reason":                     err,
+				}).Info("unable to place volume on node")
+				continue
+			}
+			remainingAssignments--
+		}
+	}
+
+	if remainingAssignments == 0 {
+		return nil
+	}
+
+	// Let autoplace place the replicas we couldn't.
+	apRequest, err := vol.ToAutoPlace()
+	if err != nil {
+		return err
+	}
+	return s.Autoplace(ctx, vol, apRequest)
+}
+
+func (s *Scheduler) AccessibleTopologies(ctx context.Context, vol *volume.Info) ([]*csi.Topology, error) {
+	return s.GenericAccessibleTopologies(ctx, vol)
+}
+
+// mostFreeNodes returns the nodes with at least requiredBytes of free space,
+// ordered from most to least free space.
+func mostFreeNodes(capacity map[string]int64, requiredBytes int64) []string {
+	var nodes = make([]string, 0, len(capacity))
+	for node, free := range capacity {
+		if free >= requiredBytes {
+			nodes = append(nodes, node)
+		}
+	}
+
+	sort.Slice(nodes, func(j, k int) bool {
+		if capacity[nodes[j]] == capacity[nodes[k]] {
+			return nodes[j] < nodes[k]
+		}
+		return capacity[nodes[j]] > capacity[nodes[k]]
+	})
+
+	return nodes
+}
@@ -0,0 +1,37 @@
+package mostfree
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMostFreeNodes(t *testing.T) {
+	capacity := map[string]int64{
+		"node-a": 10,
+		"node-b": 30,
+		"node-c": 20,
+		"node-d": 30,
+		"node-e": 5,
+	}
+
+	var tableTests = []struct {
+		required int64
+		expected []string
+	}{
+		{0, []string{"node-b", "node-d", "node-c", "node-a", "node-e"}},
+		{10, []string{"node-b", "node-d", "node-c", "node-a"}},
+		{25, []string{"node-b", "node-d"}},
+		{40, []string{}},
+	}
+
+	for _, tt := range tableTests {
+		actual := mostFreeNodes(capacity, tt.required)
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected that mostFreeNodes(%d) results in %v, but got %v", tt.required, tt.expected, actual)
+		}
+	}
+
+	if nodes := mostFreeNodes(nil, 0); len(nodes) != 0 {
+		t.Errorf("Expected no nodes without capacity, but got %v", nodes)
+	}
+}
@@ -37,6 +37,9 @@ const (
 	// BalancedTopology places remote volumes in the same zone(Rack)
 	// and pick Node, StoragePool, PrefNic based on utilization
 	Balanced
+	// MostFree places volumes on the nodes with the most free space in the
+	// volume's storage pool.
+	MostFree
 )
 
 // LinstorNodeKey refers to a node running the LINSTOR csi node service
@@ -7,9 +7,9 @@ import (
 	"fmt"
 )
 
-const _paramKeyName = "unknownallowremotevolumeaccessautoplaceclientlistdisklessonremainingdisklessstoragepooldonotplacewithregexencryptionfsfsoptslayerlistmountoptsnodelistplacementcountplacementpolicyreplicasondifferentreplicasonsamesizekibstoragepool"
+const _paramKeyName = "unknownalextentsallowremotevolumeaccessautoplacebarrierscachecfilltargetclientlistcmaxratecompressioncompressionstrictdiscarddiskflushesdisklessonremainingdisklessstoragepooldonotplacewithregexencryptionexcludenodesfallbackstoragepoolfsfsckonmountfserrorsfsoptsioweightkeepsnapshotsondeletelayerlistmdflushesmetadatastoragepoolminornumbermountoptsmountretryintervalmountretrytimeoutnodelistpinnedplacementplacementcountplacementpolicypreallocatepreferlocalreadbalancingremountonrecoveryreplicasondifferentreplicasonsameresourcedefinitionuuidresyncprioritysizekibstoragepoolsyncaftersyncratetargetgidtargetmodetargetuidtiebreakerdisklesspooltierwipeondelete"
 
-var _paramKeyIndex = [...]uint8{0, 7, 30, 39, 49, 68, 87, 106, 116, 118, 124, 133, 142, 150, 164, 179, 198, 212, 219, 230}
+var _paramKeyIndex = [...]uint16{0, 7, 16, 39, 48, 56, 61, 72, 82, 90, 101, 118, 125, 136, 155, 174, 193, 203, 215, 234, 236, 247, 255, 261, 269, 290, 299, 308, 327, 338, 347, 365, 382, 390, 396, 405, 419, 434, 445, 456, 469, 486, 505, 519, 541, 555, 562, 573, 582, 590, 599, 609, 618, 640, 644, 656}
 
 func (i paramKey) String() string {
 	if i < 0 || i >= paramKey(len(_paramKeyIndex)-1) {
@@ -18,28 +18,64 @@ func (i paramKey) String() string {
 	return _paramKeyName[_paramKeyIndex[i]:_paramKeyIndex[i+1]]
 }
 
-var _paramKeyValues = []paramKey{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18}
+var _paramKeyValues = []paramKey{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40, 41, 42, 43, 44, 45, 46, 47, 48, 49, 50, 51, 52, 53, 54}
 
 var _paramKeyNameToValueMap = map[string]paramKey{
 	_paramKeyName[0:7]:     0,
-	_paramKeyName[7:30]:    1,
-	_paramKeyName[30:39]:   2,
-	_paramKeyName[39:49]:   3,
-	_paramKeyName[49:68]:   4,
-	_paramKeyName[68:87]:   5,
-	_paramKeyName[87:106]:  6,
-	_paramKeyName[106:116]: 7,
-	_paramKeyName[116:118]: 8,
-	_paramKeyName[118:124]: 9,
-	_paramKeyName[124:133]: 10,
-	_paramKeyName[133:142]: 11,
-	_paramKeyName[142:150]: 12,
-	_paramKeyName[150:164]: 13,
-	_paramKeyName[164:179]: 14,
-	_paramKeyName[179:198]: 15,
-	_paramKeyName[198:212]: 16,
-	_paramKeyName[212:219]: 17,
-	_paramKeyName[219:230]: 18,
+	_paramKeyName[7:16]:    1,
+	_paramKeyName[16:39]:   2,
+	_paramKeyName[39:48]:   3,
+	_paramKeyName[48:56]:   4,
+	_paramKeyName[56:61]:   5,
+	_paramKeyName[61:72]:   6,
+	_paramKeyName[72:82]:   7,
+	_paramKeyName[82:90]:   8,
+	_paramKeyName[90:101]:  9,
+	_paramKeyName[101:118]: 10,
+	_paramKeyName[118:125]: 11,
+	_paramKeyName[125:136]: 12,
+	_paramKeyName[136:155]: 13,
+	_paramKeyName[155:174]: 14,
+	_paramKeyName[174:193]: 15,
+	_paramKeyName[193:203]: 16,
+	_paramKeyName[203:215]: 17,
+	_paramKeyName[215:234]: 18,
+	_paramKeyName[234:236]: 19,
+	_paramKeyName[236:247]: 20,
+	_paramKeyName[247:255]: 21,
+	_paramKeyName[255:261]: 22,
+	_paramKeyName[261:269]: 23,
+	_paramKeyName[269:290]: 24,
+	_paramKeyName[290:299]: 25,
+	_paramKeyName[299:308]: 26,
+	_paramKeyName[308:327]: 27,
+	_paramKeyName[327:338]: 28,
+	_paramKeyName[338:347]: 29,
+	_paramKeyName[347:365]: 30,
+	_paramKeyName[365:382]: 31,
+	_paramKeyName[382:390]: 32,
+	_paramKeyName[390:396]: 33,
+	_paramKeyName[396:405]: 34,
+	_paramKeyName[405:419]: 35,
+	_paramKeyName[419:434]: 36,
+	_paramKeyName[434:445]: 37,
+	_paramKeyName[445:456]: 38,
+	_paramKeyName[456:469]: 39,
+	_paramKeyName[469:486]: 40,
+	_paramKeyName[486:505]: 41,
+	_paramKeyName[505:519]: 42,
+	_paramKeyName[519:541]: 43,
+	_paramKeyName[541:555]: 44,
+	_paramKeyName[555:562]: 45,
+	_paramKeyName[562:573]: 46,
+	_paramKeyName[573:582]: 47,
+	_paramKeyName[582:590]: 48,
+	_paramKeyName[590:599]: 49,
+	_paramKeyName[599:609]: 50,
+	_paramKeyName[609:618]: 51,
+	_paramKeyName[618:640]: 52,
+	_paramKeyName[640:644]: 53,
+	_paramKeyName[644:656]: 54,
 }
 
 // paramKeyString retrieves an enum value from the enum constants string name.
@@ -22,9 +22,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	lc "github.com/LINBIT/golinstor"
@@ -32,6 +35,10 @@ import (
 	"github.com/LINBIT/linstor-csi/pkg/linstor"
 	"github.com/LINBIT/linstor-csi/pkg/topology"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/haySwim/data"
+	"github.com/pborman/uuid"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
 )
 
 // Info provides the everything need to manipulate volumes.
@@ -44,6 +51,100 @@ type Info struct {
 	Readonly     bool              `json:"readonly"`
 	Parameters   map[string]string `json:"parameters"`
 	Snapshots    []*SnapInfo       `json:"snapshots"`
+	// GrowFSOnMount is set on volumes that were cloned into a larger device
+	// than their source, so the filesystem is grown when first mounted.
+	GrowFSOnMount bool `json:"growFSOnMount"`
+	// Preallocated is set on volumes whose blocks were all written after
+	// creation, see Parameters.Preallocate. Their filesystems are created
+	// without discarding the device.
+	Preallocated bool `json:"preallocated,omitempty"`
+	// Deleted is set on volumes that were deleted, but are kept around for
+	// their snapshots or until their deletion grace period elapsed.
+	Deleted bool `json:"deleted"`
+	// DeletionTime is when the volume was deleted, if its removal was
+	// deferred by a deletion grace period.
+	DeletionTime *time.Time `json:"deletionTime,omitempty"`
+	// Kubernetes refers to the Kubernetes objects the volume belongs to, if known.
+	Kubernetes *KubernetesRef `json:"kubernetes,omitempty"`
+	// DesiredReplicas is the number of diskful replicas the volume should
+	// have, overriding its placementCount. Zero if unset.
+	DesiredReplicas int `json:"desiredReplicas,omitempty"`
+	// FSType is the filesystem chosen from the candidates of the fs parameter
+	// when the volume was first mounted, so later mounts use the same one.
+	FSType string `json:"fsType,omitempty"`
+}
+
+// KubernetesRef refers to the Kubernetes objects a volume was provisioned for.
+type KubernetesRef struct {
+	PVName       string `json:"pvName,omitempty"`
+	PVCName      string `json:"pvcName,omitempty"`
+	PVCNamespace string `json:"pvcNamespace,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+}
+
+// ExportSpec serializes the complete state of vol as YAML, which can be
+// turned back into the volume with ImportSpec.
+func ExportSpec(vol *Info) ([]byte, error) {
+	spec, err := yaml.Marshal(vol)
+	if err != nil {
+		return nil, fmt.Errorf("unable to export volume %s: %v", vol.Name, err)
+	}
+	return spec, nil
+}
+
+// ImportSpec deserializes a volume exported with ExportSpec. JSON, like that
+// of volume annotations, is accepted as well.
+func ImportSpec(spec []byte) (*Info, error) {
+	vol := &Info{
+		Parameters: make(map[string]string),
+		Snapshots:  make([]*SnapInfo, 0),
+	}
+	if err := yaml.Unmarshal(spec, vol); err != nil {
+		return nil, fmt.Errorf("unable to import volume: %v", err)
+	}
+	if vol.Name == "" {
+		return nil, fmt.Errorf("unable to import volume: no name in %q", spec)
+	}
+	if _, err := NewParameters(vol.Parameters); err != nil {
+		return nil, fmt.Errorf("unable to import volume %s: %v", vol.Name, err)
+	}
+	return vol, nil
+}
+
+// Parameter keys that carry Kubernetes object references. The PV and PVC keys
+// are passed by the external-provisioner when run with --extra-create-metadata.
+const (
+	PVNameKey       = "csi.storage.k8s.io/pv/name"
+	PVCNameKey      = "csi.storage.k8s.io/pvc/name"
+	PVCNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+	StorageClassKey = "csi.storage.k8s.io/storageclass/name"
+)
+
+// SplitKubernetesRef separates the Kubernetes object references from the rest
+// of the parameters. The returned KubernetesRef is nil if params contain none.
+func SplitKubernetesRef(params map[string]string) (*KubernetesRef, map[string]string) {
+	var ref KubernetesRef
+	var rest = make(map[string]string, len(params))
+
+	for k, v := range params {
+		switch k {
+		case PVNameKey:
+			ref.PVName = v
+		case PVCNameKey:
+			ref.PVCName = v
+		case PVCNamespaceKey:
+			ref.PVCNamespace = v
+		case StorageClassKey:
+			ref.StorageClass = v
+		default:
+			rest[k] = v
+		}
+	}
+
+	if ref == (KubernetesRef{}) {
+		return nil, rest
+	}
+	return &ref, rest
 }
 
 //go:generate enumer -type=paramKey
@@ -51,24 +152,60 @@ type paramKey int
 
 const (
 	unknown paramKey = iota
+	alextents
 	allowremotevolumeaccess
 	autoplace
+	barriers
+	cache
+	cfilltarget
 	clientlist
+	cmaxrate
+	compression
+	compressionstrict
+	discard
+	diskflushes
 	disklessonremaining
 	disklessstoragepool
 	donotplacewithregex
 	encryption
+	excludenodes
+	fallbackstoragepool
 	fs
+	fsckonmount
+	fserrors
 	fsopts
+	ioweight
+	keepsnapshotsondelete
 	layerlist
+	mdflushes
+	metadatastoragepool
+	minornumber
 	mountopts
+	mountretryinterval
+	mountretrytimeout
 	nodelist
+	pinned
+	placement
 	placementcount
 	placementpolicy
+	preallocate
+	preferlocal
+	readbalancing
+	remountonrecovery
 	replicasondifferent
 	replicasonsame
+	resourcedefinitionuuid
+	resyncpriority
 	sizekib
 	storagepool
+	syncafter
+	syncrate
+	targetgid
+	targetmode
+	targetuid
+	tiebreakerdisklesspool
+	tier
+	wipeondelete
 )
 
 // Parameters configuration for linstor volumes.
@@ -80,6 +217,13 @@ type Parameters struct {
 	// at the time that the volume is first created. Specifying this overrides any
 	// other automatic placement rules.
 	NodeList []string
+	// Placement is an explicit placement of the volume's diskful replicas and
+	// diskless assignments, with a storage pool per replica. It fills in
+	// NodeList and ClientList, which must not be given as well.
+	Placement *Placement
+	// ExcludeNodes are nodes that must not get replicas of or attach the
+	// volume, e.g. nodes pending decommission. Separated by spaces or commas.
+	ExcludeNodes []string
 	// ReplicasOnDifferent is a list that corresonds to the `linstor resource create`
 	// option of the same name.
 	ReplicasOnDifferent []string
@@ -88,35 +232,243 @@ type Parameters struct {
 	ReplicasOnSame []string
 	// DisklessStoragePool is the diskless storage pool to use for diskless assignments.
 	DisklessStoragePool string
+	// TiebreakerDisklessPool is the diskless storage pool of the tiebreaker
+	// assignment added to volumes with two diskful replicas, which gives them
+	// quorum. Empty adds no tiebreaker.
+	TiebreakerDisklessPool string
 	// DoNotPlaceWithRegex corresonds to the `linstor resource create`
 	// option of the same name.
 	DoNotPlaceWithRegex string
 	// FS is the filesystem type: ext4, xfs, and so on.
 	FS string
+	// FSCandidates are the filesystems of a comma separated fs parameter like
+	// "xfs,ext4", in order of preference. FS is the first of them.
+	FSCandidates []string
+	// FSCKOnMount is whether filesystems are checked before they are mounted:
+	// off, check to only report errors, or repair to fix them where possible.
+	FSCKOnMount string
+	// Barriers is on or off to explicitly enable or disable write barriers at
+	// mount time. Only ext filesystems can disable them. Empty leaves the
+	// filesystem's default.
+	Barriers string
+	// Discard is how freed blocks of filesystems are returned to thin storage:
+	// mount to pass the discard mount option, fstrim to run fstrim
+	// periodically, or off to do neither.
+	Discard string
+	// FSErrors is the behavior of ext filesystems when they encounter an error,
+	// passed at mount time as the errors= mount option: continue, remount-ro, or panic.
+	FSErrors string
 	// FSOpts is a string of filesystem options passed at mount time.
 	FSOpts string
 	// MountOpts is a string of mount options passed at mount time. Comma
 	// separated like in /etc/fstab.
 	MountOpts string
+	// MountRetryTimeout is how long mounting is retried while it fails with
+	// transient errors, like a device that is busy or not ready yet, waiting
+	// MountRetryInterval between attempts. Zero mounts only once.
+	MountRetryTimeout  time.Duration
+	MountRetryInterval time.Duration
 	// StoragePool is the storage pool to use for diskful assignments.
 	StoragePool string
-	SizeKiB     uint64
+	// MetadataStoragePool is the storage pool for external DRBD metadata of
+	// the volume. Empty keeps metadata internal, next to the data.
+	MetadataStoragePool string
+	// ResourceDefinitionUUID is requested from LINSTOR as the UUID of the
+	// resource definition instead of a generated one, to keep the identity of
+	// imported volumes. Empty lets LINSTOR generate it.
+	ResourceDefinitionUUID string
+	// Tier is the storage tier, like gold or silver, whose storage pools get
+	// the volume's replicas. Storage pools belong to the tier named by their
+	// Aux/tier property, or else by that of their node. Empty places
+	// replicas regardless of tiers.
+	Tier string
+	// FallbackStoragePool is used instead of StoragePool if it doesn't have
+	// enough free space for the volume.
+	FallbackStoragePool string
+	// ResyncPriority orders volumes waiting for other resyncs to finish when
+	// concurrent resyncs are limited, higher priorities go first.
+	ResyncPriority int
+	// SyncAfter is the LINSTOR resource that must finish resyncing before this
+	// volume resyncs, optionally followed by /volume-number.
+	SyncAfter string
+	// ALExtents is the size of DRBD's activity log in extents of 4MiB, from
+	// MinALExtents to MaxALExtents. Larger activity logs need fewer metadata
+	// updates under write-heavy load, but more data to resync after a primary
+	// crashes. Zero leaves DRBD's default.
+	ALExtents int
+	// SyncRate, CMaxRate, and CFillTarget tune DRBD's resync of the volume.
+	// SyncRate is the fixed resync rate and CMaxRate the upper limit of the
+	// dynamic resync rate in bytes per second. CFillTarget is the amount of
+	// resync data in flight the dynamic controller aims for, in bytes. Zero
+	// leaves DRBD's default.
+	SyncRate    int64
+	CMaxRate    int64
+	CFillTarget int64
+	// IOWeight is the cgroup IO weight of the volume's device on the nodes
+	// it is mounted on, from MinIOWeight to MaxIOWeight. Zero leaves it unset.
+	IOWeight int
+	// TargetMode is the permission mode set on the root directory of mounted
+	// filesystems. Zero leaves it unchanged.
+	TargetMode os.FileMode
+	// TargetUID and TargetGID are the owner set on the root directory of
+	// mounted filesystems. -1 leaves them unchanged.
+	TargetUID int
+	TargetGID int
+	SizeKiB   uint64
 	// PlacementCount is the number of replicas of the volume in total.
 	PlacementCount int32
+	// MinorNumber is the DRBD minor number requested for the volume's device.
+	// Zero lets LINSTOR pick one.
+	MinorNumber int32
 	// Disklessonremaining corresonds to the `linstor resource create`
 	// option of the same name.
 	Disklessonremaining bool
+	// KeepSnapshotsOnDelete if true, deleting a volume with snapshots keeps
+	// the volume's storage until its last snapshot is deleted.
+	KeepSnapshotsOnDelete bool
+	// WipeOnDelete if true, the volume's blocks are discarded before it is
+	// deleted, rather than only removing its metadata. Volumes that cannot
+	// be wiped are not deleted.
+	WipeOnDelete bool
+	// Preallocate if true, all blocks of the volume are written with random
+	// data once after it is created, so that thin storage reserves space for
+	// them. This runs in the background, the volume cannot be attached until
+	// it is done.
+	Preallocate bool
+	// Pinned if true, the volume's replicas stay on their nodes when nodes
+	// are evacuated.
+	Pinned bool
+	// PreferLocal if true, nodes consuming the volume get a diskful replica
+	// of it, rather than a diskless one, if they have room for it.
+	PreferLocal bool
+	// Compression is the compression algorithm of volumes in ZFS storage
+	// pools, e.g. lz4, zstd, or off. Empty leaves the pool's default.
+	Compression string
+	// CompressionStrict if true, refuses to create volumes with Compression
+	// in storage pools that don't support it, rather than ignoring it.
+	CompressionStrict bool
 	// Encrypt volumes if true.
 	Encryption bool
+	// CachePool is the storage pool holding the dm-cache of the volume. Empty
+	// means the volume is not cached.
+	CachePool string
 	// AllowRemoteVolumeAccess if true, volumes may be accessed over the network.
 	AllowRemoteVolumeAccess bool
+	// DiskFlushes and MDFlushes are on or off to enable or disable DRBD's
+	// flushes of data and metadata writes to the backing device. Disabling
+	// them is only safe on storage with a non-volatile write cache, otherwise
+	// data may be lost on power failure. Empty leaves DRBD's default.
+	DiskFlushes string
+	MDFlushes   string
+	// ReadBalancing is the DRBD read-balancing policy used to spread reads
+	// across replicas, e.g. prefer-local or round-robin.
+	ReadBalancing string
+	// RemountOnRecovery if true, the node watches filesystems that were remounted
+	// read-only due to errors and remounts them read-write once the device recovers.
+	RemountOnRecovery bool
 	// LayerList is a list that corresonds to the `linstor resource create`
 	// option of the same name.
 	LayerList []lapi.LayerType
+	// AddedLayers are the layers that were missing from an explicitly given
+	// LayerList for the requested features, and were added to it.
+	AddedLayers []lapi.LayerType
 	// PlacementPolicy determines where volumes are created.
 	PlacementPolicy topology.PlacementPolicy
 }
 
+// Placement is the placement parameter, a JSON object like
+// {"diskful": {"node-a": "ssd", "node-b": ""}, "diskless": ["node-c"]}.
+type Placement struct {
+	// Diskful maps the nodes of diskful replicas to their storage pool. An
+	// empty storage pool means the volume's StoragePool.
+	Diskful map[string]string `json:"diskful"`
+	// Diskless are the nodes of diskless assignments.
+	Diskless []string `json:"diskless,omitempty"`
+}
+
+// StoragePool returns the storage pool of the diskful replica on node, or
+// fallback if it names none.
+func (p *Placement) StoragePool(node, fallback string) string {
+	if p == nil || p.Diskful[node] == "" {
+		return fallback
+	}
+	return p.Diskful[node]
+}
+
+// parsePlacement parses and validates the placement parameter.
+func parsePlacement(v string) (*Placement, error) {
+	var placement Placement
+	dec := json.NewDecoder(strings.NewReader(v))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&placement); err != nil {
+		return nil, fmt.Errorf("bad parameters: placement must be a JSON object with diskful and diskless nodes: %v", err)
+	}
+	if len(placement.Diskful) == 0 {
+		return nil, fmt.Errorf("bad parameters: placement needs at least one diskful node")
+	}
+
+	var seen = make(map[string]bool, len(placement.Diskless))
+	for _, n := range placement.Diskless {
+		if _, ok := placement.Diskful[n]; ok || seen[n] {
+			return nil, fmt.Errorf("bad parameters: node %s is placed more than once", n)
+		}
+		seen[n] = true
+	}
+	return &placement, nil
+}
+
+// nodes returns the diskful nodes of the placement, sorted.
+func (p *Placement) nodes() []string {
+	var nodes = make([]string, 0, len(p.Diskful))
+	for n := range p.Diskful {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// legacyParamKeys maps parameter names used by older versions of this driver
+// to their current equivalents. Keys are lower case.
+var legacyParamKeys = map[string]paramKey{
+	"replicationcount": autoplace,
+	"disklesspool":     disklessstoragepool,
+	"fstype":           fs,
+	"mountoptions":     mountopts,
+}
+
+// warnedLegacyParamKeys records legacy parameter names that were already
+// warned about, so the warning is logged once per name.
+var warnedLegacyParamKeys sync.Map
+
+// resolveParamKey returns the paramKey for a parameter name, accepting legacy
+// names as well.
+func resolveParamKey(k string) (paramKey, bool, error) {
+	key, err := paramKeyString(strings.ToLower(k))
+	if err == nil {
+		return key, false, nil
+	}
+	if legacy, ok := legacyParamKeys[strings.ToLower(k)]; ok {
+		return legacy, true, nil
+	}
+	return key, false, err
+}
+
+func warnLegacyParamKey(k string, key paramKey, ignored bool) {
+	if _, warned := warnedLegacyParamKeys.LoadOrStore(strings.ToLower(k), true); warned {
+		return
+	}
+
+	entry := logrus.WithFields(logrus.Fields{
+		"parameter":   k,
+		"replacement": key.String(),
+	})
+	if ignored {
+		entry.Warn("deprecated parameter ignored in favor of its replacement")
+		return
+	}
+	entry.Warn("deprecated parameter, use its replacement instead")
+}
+
 // DefaultDisklessStoragePoolName is the hidden diskless storage pool that linstor
 // assigned diskless volumes to if they're not given a user created DisklessStoragePool.
 const DefaultDisklessStoragePoolName = "DfltDisklessStorPool"
@@ -132,23 +484,50 @@ func NewParameters(params map[string]string) (Parameters, error) {
 		Encryption:              false,
 		PlacementPolicy:         topology.AutoPlace,
 		AllowRemoteVolumeAccess: true,
+		FSCKOnMount:             FSCKOff,
+		Discard:                 DiscardOff,
+		MountRetryInterval:      DefaultMountRetryInterval,
+		TargetUID:               -1,
+		TargetGID:               -1,
+	}
+
+	// explicitLayers is set if the layer list is not the default.
+	var explicitLayers bool
+	// explicitCount is set if the placement count is given.
+	var explicitCount bool
+
+	// Canonical parameter names take precedence over legacy ones.
+	var canonical = make(map[paramKey]bool, len(params))
+	for k := range params {
+		if key, err := paramKeyString(strings.ToLower(k)); err == nil {
+			canonical[key] = true
+		}
 	}
 
 	for k, v := range params {
-		key, err := paramKeyString(strings.ToLower(k))
+		key, legacy, err := resolveParamKey(k)
 		if err != nil {
 			return p, fmt.Errorf("invalid parameter: %v", err)
 		}
+		if legacy {
+			warnLegacyParamKey(k, key, canonical[key])
+			if canonical[key] {
+				continue
+			}
+		}
 
 		switch key {
 		case nodelist:
 			p.NodeList = strings.Split(v, " ")
+		case excludenodes:
+			p.ExcludeNodes = strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' })
 		case layerlist:
 			l, err := ParseLayerList(v)
 			if err != nil {
 				return p, err
 			}
 			p.LayerList = l
+			explicitLayers = true
 		case replicasonsame:
 			p.ReplicasOnSame = strings.Split(v, " ")
 		case replicasondifferent:
@@ -157,6 +536,10 @@ func NewParameters(params map[string]string) (Parameters, error) {
 			p.StoragePool = v
 		case disklessstoragepool:
 			p.DisklessStoragePool = v
+		case tiebreakerdisklesspool:
+			p.TiebreakerDisklessPool = v
+		case tier:
+			p.Tier = strings.TrimSpace(v)
 		case autoplace, placementcount:
 			if v == "" {
 				v = "1"
@@ -166,12 +549,20 @@ func NewParameters(params map[string]string) (Parameters, error) {
 				return p, fmt.Errorf("bad parameters: unable to parse %q as a 32 bit integer", v)
 			}
 			p.PlacementCount = int32(count)
+			explicitCount = true
+		case placement:
+			pl, err := parsePlacement(v)
+			if err != nil {
+				return p, err
+			}
+			p.Placement = pl
 		case donotplacewithregex:
 			p.DoNotPlaceWithRegex = v
 		case encryption:
-			e, err := strconv.ParseBool(v)
+			// Never fall back to unencrypted volumes on a typo.
+			e, err := parseBool(v)
 			if err != nil {
-				return p, err
+				return p, fmt.Errorf("bad parameters: encryption must be a boolean, got %q", v)
 			}
 			p.Encryption = e
 		case disklessonremaining:
@@ -198,7 +589,14 @@ func NewParameters(params map[string]string) (Parameters, error) {
 			}
 			p.SizeKiB = size
 		case fs:
-			p.FS = v
+			p.FSCandidates = nil
+			for _, f := range strings.Split(v, ",") {
+				if f = strings.TrimSpace(f); f == "" {
+					return p, fmt.Errorf("bad parameters: fs must be a filesystem or a comma separated list of them, got %q", v)
+				}
+				p.FSCandidates = append(p.FSCandidates, f)
+			}
+			p.FS = p.FSCandidates[0]
 		case placementpolicy:
 			policy, err := topology.PlacementPolicyString(v)
 			if err != nil {
@@ -209,6 +607,196 @@ func NewParameters(params map[string]string) (Parameters, error) {
 			p.MountOpts = v
 		case fsopts:
 			p.FSOpts = v
+		case mountretrytimeout, mountretryinterval:
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return p, fmt.Errorf("bad parameters: %s must be a duration like 30s, got %q", k, v)
+			}
+			if key == mountretrytimeout {
+				p.MountRetryTimeout = d
+			} else if d > 0 {
+				p.MountRetryInterval = d
+			}
+		case fsckonmount:
+			if !isValidFSCKOnMount(v) {
+				return p, fmt.Errorf("invalid fsckOnMount %q, must be one of %v", v, validFSCKOnMount)
+			}
+			p.FSCKOnMount = v
+		case diskflushes:
+			if !isValidOnOff(v) {
+				return p, fmt.Errorf("invalid diskFlushes %q, must be one of %v", v, validOnOff)
+			}
+			p.DiskFlushes = v
+		case mdflushes:
+			if !isValidOnOff(v) {
+				return p, fmt.Errorf("invalid mdFlushes %q, must be one of %v", v, validOnOff)
+			}
+			p.MDFlushes = v
+		case compression:
+			if !isValidCompression(v) {
+				return p, fmt.Errorf("invalid compression %q, must be one of %v", v, validCompression)
+			}
+			p.Compression = v
+		case compressionstrict:
+			c, err := parseBool(v)
+			if err != nil {
+				return p, fmt.Errorf("bad parameters: compressionStrict must be a boolean, got %q", v)
+			}
+			p.CompressionStrict = c
+		case barriers:
+			if !isValidOnOff(v) {
+				return p, fmt.Errorf("invalid barriers %q, must be one of %v", v, validOnOff)
+			}
+			p.Barriers = v
+		case discard:
+			if !isValidDiscard(v) {
+				return p, fmt.Errorf("invalid discard %q, must be one of %v", v, validDiscard)
+			}
+			p.Discard = v
+		case fserrors:
+			if !isValidFSErrors(v) {
+				return p, fmt.Errorf("invalid fsErrors %q, must be one of %v", v, validFSErrors)
+			}
+			p.FSErrors = v
+		case minornumber:
+			minor, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || minor < 1 || minor > maxMinorNumber {
+				return p, fmt.Errorf("bad parameters: minorNumber must be an integer between 1 and %d, got %q", maxMinorNumber, v)
+			}
+			p.MinorNumber = int32(minor)
+		case alextents:
+			al, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || al < MinALExtents || al > MaxALExtents {
+				return p, fmt.Errorf("bad parameters: alExtents must be an integer between %d and %d, got %q", MinALExtents, MaxALExtents, v)
+			}
+			p.ALExtents = int(al)
+		case syncrate:
+			r, err := parseDrbdQuantity(v, kiB, MinSyncRate, MaxSyncRate)
+			if err != nil {
+				return p, fmt.Errorf("bad parameters: syncRate %v", err)
+			}
+			p.SyncRate = r
+		case cmaxrate:
+			r, err := parseDrbdQuantity(v, kiB, MinCMaxRate, MaxCMaxRate)
+			if err != nil {
+				return p, fmt.Errorf("bad parameters: cMaxRate %v", err)
+			}
+			p.CMaxRate = r
+		case cfilltarget:
+			f, err := parseDrbdQuantity(v, sector, MinCFillTarget, MaxCFillTarget)
+			if err != nil {
+				return p, fmt.Errorf("bad parameters: cFillTarget %v", err)
+			}
+			p.CFillTarget = f
+		case ioweight:
+			w, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || w < MinIOWeight || w > MaxIOWeight {
+				return p, fmt.Errorf("bad parameters: ioWeight must be an integer between %d and %d, got %q", MinIOWeight, MaxIOWeight, v)
+			}
+			p.IOWeight = int(w)
+		case keepsnapshotsondelete:
+			k, err := parseBool(v)
+			if err != nil {
+				return p, fmt.Errorf("bad parameters: keepSnapshotsOnDelete must be a boolean, got %q", v)
+			}
+			p.KeepSnapshotsOnDelete = k
+		case wipeondelete:
+			w, err := parseBool(v)
+			if err != nil {
+				return p, fmt.Errorf("bad parameters: wipeOnDelete must be a boolean, got %q", v)
+			}
+			p.WipeOnDelete = w
+		case preallocate:
+			pre, err := parseBool(v)
+			if err != nil {
+				return p, fmt.Errorf("bad parameters: preallocate must be a boolean, got %q", v)
+			}
+			p.Preallocate = pre
+		case pinned:
+			pin, err := parseBool(v)
+			if err != nil {
+				return p, fmt.Errorf("bad parameters: pinned must be a boolean, got %q", v)
+			}
+			p.Pinned = pin
+		case preferlocal:
+			local, err := parseBool(v)
+			if err != nil {
+				return p, fmt.Errorf("bad parameters: preferLocal must be a boolean, got %q", v)
+			}
+			p.PreferLocal = local
+		case resyncpriority:
+			prio, err := strconv.Atoi(v)
+			if err != nil {
+				return p, fmt.Errorf("bad parameters: resyncPriority must be an integer, got %q", v)
+			}
+			p.ResyncPriority = prio
+		case syncafter:
+			p.SyncAfter = v
+		case metadatastoragepool:
+			p.MetadataStoragePool = v
+		case cache:
+			p.CachePool = v
+		case resourcedefinitionuuid:
+			id := uuid.Parse(v)
+			if id == nil {
+				return p, fmt.Errorf("bad parameters: resourceDefinitionUUID must be a UUID, got %q", v)
+			}
+			p.ResourceDefinitionUUID = id.String()
+		case fallbackstoragepool:
+			p.FallbackStoragePool = v
+		case targetmode:
+			m, err := strconv.ParseUint(v, 8, 32)
+			if err != nil || m > 0777 {
+				return p, fmt.Errorf("bad parameters: targetMode must be an octal mode like 0770, got %q", v)
+			}
+			p.TargetMode = os.FileMode(m)
+		case targetuid:
+			u, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || u < 0 {
+				return p, fmt.Errorf("bad parameters: targetUID must be a non-negative integer, got %q", v)
+			}
+			p.TargetUID = int(u)
+		case targetgid:
+			g, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || g < 0 {
+				return p, fmt.Errorf("bad parameters: targetGID must be a non-negative integer, got %q", v)
+			}
+			p.TargetGID = int(g)
+		case readbalancing:
+			if !isValidReadBalancing(v) {
+				return p, fmt.Errorf("invalid readBalancing %q, must be one of %v", v, validReadBalancing)
+			}
+			p.ReadBalancing = v
+		case remountonrecovery:
+			r, err := parseBool(v)
+			if err != nil {
+				return p, fmt.Errorf("bad parameters: remountOnRecovery must be a boolean, got %q", v)
+			}
+			p.RemountOnRecovery = r
+		}
+	}
+
+	// Requested features must not be silently ignored for want of a layer.
+	var added []lapi.LayerType
+	p.LayerList, added = completeLayerList(p.LayerList, p.requiredLayers())
+	if explicitLayers {
+		p.AddedLayers = added
+	}
+
+	if p.Placement != nil {
+		if len(p.NodeList)+len(p.ClientList) != 0 {
+			return p, fmt.Errorf("bad parameters: placement and nodeList or clientList are mutually exclusive")
+		}
+		if explicitCount && int(p.PlacementCount) != len(p.Placement.Diskful) {
+			return p, fmt.Errorf("bad parameters: placement has %d diskful nodes, but %d replicas are requested", len(p.Placement.Diskful), p.PlacementCount)
+		}
+		p.NodeList = p.Placement.nodes()
+		p.ClientList = p.Placement.Diskless
+	}
+
+	for _, n := range append(p.NodeList, p.ClientList...) {
+		if p.Excludes(n) {
+			return p, fmt.Errorf("bad parameters: node %s is in excludeNodes, but also explicitly requested", n)
 		}
 	}
 
@@ -220,15 +808,423 @@ func NewParameters(params map[string]string) (Parameters, error) {
 		p.DoNotPlaceWithRegex = ""
 		p.PlacementPolicy = topology.Manual
 	}
+	if p.Placement != nil {
+		p.PlacementCount = int32(len(p.Placement.Diskful))
+	}
 
 	return p, nil
 }
 
+// Excludes returns true if node is one of the ExcludeNodes.
+func (p Parameters) Excludes(node string) bool {
+	for _, n := range p.ExcludeNodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// ParamDiff is a parameter whose value on a volume differs from the desired one.
+// Key is the name of the Parameters field.
+type ParamDiff struct {
+	Key     string
+	Current string
+	Desired string
+}
+
+// DiffParameters returns the parameters of vol that differ from the desired
+// parameters, in the order of the Parameters fields. Parameters are compared
+// by their effective values, so legacy names, different spellings of the same
+// value, and defaults do not count as differences.
+func DiffParameters(vol *Info, desired map[string]string) ([]ParamDiff, error) {
+	current, err := NewParameters(vol.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compare parameters of %s: %v", vol.Name, err)
+	}
+	if vol.DesiredReplicas != 0 {
+		current.PlacementCount = int32(vol.DesiredReplicas)
+	}
+
+	_, desired = SplitKubernetesRef(desired)
+	want, err := NewParameters(desired)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compare parameters of %s: %v", vol.Name, err)
+	}
+
+	var diffs = make([]ParamDiff, 0)
+	c, w := reflect.ValueOf(current), reflect.ValueOf(want)
+	for i := 0; i < c.NumField(); i++ {
+		name := c.Type().Field(i).Name
+		// Added layers are bookkeeping, the layer list itself is compared.
+		if name == "AddedLayers" {
+			continue
+		}
+		if !reflect.DeepEqual(c.Field(i).Interface(), w.Field(i).Interface()) {
+			diffs = append(diffs, ParamDiff{
+				Key:     name,
+				Current: fmt.Sprintf("%v", c.Field(i).Interface()),
+				Desired: fmt.Sprintf("%v", w.Field(i).Interface()),
+			})
+		}
+	}
+	return diffs, nil
+}
+
+// MergeParameters returns params with changes applied. Parameters of params
+// that changes sets under any name, including legacy ones, are replaced, or
+// removed if changes sets them to an empty value.
+func MergeParameters(params, changes map[string]string) map[string]string {
+	var merged = make(map[string]string, len(params)+len(changes))
+	var changed = make(map[paramKey]bool, len(changes))
+	for k := range changes {
+		if key, _, err := resolveParamKey(k); err == nil {
+			changed[key] = true
+		}
+	}
+	for k, v := range params {
+		if key, _, err := resolveParamKey(k); err == nil && changed[key] {
+			continue
+		}
+		merged[k] = v
+	}
+	for k, v := range changes {
+		if v != "" {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Range of DRBD activity log sizes.
+const (
+	MinALExtents = 67
+	MaxALExtents = 65534
+)
+
+// Ranges of DRBD's resync tuning options, in bytes (per second).
+const (
+	MinSyncRate    = 1 * kiB
+	MaxSyncRate    = 4 * 1024 * 1024 * kiB
+	MinCMaxRate    = 250 * kiB
+	MaxCMaxRate    = 4 * 1024 * 1024 * kiB
+	MinCFillTarget = 0
+	MaxCFillTarget = 1024 * 1024 * sector
+)
+
+// Units of DRBD options.
+const (
+	sector = 512
+	kiB    = 1024
+)
+
+// byteSuffixes are the binary unit suffixes of byte quantities.
+var byteSuffixes = map[string]int64{
+	"":  1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+}
+
+// parseDrbdQuantity parses a byte quantity like 512k, 100M, or 1G, returning
+// it in bytes. It must be a multiple of unit, the unit of the DRBD option it
+// is for, and within min and max.
+func parseDrbdQuantity(v string, unit, min, max int64) (int64, error) {
+	q := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(v)), "b")
+	num := strings.TrimRight(q, "kmg")
+	mult, ok := byteSuffixes[q[len(num):]]
+	if !ok {
+		return 0, fmt.Errorf("must be a byte quantity like 512k, 100M, or 1G, got %q", v)
+	}
+
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("must be a byte quantity like 512k, 100M, or 1G, got %q", v)
+	}
+
+	bytes := n * mult
+	if bytes%unit != 0 {
+		return 0, fmt.Errorf("must be a multiple of %d bytes, got %q", unit, v)
+	}
+	if bytes < min || bytes > max {
+		return 0, fmt.Errorf("must be between %d and %d bytes, got %q", min, max, v)
+	}
+	return bytes, nil
+}
+
+// Range of cgroup IO weights.
+const (
+	MinIOWeight = 1
+	MaxIOWeight = 10000
+)
+
+// DefaultMountRetryInterval is how long to wait between attempts to mount a
+// volume with a MountRetryTimeout, if its parameters set no interval.
+const DefaultMountRetryInterval = time.Second
+
+// maxMinorNumber is the highest minor number a DRBD device can have.
+const maxMinorNumber = 1<<20 - 1
+
+// Modes of checking filesystems before mounting them.
+const (
+	FSCKOff    = "off"
+	FSCKCheck  = "check"
+	FSCKRepair = "repair"
+)
+
+var validFSCKOnMount = []string{FSCKOff, FSCKCheck, FSCKRepair}
+
+func isValidFSCKOnMount(s string) bool {
+	for _, v := range validFSCKOnMount {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Ways of returning freed filesystem blocks to the storage.
+const (
+	DiscardMount  = "mount"
+	DiscardFstrim = "fstrim"
+	DiscardOff    = "off"
+)
+
+var validDiscard = []string{DiscardMount, DiscardFstrim, DiscardOff}
+
+func isValidDiscard(s string) bool {
+	for _, v := range validDiscard {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Write barrier settings of filesystems.
+const (
+	BarriersOn  = "on"
+	BarriersOff = "off"
+)
+
+var validCompression = []string{
+	"on", "off", "lz4", "lzjb", "zle", "gzip", "gzip-1", "gzip-2", "gzip-3", "gzip-4", "gzip-5",
+	"gzip-6", "gzip-7", "gzip-8", "gzip-9", "zstd", "zstd-fast",
+}
+
+func isValidCompression(s string) bool {
+	for _, v := range validCompression {
+		if s == v {
+			return true
+		}
+	}
+	// zstd has too many levels to list.
+	if strings.HasPrefix(s, "zstd-fast-") {
+		level, err := strconv.Atoi(strings.TrimPrefix(s, "zstd-fast-"))
+		return err == nil && level >= 1
+	}
+	if strings.HasPrefix(s, "zstd-") {
+		level, err := strconv.Atoi(strings.TrimPrefix(s, "zstd-"))
+		return err == nil && level >= 1 && level <= 19
+	}
+	return false
+}
+
+var validOnOff = []string{"on", "off"}
+
+func isValidOnOff(s string) bool {
+	for _, v := range validOnOff {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+var validFSErrors = []string{"continue", "remount-ro", "panic"}
+
+func isValidFSErrors(s string) bool {
+	for _, v := range validFSErrors {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+var validReadBalancing = []string{
+	"prefer-local", "prefer-remote", "round-robin", "least-pending", "when-congested-remote",
+	"32K-striping", "64K-striping", "128K-striping", "256K-striping", "512K-striping", "1M-striping",
+}
+
+func isValidReadBalancing(s string) bool {
+	for _, v := range validReadBalancing {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// DrbdOptions returns the DRBD options requested by the parameters as LINSTOR
+// properties, ready to be set on a resource definition.
+func (p Parameters) DrbdOptions() map[string]string {
+	var props = make(map[string]string)
+
+	if p.ReadBalancing != "" {
+		props[lc.NamespcDrbdDiskOptions+"/read-balancing"] = p.ReadBalancing
+	}
+	if p.SyncAfter != "" {
+		props[lc.NamespcDrbdDiskOptions+"/resync-after"] = p.SyncAfterVolume()
+	}
+	if p.DiskFlushes != "" {
+		props[lc.NamespcDrbdDiskOptions+"/disk-flushes"] = drbdYesNo(p.DiskFlushes)
+	}
+	if p.MDFlushes != "" {
+		props[lc.NamespcDrbdDiskOptions+"/md-flushes"] = drbdYesNo(p.MDFlushes)
+	}
+	if p.SyncRate != 0 {
+		props[lc.NamespcDrbdPeerDeviceOptions+"/resync-rate"] = strconv.FormatInt(p.SyncRate/kiB, 10)
+	}
+	if p.CMaxRate != 0 {
+		props[lc.NamespcDrbdPeerDeviceOptions+"/c-max-rate"] = strconv.FormatInt(p.CMaxRate/kiB, 10)
+	}
+	if p.CFillTarget != 0 {
+		props[lc.NamespcDrbdPeerDeviceOptions+"/c-fill-target"] = strconv.FormatInt(p.CFillTarget/sector, 10)
+	}
+	if p.ALExtents != 0 {
+		props[lc.NamespcDrbdDiskOptions+"/al-extents"] = strconv.Itoa(p.ALExtents)
+	}
+
+	return props
+}
+
+// drbdYesNo translates on and off into DRBD's yes and no.
+func drbdYesNo(onOff string) string {
+	if onOff == "on" {
+		return "yes"
+	}
+	return "no"
+}
+
+// SyncAfterResource returns the name of the resource referenced by SyncAfter.
+func (p Parameters) SyncAfterResource() string {
+	return strings.SplitN(p.SyncAfter, "/", 2)[0]
+}
+
+// SyncAfterVolume returns the resource/volume-number referenced by SyncAfter,
+// defaulting to the first volume of the resource.
+func (p Parameters) SyncAfterVolume() string {
+	if strings.Contains(p.SyncAfter, "/") {
+		return p.SyncAfter
+	}
+	return p.SyncAfter + "/0"
+}
+
+// SetPlacementCount sets the number of replicas of the volume, replacing any
+// parameter that set it before.
+func (i *Info) SetPlacementCount(count int32) {
+	for k := range i.Parameters {
+		if key, _, err := resolveParamKey(k); err == nil && (key == autoplace || key == placementcount) {
+			delete(i.Parameters, k)
+		}
+	}
+	if i.Parameters == nil {
+		i.Parameters = make(map[string]string)
+	}
+	i.Parameters[placementcount.String()] = strconv.FormatInt(int64(count), 10)
+}
+
+// SetStoragePool sets the storage pool of the volume's diskful replicas,
+// replacing any parameter that set it before.
+func (i *Info) SetStoragePool(pool string) {
+	for k := range i.Parameters {
+		if key, _, err := resolveParamKey(k); err == nil && key == storagepool {
+			delete(i.Parameters, k)
+		}
+	}
+	if i.Parameters == nil {
+		i.Parameters = make(map[string]string)
+	}
+	i.Parameters[storagepool.String()] = pool
+}
+
+// parseBool parses the values strconv.ParseBool accepts, ignoring case and
+// surrounding space, as well as yes, no, on, and off.
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "yes", "on":
+		return true, nil
+	case "no", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(strings.ToLower(strings.TrimSpace(v)))
+}
+
+// cacheLayer is LINSTOR's dm-cache layer.
+const cacheLayer lapi.LayerType = "CACHE"
+
+// layerOrder is the order of layers from top to bottom in a layer list.
+// Caches go below encryption, so that they only hold encrypted data.
+var layerOrder = []lapi.LayerType{lapi.DRBD, lapi.NVME, lapi.LUKS, cacheLayer, lapi.STORAGE}
+
+// requiredLayers returns the layers needed for the features requested in p.
+func (p Parameters) requiredLayers() []lapi.LayerType {
+	var layers []lapi.LayerType
+	if p.Encryption {
+		layers = append(layers, lapi.LUKS)
+	}
+	if p.CachePool != "" {
+		layers = append(layers, cacheLayer)
+	}
+	return layers
+}
+
+// completeLayerList inserts the required layers missing from layers at their
+// place in layerOrder. It returns the completed list and the added layers.
+func completeLayerList(layers, required []lapi.LayerType) ([]lapi.LayerType, []lapi.LayerType) {
+	var added []lapi.LayerType
+	for _, r := range required {
+		if containsLayerType(layers, r) {
+			continue
+		}
+
+		pos := len(layers)
+		for i, l := range layers {
+			if layerIndex(l) > layerIndex(r) {
+				pos = i
+				break
+			}
+		}
+		layers = append(layers[:pos:pos], append([]lapi.LayerType{r}, layers[pos:]...)...)
+		added = append(added, r)
+	}
+	return layers, added
+}
+
+func layerIndex(layer lapi.LayerType) int {
+	for i, l := range layerOrder {
+		if l == layer {
+			return i
+		}
+	}
+	return len(layerOrder)
+}
+
+func containsLayerType(layers []lapi.LayerType, layer lapi.LayerType) bool {
+	for _, l := range layers {
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
 //ParseLayerList returns a slice of LayerType from a string of space-separated layers.
 func ParseLayerList(s string) ([]lapi.LayerType, error) {
 	list := strings.Split(s, " ")
 	var layers = make([]lapi.LayerType, 0)
-	knownLayers := []lapi.LayerType{lapi.DRBD, lapi.STORAGE, lapi.LUKS, lapi.NVME}
+	knownLayers := []lapi.LayerType{lapi.DRBD, lapi.STORAGE, lapi.LUKS, lapi.NVME, cacheLayer}
 
 userLayers:
 	for _, l := range list {
@@ -244,6 +1240,45 @@ userLayers:
 	return layers, nil
 }
 
+// onlineGrowFilesystems are the filesystems that can be grown while mounted.
+// None of them shrink online, and xfs can't be shrunk at all, so volumes are
+// only ever expanded.
+var onlineGrowFilesystems = []string{"ext3", "ext4", "xfs", "btrfs"}
+
+// offlineResizeLayers are the layers whose devices can't be resized while in
+// use: NVMe-oF initiators only see the new size after reconnecting.
+var offlineResizeLayers = []lapi.LayerType{lapi.NVME}
+
+// SupportsOnlineExpand returns true if vol can be expanded while it is in use,
+// judging by its layer list and filesystem. Volumes without a filesystem
+// parameter are block volumes or use the default filesystem, which grow online.
+func SupportsOnlineExpand(vol *Info) (bool, error) {
+	params, err := NewParameters(vol.Parameters)
+	if err != nil {
+		return false, err
+	}
+
+	for _, l := range params.LayerList {
+		for _, offline := range offlineResizeLayers {
+			if l == offline {
+				return false, nil
+			}
+		}
+	}
+
+	// Any of the candidates may end up on the volume.
+candidates:
+	for _, candidate := range params.FSCandidates {
+		for _, fs := range onlineGrowFilesystems {
+			if strings.EqualFold(candidate, fs) {
+				continue candidates
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
 // Sort sorts a list of snaphosts.
 func Sort(vols []*Info) {
 	sort.Slice(vols, func(j, k int) bool {
@@ -270,6 +1305,7 @@ func (i *Info) ToResourceDefinition() (lapi.ResourceDefinition, error) {
 
 	resDef := lapi.ResourceDefinition{
 		ExternalName: i.Name,
+		Uuid:         params.ResourceDefinitionUUID,
 		Props:        make(map[string]string),
 		LayerData:    make([]lapi.ResourceDefinitionLayer, len(params.LayerList)),
 	}
@@ -278,6 +1314,22 @@ func (i *Info) ToResourceDefinition() (lapi.ResourceDefinition, error) {
 		resDef.LayerData[k].Type = params.LayerList[k]
 	}
 
+	for k, v := range params.DrbdOptions() {
+		resDef.Props[k] = v
+	}
+
+	if params.MetadataStoragePool != "" {
+		resDef.Props[linstor.DrbdMetaStoragePoolKey] = params.MetadataStoragePool
+	}
+
+	if params.CachePool != "" {
+		resDef.Props[linstor.CachePoolKey] = params.CachePool
+	}
+
+	if params.Compression != "" {
+		resDef.Props[linstor.ZfsCreateOptionsKey] = "-o compression=" + params.Compression
+	}
+
 	serializedVol, err := json.Marshal(i)
 	if err != nil {
 		return resDef, err
@@ -289,6 +1341,20 @@ func (i *Info) ToResourceDefinition() (lapi.ResourceDefinition, error) {
 	return resDef, nil
 }
 
+// ToVolumeDefinitionCreate prepares a lapi.VolumeDefinitionCreate from a
+// volume.Info.
+func (i *Info) ToVolumeDefinitionCreate() (lapi.VolumeDefinitionCreate, error) {
+	params, err := NewParameters(i.Parameters)
+	if err != nil {
+		return lapi.VolumeDefinitionCreate{}, err
+	}
+
+	return lapi.VolumeDefinitionCreate{
+		VolumeDefinition: lapi.VolumeDefinition{SizeKib: uint64(data.NewKibiByte(data.ByteSize(i.SizeBytes)).Value())},
+		DrbdMinorNumber:  params.MinorNumber,
+	}, nil
+}
+
 // ToResourceCreateList prepares a list of lapi.ResourceCreate to be used to
 // manually assign resources based on the node and client lists of the volume.
 func (i *Info) ToResourceCreateList() ([]lapi.ResourceCreate, error) {
@@ -323,7 +1389,21 @@ func (i *Info) ToDiskfullResourceCreate(node string) (lapi.ResourceCreate, error
 	}
 
 	res := i.toGenericResourceCreate(params, node)
-	res.Resource.Props[lc.KeyStorPoolName] = params.StoragePool
+	res.Resource.Props[lc.KeyStorPoolName] = params.Placement.StoragePool(node, params.StoragePool)
+	return res, nil
+}
+
+// ToDiskfullResourceCreateInPool is ToDiskfullResourceCreate, but places the
+// replica in pool if neither the placement nor the parameters name a storage
+// pool for node.
+func (i *Info) ToDiskfullResourceCreateInPool(node, pool string) (lapi.ResourceCreate, error) {
+	res, err := i.ToDiskfullResourceCreate(node)
+	if err != nil {
+		return res, err
+	}
+	if res.Resource.Props[lc.KeyStorPoolName] == "" {
+		res.Resource.Props[lc.KeyStorPoolName] = pool
+	}
 	return res, nil
 }
 
@@ -341,6 +1421,20 @@ func (i *Info) ToDisklessResourceCreate(node string) (lapi.ResourceCreate, error
 	return res, nil
 }
 
+// ToTiebreakerResourceCreate prepares a Info to be deployed by linstor on a
+// node as a diskless tiebreaker in the tiebreaker diskless pool.
+func (i *Info) ToTiebreakerResourceCreate(node string) (lapi.ResourceCreate, error) {
+	params, err := NewParameters(i.Parameters)
+	if err != nil {
+		return lapi.ResourceCreate{}, err
+	}
+
+	res := i.toGenericResourceCreate(params, node)
+	res.Resource.Props[lc.KeyStorPoolName] = params.TiebreakerDisklessPool
+	res.Resource.Flags = append(res.Resource.Flags, lc.FlagDiskless)
+	return res, nil
+}
+
 func (i *Info) toGenericResourceCreate(params Parameters, node string) lapi.ResourceCreate {
 	return lapi.ResourceCreate{
 		LayerList: params.LayerList,
@@ -375,6 +1469,12 @@ func (i *Info) ToAutoPlace() (lapi.AutoPlaceRequest, error) {
 type SnapInfo struct {
 	Name    string        `json:"name"`
 	CsiSnap *csi.Snapshot `json:"csiSnapshot"`
+	// UsedBytes is the space the snapshot consumes in its storage pools,
+	// summed over all nodes. It is filled in when snapshots are listed and
+	// never stored. UsedBytesEstimated is true if LINSTOR doesn't report the
+	// consumption, UsedBytes is then the logical size of the snapshot.
+	UsedBytes          int64 `json:"-"`
+	UsedBytesEstimated bool  `json:"-"`
 }
 
 // SnapSort sorts a list of snaphosts.
@@ -394,6 +1494,9 @@ type Assignment struct {
 	Node string
 	// Path is a location on the Node's filesystem where the volume may be accessed.
 	Path string
+	// Diskless is true if the node accesses the volume over the network,
+	// rather than having a replica of it.
+	Diskless bool
 }
 
 // CreateDeleter handles the creation and deletion of volumes.
@@ -425,6 +1528,13 @@ type SnapshotCreateDeleter interface {
 	VolFromVol(ctx context.Context, sourceVol, vol *Info) error
 }
 
+// Expander grows volumes.
+type Expander interface {
+	// Expand grows vol to sizeBytes. Volumes that can not be expanded online
+	// are refused while they are in use.
+	Expand(ctx context.Context, vol *Info, sizeBytes int64) error
+}
+
 // AttacherDettacher handles operations relating to volume accessiblity on nodes.
 type AttacherDettacher interface {
 	Querier
@@ -447,8 +1557,35 @@ type Querier interface {
 	CapacityBytes(ctx context.Context, params map[string]string) (int64, error)
 }
 
+// Condition describes the health of a volume, as reported to CSI volume
+// health monitoring.
+type Condition struct {
+	// Abnormal is set if the volume needs attention.
+	Abnormal bool
+	// Message describes the condition for humans.
+	Message string
+}
+
+// MountResult describes what mounting a volume did.
+type MountResult struct {
+	// Formatted is set if a filesystem was created on the volume.
+	Formatted bool
+	// AlreadyMounted is set if the volume was mounted to the target already.
+	AlreadyMounted bool
+	// FSType is the filesystem of the volume, empty for block volumes.
+	FSType string
+}
+
 // Mounter handles the filesystems located on volumes.
 type Mounter interface {
-	Mount(vol *Info, source, target, fsType string, options []string) error
+	Mount(vol *Info, source, target, fsType string, options []string) (MountResult, error)
+	// MountWithSecrets mounts like Mount, replacing references like
+	// ${secret:key} in options with the value of key in secrets.
+	MountWithSecrets(vol *Info, source, target, fsType string, options []string, secrets map[string]string) (MountResult, error)
 	Unmount(target string) error
+	// ExpandFilesystem grows the filesystem mounted at target to the size of
+	// its device.
+	ExpandFilesystem(target string) error
+	// CheckDevice returns an error if source is not an existing block device.
+	CheckDevice(source string) error
 }
@@ -0,0 +1,526 @@
+/*
+CSI Driver for Linstor
+Copyright © 2019 LINBIT USA, LLC
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, see <http://www.gnu.org/licenses/>.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	lc "github.com/LINBIT/golinstor"
+	lapi "github.com/LINBIT/golinstor/client"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/timestamp"
+)
+
+func TestSpecRoundTrip(t *testing.T) {
+	vol := &Info{
+		Name:         "pvc-1",
+		ID:           "csi-1",
+		CreatedBy:    "linstor.csi.linbit.com",
+		CreationTime: time.Date(2019, 8, 9, 10, 11, 12, 0, time.UTC),
+		SizeBytes:    1 << 30,
+		Parameters: map[string]string{
+			"placementCount": "2",
+			"storagePool":    "thin",
+			"mountOpts":      "noatime,discard",
+		},
+		Snapshots: []*SnapInfo{{
+			Name: "snap-1",
+			CsiSnap: &csi.Snapshot{
+				SnapshotId:     "snap-1",
+				SourceVolumeId: "csi-1",
+				CreationTime:   &timestamp.Timestamp{Seconds: 1565345472},
+				ReadyToUse:     true,
+			},
+		}},
+		GrowFSOnMount:   true,
+		Preallocated:    true,
+		Kubernetes:      &KubernetesRef{PVCName: "data", PVCNamespace: "default"},
+		DesiredReplicas: 3,
+	}
+
+	spec, err := ExportSpec(vol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ImportSpec(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vol, actual) {
+		t.Errorf("Expected round trip of\n\t%+v\nbut got\n\t%+v\nfrom\n%s", vol, actual, spec)
+	}
+}
+
+func TestImportSpec(t *testing.T) {
+	var tableTests = []struct {
+		spec string
+		fail bool
+	}{
+		{spec: `{"name": "pvc-1", "parameters": {"storagePool": "thin"}}`},
+		{spec: "name: pvc-1\nparameters:\n  storagePool: thin\n"},
+		{spec: "parameters:\n  storagePool: thin\n", fail: true},
+		{spec: "name: pvc-1\nparameters:\n  placementCount: many\n", fail: true},
+		{spec: "name: [", fail: true},
+	}
+
+	for _, tt := range tableTests {
+		_, err := ImportSpec([]byte(tt.spec))
+		if tt.fail && err == nil {
+			t.Errorf("Expected importing %q to fail", tt.spec)
+		}
+		if !tt.fail && err != nil {
+			t.Errorf("Expected importing %q to succeed, but got %v", tt.spec, err)
+		}
+	}
+}
+
+func TestLayerListFeatures(t *testing.T) {
+	var tableTests = []struct {
+		name   string
+		params map[string]string
+		layers []lapi.LayerType
+		added  []lapi.LayerType
+	}{
+		{
+			name:   "default layers",
+			params: map[string]string{},
+			layers: []lapi.LayerType{lapi.DRBD, lapi.STORAGE},
+		},
+		{
+			name:   "encryption adds luks to default layers",
+			params: map[string]string{"encryption": "true"},
+			layers: []lapi.LayerType{lapi.DRBD, lapi.LUKS, lapi.STORAGE},
+		},
+		{
+			name:   "encryption adds luks to explicit layers",
+			params: map[string]string{"encryption": "true", "layerList": "drbd storage"},
+			layers: []lapi.LayerType{lapi.DRBD, lapi.LUKS, lapi.STORAGE},
+			added:  []lapi.LayerType{lapi.LUKS},
+		},
+		{
+			name:   "luks goes above storage",
+			params: map[string]string{"encryption": "true", "layerList": "storage"},
+			layers: []lapi.LayerType{lapi.LUKS, lapi.STORAGE},
+			added:  []lapi.LayerType{lapi.LUKS},
+		},
+		{
+			name:   "cache adds the cache layer",
+			params: map[string]string{"cache": "nvme"},
+			layers: []lapi.LayerType{lapi.DRBD, cacheLayer, lapi.STORAGE},
+		},
+		{
+			name:   "cache goes below luks",
+			params: map[string]string{"cache": "nvme", "encryption": "true", "layerList": "drbd storage"},
+			layers: []lapi.LayerType{lapi.DRBD, lapi.LUKS, cacheLayer, lapi.STORAGE},
+			added:  []lapi.LayerType{lapi.LUKS, cacheLayer},
+		},
+		{
+			name:   "explicit luks is kept",
+			params: map[string]string{"encryption": "true", "layerList": "drbd luks storage"},
+			layers: []lapi.LayerType{lapi.DRBD, lapi.LUKS, lapi.STORAGE},
+		},
+	}
+
+	for _, tt := range tableTests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewParameters(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.layers, p.LayerList) {
+				t.Errorf("Expected layer list %v, but got %v", tt.layers, p.LayerList)
+			}
+			if !reflect.DeepEqual(tt.added, p.AddedLayers) {
+				t.Errorf("Expected added layers %v, but got %v", tt.added, p.AddedLayers)
+			}
+		})
+	}
+}
+
+func TestALExtents(t *testing.T) {
+	var tableTests = []struct {
+		alExtents string
+		expected  string
+		fail      bool
+	}{
+		{alExtents: "6007", expected: "6007"},
+		{alExtents: "67", expected: "67"},
+		{alExtents: "65534", expected: "65534"},
+		{alExtents: "66", fail: true},
+		{alExtents: "65535", fail: true},
+		{alExtents: "lots", fail: true},
+	}
+
+	for _, tt := range tableTests {
+		p, err := NewParameters(map[string]string{"alExtents": tt.alExtents})
+		if tt.fail {
+			if err == nil {
+				t.Errorf("Expected alExtents %q to be refused", tt.alExtents)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := p.DrbdOptions()["DrbdOptions/Disk/al-extents"]
+		if tt.expected != actual {
+			t.Errorf("Expected al-extents %q for alExtents %q, but got %q", tt.expected, tt.alExtents, actual)
+		}
+	}
+}
+
+func TestBoolParameters(t *testing.T) {
+	var tableTests = []struct {
+		value    string
+		expected bool
+		fail     bool
+	}{
+		{value: "true", expected: true},
+		{value: "Yes", expected: true},
+		{value: " off ", expected: false},
+		{value: "maybe", fail: true},
+	}
+
+	for _, tt := range tableTests {
+		params := map[string]string{"wipeOnDelete": tt.value}
+		if !tt.fail {
+			params["keepSnapshotsOnDelete"] = tt.value
+		}
+
+		p, err := NewParameters(params)
+		if tt.fail {
+			expected := fmt.Sprintf("bad parameters: wipeOnDelete must be a boolean, got %q", tt.value)
+			if err == nil || err.Error() != expected {
+				t.Errorf("Expected %q for %q, but got %v", expected, tt.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.WipeOnDelete != tt.expected || p.KeepSnapshotsOnDelete != tt.expected {
+			t.Errorf("Expected %t for %q, but got wipeOnDelete %t and keepSnapshotsOnDelete %t", tt.expected, tt.value, p.WipeOnDelete, p.KeepSnapshotsOnDelete)
+		}
+	}
+}
+
+func TestResyncTuning(t *testing.T) {
+	var tableTests = []struct {
+		params   map[string]string
+		expected map[string]string
+		fail     bool
+	}{
+		{
+			params: map[string]string{"syncRate": "100M", "cMaxRate": "1G", "cFillTarget": "1M"},
+			expected: map[string]string{
+				"DrbdOptions/PeerDevice/resync-rate":   "102400",
+				"DrbdOptions/PeerDevice/c-max-rate":    "1048576",
+				"DrbdOptions/PeerDevice/c-fill-target": "2048",
+			},
+		},
+		{
+			params:   map[string]string{"syncRate": "512KB", "cFillTarget": "1024"},
+			expected: map[string]string{"DrbdOptions/PeerDevice/resync-rate": "512", "DrbdOptions/PeerDevice/c-fill-target": "2"},
+		},
+		{params: map[string]string{"syncRate": "fast"}, fail: true},
+		{params: map[string]string{"syncRate": "100T"}, fail: true},
+		{params: map[string]string{"syncRate": "-1M"}, fail: true},
+		{params: map[string]string{"syncRate": "100"}, fail: true},
+		{params: map[string]string{"cMaxRate": "100k"}, fail: true},
+		{params: map[string]string{"cMaxRate": "5G"}, fail: true},
+		{params: map[string]string{"cFillTarget": "1000"}, fail: true},
+	}
+
+	for _, tt := range tableTests {
+		p, err := NewParameters(tt.params)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("Expected %v to be refused", tt.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := p.DrbdOptions()
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("Expected DRBD options %v for %v, but got %v", tt.expected, tt.params, actual)
+		}
+	}
+}
+
+func TestResourceDefinitionUUID(t *testing.T) {
+	var tableTests = []struct {
+		uuid     string
+		expected string
+		fail     bool
+	}{
+		{uuid: "6ba7b810-9dad-11d1-80b4-00c04fd430c8", expected: "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		{uuid: "6BA7B810-9DAD-11D1-80B4-00C04FD430C8", expected: "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		{uuid: "6ba7b810", fail: true},
+		{uuid: "pvc-6ba7b810-9dad-11d1-80b4-00c04fd430c8", fail: true},
+	}
+
+	for _, tt := range tableTests {
+		vol := &Info{Name: "pvc-a", Parameters: map[string]string{"resourceDefinitionUUID": tt.uuid}}
+		resDef, err := vol.ToResourceDefinition()
+		if tt.fail {
+			if err == nil {
+				t.Errorf("Expected resourceDefinitionUUID %q to be refused", tt.uuid)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.expected != resDef.Uuid {
+			t.Errorf("Expected UUID %q for resourceDefinitionUUID %q, but got %q", tt.expected, tt.uuid, resDef.Uuid)
+		}
+	}
+}
+
+func TestDiffParameters(t *testing.T) {
+	vol := &Info{
+		Name: "pvc-a",
+		Parameters: map[string]string{
+			"autoPlace":   "2",
+			"storagePool": "thin",
+			"encryption":  "yes",
+			"fsType":      "xfs",
+		},
+		DesiredReplicas: 3,
+	}
+
+	var tableTests = []struct {
+		desired  map[string]string
+		expected []ParamDiff
+	}{
+		{
+			desired: map[string]string{
+				"placementCount":             "3",
+				"storagePool":                "thin",
+				"encryption":                 "true",
+				"fs":                         "xfs",
+				"csi.storage.k8s.io/pv/name": "pvc-a",
+			},
+			expected: []ParamDiff{},
+		},
+		{
+			desired: map[string]string{
+				"placementCount": "2",
+				"storagePool":    "thick",
+				"encryption":     "true",
+				"fs":             "xfs",
+				"alExtents":      "6007",
+			},
+			expected: []ParamDiff{
+				{Key: "StoragePool", Current: "thin", Desired: "thick"},
+				{Key: "ALExtents", Current: "0", Desired: "6007"},
+				{Key: "PlacementCount", Current: "3", Desired: "2"},
+			},
+		},
+	}
+
+	for _, tt := range tableTests {
+		diffs, err := DiffParameters(vol, tt.desired)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tt.expected, diffs) {
+			t.Errorf("Expected differences %+v for %v, got %+v", tt.expected, tt.desired, diffs)
+		}
+	}
+
+	if _, err := DiffParameters(vol, map[string]string{"placementCount": "many"}); err == nil {
+		t.Errorf("Expected invalid desired parameters to be refused")
+	}
+}
+
+func TestSupportsOnlineExpand(t *testing.T) {
+	var tableTests = []struct {
+		params   map[string]string
+		expected bool
+	}{
+		{params: map[string]string{}, expected: true},
+		{params: map[string]string{"fs": "xfs"}, expected: true},
+		{params: map[string]string{"fs": "ext4", "encryption": "true"}, expected: true},
+		{params: map[string]string{"fs": "vfat"}, expected: false},
+		{params: map[string]string{"fs": "xfs,vfat"}, expected: false},
+		{params: map[string]string{"layerList": "nvme storage"}, expected: false},
+	}
+
+	for _, tt := range tableTests {
+		actual, err := SupportsOnlineExpand(&Info{Parameters: tt.params})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.expected != actual {
+			t.Errorf("Expected online expansion support: %t for %v, got %t", tt.expected, tt.params, actual)
+		}
+	}
+
+	if _, err := SupportsOnlineExpand(&Info{Parameters: map[string]string{"layerList": "zram"}}); err == nil {
+		t.Errorf("Expected invalid parameters to be refused")
+	}
+}
+
+func TestFSCandidates(t *testing.T) {
+	p, err := NewParameters(map[string]string{"fs": "xfs, ext4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"xfs", "ext4"}; p.FS != "xfs" || !reflect.DeepEqual(expected, p.FSCandidates) {
+		t.Errorf("Expected fs xfs from candidates %v, got %q from %v", expected, p.FS, p.FSCandidates)
+	}
+
+	if _, err := NewParameters(map[string]string{"fs": "xfs,"}); err == nil {
+		t.Errorf("Expected empty filesystem candidates to be refused")
+	}
+}
+
+func TestExcludeNodes(t *testing.T) {
+	var tableTests = []struct {
+		params   map[string]string
+		expected []string
+		fail     bool
+	}{
+		{params: map[string]string{"excludeNodes": "node-a node-b"}, expected: []string{"node-a", "node-b"}},
+		{params: map[string]string{"excludeNodes": "node-a, node-b,node-c"}, expected: []string{"node-a", "node-b", "node-c"}},
+		{params: map[string]string{"excludeNodes": "node-a", "nodeList": "node-b node-c"}, expected: []string{"node-a"}},
+		{params: map[string]string{"excludeNodes": "node-a", "clientList": "node-a"}, fail: true},
+	}
+
+	for _, tt := range tableTests {
+		p, err := NewParameters(tt.params)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("Expected %v to be refused", tt.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(tt.expected, p.ExcludeNodes) {
+			t.Errorf("Expected excluded nodes %v for %v, got %v", tt.expected, tt.params, p.ExcludeNodes)
+		}
+		for _, n := range tt.expected {
+			if !p.Excludes(n) {
+				t.Errorf("Expected %s to be excluded by %v", n, tt.params)
+			}
+		}
+	}
+}
+
+func TestMountRetry(t *testing.T) {
+	var tableTests = []struct {
+		params            map[string]string
+		timeout, interval time.Duration
+		fail              bool
+	}{
+		{params: map[string]string{}, interval: DefaultMountRetryInterval},
+		{params: map[string]string{"mountRetryTimeout": "30s"}, timeout: 30 * time.Second, interval: DefaultMountRetryInterval},
+		{params: map[string]string{"mountRetryTimeout": "1m", "mountRetryInterval": "500ms"}, timeout: time.Minute, interval: 500 * time.Millisecond},
+		{params: map[string]string{"mountRetryTimeout": "30"}, fail: true},
+		{params: map[string]string{"mountRetryInterval": "-1s"}, fail: true},
+	}
+
+	for _, tt := range tableTests {
+		p, err := NewParameters(tt.params)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("Expected %v to be refused", tt.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.MountRetryTimeout != tt.timeout || p.MountRetryInterval != tt.interval {
+			t.Errorf("Expected mount retries for %v every %v from %v, got %v every %v", tt.timeout, tt.interval, tt.params, p.MountRetryTimeout, p.MountRetryInterval)
+		}
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	var tableTests = []struct {
+		params  map[string]string
+		nodes   []string
+		clients []string
+		count   int32
+		pools   map[string]string
+		fail    bool
+	}{
+		{
+			params:  map[string]string{"placement": `{"diskful": {"node-b": "hdd", "node-a": ""}, "diskless": ["node-c"]}`, "storagePool": "ssd"},
+			nodes:   []string{"node-a", "node-b"},
+			clients: []string{"node-c"},
+			count:   2,
+			pools:   map[string]string{"node-a": "ssd", "node-b": "hdd"},
+		},
+		{
+			params: map[string]string{"placement": `{"diskful": {"node-a": "ssd"}}`, "placementCount": "1"},
+			nodes:  []string{"node-a"},
+			count:  1,
+			pools:  map[string]string{"node-a": "ssd"},
+		},
+		{params: map[string]string{"placement": `{"diskful": {"node-a": "ssd"}}`, "placementCount": "2"}, fail: true},
+		{params: map[string]string{"placement": `{"diskful": {"node-a": ""}, "diskless": ["node-a"]}`}, fail: true},
+		{params: map[string]string{"placement": `{"diskful": {"node-a": ""}}`, "nodeList": "node-b"}, fail: true},
+		{params: map[string]string{"placement": `{"diskless": ["node-a"]}`}, fail: true},
+		{params: map[string]string{"placement": `{"diskfull": {"node-a": ""}}`}, fail: true},
+		{params: map[string]string{"placement": `node-a`}, fail: true},
+	}
+
+	for _, tt := range tableTests {
+		p, err := NewParameters(tt.params)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("Expected %v to be refused", tt.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(tt.nodes, p.NodeList) || !reflect.DeepEqual(tt.clients, p.ClientList) || tt.count != p.PlacementCount {
+			t.Errorf("Expected nodes %v, clients %v, and %d replicas for %v, got %v, %v, and %d", tt.nodes, tt.clients, tt.count, tt.params, p.NodeList, p.ClientList, p.PlacementCount)
+		}
+		for node, pool := range tt.pools {
+			vol := &Info{Parameters: tt.params}
+			res, err := vol.ToDiskfullResourceCreate(node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := res.Resource.Props[lc.KeyStorPoolName]; actual != pool {
+				t.Errorf("Expected storage pool %s on %s for %v, got %s", pool, node, tt.params, actual)
+			}
+		}
+	}
+}